@@ -0,0 +1,784 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/audit"
+	"github.com/treeverse/terminus/pkg/billing"
+	"github.com/treeverse/terminus/pkg/client"
+	"github.com/treeverse/terminus/pkg/export"
+	"github.com/treeverse/terminus/pkg/http"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// Output formats of --output.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// listPageSize is the number of keys fetched per page by list commands.
+const listPageSize = 1000
+
+// Admin administers quota and usage, through the REST API of a server or
+// directly on its store.  *client.Client is an Admin.
+type Admin interface {
+	GetKey(ctx context.Context, key string) (*store.Record, error)
+	ListKeys(ctx context.Context, after string, limit int) ([]store.Record, string, error)
+	GetExceeded(ctx context.Context) ([]store.Record, error)
+	SetQuota(ctx context.Context, key string, quotaBytes int64) error
+	ClearQuota(ctx context.Context, key string) error
+	SetObjectQuota(ctx context.Context, key string, quotaObjects int64) error
+	ClearObjectQuota(ctx context.Context, key string) error
+	SetGracePeriod(ctx context.Context, key string, gracePeriod time.Duration) error
+	ClearGracePeriod(ctx context.Context, key string) error
+	SetPlan(ctx context.Context, p store.Plan) error
+	GetPlan(ctx context.Context, name string) (store.Plan, error)
+	ListPlans(ctx context.Context) ([]store.Plan, error)
+	DeletePlan(ctx context.Context, name string) error
+	AssignPlan(ctx context.Context, key, name string) error
+	UnassignPlan(ctx context.Context, key string) error
+	SetUsage(ctx context.Context, key string, sizeBytes int64) error
+	ExportUsage(ctx context.Context, format string, w io.Writer) error
+	GetHistory(ctx context.Context, key string, from, to time.Time, step time.Duration) ([]store.HistoryPoint, error)
+	Billing(ctx context.Context, month string) (*api.BillingResponse, error)
+}
+
+// StoreAdmin is an Admin directly on a store.
+type StoreAdmin struct {
+	store.Store
+}
+
+func (a StoreAdmin) GetKey(ctx context.Context, key string) (*store.Record, error) {
+	check, err := a.CheckQuota(ctx, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &store.Record{Key: key, Info: check.Info}, nil
+}
+
+func (a StoreAdmin) ListKeys(ctx context.Context, after string, limit int) ([]store.Record, string, error) {
+	records, err := a.List(ctx, after, limit)
+	if err != nil || len(records) < limit {
+		return records, "", err
+	}
+	return records, records[len(records)-1].Key, nil
+}
+
+func (a StoreAdmin) SetUsage(ctx context.Context, key string, sizeBytes int64) error {
+	err := a.Set(ctx, key, store.Value{SizeBytes: sizeBytes})
+	if errors.Is(err, store.ErrQuotaExceeded) {
+		return nil
+	}
+	return err
+}
+
+func (a StoreAdmin) ExportUsage(ctx context.Context, format string, w io.Writer) error {
+	ew, err := export.NewWriter(w, format)
+	if err != nil {
+		return err
+	}
+	_, err = export.Export(ctx, a.Store, ew)
+	return err
+}
+
+func (a StoreAdmin) GetHistory(ctx context.Context, key string, from, to time.Time, step time.Duration) ([]store.HistoryPoint, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-http.DefaultHistoryRange)
+	}
+	points, err := a.Store.GetHistory(ctx, key, from, to)
+	if err != nil || step <= 0 {
+		return points, err
+	}
+	return store.Resample(points, step), nil
+}
+
+func (a StoreAdmin) Billing(ctx context.Context, month string) (*api.BillingResponse, error) {
+	from, to, err := billing.ParseMonth(month)
+	if err != nil {
+		return nil, err
+	}
+	report, err := billing.NewReport(ctx, a.Store, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return &api.BillingResponse{Month: month, From: report.From, To: report.To, Lines: report.Lines}, nil
+}
+
+// AddAdminFlags adds flags that select an Admin to flags.
+func AddAdminFlags(flags *pflag.FlagSet) {
+	flags.StringP("server", "s", "http://localhost:80", "URL of the Terminus server; its bearer token is read from $TERMINUS_TOKEN")
+	// Without a DSN, talk to the server.
+	AddStoreFlags(flags)
+}
+
+// NewAdminOrDie returns the Admin configured by flags added by
+// AddAdminFlags, and a function that closes it.  It connects to the store
+// if --db-dsn is set, and otherwise to the server.
+func NewAdminOrDie(ctx context.Context, flags *pflag.FlagSet) (Admin, func()) {
+	if GetFlagStringOrDie(flags, "db-dsn") == "" {
+		var editors []client.RequestEditor
+		if token := os.Getenv("TERMINUS_TOKEN"); token != "" {
+			editors = append(editors, client.WithBearerToken(token))
+		}
+		c, err := client.New(GetFlagStringOrDie(flags, "server"), editors...)
+		DieOnErr(err)
+		return c, func() {}
+	}
+
+	cfg := GetStoreConfigOrDie(flags)
+	if cfg.Driver == memoryDriver {
+		DieOnErr(fmt.Errorf("--db-driver=%s is private to its server, use --server", memoryDriver))
+	}
+	storeCtx, cancel := context.WithCancel(ctx)
+	st, waitStore, err := OpenStore(storeCtx, logging.Discard(), cfg)
+	if err != nil {
+		cancel()
+		DieOnErr(err)
+	}
+	return StoreAdmin{audit.NewStore(st, logging.Default())}, func() {
+		cancel()
+		waitStore()
+	}
+}
+
+// adminContext returns a context for an administration command, cancelled
+// on SIGINT or SIGTERM.  Changes made directly on the store are audited to
+// the local user.
+func adminContext() context.Context {
+	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	actor := "cli"
+	if u, err := user.Current(); err == nil {
+		actor += ":" + u.Username
+	}
+	return audit.WithActor(ctx, actor)
+}
+
+// runAdmin runs f on the Admin configured by the flags of cmd, and dies if
+// it fails.
+func runAdmin(cmd *cobra.Command, f func(ctx context.Context, a Admin) error) {
+	ctx := adminContext()
+	a, closeAdmin := NewAdminOrDie(ctx, cmd.Flags())
+	err := f(ctx, a)
+	closeAdmin()
+	DieOnErr(err)
+}
+
+// ListAll returns up to limit records after the key after from a, or all
+// of them if limit is zero.
+func ListAll(ctx context.Context, a Admin, after string, limit int) ([]store.Record, error) {
+	var records []store.Record
+	for {
+		pageSize := listPageSize
+		if limit > 0 && limit-len(records) < pageSize {
+			pageSize = limit - len(records)
+		}
+		page, next, err := a.ListKeys(ctx, after, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, page...)
+		if next == "" || (limit > 0 && len(records) >= limit) {
+			return records, nil
+		}
+		after = next
+	}
+}
+
+// PrintRecords prints records to w as output.  Tables show the plans of
+// keys if any are on one, which limits keys exceed if any records hold
+// them, and whether they are in grace.
+func PrintRecords(w io.Writer, output string, records []store.Record) error {
+	switch output {
+	case outputJSON:
+		if records == nil {
+			records = []store.Record{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case outputTable:
+		showPlan, showExceeded := false, false
+		for _, r := range records {
+			showPlan = showPlan || r.Info.Plan != ""
+			showExceeded = showExceeded || len(r.Exceeded) > 0
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		header := "KEY\tUSAGE\tQUOTA\tUSED\tOBJECTS"
+		if showPlan {
+			header += "\tPLAN"
+		}
+		if showExceeded {
+			header += "\tEXCEEDED"
+		}
+		fmt.Fprintln(tw, header)
+		for _, r := range records {
+			used := "-"
+			if r.Info.QuotaBytes > 0 {
+				used = fmt.Sprintf("%.1f%%", 100*float64(r.Info.UsageBytes)/float64(r.Info.QuotaBytes))
+			}
+			objects := strconv.FormatInt(r.Info.ObjectCount, 10)
+			if r.Info.QuotaObjects > 0 {
+				objects += "/" + strconv.FormatInt(r.Info.QuotaObjects, 10)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s", r.Key,
+				humanize.IBytes(uint64(r.Info.UsageBytes)), humanize.IBytes(uint64(r.Info.QuotaBytes)), used, objects)
+			if showPlan {
+				fmt.Fprintf(tw, "\t%s", r.Info.Plan)
+			}
+			if showExceeded {
+				exceeded := strings.Join(r.Exceeded, ",")
+				if r.InGrace {
+					exceeded += " (in grace)"
+				}
+				fmt.Fprintf(tw, "\t%s", exceeded)
+			}
+			fmt.Fprintln(tw)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown --output %s", output)
+}
+
+// PrintPlans prints plans to w as output.  Tables show limits that plans
+// leave to the defaults as "default".
+func PrintPlans(w io.Writer, output string, plans []store.Plan) error {
+	switch output {
+	case outputJSON:
+		apiPlans := make([]api.Plan, 0, len(plans))
+		for _, p := range plans {
+			apiPlans = append(apiPlans, api.NewPlan(p))
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(apiPlans)
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PLAN\tQUOTA\tOBJECTS\tRATE")
+		for _, p := range plans {
+			quota, objects, rates := "default", "default", "default"
+			if p.QuotaBytes != nil {
+				quota = humanize.IBytes(uint64(*p.QuotaBytes))
+			}
+			if p.QuotaObjects != nil {
+				objects = strconv.FormatInt(*p.QuotaObjects, 10)
+			}
+			if len(p.RateLimits) > 0 {
+				limits := make([]string, 0, len(p.RateLimits))
+				for _, l := range p.RateLimits {
+					limits = append(limits, l.Window.String()+"="+humanize.IBytes(uint64(l.Bytes)))
+				}
+				rates = strings.Join(limits, ",")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Name, quota, objects, rates)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown --output %s", output)
+}
+
+// PrintHistory prints points to w as output.
+func PrintHistory(w io.Writer, output string, points []store.HistoryPoint) error {
+	switch output {
+	case outputJSON:
+		if points == nil {
+			points = []store.HistoryPoint{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(points)
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tUSAGE")
+		for _, p := range points {
+			fmt.Fprintf(tw, "%s\t%s\n", p.Time.Format(time.RFC3339), humanize.IBytes(uint64(p.UsageBytes)))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown --output %s", output)
+}
+
+// PrintBilling prints the lines of report to w as output.
+func PrintBilling(w io.Writer, output string, report *api.BillingResponse) error {
+	switch output {
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tBYTE-HOURS\tGB-MONTHS")
+		for _, l := range report.Lines {
+			fmt.Fprintf(tw, "%s\t%.0f\t%.3f\n", l.Key, l.ByteHours, l.GBMonths)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown --output %s", output)
+}
+
+// ParseBytes parses humanized bytes, e.g. "8K" -> 8000 and "8KiB" -> 8192.
+func ParseBytes(s string) (int64, error) {
+	bytes, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("parse bytes %s: %w", s, err)
+	}
+	if int64(bytes) < 0 {
+		return 0, fmt.Errorf("bytes %s too large", s)
+	}
+	return int64(bytes), nil
+}
+
+// getKeys is the Run of commands that print keys given as arguments.
+func getKeys(cmd *cobra.Command, args []string) {
+	runAdmin(cmd, func(ctx context.Context, a Admin) error {
+		records := make([]store.Record, 0, len(args))
+		for _, key := range args {
+			r, err := a.GetKey(ctx, key)
+			if err != nil {
+				return fmt.Errorf("get %s: %w", key, err)
+			}
+			records = append(records, *r)
+		}
+		return PrintRecords(cmd.OutOrStdout(), GetFlagStringOrDie(cmd.Flags(), "output"), records)
+	})
+}
+
+// listKeys is the Run of commands that list keys.
+func listKeys(cmd *cobra.Command, _ []string) {
+	runAdmin(cmd, func(ctx context.Context, a Admin) error {
+		records, err := ListAll(ctx, a, GetFlagStringOrDie(cmd.Flags(), "after"), GetFlagIntOrDie(cmd.Flags(), "limit"))
+		if err != nil {
+			return fmt.Errorf("list keys: %w", err)
+		}
+		return PrintRecords(cmd.OutOrStdout(), GetFlagStringOrDie(cmd.Flags(), "output"), records)
+	})
+}
+
+// GetFlagTimeOrDie returns the value of flag in RFC 3339, or the zero time
+// if it is empty.
+func GetFlagTimeOrDie(flags *pflag.FlagSet, flag string) time.Time {
+	s := GetFlagStringOrDie(flags, flag)
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	DieOnErr(err)
+	return t
+}
+
+var quotaCmd = &cobra.Command{
+	Use:   "quota",
+	Short: "Administer quotas",
+	Long: `Administer quotas through the REST API of a Terminus server, or directly on
+its database with --db-dsn.  Changes made directly are audited to the local
+user.`,
+}
+
+var quotaGetCmd = &cobra.Command{
+	Use:     "get KEY...",
+	Short:   "Print usage and quota of keys",
+	Example: "terminus quota get alice bob",
+	Args:    cobra.MinimumNArgs(1),
+	Run:     getKeys,
+}
+
+var quotaSetCmd = &cobra.Command{
+	Use:     "set KEY SIZE",
+	Short:   "Set the quota of a key",
+	Example: "terminus quota set alice 10GiB",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		quotaBytes, err := ParseBytes(args[1])
+		DieOnErr(err)
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			return a.SetQuota(ctx, args[0], quotaBytes)
+		})
+	},
+}
+
+var quotaClearCmd = &cobra.Command{
+	Use:   "clear KEY",
+	Short: "Clear the quota of a key, which then uses the default quota",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			return a.ClearQuota(ctx, args[0])
+		})
+	},
+}
+
+var quotaSetObjectsCmd = &cobra.Command{
+	Use:     "set-objects KEY COUNT",
+	Short:   "Set the quota of objects of a key, or remove it with 0",
+	Example: "terminus quota set-objects alice 100000",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		quotaObjects, err := strconv.ParseInt(args[1], 10, 64)
+		DieOnErr(err)
+		if quotaObjects < 0 {
+			DieOnErr(fmt.Errorf("negative quota of objects %d", quotaObjects))
+		}
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			return a.SetObjectQuota(ctx, args[0], quotaObjects)
+		})
+	},
+}
+
+var quotaClearObjectsCmd = &cobra.Command{
+	Use:   "clear-objects KEY",
+	Short: "Clear the quota of objects of a key, which then uses the default quota of objects",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			return a.ClearObjectQuota(ctx, args[0])
+		})
+	},
+}
+
+var quotaSetGraceCmd = &cobra.Command{
+	Use:     "set-grace KEY DURATION",
+	Short:   "Set the grace period for which a key may exceed quota before it is enforced",
+	Example: "terminus quota set-grace alice 24h",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		gracePeriod, err := time.ParseDuration(args[1])
+		DieOnErr(err)
+		if gracePeriod < 0 {
+			DieOnErr(fmt.Errorf("negative grace period %s", gracePeriod))
+		}
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			return a.SetGracePeriod(ctx, args[0], gracePeriod)
+		})
+	},
+}
+
+var quotaClearGraceCmd = &cobra.Command{
+	Use:   "clear-grace KEY",
+	Short: "Clear the grace period of a key, which then uses the default grace period",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			return a.ClearGracePeriod(ctx, args[0])
+		})
+	},
+}
+
+var quotaListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List usage and quota of keys, sorted by key",
+	Args:  cobra.NoArgs,
+	Run:   listKeys,
+}
+
+var quotaExceededCmd = &cobra.Command{
+	Use:   "exceeded",
+	Short: "List keys over quota, sorted by key",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			records, err := a.GetExceeded(ctx)
+			if err != nil {
+				return fmt.Errorf("get keys exceeding quota: %w", err)
+			}
+			return PrintRecords(cmd.OutOrStdout(), GetFlagStringOrDie(cmd.Flags(), "output"), records)
+		})
+	},
+}
+
+// planDefault is the value of limit flags of "plan set" that leave the
+// limit to the default.
+const planDefault = "default"
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Administer plans of limits shared by keys",
+	Long: `Administer plans, such as free, team or enterprise, that hold quotas of bytes,
+of objects and of ingest rate shared by the keys on them.  A quota set on a
+key overrides that of its plan, and keys with neither use the default.
+Changes of plans apply at once to every key on them.`,
+}
+
+var planListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List plans, sorted by name",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			plans, err := a.ListPlans(ctx)
+			if err != nil {
+				return fmt.Errorf("list plans: %w", err)
+			}
+			return PrintPlans(cmd.OutOrStdout(), GetFlagStringOrDie(cmd.Flags(), "output"), plans)
+		})
+	},
+}
+
+var planGetCmd = &cobra.Command{
+	Use:     "get NAME...",
+	Short:   "Print limits of plans",
+	Example: "terminus plan get free team",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			plans := make([]store.Plan, 0, len(args))
+			for _, name := range args {
+				p, err := a.GetPlan(ctx, name)
+				if err != nil {
+					return fmt.Errorf("get plan %s: %w", name, err)
+				}
+				plans = append(plans, p)
+			}
+			return PrintPlans(cmd.OutOrStdout(), GetFlagStringOrDie(cmd.Flags(), "output"), plans)
+		})
+	},
+}
+
+// parsePlanLimit returns the limit of value of a limit flag of "plan set"
+// parsed by parse, or nil for planDefault.
+func parsePlanLimit(value string, parse func(string) (int64, error)) (*int64, error) {
+	if value == planDefault {
+		return nil, nil
+	}
+	limit, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return &limit, nil
+}
+
+// parseCount parses a non-negative count of objects.
+func parseCount(s string) (int64, error) {
+	count, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		return 0, fmt.Errorf("negative count %d", count)
+	}
+	return count, nil
+}
+
+var planSetCmd = &cobra.Command{
+	Use:   "set NAME",
+	Short: "Create a plan or change its limits",
+	Long: `Create a plan or change its limits.  Flags that are not given keep the limits
+of an existing plan, or leave them to the defaults on a new one.`,
+	Example: "terminus plan set team --quota=1TiB --objects=1000000 --rate=1h=100GiB",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			p, err := a.GetPlan(ctx, args[0])
+			if errors.Is(err, store.ErrNotFound) {
+				p, err = store.Plan{Name: args[0]}, nil
+			}
+			if err != nil {
+				return fmt.Errorf("get plan %s: %w", args[0], err)
+			}
+			if flags.Changed("quota") {
+				if p.QuotaBytes, err = parsePlanLimit(GetFlagStringOrDie(flags, "quota"), ParseBytes); err != nil {
+					return fmt.Errorf("--quota: %w", err)
+				}
+			}
+			if flags.Changed("objects") {
+				if p.QuotaObjects, err = parsePlanLimit(GetFlagStringOrDie(flags, "objects"), parseCount); err != nil {
+					return fmt.Errorf("--objects: %w", err)
+				}
+			}
+			if flags.Changed("rate") {
+				p.RateLimits = nil
+				for _, limit := range GetFlagStringSliceOrDie(flags, "rate") {
+					l, err := ParseRateLimit(limit)
+					if err != nil {
+						return fmt.Errorf("--rate: %w", err)
+					}
+					p.RateLimits = append(p.RateLimits, store.RateLimit(l))
+				}
+			}
+			return a.SetPlan(ctx, p)
+		})
+	},
+}
+
+var planDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete a plan, leaving keys on it on no plan",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			return a.DeletePlan(ctx, args[0])
+		})
+	},
+}
+
+var planAssignCmd = &cobra.Command{
+	Use:     "assign KEY NAME",
+	Short:   "Put a key on a plan",
+	Example: "terminus plan assign alice team",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			return a.AssignPlan(ctx, args[0], args[1])
+		})
+	},
+}
+
+var planUnassignCmd = &cobra.Command{
+	Use:   "unassign KEY",
+	Short: "Remove a key from its plan, which then uses the defaults",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			return a.UnassignPlan(ctx, args[0])
+		})
+	},
+}
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Inspect usage",
+	Long: `Inspect usage through the REST API of a Terminus server, or directly on its
+database with --db-dsn.`,
+}
+
+var usageGetCmd = &cobra.Command{
+	Use:     "get KEY...",
+	Short:   "Print usage and quota of keys",
+	Example: "terminus usage get alice",
+	Args:    cobra.MinimumNArgs(1),
+	Run:     getKeys,
+}
+
+var usageListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List usage and quota of keys, sorted by key",
+	Args:  cobra.NoArgs,
+	Run:   listKeys,
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export usage, effective quota and percent used of all keys",
+	Long: `Export usage, effective quota and percent used of all keys, sorted by key,
+from a Terminus server or directly from its database with --db-dsn.`,
+	Example: "terminus export --format=parquet --output-file=usage.parquet",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		format := GetFlagStringOrDie(cmd.Flags(), "format")
+		var w io.Writer = cmd.OutOrStdout()
+		if path := GetFlagStringOrDie(cmd.Flags(), "output-file"); path != "" {
+			f, err := os.Create(path)
+			DieOnErr(err)
+			defer func() { DieOnErr(f.Close()) }()
+			w = f
+		}
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			if err := a.ExportUsage(ctx, format, w); err != nil {
+				return fmt.Errorf("export: %w", err)
+			}
+			return nil
+		})
+	},
+}
+
+var usageHistoryCmd = &cobra.Command{
+	Use:     "history KEY",
+	Short:   "Print usage history of a key",
+	Example: "terminus usage history alice --from=2021-01-01T00:00:00Z --step=24h",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		from := GetFlagTimeOrDie(cmd.Flags(), "from")
+		to := GetFlagTimeOrDie(cmd.Flags(), "to")
+		step := GetFlagDurationOrDie(cmd.Flags(), "step")
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			points, err := a.GetHistory(ctx, args[0], from, to, step)
+			if err != nil {
+				return fmt.Errorf("get history of %s: %w", args[0], err)
+			}
+			return PrintHistory(cmd.OutOrStdout(), GetFlagStringOrDie(cmd.Flags(), "output"), points)
+		})
+	},
+}
+
+var billingCmd = &cobra.Command{
+	Use:   "billing",
+	Short: "Report usage of every key integrated over a month",
+	Long: `Report byte-hours and GB-months of every key over a month in UTC, sorted by
+key, from the usage ledger of a Terminus server or directly of its database
+with --db-dsn.  A month in progress reports usage until now.  A GB is 2^30
+bytes.`,
+	Example: "terminus billing --month=2026-09",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		month := GetFlagStringOrDie(cmd.Flags(), "month")
+		runAdmin(cmd, func(ctx context.Context, a Admin) error {
+			report, err := a.Billing(ctx, month)
+			if err != nil {
+				return fmt.Errorf("billing of %s: %w", month, err)
+			}
+			return PrintBilling(cmd.OutOrStdout(), GetFlagStringOrDie(cmd.Flags(), "output"), report)
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(quotaCmd)
+	AddAdminFlags(quotaCmd.PersistentFlags())
+	quotaCmd.PersistentFlags().StringP("output", "o", outputTable, "Output format: "+outputTable+" or "+outputJSON)
+	quotaCmd.AddCommand(quotaGetCmd, quotaSetCmd, quotaClearCmd, quotaSetObjectsCmd, quotaClearObjectsCmd, quotaSetGraceCmd, quotaClearGraceCmd, quotaListCmd, quotaExceededCmd)
+
+	rootCmd.AddCommand(planCmd)
+	AddAdminFlags(planCmd.PersistentFlags())
+	planCmd.PersistentFlags().StringP("output", "o", outputTable, "Output format: "+outputTable+" or "+outputJSON)
+	planCmd.AddCommand(planListCmd, planGetCmd, planSetCmd, planDeleteCmd, planAssignCmd, planUnassignCmd)
+	planSetCmd.Flags().String("quota", planDefault, "Quota of keys on the plan, or \""+planDefault+"\" for the default quota")
+	planSetCmd.Flags().String("objects", planDefault, "Quota of objects of keys on the plan, 0 for none, or \""+planDefault+"\" for the default")
+	planSetCmd.Flags().StringSlice("rate", nil, "Quotas of ingest of keys on the plan as WINDOW=SIZE, e.g. 1h=10GB; if empty, the default rate quotas")
+
+	rootCmd.AddCommand(usageCmd)
+	AddAdminFlags(usageCmd.PersistentFlags())
+	usageCmd.PersistentFlags().StringP("output", "o", outputTable, "Output format: "+outputTable+" or "+outputJSON)
+	usageCmd.AddCommand(usageGetCmd, usageListCmd, usageHistoryCmd)
+	usageHistoryCmd.Flags().String("from", "", "Start time in RFC 3339; if empty, 7 days before --to")
+	usageHistoryCmd.Flags().String("to", "", "End time in RFC 3339, exclusive; if empty, now")
+	usageHistoryCmd.Flags().Duration("step", 0, "Print the last usage in each interval of step; if zero, all recorded usage")
+
+	rootCmd.AddCommand(exportCmd)
+	AddAdminFlags(exportCmd.Flags())
+	exportCmd.Flags().StringP("format", "f", export.FormatCSV, "Export format: "+strings.Join(export.Formats, ", "))
+	exportCmd.Flags().StringP("output-file", "w", "", "File to export to; if empty, standard output")
+
+	rootCmd.AddCommand(billingCmd)
+	AddAdminFlags(billingCmd.Flags())
+	// The last day of last month.
+	now := time.Now().UTC()
+	lastMonth := now.AddDate(0, 0, -now.Day())
+	billingCmd.Flags().String("month", lastMonth.Format(billing.MonthLayout), "Month to report, e.g. 2026-09; if unset, last month")
+	billingCmd.Flags().StringP("output", "o", outputTable, "Output format: "+outputTable+" or "+outputJSON)
+
+	for _, cmd := range []*cobra.Command{quotaListCmd, usageListCmd} {
+		cmd.Flags().String("after", "", "List keys after this key")
+		cmd.Flags().Int("limit", 0, "Maximal number of keys to list, or 0 for all")
+	}
+}
@@ -3,23 +3,40 @@ package main
 import (
 	"context"
 	dbsql "database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"net/url"
 	"os"
 	"os/signal"
-	"regexp"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/treeverse/terminus/pkg/anomaly"
+	"github.com/treeverse/terminus/pkg/audit"
+	"github.com/treeverse/terminus/pkg/auth"
+	"github.com/treeverse/terminus/pkg/cost"
+	"github.com/treeverse/terminus/pkg/enforce"
+	"github.com/treeverse/terminus/pkg/forecast"
+	"github.com/treeverse/terminus/pkg/grace"
 	"github.com/treeverse/terminus/pkg/http"
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/proxy"
 	"github.com/treeverse/terminus/pkg/queue_handler"
+	"github.com/treeverse/terminus/pkg/rate"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
 	"github.com/treeverse/terminus/pkg/store/sql"
 
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sqs"
-	"github.com/dustin/go-humanize"
+	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
@@ -44,6 +61,126 @@ func NewSQS() (*sqs.SQS, error) {
 	return sqs, nil
 }
 
+// NewS3 returns an S3 client configured from the environment.
+func NewS3() (*s3.S3, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("New AWS session: %w", err)
+	}
+	return s3.New(sess), nil
+}
+
+// memoryDriver is the --db-driver that keeps the store in memory.  Its
+// --db-dsn is the path of a snapshot file, or empty for no persistence.
+const memoryDriver = "memory"
+
+// StoreConfig configures the store that OpenStore opens.
+type StoreConfig struct {
+	// Driver is memoryDriver or the name of a SQL dialect.
+	Driver string
+	DSN    string
+	// CreateSchema creates SQL tables if missing.
+	CreateSchema bool
+	// SnapshotInterval is the interval between snapshots of a memory
+	// store.
+	SnapshotInterval  time.Duration
+	DefaultQuotaBytes int64
+	// DefaultQuotaObjects is the quota of objects of keys without one,
+	// or 0 for none.
+	DefaultQuotaObjects int64
+	// Costs prices usage and sets cost quotas, or nil for none.
+	Costs *cost.Config
+	// RateLimits are quotas of ingest of keys, or empty for none.  Keys
+	// on plans with rate limits use those instead.
+	RateLimits rate.Limits
+	// Anomalies detects anomalous ingest to warn about, or nil not to.
+	Anomalies *anomaly.Detector
+	// DefaultGracePeriod is the grace period of keys without one.
+	DefaultGracePeriod time.Duration
+}
+
+// OpenStore opens a store configured by cfg.  It returns the store and a
+// function that waits for the store to close after ctx is done.
+func OpenStore(ctx context.Context, logger logging.Logger, cfg StoreConfig) (store.Store, func(), error) {
+	s, wait, err := openStore(ctx, logger, cfg)
+	if err != nil {
+		return s, wait, err
+	}
+	if cfg.Costs != nil {
+		s = cost.NewStore(s, cfg.Costs)
+	}
+	// Plans may set rate limits at any time.
+	s = rate.NewStore(s, cfg.RateLimits)
+	if cfg.Anomalies != nil {
+		s = anomaly.NewStore(s, cfg.Anomalies, logger.WithField("service", "anomaly"))
+	}
+	// Grace applies to every limit, so it wraps them all.
+	return grace.NewStore(s, cfg.DefaultGracePeriod), wait, nil
+}
+
+// openStore opens the store of cfg.Driver.
+func openStore(ctx context.Context, logger logging.Logger, cfg StoreConfig) (store.Store, func(), error) {
+	if cfg.Driver == memoryDriver {
+		return OpenMemoryStore(ctx, logger, cfg)
+	}
+	dialect, err := sql.DialectByName(cfg.Driver)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := dbsql.Open(dialect.DriverName(), cfg.DSN)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open %s database: %w", dialect.Name(), err)
+	}
+	if err = db.PingContext(ctx); err != nil {
+		return nil, nil, fmt.Errorf("ping %s database: %w", dialect.Name(), err)
+	}
+	if dialect.Name() == "sqlite" {
+		// SQLite supports a single writer.
+		db.SetMaxOpenConns(1)
+	}
+	if cfg.CreateSchema {
+		if err = sql.CreateSchema(ctx, db, dialect); err != nil {
+			return nil, nil, err
+		}
+	}
+	s, err := sql.NewSQLStore(db, dialect, cfg.DefaultQuotaBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.DefaultQuotaObjects = cfg.DefaultQuotaObjects
+	wait := func() {
+		<-ctx.Done()
+		if err := db.Close(); err != nil {
+			logger.WithError(err).Error("Close DB")
+		}
+	}
+	return s, wait, nil
+}
+
+// OpenMemoryStore opens a memory store restored from the snapshot file at
+// cfg.DSN, if it exists, and snapshots to it until ctx is done.
+func OpenMemoryStore(ctx context.Context, logger logging.Logger, cfg StoreConfig) (store.Store, func(), error) {
+	s := memory.NewStore(cfg.DefaultQuotaBytes)
+	s.DefaultQuotaObjects = cfg.DefaultQuotaObjects
+	if cfg.DSN == "" {
+		return s, func() {}, nil
+	}
+	err := s.LoadFile(cfg.DSN)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.WithField("snapshot_path", cfg.DSN).Info("No snapshot, starting with empty store")
+	} else if err != nil {
+		return nil, nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		s.SnapshotEvery(ctx, logger, cfg.DSN, cfg.SnapshotInterval)
+		close(done)
+	}()
+	return s, func() { <-done }, nil
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "terminus",
 	Short: "Terminus monitors and optionally controls quotas for lakeFS users on S3",
@@ -57,14 +194,11 @@ func GetFlagBytes(flags *pflag.FlagSet, flag string) (int64, error) {
 	if err != nil {
 		return 0, fmt.Errorf("get flag %s: %w", flag, err)
 	}
-	bytes, err := humanize.ParseBytes(s)
+	bytes, err := ParseBytes(s)
 	if err != nil {
-		return 0, fmt.Errorf("parse flag %s value %s: %w", flag, s)
+		return 0, fmt.Errorf("flag %s: %w", flag, err)
 	}
-	if int64(bytes) < 0 {
-		return int64(bytes), fmt.Errorf("Quota bytes %s too large", flag)
-	}
-	return int64(bytes), nil
+	return bytes, nil
 }
 
 func GetFlagBytesOrDie(flags *pflag.FlagSet, flag string) int64 {
@@ -73,70 +207,391 @@ func GetFlagBytesOrDie(flags *pflag.FlagSet, flag string) int64 {
 	return i
 }
 
+// ParseRateLimit parses a rate limit "WINDOW=SIZE" with a duration and
+// humanized bytes.  E.g. "1h=10GB".
+func ParseRateLimit(s string) (rate.Limit, error) {
+	window, size, ok := strings.Cut(s, "=")
+	if !ok {
+		return rate.Limit{}, fmt.Errorf("%s: expected WINDOW=SIZE", s)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil {
+		return rate.Limit{}, fmt.Errorf("%s: %w", s, err)
+	}
+	if d <= 0 {
+		return rate.Limit{}, fmt.Errorf("%s: window must be positive", s)
+	}
+	bytes, err := ParseBytes(size)
+	if err != nil {
+		return rate.Limit{}, fmt.Errorf("%s: %w", s, err)
+	}
+	return rate.Limit{Window: d, Bytes: bytes}, nil
+}
+
 func GetFlagStringOrDie(flags *pflag.FlagSet, flag string) string {
 	s, err := flags.GetString(flag)
 	DieOnErr(err)
 	return s
 }
 
+func GetFlagStringSliceOrDie(flags *pflag.FlagSet, flag string) []string {
+	s, err := flags.GetStringSlice(flag)
+	DieOnErr(err)
+	return s
+}
+
+func GetFlagBoolOrDie(flags *pflag.FlagSet, flag string) bool {
+	b, err := flags.GetBool(flag)
+	DieOnErr(err)
+	return b
+}
+
+func GetFlagDurationOrDie(flags *pflag.FlagSet, flag string) time.Duration {
+	d, err := flags.GetDuration(flag)
+	DieOnErr(err)
+	return d
+}
+
+func GetFlagFloat64OrDie(flags *pflag.FlagSet, flag string) float64 {
+	f, err := flags.GetFloat64(flag)
+	DieOnErr(err)
+	return f
+}
+
+func GetFlagIntOrDie(flags *pflag.FlagSet, flag string) int {
+	i, err := flags.GetInt(flag)
+	DieOnErr(err)
+	return i
+}
+
+func GetFlagInt64OrDie(flags *pflag.FlagSet, flag string) int64 {
+	i, err := flags.GetInt64(flag)
+	DieOnErr(err)
+	return i
+}
+
+// NewLoggerOrDie returns a logger configured by the logging flags.
+func NewLoggerOrDie(flags *pflag.FlagSet) logging.Logger {
+	logger, err := logging.New(logging.Config{
+		Level:  GetFlagStringOrDie(flags, "log-level"),
+		Format: GetFlagStringOrDie(flags, "log-format"),
+		Redact: logging.RedactConfig{
+			Mode:           GetFlagStringOrDie(flags, "log-redact"),
+			Fields:         GetFlagStringSliceOrDie(flags, "log-redact-fields"),
+			TruncateLength: GetFlagIntOrDie(flags, "log-redact-length"),
+			Salt:           os.Getenv("TERMINUS_LOG_REDACT_SALT"),
+		},
+	})
+	DieOnErr(err)
+	return logger
+}
+
+// AddStoreFlags adds flags that configure the store to flags.
+func AddStoreFlags(flags *pflag.FlagSet) {
+	flags.StringP("default-quota", "Q", "5KB", "Default quota size")
+	flags.Int64("default-object-quota", 0, "Default quota of objects, or 0 for none")
+	flags.Duration("default-grace-period", 0, "Default grace period for which keys may exceed quota before it is enforced")
+
+	flags.String("db-driver", "pgx", "Database SQL dialect: "+strings.Join(sql.DialectNames(), ", ")+"; or "+memoryDriver+" to keep data in memory")
+	flags.StringP("db-dsn", "d", "", "DSN to connect to database, or snapshot file for "+memoryDriver)
+	flags.Duration("snapshot-interval", time.Minute, "Interval between snapshots of "+memoryDriver+" store")
+	flags.Bool("db-create-schema", true, "Create database tables if missing")
+	flags.String("cost-config", "", "JSON file of prices of storage classes and quotas in dollars; if empty, no cost quotas")
+	flags.StringSlice("rate-quota", nil, "Quotas of bytes that every key may ingest over a sliding window, as WINDOW=SIZE, e.g. 1h=10GB")
+}
+
+// GetStoreConfigOrDie returns the store configuration from flags added by
+// AddStoreFlags.
+func GetStoreConfigOrDie(flags *pflag.FlagSet) StoreConfig {
+	cfg := StoreConfig{
+		Driver:              GetFlagStringOrDie(flags, "db-driver"),
+		DSN:                 GetFlagStringOrDie(flags, "db-dsn"),
+		CreateSchema:        GetFlagBoolOrDie(flags, "db-create-schema"),
+		SnapshotInterval:    GetFlagDurationOrDie(flags, "snapshot-interval"),
+		DefaultQuotaBytes:   GetFlagBytesOrDie(flags, "default-quota"),
+		DefaultQuotaObjects: GetFlagInt64OrDie(flags, "default-object-quota"),
+		DefaultGracePeriod:  GetFlagDurationOrDie(flags, "default-grace-period"),
+	}
+	if path := GetFlagStringOrDie(flags, "cost-config"); path != "" {
+		var err error
+		cfg.Costs, err = cost.LoadConfig(path)
+		DieOnErr(err)
+	}
+	for _, limit := range GetFlagStringSliceOrDie(flags, "rate-quota") {
+		l, err := ParseRateLimit(limit)
+		DieOnErr(err)
+		cfg.RateLimits = append(cfg.RateLimits, l)
+	}
+	if cfg.DSN == "" && cfg.Driver != memoryDriver {
+		DieOnErr(fmt.Errorf("--db-dsn required for --db-driver=%s", cfg.Driver))
+	}
+	return cfg
+}
+
+// AddMapperFlags adds flags that map paths to keys to flags.
+func AddMapperFlags(flags *pflag.FlagSet) {
+	flags.StringP("pattern", "p", `^s3://[^/]+/user/([^/]+)/.*$`, "Regexp matching paths to track")
+	flags.StringP("replacement", "r", "$1", "Replacement on path matched by `--pattern' generating key for quota")
+}
+
+// NewMapperOrDie returns the key mapper configured by flags added by
+// AddMapperFlags.
+func NewMapperOrDie(flags *pflag.FlagSet) *keys.Mapper {
+	mapper, err := keys.NewMapper(GetFlagStringOrDie(flags, "pattern"), GetFlagStringOrDie(flags, "replacement"))
+	DieOnErr(err)
+	return mapper
+}
+
+// GetHistoryConfigOrDie returns the usage history configuration from the
+// history flags.
+func GetHistoryConfigOrDie(flags *pflag.FlagSet) store.HistoryConfig {
+	cfg := store.HistoryConfig{
+		Interval:        GetFlagDurationOrDie(flags, "history-interval"),
+		Retention:       GetFlagDurationOrDie(flags, "history-retention"),
+		DownsampleAfter: GetFlagDurationOrDie(flags, "history-downsample-after"),
+		DownsampleStep:  GetFlagDurationOrDie(flags, "history-downsample-step"),
+	}
+	if cfg.Interval < 0 || cfg.Retention < 0 || cfg.DownsampleAfter < 0 || cfg.DownsampleStep < 0 {
+		DieOnErr(errors.New("--history-* durations must not be negative"))
+	}
+	return cfg
+}
+
+// Actions of --enforce-action.
+const (
+	enforceNone             = "none"
+	enforcePolicy           = "policy"
+	enforceBranchProtection = "branch-protection"
+)
+
+// NewEnforceActionOrDie returns the action configured by the enforcement
+// flags, or nil if none is.
+func NewEnforceActionOrDie(flags *pflag.FlagSet) enforce.Action {
+	action := GetFlagStringOrDie(flags, "enforce-action")
+	if action == enforceNone {
+		return nil
+	}
+	endpoint, err := url.Parse(GetFlagStringOrDie(flags, "lakefs-endpoint"))
+	DieOnErr(err)
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		DieOnErr(fmt.Errorf("--lakefs-endpoint %s: need scheme://host/api/v1", endpoint))
+	}
+	lakeFS := &enforce.LakeFS{
+		Endpoint:        endpoint,
+		AccessKeyID:     GetFlagStringOrDie(flags, "lakefs-access-key-id"),
+		SecretAccessKey: os.Getenv("TERMINUS_LAKEFS_SECRET_ACCESS_KEY"),
+	}
+	switch action {
+	case enforcePolicy:
+		return &enforce.PolicyAction{LakeFS: lakeFS, PolicyID: GetFlagStringOrDie(flags, "enforce-policy-id")}
+	case enforceBranchProtection:
+		return &enforce.BranchProtectionAction{LakeFS: lakeFS, Pattern: GetFlagStringOrDie(flags, "enforce-branch-pattern")}
+	}
+	DieOnErr(fmt.Errorf("unknown --enforce-action %s", action))
+	return nil
+}
+
 var runCmd = &cobra.Command{
 	Use:     "run",
 	Short:   "Start the Terminus server",
 	Example: "terminus run --sqs-name=terminus-queue --db-dsn=postgres:/// --default-quota=1G",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
-		dbDriver := GetFlagStringOrDie(cmd.Flags(), "db-driver")
-		dbDSN := GetFlagStringOrDie(cmd.Flags(), "db-dsn")
-		db, err := dbsql.Open(dbDriver, dbDSN)
-		DieOnErr(err)
-		err = db.PingContext(ctx)
-		DieOnErr(err)
+		logger := NewLoggerOrDie(cmd.Flags())
+		pollCtx, _ := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 
-		fmt.Println("Open DB")
-		defaultQuotaBytes := GetFlagBytesOrDie(cmd.Flags(), "default-quota")
-		store, err := sql.NewSQLStore(db, defaultQuotaBytes)
+		storeCfg := GetStoreConfigOrDie(cmd.Flags())
+		detector, err := anomaly.New(
+			GetFlagFloat64OrDie(cmd.Flags(), "anomaly-factor"),
+			GetFlagDurationOrDie(cmd.Flags(), "anomaly-window"),
+			GetFlagDurationOrDie(cmd.Flags(), "anomaly-baseline"),
+			GetFlagBytesOrDie(cmd.Flags(), "anomaly-min-size"))
+		DieOnErr(err)
+		if GetFlagBoolOrDie(cmd.Flags(), "anomaly-warn") {
+			storeCfg.Anomalies = detector
+		}
+		logger.WithField("driver", storeCfg.Driver).Info("Open DB")
+		st, waitStore, err := OpenStore(pollCtx, logger.WithField("service", "store"), storeCfg)
 		DieOnErr(err)
 
-		fmt.Println("Open SQS")
+		logger.Info("Open SQS")
 		sqs, err := NewSQS()
 		DieOnErr(err)
 
-		logger := log.Default()
-		logger.SetPrefix("[terminus] ")
 		queueName := GetFlagStringOrDie(cmd.Flags(), "sqs-name")
-		keyPattern := GetFlagStringOrDie(cmd.Flags(), "pattern")
-		keyRegexp, err := regexp.Compile(keyPattern)
-		DieOnErr(err)
-		keyReplacement := GetFlagStringOrDie(cmd.Flags(), "replacement")
+		mapper := NewMapperOrDie(cmd.Flags())
 
-		pollCtx, _ := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+		go store.SweepReservationsEvery(pollCtx, logger.WithField("service", "store"), st, GetFlagDurationOrDie(cmd.Flags(), "reservation-sweep-interval"))
+		if historyCfg := GetHistoryConfigOrDie(cmd.Flags()); historyCfg.Interval > 0 {
+			go store.RecordHistoryEvery(pollCtx, logger.WithField("service", "history"), st, historyCfg)
+		}
+		if compactAfter := GetFlagDurationOrDie(cmd.Flags(), "ledger-compact-after"); compactAfter > 0 {
+			go store.CompactLedgerEvery(pollCtx, logger.WithField("service", "ledger"), st, time.Hour, compactAfter)
+		}
+		if retention := GetFlagDurationOrDie(cmd.Flags(), "ingest-retention"); retention > 0 {
+			for _, l := range storeCfg.RateLimits {
+				if retention < l.Window {
+					DieOnErr(fmt.Errorf("--ingest-retention %s shorter than rate quota window %s", retention, l.Window))
+				}
+			}
+			if retention < detector.Window+detector.Baseline {
+				DieOnErr(fmt.Errorf("--ingest-retention %s shorter than anomaly window and baseline %s", retention, detector.Window+detector.Baseline))
+			}
+			go store.DeleteIngestEvery(pollCtx, logger.WithField("service", "ingest"), st, time.Hour, retention)
+		}
 
-		server := &http.Server{Store: store}
+		server := &http.Server{
+			Store:          audit.NewStore(st, logger.WithField("service", "audit")),
+			Logger:         logger.WithField("service", "http"),
+			Keys:           mapper,
+			ReservationTTL: GetFlagDurationOrDie(cmd.Flags(), "reservation-ttl"),
+			Costs:          storeCfg.Costs,
+			RateLimits:     storeCfg.RateLimits,
+			Anomalies:      detector,
+		}
+		server.Forecaster, err = forecast.New(
+			GetFlagStringOrDie(cmd.Flags(), "forecast-method"),
+			GetFlagDurationOrDie(cmd.Flags(), "forecast-window"),
+			GetFlagFloat64OrDie(cmd.Flags(), "forecast-alpha"))
+		DieOnErr(err)
+		server.AdminListenAddress = GetFlagStringOrDie(cmd.Flags(), "admin-listen")
+		if certFile := GetFlagStringOrDie(cmd.Flags(), "tls-cert"); certFile != "" {
+			reloader, err := http.NewCertReloader(certFile, GetFlagStringOrDie(cmd.Flags(), "tls-key"))
+			DieOnErr(err)
+			go reloader.ReloadEvery(pollCtx, logger.WithField("service", "http"), GetFlagDurationOrDie(cmd.Flags(), "tls-reload-interval"))
+			server.TLSConfig, err = http.NewTLSConfig(reloader, GetFlagStringOrDie(cmd.Flags(), "tls-client-ca"))
+			DieOnErr(err)
+		}
+		if authConfigPath := GetFlagStringOrDie(cmd.Flags(), "auth-config"); authConfigPath != "" {
+			authConfig, err := auth.LoadConfig(authConfigPath)
+			DieOnErr(err)
+			server.Auth, err = authConfig.Authenticator()
+			DieOnErr(err)
+		} else if !GetFlagBoolOrDie(cmd.Flags(), "no-auth") {
+			logger.Warn("No --auth-config, REST API and administration are open to all; pass --auth-config to protect them or --no-auth to silence this warning")
+		}
+		if hookPattern := GetFlagStringOrDie(cmd.Flags(), "hook-pattern"); hookPattern != "" {
+			server.HookKeys, err = keys.NewMapper(hookPattern, GetFlagStringOrDie(cmd.Flags(), "hook-replacement"))
+			DieOnErr(err)
+		}
 		listenAddress := GetFlagStringOrDie(cmd.Flags(), "listen")
-		fmt.Printf("Starting webserver on %s...\n", listenAddress)
+		logger.WithField("listen_address", listenAddress).Info("Starting webserver")
 		server.Serve(ctx, listenAddress)
 
-		fmt.Println("Starting to listen on queue...")
-		queue_handler.Poll(pollCtx, logger, sqs, queueName, keyRegexp, keyReplacement, store)
-		fmt.Println("Done!")
+		var observer queue_handler.Observer
+		if action := NewEnforceActionOrDie(cmd.Flags()); action != nil {
+			enforcer := enforce.New(st, action, logger.WithField("service", "enforce"))
+			DieOnErr(enforcer.Load(ctx))
+			go enforcer.ReconcileEvery(pollCtx, GetFlagDurationOrDie(cmd.Flags(), "enforce-interval"))
+			observer = enforcer
+		}
+
+		var classes queue_handler.StorageClasses
+		if GetFlagBoolOrDie(cmd.Flags(), "storage-class-lookup") {
+			s3Client, err := NewS3()
+			DieOnErr(err)
+			classes = queue_handler.NewHeadObjectClasses(s3Client, GetFlagIntOrDie(cmd.Flags(), "storage-class-cache-size"))
+		}
+
+		logger.WithField("queue", queueName).Info("Starting to listen on queue")
+		queue_handler.Poll(pollCtx, logger.WithField("service", "queue"), sqs, queueName, mapper, classes, st, observer)
+		waitStore()
+		logger.Info("Done!")
+	},
+}
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Start an S3 proxy that rejects writes over quota",
+	Long: `Proxy S3 requests to a backend endpoint, rejecting object writes to keys
+over quota.  Usage is tracked by "terminus run" on the same store.`,
+	Example: "terminus proxy --backend=https://s3.us-east-1.amazonaws.com --db-dsn=postgres:///",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		logger := NewLoggerOrDie(cmd.Flags())
+
+		backend, err := url.Parse(GetFlagStringOrDie(cmd.Flags(), "backend"))
+		DieOnErr(err)
+		if backend.Scheme == "" || backend.Host == "" {
+			DieOnErr(fmt.Errorf("--backend %s: need scheme://host", backend))
+		}
+
+		storeCfg := GetStoreConfigOrDie(cmd.Flags())
+		logger.WithField("driver", storeCfg.Driver).Info("Open DB")
+		st, waitStore, err := OpenStore(ctx, logger.WithField("service", "store"), storeCfg)
+		DieOnErr(err)
+
+		p := proxy.New(backend, st, NewMapperOrDie(cmd.Flags()), logger.WithField("service", "proxy"))
+		p.Domain = GetFlagStringOrDie(cmd.Flags(), "domain")
+		p.ReservationTTL = GetFlagDurationOrDie(cmd.Flags(), "reservation-ttl")
+		listenAddress := GetFlagStringOrDie(cmd.Flags(), "listen")
+		logger.WithFields(logging.Fields{"listen_address": listenAddress, "backend": backend.String()}).Info("Starting proxy")
+		DieOnErr(p.Serve(ctx, listenAddress))
+		waitStore()
+		logger.Info("Done!")
 	},
 }
 
 func init() {
+	rootCmd.PersistentFlags().String("log-level", "info", "Minimal level to log: debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", logging.FormatLogfmt, "Log format: json, logfmt or text")
+	rootCmd.PersistentFlags().String("log-redact", logging.RedactHash, "Redaction of object keys and message bodies in logs: none, hash, truncate or drop.  Hashes are salted with $TERMINUS_LOG_REDACT_SALT")
+	rootCmd.PersistentFlags().StringSlice("log-redact-fields", logging.DefaultRedactFields, "Log fields to redact")
+	rootCmd.PersistentFlags().Int("log-redact-length", 16, "Number of bytes to keep when truncating redacted fields")
+
 	rootCmd.AddCommand(runCmd)
 
 	runCmd.Flags().StringP("listen", "l", "localhost:80", "Address for webserver to listen")
+	runCmd.Flags().String("admin-listen", "", "Address for profiling and administration, e.g. localhost:8081; if empty, served on --listen")
+	runCmd.Flags().String("tls-cert", "", "TLS certificate file; if empty, serve plain HTTP")
+	runCmd.Flags().String("tls-key", "", "TLS private key file")
+	runCmd.Flags().String("tls-client-ca", "", "CA file verifying TLS client certificates for authentication")
+	runCmd.Flags().Duration("tls-reload-interval", 10*time.Second, "Interval between checks of TLS certificate files for changes")
+	runCmd.Flags().String("auth-config", "", "JSON file configuring bearer tokens, HMAC keys and client certificates for the REST API")
+	runCmd.Flags().Bool("no-auth", false, "Serve the REST API and administration without authentication, without warning when --auth-config is unset")
 	runCmd.Flags().StringP("sqs-name", "q", "", "Name of topic on SQS with S3 events to process")
 	runCmd.MarkFlagRequired("sqs-name")
 
-	runCmd.Flags().StringP("default-quota", "Q", "5KB", "Default quota size")
+	AddStoreFlags(runCmd.Flags())
+
+	runCmd.Flags().Duration("reservation-ttl", time.Hour, "Default time to live of quota reservations for uploads")
+	runCmd.Flags().Duration("reservation-sweep-interval", time.Minute, "Interval between removals of expired quota reservations")
+	runCmd.Flags().Duration("history-interval", time.Hour, "Interval between recordings of usage history; 0 to disable")
+	runCmd.Flags().Duration("history-retention", 90*24*time.Hour, "Age after which usage history is deleted; 0 to keep forever")
+	runCmd.Flags().Duration("history-downsample-after", 7*24*time.Hour, "Age after which usage history is downsampled to --history-downsample-step")
+	runCmd.Flags().Duration("history-downsample-step", 24*time.Hour, "Interval of downsampled usage history; 0 not to downsample")
+	runCmd.Flags().Duration("ledger-compact-after", 400*24*time.Hour, "Age after which changes on the usage ledger are merged, so months before cannot be billed; 0 to keep forever")
+	runCmd.Flags().Duration("ingest-retention", 8*24*time.Hour, "Age after which ingest counts are deleted, at least the longest --rate-quota window; 0 to keep forever")
+	runCmd.Flags().Float64("anomaly-factor", anomaly.DefaultFactor, "Multiple of the median ingest per --anomaly-window over --anomaly-baseline above which ingest is anomalous")
+	runCmd.Flags().Duration("anomaly-window", anomaly.DefaultWindow, "Duration of ingest compared to its baseline")
+	runCmd.Flags().Duration("anomaly-baseline", anomaly.DefaultBaseline, "Duration of ingest before --anomaly-window whose median is the baseline")
+	runCmd.Flags().String("anomaly-min-size", "1GiB", "Ingest per --anomaly-window at or below which nothing is anomalous")
+	runCmd.Flags().Bool("anomaly-warn", false, "Warn about keys with anomalous ingest as they ingest, like about quota breaches.  Anomalies are never enforced")
+	runCmd.Flags().Bool("storage-class-lookup", false, "Look up storage classes that events omit with S3 HeadObject, to track usage by storage class")
+	runCmd.Flags().Int("storage-class-cache-size", queue_handler.DefaultStorageClassCacheSize, "Number of storage classes of objects to cache")
+	runCmd.Flags().String("forecast-method", forecast.MethodLinear, "Fit of usage growth to forecast when keys exceed quota: "+forecast.MethodLinear+" or "+forecast.MethodEWMA)
+	runCmd.Flags().Duration("forecast-window", forecast.DefaultWindow, "Duration of usage history to fit for forecasts")
+	runCmd.Flags().Float64("forecast-alpha", forecast.DefaultAlpha, "Weight of the latest growth for "+forecast.MethodEWMA+" forecasts, in (0, 1]")
+	AddMapperFlags(runCmd.Flags())
+	runCmd.Flags().String("hook-pattern", `^lakefs://[^/]+/(.+)$`, "Regexp matching \"lakefs://repository/committer\" of lakeFS hooks to enforce; empty to disable hooks")
+	runCmd.Flags().String("hook-replacement", "$1", "Replacement on lakeFS hook path matched by `--hook-pattern' generating key for quota")
+
+	runCmd.Flags().String("enforce-action", enforceNone, "Action on lakeFS when keys exceed quota: none; "+enforcePolicy+" to attach --enforce-policy-id to users; or "+enforceBranchProtection+" to protect --enforce-branch-pattern on repositories")
+	runCmd.Flags().String("enforce-policy-id", "TerminusQuotaExceeded", "lakeFS policy denying writes, attached to users over quota")
+	runCmd.Flags().String("enforce-branch-pattern", "*", "lakeFS branch pattern protected on repositories over quota")
+	runCmd.Flags().Duration("enforce-interval", time.Minute, "Interval between reconciliations of lakeFS enforcement with quota")
+	runCmd.Flags().String("lakefs-endpoint", "", "lakeFS API URL, e.g. http://lakefs:8000/api/v1")
+	runCmd.Flags().String("lakefs-access-key-id", "", "lakeFS access key ID; the secret is read from $TERMINUS_LAKEFS_SECRET_ACCESS_KEY")
 
-	runCmd.Flags().String("db-driver", "pgx", "Database driver code")
-	runCmd.Flags().StringP("db-dsn", "d", "", "DSN to connect to database")
-	runCmd.MarkFlagRequired("db-dsn")
+	rootCmd.AddCommand(proxyCmd)
 
-	runCmd.Flags().StringP("pattern", "p", `^s3://[^/]+/user/([^/]+)/.*$`, "Regexp matching paths to track")
-	runCmd.Flags().StringP("replacement", "r", "$1", "Replacement on path matched by `--pattern' generating key for quota")
+	proxyCmd.Flags().StringP("listen", "l", "localhost:9000", "Address for proxy to listen")
+	proxyCmd.Flags().StringP("backend", "b", "", "URL of S3 endpoint to forward requests to")
+	proxyCmd.MarkFlagRequired("backend")
+	proxyCmd.Flags().String("domain", "", "Domain of virtual-hosted-style bucket requests; if empty, only path-style requests are enforced")
+	proxyCmd.Flags().Duration("reservation-ttl", proxy.DefaultReservationTTL, "Time to live of quota reservations for object puts and parts of multipart uploads")
+	AddStoreFlags(proxyCmd.Flags())
+	AddMapperFlags(proxyCmd.Flags())
 }
 
 func Execute() {
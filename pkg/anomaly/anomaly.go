@@ -0,0 +1,145 @@
+// Package anomaly flags keys whose ingest spikes far above their usual
+// rate, to catch misconfigured writers before they reach their quota.
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// Defaults of a Detector.
+const (
+	DefaultFactor   = 10
+	DefaultWindow   = time.Hour
+	DefaultBaseline = 7 * 24 * time.Hour
+	DefaultMinBytes = 1 << 30
+)
+
+// Anomaly is a key that ingests much more than its baseline.
+type Anomaly struct {
+	Key string
+	// IngestBytes is the number of bytes that the key ingested over the
+	// latest window.
+	IngestBytes int64
+	// BaselineBytes is the median of the bytes that the key ingested
+	// per window over the baseline before it.
+	BaselineBytes int64
+}
+
+// Detector detects anomalies in the ingest of keys.
+type Detector struct {
+	// Factor is the multiple of its baseline above which the ingest of
+	// a key is anomalous.
+	Factor float64
+	// Window is the duration over which ingest is compared.
+	Window time.Duration
+	// Baseline is the duration of ingest before the latest window whose
+	// median per window is the baseline.
+	Baseline time.Duration
+	// MinBytes is the ingest at or below which nothing is anomalous, so
+	// that keys that rarely ingest are not flagged for every write.
+	MinBytes int64
+}
+
+// DefaultDetector flags keys that ingest more than a GB and 10 times the
+// median of the last week within an hour.
+var DefaultDetector = &Detector{Factor: DefaultFactor, Window: DefaultWindow, Baseline: DefaultBaseline, MinBytes: DefaultMinBytes}
+
+// New returns a Detector, or an error if its configuration is invalid.
+func New(factor float64, window, baseline time.Duration, minBytes int64) (*Detector, error) {
+	if factor <= 1 {
+		return nil, fmt.Errorf("factor %g not above 1", factor)
+	}
+	if window <= 0 || window%store.IngestBucket != 0 {
+		return nil, fmt.Errorf("window %s not a positive multiple of %s", window, store.IngestBucket)
+	}
+	if baseline < window || baseline%window != 0 {
+		return nil, fmt.Errorf("baseline %s not a positive multiple of window %s", baseline, window)
+	}
+	if minBytes < 0 {
+		return nil, fmt.Errorf("negative minimal bytes %d", minBytes)
+	}
+	return &Detector{Factor: factor, Window: window, Baseline: baseline, MinBytes: minBytes}, nil
+}
+
+// Anomalous returns true if ingestBytes over a window is anomalous for a
+// key with baselineBytes.  A key with a baseline of 0 has too little
+// history to tell, so its ingest is never anomalous.
+func (d *Detector) Anomalous(ingestBytes, baselineBytes int64) bool {
+	return baselineBytes > 0 && ingestBytes > d.MinBytes && float64(ingestBytes) > d.Factor*float64(baselineBytes)
+}
+
+// latest returns the end of the latest window at now, which includes the
+// current partial ingest bucket.
+func latest(now time.Time) time.Time {
+	return now.Truncate(store.IngestBucket).Add(store.IngestBucket)
+}
+
+// Ingest returns the bytes that key ingested on s over the latest window
+// at now.
+func (d *Detector) Ingest(ctx context.Context, s store.Store, key string, now time.Time) (int64, error) {
+	to := latest(now)
+	return s.GetIngest(ctx, key, to.Add(-d.Window), to)
+}
+
+// Baselines returns the baselines at now of all keys that ingested on s in
+// at least half the windows of the baseline.  Other keys have too little
+// history for a baseline, and are at baseline 0.
+func (d *Detector) Baselines(ctx context.Context, s store.Store, now time.Time) (map[string]int64, error) {
+	to := latest(now).Add(-d.Window)
+	n := int(d.Baseline / d.Window)
+	perWindow := make(map[string][]int64)
+	for i := 0; i < n; i++ {
+		ingest, err := s.ListIngest(ctx, to.Add(-d.Window), to)
+		if err != nil {
+			return nil, fmt.Errorf("list ingest until %s: %w", to, err)
+		}
+		for _, in := range ingest {
+			perWindow[in.Key] = append(perWindow[in.Key], in.IngestBytes)
+		}
+		to = to.Add(-d.Window)
+	}
+	baselines := make(map[string]int64, len(perWindow))
+	for key, ingest := range perWindow {
+		if 2*len(ingest) < n {
+			continue
+		}
+		baselines[key] = median(ingest, n)
+	}
+	return baselines, nil
+}
+
+// median returns the median of n values, of which all but ingest are 0.
+func median(ingest []int64, n int) int64 {
+	values := make([]int64, n)
+	copy(values[n-len(ingest):], ingest)
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// Detect returns the anomalies of all keys on s at now, sorted by key.
+func (d *Detector) Detect(ctx context.Context, s store.Store, now time.Time) ([]Anomaly, error) {
+	baselines, err := d.Baselines(ctx, s, now)
+	if err != nil {
+		return nil, err
+	}
+	to := latest(now)
+	ingest, err := s.ListIngest(ctx, to.Add(-d.Window), to)
+	if err != nil {
+		return nil, fmt.Errorf("list ingest until %s: %w", to, err)
+	}
+	anomalies := []Anomaly{}
+	for _, in := range ingest {
+		if baseline := baselines[in.Key]; d.Anomalous(in.IngestBytes, baseline) {
+			anomalies = append(anomalies, Anomaly{Key: in.Key, IngestBytes: in.IngestBytes, BaselineBytes: baseline})
+		}
+	}
+	return anomalies, nil
+}
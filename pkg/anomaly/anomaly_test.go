@@ -0,0 +1,133 @@
+package anomaly_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/anomaly"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Factor   float64
+		Window   time.Duration
+		Baseline time.Duration
+		MinBytes int64
+		Ok       bool
+	}{
+		{"Default", anomaly.DefaultFactor, anomaly.DefaultWindow, anomaly.DefaultBaseline, anomaly.DefaultMinBytes, true},
+		{"LowFactor", 1, time.Hour, 24 * time.Hour, 0, false},
+		{"UnalignedWindow", 10, time.Minute, time.Hour, 0, false},
+		{"ShortBaseline", 10, time.Hour, 30 * time.Minute, 0, false},
+		{"UnalignedBaseline", 10, time.Hour, 90 * time.Minute, 0, false},
+		{"NegativeMinBytes", 10, time.Hour, 24 * time.Hour, -1, false},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			_, err := anomaly.New(c.Factor, c.Window, c.Baseline, c.MinBytes)
+			if (err == nil) != c.Ok {
+				t.Errorf("Got error %v, expected ok %t", err, c.Ok)
+			}
+		})
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	d, err := anomaly.New(10, time.Hour, 4*time.Hour, 100)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	var logs bytes.Buffer
+	l, err := logging.New(logging.Config{Format: logging.FormatJSON, Output: &logs})
+	if err != nil {
+		t.Fatalf("New logger: %s", err)
+	}
+	s := anomaly.NewStore(memory.NewStore(math.MaxInt64), d, l)
+	s.Now = func() time.Time { return now }
+
+	// Ingest in the current hour and in each of the 4 hours before it.
+	ingest := map[string][]int64{
+		"steady": {900, 100, 100, 100, 100},
+		"spike":  {500, 10, 0, 10, 10},
+		// Too little history for a baseline.
+		"new":    {150},
+		"sparse": {500, 0, 0, 0, 10},
+		"small":  {50},
+	}
+	for key, perHour := range ingest {
+		for i, size := range perHour {
+			if size == 0 {
+				continue
+			}
+			path := fmt.Sprintf("s3://b/%s/%d", key, i)
+			at := now.Add(-time.Duration(i)*time.Hour - 10*time.Minute)
+			if err := s.Store.PutObject(ctx, key, store.Object{Path: path, SizeBytes: size}, at); err != nil {
+				t.Fatalf("PutObject %s: %s", path, err)
+			}
+		}
+	}
+
+	anomalies, err := d.Detect(ctx, s, now)
+	if err != nil {
+		t.Fatalf("Detect: %s", err)
+	}
+	expected := []anomaly.Anomaly{
+		{Key: "spike", IngestBytes: 500, BaselineBytes: 10},
+	}
+	if diffs := deep.Equal(anomalies, expected); diffs != nil {
+		t.Errorf("Detect: %s", diffs)
+	}
+
+	// Anomalies are only warned about, once per window.
+	for i := 0; i < 2; i++ {
+		path := fmt.Sprintf("s3://b/spike/more/%d", i)
+		if err = s.PutObject(ctx, "spike", store.Object{Path: path, SizeBytes: 1}, now); err != nil {
+			t.Errorf("PutObject anomalous %s: %s", path, err)
+		}
+	}
+	for _, key := range []string{"steady", "new", "sparse"} {
+		if err = s.PutObject(ctx, key, store.Object{Path: "s3://b/" + key + "/more", SizeBytes: 1}, now); err != nil {
+			t.Errorf("PutObject %s: %s", key, err)
+		}
+	}
+	var warned []string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry struct {
+			Msg string `json:"msg"`
+			Key string `json:"key"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Parse log line %q: %s", line, err)
+		}
+		if entry.Msg == "Anomalous ingest" {
+			warned = append(warned, entry.Key)
+		}
+	}
+	if diffs := deep.Equal(warned, []string{"spike"}); diffs != nil {
+		t.Errorf("Warned keys: %s", diffs)
+	}
+	exceeded, err := s.GetExceeded(ctx)
+	if err != nil || len(exceeded) != 0 {
+		t.Errorf("GetExceeded: got %+v, %v", exceeded, err)
+	}
+
+	// The spike becomes history.
+	now = now.Add(time.Hour)
+	if a, err := s.Check(ctx, "spike"); err != nil || a != nil {
+		t.Errorf("Check spike an hour later: got %+v, %v", a, err)
+	}
+}
@@ -0,0 +1,121 @@
+package anomaly
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// Store is a store.Store that warns about keys whose ingest is anomalous
+// as they put objects, once per key and window.  Anomalies are not quota
+// breaches: they do not fail changes, appear in GetExceeded or limit
+// CheckQuota, so nothing enforces them.
+//
+// Store caches baselines of all keys for store.IngestBucket, since they
+// change slowly and cost a listing of ingest per window to compute.
+// Failures to detect anomalies after a change are not errors of the
+// change.
+type Store struct {
+	store.Store
+	Detector *Detector
+	Logger   logging.Logger
+	// Now returns the current time, or nil for time.Now.
+	Now func() time.Time
+
+	mu          sync.Mutex
+	baselines   map[string]int64
+	baselinesAt time.Time
+	// warned holds the end of the latest window in which each key was
+	// warned about.  Entries are pruned once that window ended more than
+	// a Detector.Window ago, since they can no longer suppress a warning.
+	warned map[string]time.Time
+}
+
+// NewStore returns a Store that detects anomalies on s with d and warns
+// about them on l.
+func NewStore(s store.Store, d *Detector, l logging.Logger) *Store {
+	return &Store{Store: s, Detector: d, Logger: l, warned: make(map[string]time.Time)}
+}
+
+func (s *Store) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// baseline returns the baseline of key at now, from the cache if it is
+// fresh.  It computes baselines without holding the cache, so concurrent
+// callers may compute them more than once.
+func (s *Store) baseline(ctx context.Context, key string, now time.Time) (int64, error) {
+	s.mu.Lock()
+	if s.baselines != nil && now.Sub(s.baselinesAt) < store.IngestBucket && !now.Before(s.baselinesAt) {
+		baseline := s.baselines[key]
+		s.mu.Unlock()
+		return baseline, nil
+	}
+	s.mu.Unlock()
+
+	baselines, err := s.Detector.Baselines(ctx, s.Store, now)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.baselines == nil || now.After(s.baselinesAt) {
+		s.baselines, s.baselinesAt = baselines, now
+	}
+	return baselines[key], nil
+}
+
+// Check returns the anomaly of key, or nil if its ingest is not anomalous.
+func (s *Store) Check(ctx context.Context, key string) (*Anomaly, error) {
+	now := s.now()
+	ingestBytes, err := s.Detector.Ingest(ctx, s.Store, key, now)
+	if err != nil || ingestBytes <= s.Detector.MinBytes {
+		return nil, err
+	}
+	baseline, err := s.baseline(ctx, key, now)
+	if err != nil || !s.Detector.Anomalous(ingestBytes, baseline) {
+		return nil, err
+	}
+	return &Anomaly{Key: key, IngestBytes: ingestBytes, BaselineBytes: baseline}, nil
+}
+
+// warn logs a, unless it already did in the same window.
+func (s *Store) warn(a *Anomaly) {
+	window := latest(s.now()).Truncate(s.Detector.Window)
+	s.mu.Lock()
+	if !s.warned[a.Key].Before(window) {
+		s.mu.Unlock()
+		return
+	}
+	for key, warnedWindow := range s.warned {
+		if window.Sub(warnedWindow) > s.Detector.Window {
+			delete(s.warned, key)
+		}
+	}
+	s.warned[a.Key] = window
+	s.mu.Unlock()
+	s.Logger.WithFields(logging.Fields{
+		logging.FieldKey: a.Key,
+		"ingest_bytes":   a.IngestBytes,
+		"baseline_bytes": a.BaselineBytes,
+	}).Warn("Anomalous ingest")
+}
+
+// PutObject also warns if the ingest of key becomes anomalous.
+func (s *Store) PutObject(ctx context.Context, key string, o store.Object, at time.Time) error {
+	err := s.Store.PutObject(ctx, key, o, at)
+	if err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+		return err
+	}
+	if a, checkErr := s.Check(ctx, key); checkErr == nil && a != nil {
+		s.warn(a)
+	}
+	return err
+}
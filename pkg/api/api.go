@@ -0,0 +1,219 @@
+// Package api holds the OpenAPI specification of the Terminus HTTP APIs,
+// and the types of their requests and responses.
+package api
+
+import (
+	_ "embed"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/anomaly"
+	"github.com/treeverse/terminus/pkg/billing"
+	"github.com/treeverse/terminus/pkg/cost"
+	"github.com/treeverse/terminus/pkg/forecast"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// Spec is the OpenAPI 3 specification of all endpoints, in JSON.
+//
+//go:embed "openapi.json"
+var Spec []byte
+
+// Error is the body of every error response.
+type Error struct {
+	Message string
+}
+
+// ExceededResponse lists keys over quota.
+type ExceededResponse struct {
+	Records []store.Record
+}
+
+// ListKeysResponse is a page of keys, sorted by key.
+type ListKeysResponse struct {
+	Records []store.Record
+	// Next is the "after" parameter of the next page, or empty on the
+	// last page.
+	Next string
+}
+
+// HistoryResponse is the usage history of a key, in order of time.
+type HistoryResponse struct {
+	Key    string
+	Points []store.HistoryPoint
+}
+
+// UsageResponse is the usage and quota of a key, its cost and its
+// forecast.
+type UsageResponse struct {
+	Key  string
+	Info store.Info
+	// StorageClasses is the usage of the key by storage class, where
+	// known.
+	StorageClasses map[string]int64
+	// Cost is nil if the server does not price usage.
+	Cost *cost.Cost
+	// Forecast is nil if the key has too little usage history.
+	Forecast *forecast.Forecast
+}
+
+// KeyForecast is the forecast of a key.
+type KeyForecast struct {
+	Key      string
+	Info     store.Info
+	Forecast forecast.Forecast
+}
+
+// ForecastResponse lists keys projected to exceed quota, soonest first.
+type ForecastResponse struct {
+	Forecasts []KeyForecast
+}
+
+// RateStatus is the ingest of a key over the window of a rate quota.
+type RateStatus struct {
+	Key           string
+	WindowSeconds int64
+	LimitBytes    int64
+	IngestBytes   int64
+}
+
+// RateExceededResponse lists keys over rate quotas, sorted by key.
+type RateExceededResponse struct {
+	Statuses []RateStatus
+}
+
+// AnomaliesResponse lists keys whose ingest is anomalous, sorted by key.
+type AnomaliesResponse struct {
+	// WindowSeconds is the duration of the latest window of ingest.
+	WindowSeconds int64
+	Anomalies     []anomaly.Anomaly
+}
+
+// BillingResponse is the usage of every key integrated over a month.
+type BillingResponse struct {
+	Month string
+	From  time.Time
+	To    time.Time
+	// Lines are in order of key.
+	Lines []billing.Line
+}
+
+// CheckQuotaRequest asks whether a key may grow.  Exactly one of Key or
+// Path should be set.
+type CheckQuotaRequest struct {
+	// Key is the key to check.
+	Key string
+	// Path is an "s3://..." path, mapped to the key to check.
+	Path string
+	// Bytes is the number of bytes to add.
+	Bytes int64
+}
+
+// CheckQuotaResponse reports whether a key may grow.  An empty Key means
+// the path is not tracked, so it is always allowed.
+type CheckQuotaResponse struct {
+	Key string
+	store.QuotaCheck
+}
+
+// ReserveRequest asks to reserve quota for an upload to Path.
+type ReserveRequest struct {
+	// Key is the key to reserve on.  If empty it is mapped from Path.
+	Key string
+	// Path is the "s3://..." path of the upload.  The reservation is
+	// released when an object is created there.
+	Path string
+	// Bytes is the number of bytes to reserve.
+	Bytes int64
+	// TTLSeconds is the number of seconds until the reservation
+	// expires, or zero for the server default.
+	TTLSeconds int64
+}
+
+// lakeFS hook event types that Terminus enforces.
+const (
+	EventTypePreCommit = "pre-commit"
+	EventTypePreMerge  = "pre-merge"
+)
+
+// LakeFSHookEvent is the payload of a lakeFS webhook action.
+type LakeFSHookEvent struct {
+	EventType    string `json:"event_type"`
+	ActionName   string `json:"action_name"`
+	HookID       string `json:"hook_id"`
+	RepositoryID string `json:"repository_id"`
+	BranchID     string `json:"branch_id"`
+	SourceRef    string `json:"source_ref"`
+	Committer    string `json:"committer"`
+}
+
+// SetQuotaRequest sets the quota of a key.
+type SetQuotaRequest struct {
+	QuotaBytes int64
+}
+
+// SetObjectQuotaRequest sets the quota of objects of a key, or removes its
+// quota of objects if QuotaObjects is 0.
+type SetObjectQuotaRequest struct {
+	QuotaObjects int64
+}
+
+// SetGracePeriodRequest sets the grace period of a key.
+type SetGracePeriodRequest struct {
+	GracePeriodSeconds int64
+}
+
+// PlanRateLimit caps the bytes that keys on a plan may ingest over a
+// sliding window.
+type PlanRateLimit struct {
+	WindowSeconds int64
+	LimitBytes    int64
+}
+
+// Plan is a named plan of limits shared by the keys on it.  Quotas set on
+// keys override those of their plan, and null quotas of the plan use the
+// defaults.
+type Plan struct {
+	Name         string
+	QuotaBytes   *int64
+	QuotaObjects *int64
+	// RateLimits replace the default rate quotas unless empty.
+	RateLimits []PlanRateLimit
+}
+
+// NewPlan returns the Plan of p.
+func NewPlan(p store.Plan) Plan {
+	plan := Plan{Name: p.Name, QuotaBytes: p.QuotaBytes, QuotaObjects: p.QuotaObjects, RateLimits: []PlanRateLimit{}}
+	for _, l := range p.RateLimits {
+		plan.RateLimits = append(plan.RateLimits, PlanRateLimit{WindowSeconds: int64(l.Window / time.Second), LimitBytes: l.Bytes})
+	}
+	return plan
+}
+
+// StorePlan returns the store.Plan of p.
+func (p Plan) StorePlan() store.Plan {
+	plan := store.Plan{Name: p.Name, QuotaBytes: p.QuotaBytes, QuotaObjects: p.QuotaObjects}
+	for _, l := range p.RateLimits {
+		plan.RateLimits = append(plan.RateLimits, store.RateLimit{Window: time.Duration(l.WindowSeconds) * time.Second, Bytes: l.LimitBytes})
+	}
+	return plan
+}
+
+// PlansResponse lists plans, sorted by name.
+type PlansResponse struct {
+	Plans []Plan
+}
+
+// AssignPlanRequest puts a key on a plan.
+type AssignPlanRequest struct {
+	Plan string
+}
+
+// SetUsageRequest sets the usage of a key.
+type SetUsageRequest struct {
+	SizeBytes int64
+}
+
+// AuditResponse holds audit entries.
+type AuditResponse struct {
+	Entries []store.AuditEntry
+}
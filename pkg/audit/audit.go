@@ -0,0 +1,355 @@
+// Package audit records changes of quota and usage on the audit log of a
+// store.
+//
+// Auditing is best-effort: every entry is appended after its change, in a
+// separate step, so a crash or a failing store between the two loses the
+// entry but keeps the change.  Failures to audit a change are logged, not
+// returned, since the change already happened.  Enforcement acts on
+// lakeFS, outside any store transaction, so its entries follow the same
+// order.
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// SystemActor is the actor of changes made by Terminus itself.
+const SystemActor = "terminus"
+
+type actorKey struct{}
+
+// WithActor returns a context for changes made by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor of changes made on ctx, or SystemActor.
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return SystemActor
+}
+
+// Store is a store.Store that records every change of quota of bytes or of
+// objects or of grace period, every change of plans and of keys on them,
+// and every Set of usage, on its audit log.  Usage changes by AddSizeBytes
+// or PutObject track objects and are not audited.
+type Store struct {
+	store.Store
+	Logger logging.Logger
+	// Now returns the current time, or nil for time.Now.
+	Now func() time.Time
+}
+
+// NewStore returns a Store that audits changes on s and logs failures to
+// audit them on l.
+func NewStore(s store.Store, l logging.Logger) *Store {
+	return &Store{Store: s, Logger: l}
+}
+
+func (s *Store) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Record appends an entry for action on key by the actor of ctx.
+func (s *Store) Record(ctx context.Context, action, key string, oldValue, newValue *int64) error {
+	s.audited(ctx, action, key, "", oldValue, newValue)
+	return nil
+}
+
+// record appends an entry for action on key with detail by the actor of
+// ctx.
+func (s *Store) record(ctx context.Context, action, key, detail string, oldValue, newValue *int64) error {
+	err := s.Store.AppendAudit(ctx, store.AuditEntry{
+		Time:     s.now(),
+		Actor:    Actor(ctx),
+		Action:   action,
+		Key:      key,
+		OldValue: oldValue,
+		NewValue: newValue,
+		Detail:   detail,
+	})
+	if err != nil {
+		return fmt.Errorf("audit %s on %s: %w", action, key, err)
+	}
+	return nil
+}
+
+// audited records action on key with detail after its change.  The change
+// already happened, so failures are only logged, and the entry is lost.
+func (s *Store) audited(ctx context.Context, action, key, detail string, oldValue, newValue *int64) {
+	if err := s.record(ctx, action, key, detail, oldValue, newValue); err != nil {
+		s.lost(err, action, key)
+	}
+}
+
+// lost logs err, which lost the entry for action on key.
+func (s *Store) lost(err error, action, key string) {
+	s.Logger.WithError(err).WithFields(logging.Fields{logging.FieldKey: key, "action": action}).Error("Audit change")
+}
+
+// quota returns the effective quota of key.
+func (s *Store) quota(ctx context.Context, key string) (*int64, error) {
+	check, err := s.Store.CheckQuota(ctx, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &check.Info.QuotaBytes, nil
+}
+
+// quotaObjects returns the effective quota of objects of key.
+func (s *Store) quotaObjects(ctx context.Context, key string) (*int64, error) {
+	check, err := s.Store.CheckQuota(ctx, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &check.Info.QuotaObjects, nil
+}
+
+// gracePeriod returns the grace period set on key in seconds, or nil if it
+// has none.
+func (s *Store) gracePeriod(ctx context.Context, key string) (*int64, error) {
+	gracePeriod, err := s.Store.GetGracePeriod(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seconds := int64(gracePeriod / time.Second)
+	return &seconds, nil
+}
+
+func (s *Store) Set(ctx context.Context, key string, value store.Value) error {
+	var oldValue *int64
+	old, err := s.Store.Get(ctx, key)
+	if err == nil {
+		oldValue = &old.SizeBytes
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	err = s.Store.Set(ctx, key, value)
+	if err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+		return err
+	}
+	s.audited(ctx, store.AuditSetUsage, key, "", oldValue, &value.SizeBytes)
+	return err
+}
+
+func (s *Store) SetQuota(ctx context.Context, key string, quotaBytes int64) error {
+	oldValue, err := s.quota(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.SetQuota(ctx, key, quotaBytes); err != nil {
+		return err
+	}
+	s.audited(ctx, store.AuditSetQuota, key, "", oldValue, &quotaBytes)
+	return nil
+}
+
+func (s *Store) ClearQuota(ctx context.Context, key string) error {
+	oldValue, err := s.quota(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.ClearQuota(ctx, key); err != nil {
+		return err
+	}
+	newValue, err := s.quota(ctx, key)
+	if err != nil {
+		s.lost(err, store.AuditClearQuota, key)
+		return nil
+	}
+	s.audited(ctx, store.AuditClearQuota, key, "", oldValue, newValue)
+	return nil
+}
+
+func (s *Store) SetObjectQuota(ctx context.Context, key string, quotaObjects int64) error {
+	oldValue, err := s.quotaObjects(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.SetObjectQuota(ctx, key, quotaObjects); err != nil {
+		return err
+	}
+	s.audited(ctx, store.AuditSetObjectQuota, key, "", oldValue, &quotaObjects)
+	return nil
+}
+
+func (s *Store) ClearObjectQuota(ctx context.Context, key string) error {
+	oldValue, err := s.quotaObjects(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.ClearObjectQuota(ctx, key); err != nil {
+		return err
+	}
+	newValue, err := s.quotaObjects(ctx, key)
+	if err != nil {
+		s.lost(err, store.AuditClearObjectQuota, key)
+		return nil
+	}
+	s.audited(ctx, store.AuditClearObjectQuota, key, "", oldValue, newValue)
+	return nil
+}
+
+func (s *Store) SetGracePeriod(ctx context.Context, key string, gracePeriod time.Duration) error {
+	oldValue, err := s.gracePeriod(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.SetGracePeriod(ctx, key, gracePeriod); err != nil {
+		return err
+	}
+	seconds := int64(gracePeriod / time.Second)
+	s.audited(ctx, store.AuditSetGracePeriod, key, "", oldValue, &seconds)
+	return nil
+}
+
+func (s *Store) ClearGracePeriod(ctx context.Context, key string) error {
+	oldValue, err := s.gracePeriod(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.ClearGracePeriod(ctx, key); err != nil {
+		return err
+	}
+	s.audited(ctx, store.AuditClearGracePeriod, key, "", oldValue, nil)
+	return nil
+}
+
+// plan returns the plan name, or nil if there is no such plan.
+func (s *Store) plan(ctx context.Context, name string) (*store.Plan, error) {
+	p, err := s.Store.GetPlan(ctx, name)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// planQuota returns the quota of p, or nil if it has none or is nil.
+func planQuota(p *store.Plan) *int64 {
+	if p == nil {
+		return nil
+	}
+	return p.QuotaBytes
+}
+
+// planDetail returns the name of the plan followed by every limit that
+// differs between oldPlan and newPlan, either of which may be nil.
+func planDetail(name string, oldPlan, newPlan *store.Plan) string {
+	var oldLimits, newLimits store.Plan
+	if oldPlan != nil {
+		oldLimits = *oldPlan
+	}
+	if newPlan != nil {
+		newLimits = *newPlan
+	}
+	changes := []struct{ name, old, new string }{
+		{"quota_bytes", formatLimit(oldLimits.QuotaBytes), formatLimit(newLimits.QuotaBytes)},
+		{"quota_objects", formatLimit(oldLimits.QuotaObjects), formatLimit(newLimits.QuotaObjects)},
+		{"rate_limits", formatRateLimits(oldLimits.RateLimits), formatRateLimits(newLimits.RateLimits)},
+	}
+	var changed []string
+	for _, c := range changes {
+		if c.old != c.new {
+			changed = append(changed, fmt.Sprintf("%s %s -> %s", c.name, c.old, c.new))
+		}
+	}
+	if len(changed) == 0 {
+		return name
+	}
+	return name + ": " + strings.Join(changed, ", ")
+}
+
+func formatLimit(limit *int64) string {
+	if limit == nil {
+		return "none"
+	}
+	return strconv.FormatInt(*limit, 10)
+}
+
+func formatRateLimits(limits []store.RateLimit) string {
+	if len(limits) == 0 {
+		return "none"
+	}
+	formatted := make([]string, 0, len(limits))
+	for _, l := range limits {
+		formatted = append(formatted, fmt.Sprintf("%d/%s", l.Bytes, l.Window))
+	}
+	return strings.Join(formatted, " ")
+}
+
+func (s *Store) SetPlan(ctx context.Context, p store.Plan) error {
+	oldPlan, err := s.plan(ctx, p.Name)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.SetPlan(ctx, p); err != nil {
+		return err
+	}
+	s.audited(ctx, store.AuditSetPlan, "", planDetail(p.Name, oldPlan, &p), planQuota(oldPlan), p.QuotaBytes)
+	return nil
+}
+
+func (s *Store) DeletePlan(ctx context.Context, name string) error {
+	oldPlan, err := s.plan(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.DeletePlan(ctx, name); err != nil {
+		return err
+	}
+	s.audited(ctx, store.AuditDeletePlan, "", planDetail(name, oldPlan, nil), planQuota(oldPlan), nil)
+	return nil
+}
+
+func (s *Store) AssignPlan(ctx context.Context, key, name string) error {
+	oldValue, err := s.quota(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.AssignPlan(ctx, key, name); err != nil {
+		return err
+	}
+	newValue, err := s.quota(ctx, key)
+	if err != nil {
+		s.lost(err, store.AuditAssignPlan, key)
+		return nil
+	}
+	s.audited(ctx, store.AuditAssignPlan, key, name, oldValue, newValue)
+	return nil
+}
+
+func (s *Store) UnassignPlan(ctx context.Context, key string) error {
+	check, err := s.Store.CheckQuota(ctx, key, 0)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.UnassignPlan(ctx, key); err != nil {
+		return err
+	}
+	newValue, err := s.quota(ctx, key)
+	if err != nil {
+		s.lost(err, store.AuditUnassignPlan, key)
+		return nil
+	}
+	s.audited(ctx, store.AuditUnassignPlan, key, check.Info.Plan, &check.Info.QuotaBytes, newValue)
+	return nil
+}
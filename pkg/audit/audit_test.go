@@ -0,0 +1,140 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/audit"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+const defaultQuota = 100
+
+func int64p(i int64) *int64 {
+	return &i
+}
+
+func TestStore(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := audit.NewStore(memory.NewStore(defaultQuota), logging.Discard())
+	s.Now = func() time.Time { return now }
+	ctx := audit.WithActor(context.Background(), "alice")
+
+	if err := s.SetQuota(ctx, "k", 7); err != nil {
+		t.Fatalf("SetQuota: %s", err)
+	}
+	if err := s.Set(ctx, "k", store.Value{SizeBytes: 3}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if err := s.ClearQuota(ctx, "k"); err != nil {
+		t.Fatalf("ClearQuota: %s", err)
+	}
+	if err := s.SetObjectQuota(ctx, "k", 2); err != nil {
+		t.Fatalf("SetObjectQuota: %s", err)
+	}
+	if err := s.ClearObjectQuota(ctx, "k"); err != nil {
+		t.Fatalf("ClearObjectQuota: %s", err)
+	}
+	if err := s.SetGracePeriod(ctx, "k", time.Hour); err != nil {
+		t.Fatalf("SetGracePeriod: %s", err)
+	}
+	if err := s.ClearGracePeriod(ctx, "k"); err != nil {
+		t.Fatalf("ClearGracePeriod: %s", err)
+	}
+	if err := s.SetPlan(ctx, store.Plan{Name: "team", QuotaBytes: int64p(20)}); err != nil {
+		t.Fatalf("SetPlan: %s", err)
+	}
+	if err := s.SetPlan(ctx, store.Plan{
+		Name:         "team",
+		QuotaBytes:   int64p(30),
+		QuotaObjects: int64p(5),
+		RateLimits:   []store.RateLimit{{Window: time.Hour, Bytes: 100}},
+	}); err != nil {
+		t.Fatalf("SetPlan again: %s", err)
+	}
+	if err := s.AssignPlan(ctx, "k", "team"); err != nil {
+		t.Fatalf("AssignPlan: %s", err)
+	}
+	if err := s.UnassignPlan(ctx, "k"); err != nil {
+		t.Fatalf("UnassignPlan: %s", err)
+	}
+	if err := s.DeletePlan(ctx, "team"); err != nil {
+		t.Fatalf("DeletePlan: %s", err)
+	}
+	// Usage tracked from objects is not audited.
+	if err := s.AddSizeBytes(context.Background(), "k", 5, time.Now()); err != nil {
+		t.Fatalf("AddSizeBytes: %s", err)
+	}
+	if err := s.Set(context.Background(), "k", store.Value{SizeBytes: 1}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	entries, err := s.ListAudit(ctx, store.AuditFilter{})
+	if err != nil {
+		t.Fatalf("ListAudit: %s", err)
+	}
+	expected := []store.AuditEntry{
+		{ID: 1, Time: now, Actor: "alice", Action: store.AuditSetQuota, Key: "k", OldValue: int64p(defaultQuota), NewValue: int64p(7)},
+		{ID: 2, Time: now, Actor: "alice", Action: store.AuditSetUsage, Key: "k", OldValue: int64p(0), NewValue: int64p(3)},
+		{ID: 3, Time: now, Actor: "alice", Action: store.AuditClearQuota, Key: "k", OldValue: int64p(7), NewValue: int64p(defaultQuota)},
+		{ID: 4, Time: now, Actor: "alice", Action: store.AuditSetObjectQuota, Key: "k", OldValue: int64p(0), NewValue: int64p(2)},
+		{ID: 5, Time: now, Actor: "alice", Action: store.AuditClearObjectQuota, Key: "k", OldValue: int64p(2), NewValue: int64p(0)},
+		{ID: 6, Time: now, Actor: "alice", Action: store.AuditSetGracePeriod, Key: "k", NewValue: int64p(3600)},
+		{ID: 7, Time: now, Actor: "alice", Action: store.AuditClearGracePeriod, Key: "k", OldValue: int64p(3600)},
+		{ID: 8, Time: now, Actor: "alice", Action: store.AuditSetPlan, NewValue: int64p(20), Detail: "team: quota_bytes none -> 20"},
+		{ID: 9, Time: now, Actor: "alice", Action: store.AuditSetPlan, OldValue: int64p(20), NewValue: int64p(30), Detail: "team: quota_bytes 20 -> 30, quota_objects none -> 5, rate_limits none -> 100/1h0m0s"},
+		{ID: 10, Time: now, Actor: "alice", Action: store.AuditAssignPlan, Key: "k", OldValue: int64p(defaultQuota), NewValue: int64p(30), Detail: "team"},
+		{ID: 11, Time: now, Actor: "alice", Action: store.AuditUnassignPlan, Key: "k", OldValue: int64p(30), NewValue: int64p(defaultQuota), Detail: "team"},
+		{ID: 12, Time: now, Actor: "alice", Action: store.AuditDeletePlan, OldValue: int64p(30), Detail: "team: quota_bytes 30 -> none, quota_objects 5 -> none, rate_limits 100/1h0m0s -> none"},
+		{ID: 13, Time: now, Actor: audit.SystemActor, Action: store.AuditSetUsage, Key: "k", OldValue: int64p(8), NewValue: int64p(1)},
+	}
+	if diffs := deep.Equal(entries, expected); diffs != nil {
+		t.Errorf("Unexpected audit log: %s", diffs)
+	}
+}
+
+var errAudit = errors.New("audit log unavailable")
+
+// failingAudit is a store.Store whose audit log fails every append.
+type failingAudit struct {
+	store.Store
+}
+
+func (failingAudit) AppendAudit(context.Context, store.AuditEntry) error {
+	return errAudit
+}
+
+func TestStoreKeepsChangesWhenAuditFails(t *testing.T) {
+	s := audit.NewStore(failingAudit{memory.NewStore(defaultQuota)}, logging.Discard())
+	ctx := context.Background()
+
+	if err := s.SetQuota(ctx, "k", 7); err != nil {
+		t.Fatalf("SetQuota: %s", err)
+	}
+	if err := s.Set(ctx, "k", store.Value{SizeBytes: 9}); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("Got Set error %v expected %v", err, store.ErrQuotaExceeded)
+	}
+	if err := s.SetPlan(ctx, store.Plan{Name: "team", QuotaBytes: int64p(20)}); err != nil {
+		t.Fatalf("SetPlan: %s", err)
+	}
+	if err := s.AssignPlan(ctx, "k", "team"); err != nil {
+		t.Fatalf("AssignPlan: %s", err)
+	}
+	if err := s.ClearQuota(ctx, "k"); err != nil {
+		t.Fatalf("ClearQuota: %s", err)
+	}
+
+	check, err := s.CheckQuota(ctx, "k", 0)
+	if err != nil {
+		t.Fatalf("CheckQuota: %s", err)
+	}
+	if check.Info.QuotaBytes != 20 || check.Info.UsageBytes != 9 {
+		t.Errorf("Got quota %d and usage %d, expected 20 and 9", check.Info.QuotaBytes, check.Info.UsageBytes)
+	}
+}
@@ -0,0 +1,122 @@
+// Package auth authenticates requests to the Terminus HTTP APIs.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/logging"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator for requests that
+	// carry none of its credentials.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrUnauthenticated is returned by an Authenticator for requests
+	// with bad credentials.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrUnknownRole     = errors.New("unknown role")
+)
+
+// Role is the set of endpoints that a principal may access.
+type Role string
+
+const (
+	// RoleUser may access the REST API.
+	RoleUser Role = "user"
+	// RoleAdmin may access everything, including profiling and
+	// administration endpoints.
+	RoleAdmin Role = "admin"
+)
+
+// ParseRole returns the Role named s.
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case RoleUser, RoleAdmin:
+		return r, nil
+	}
+	return "", fmt.Errorf("%s: %w", s, ErrUnknownRole)
+}
+
+// Allows returns whether r may access endpoints that require role.
+func (r Role) Allows(role Role) bool {
+	return r == RoleAdmin || r == role
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Name string
+	Role Role
+}
+
+// Authenticator authenticates requests.
+type Authenticator interface {
+	// Authenticate returns the principal that sent r.  It returns
+	// ErrNoCredentials if r has no credentials that it understands,
+	// or ErrUnauthenticated if they are bad.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain authenticates requests using the first of its authenticators that
+// finds credentials on them.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return nil, ErrNoCredentials
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context that carries p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, or nil.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Require returns middleware that serves only requests authenticated by a
+// with a principal allowed role.  Handlers find the principal using
+// FromContext.
+func Require(a Authenticator, role Role, l logging.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := a.Authenticate(r)
+			if err != nil {
+				if !errors.Is(err, ErrNoCredentials) {
+					l.WithError(err).WithField("remote_addr", r.RemoteAddr).Warn("Authentication failed")
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer realm="terminus"`)
+				writeError(w, http.StatusUnauthorized, "Unauthenticated")
+				return
+			}
+			if !p.Role.Allows(role) {
+				l.WithFields(logging.Fields{"principal": p.Name, "role": p.Role}).Warn("Forbidden")
+				writeError(w, http.StatusForbidden, fmt.Sprintf("Role %s required", role))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+// writeError writes an api.Error with message to w.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(api.Error{Message: message})
+}
@@ -0,0 +1,185 @@
+package auth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/auth"
+	"github.com/treeverse/terminus/pkg/logging"
+)
+
+var (
+	alice = auth.Principal{Name: "alice", Role: auth.RoleUser}
+	admin = auth.Principal{Name: "root", Role: auth.RoleAdmin}
+	hooks = auth.Principal{Name: "lakefs-hooks", Role: auth.RoleUser}
+)
+
+func newRequest(t *testing.T, method, target, body string) *http.Request {
+	t.Helper()
+	return httptest.NewRequest(method, target, strings.NewReader(body))
+}
+
+func TestBearerTokens(t *testing.T) {
+	a := auth.NewBearerTokens(map[string]auth.BearerToken{
+		"alice-token": {Principal: alice},
+		"hooks-token": {Principal: hooks, AllowQuery: true},
+	})
+	cases := []struct {
+		Name     string
+		Header   string
+		Target   string
+		Expected *auth.Principal
+		Err      error
+	}{
+		{"Header", "Bearer alice-token", "/", &alice, nil},
+		{"QueryParam", "", "/?access_token=hooks-token", &hooks, nil},
+		{"QueryParamNotAllowed", "", "/?access_token=alice-token", nil, auth.ErrUnauthenticated},
+		{"BadToken", "Bearer bob-token", "/", nil, auth.ErrUnauthenticated},
+		{"OtherScheme", "Basic YWxpY2U6", "/", nil, auth.ErrNoCredentials},
+		{"None", "", "/", nil, auth.ErrNoCredentials},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			r := newRequest(t, http.MethodGet, c.Target, "")
+			if c.Header != "" {
+				r.Header.Set("Authorization", c.Header)
+			}
+			p, err := a.Authenticate(r)
+			if !errors.Is(err, c.Err) {
+				t.Errorf("Expected error %v, got %v", c.Err, err)
+			}
+			if diffs := deep.Equal(p, c.Expected); diffs != nil {
+				t.Errorf("Unexpected principal: %s", diffs)
+			}
+		})
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secret := []byte("s3cr3t")
+	a := &auth.HMAC{
+		Keys: map[string]auth.HMACKey{"k1": {Secret: secret, Principal: admin}},
+		Now:  func() time.Time { return now },
+	}
+	cases := []struct {
+		Name   string
+		KeyID  string
+		Secret []byte
+		SignAt time.Time
+		Tamper func(r *http.Request)
+		Err    error
+	}{
+		{Name: "OK", KeyID: "k1", Secret: secret, SignAt: now},
+		{Name: "UnknownKey", KeyID: "k2", Secret: secret, SignAt: now, Err: auth.ErrUnauthenticated},
+		{Name: "BadSecret", KeyID: "k1", Secret: []byte("guess"), SignAt: now, Err: auth.ErrUnauthenticated},
+		{Name: "Skewed", KeyID: "k1", Secret: secret, SignAt: now.Add(-time.Hour), Err: auth.ErrUnauthenticated},
+		{
+			Name: "TamperedPath", KeyID: "k1", Secret: secret, SignAt: now,
+			Tamper: func(r *http.Request) { r.URL.Path = "/other" },
+			Err:    auth.ErrUnauthenticated,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			r := newRequest(t, http.MethodPost, "/quota/check", `{"Key": "alice"}`)
+			if err := auth.SignRequest(r, c.KeyID, c.Secret, c.SignAt); err != nil {
+				t.Fatalf("Sign: %s", err)
+			}
+			if c.Tamper != nil {
+				c.Tamper(r)
+			}
+			p, err := a.Authenticate(r)
+			if !errors.Is(err, c.Err) {
+				t.Fatalf("Expected error %v, got %v", c.Err, err)
+			}
+			if err == nil && *p != admin {
+				t.Errorf("Got principal %+v expected %+v", p, admin)
+			}
+		})
+	}
+}
+
+func TestHMACBodyTooLarge(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secret := []byte("s3cr3t")
+	a := &auth.HMAC{
+		Keys:         map[string]auth.HMACKey{"k1": {Secret: secret, Principal: admin}},
+		MaxBodyBytes: 8,
+		Now:          func() time.Time { return now },
+	}
+	r := newRequest(t, http.MethodPost, "/quota/check", `{"Key": "alice"}`)
+	if err := auth.SignRequest(r, "k1", secret, now); err != nil {
+		t.Fatalf("Sign: %s", err)
+	}
+	if _, err := a.Authenticate(r); !errors.Is(err, auth.ErrUnauthenticated) {
+		t.Errorf("Expected error %v, got %v", auth.ErrUnauthenticated, err)
+	}
+}
+
+func TestClientCerts(t *testing.T) {
+	a := &auth.ClientCerts{Roles: map[string]auth.Role{"root": auth.RoleAdmin}}
+	withCert := func(cn string) *http.Request {
+		r := newRequest(t, http.MethodGet, "/", "")
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}}
+		return r
+	}
+
+	if p, err := a.Authenticate(withCert("root")); err != nil || *p != admin {
+		t.Errorf("Authenticate root: got %+v, %v", p, err)
+	}
+	if _, err := a.Authenticate(withCert("mallory")); !errors.Is(err, auth.ErrUnauthenticated) {
+		t.Errorf("Authenticate unknown certificate: expected %s, got %v", auth.ErrUnauthenticated, err)
+	}
+	if _, err := a.Authenticate(newRequest(t, http.MethodGet, "/", "")); !errors.Is(err, auth.ErrNoCredentials) {
+		t.Errorf("Authenticate without TLS: expected %s, got %v", auth.ErrNoCredentials, err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	a := auth.NewBearerTokens(map[string]auth.BearerToken{"alice-token": {Principal: alice}, "root-token": {Principal: admin}})
+	var principal *auth.Principal
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = auth.FromContext(r.Context())
+	})
+
+	cases := []struct {
+		Name   string
+		Role   auth.Role
+		Token  string
+		Status int
+	}{
+		{"UserAsUser", auth.RoleUser, "alice-token", http.StatusOK},
+		{"UserAsAdmin", auth.RoleUser, "root-token", http.StatusOK},
+		{"AdminAsUser", auth.RoleAdmin, "alice-token", http.StatusForbidden},
+		{"AdminAsAdmin", auth.RoleAdmin, "root-token", http.StatusOK},
+		{"NoToken", auth.RoleUser, "", http.StatusUnauthorized},
+		{"BadToken", auth.RoleUser, "bad-token", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			principal = nil
+			r := newRequest(t, http.MethodGet, "/", "")
+			if c.Token != "" {
+				r.Header.Set("Authorization", "Bearer "+c.Token)
+			}
+			w := httptest.NewRecorder()
+			auth.Require(a, c.Role, logging.Discard())(handler).ServeHTTP(w, r)
+			if w.Code != c.Status {
+				t.Errorf("Got status %d expected %d", w.Code, c.Status)
+			}
+			if (principal != nil) != (c.Status == http.StatusOK) {
+				t.Errorf("Got principal %+v on status %d", principal, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// accessTokenParam is the query parameter that may carry a bearer token,
+// for callers such as lakeFS hooks that cannot set headers.
+const accessTokenParam = "access_token"
+
+// BearerToken configures a static bearer token.
+type BearerToken struct {
+	Principal Principal
+	// AllowQuery lets the token authenticate in the access_token query
+	// parameter.  URLs end up in access logs, so allow it only for
+	// callers that cannot set headers, such as lakeFS hooks.
+	AllowQuery bool
+}
+
+// BearerTokens authenticates requests that carry static bearer tokens.
+type BearerTokens struct {
+	// tokens maps SHA-256 hashes of tokens to their configuration, so
+	// lookups do not leak tokens through timing.
+	tokens map[[sha256.Size]byte]*BearerToken
+}
+
+// NewBearerTokens returns BearerTokens that authenticate each token in
+// tokens as configured.
+func NewBearerTokens(tokens map[string]BearerToken) *BearerTokens {
+	b := &BearerTokens{tokens: make(map[[sha256.Size]byte]*BearerToken, len(tokens))}
+	for token, t := range tokens {
+		t := t
+		b.tokens[sha256.Sum256([]byte(token))] = &t
+	}
+	return b
+}
+
+func (b *BearerTokens) Authenticate(r *http.Request) (*Principal, error) {
+	var token string
+	inQuery := false
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		scheme, credentials, _ := strings.Cut(authorization, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return nil, ErrNoCredentials
+		}
+		token = strings.TrimSpace(credentials)
+	} else {
+		token = r.URL.Query().Get(accessTokenParam)
+		inQuery = true
+	}
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	t, ok := b.tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if inQuery && !t.AllowQuery {
+		return nil, fmt.Errorf("token of %s in %s parameter: %w", t.Principal.Name, accessTokenParam, ErrUnauthenticated)
+	}
+	return &t.Principal, nil
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config configures authentication.  It is read from JSON files such as:
+//
+//	{
+//	  "Tokens": [{"Token": "...", "Name": "lakefs-hooks", "Role": "user", "AllowQuery": true}],
+//	  "HMACKeys": [{"KeyID": "ops", "Secret": "...", "Name": "ops", "Role": "admin"}],
+//	  "ClientCerts": {"Roles": {"prometheus": "admin"}, "DefaultRole": "user"}
+//	}
+type Config struct {
+	Tokens      []TokenConfig
+	HMACKeys    []HMACKeyConfig
+	ClientCerts *ClientCertsConfig
+}
+
+// TokenConfig configures a bearer token.
+type TokenConfig struct {
+	Token string
+	Name  string
+	Role  string
+	// AllowQuery accepts the token in the access_token query parameter
+	// as well as in the Authorization header.  Only enable it for
+	// callers that cannot set headers, such as lakeFS hooks.
+	AllowQuery bool
+}
+
+// HMACKeyConfig configures an HMAC signing key.
+type HMACKeyConfig struct {
+	KeyID  string
+	Secret string
+	Name   string
+	Role   string
+}
+
+// ClientCertsConfig configures roles of TLS client certificates.
+type ClientCertsConfig struct {
+	// Roles maps certificate common names to roles.
+	Roles       map[string]string
+	DefaultRole string
+}
+
+// LoadConfig reads a Config from the JSON file at path.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open auth config: %w", err)
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	var cfg Config
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parse auth config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Authenticator returns a Chain of the authenticators configured by c.
+func (c *Config) Authenticator() (Authenticator, error) {
+	var chain Chain
+	if len(c.Tokens) > 0 {
+		tokens := make(map[string]BearerToken, len(c.Tokens))
+		for _, t := range c.Tokens {
+			role, err := ParseRole(t.Role)
+			if err != nil {
+				return nil, fmt.Errorf("token %s: %w", t.Name, err)
+			}
+			tokens[t.Token] = BearerToken{Principal: Principal{Name: t.Name, Role: role}, AllowQuery: t.AllowQuery}
+		}
+		chain = append(chain, NewBearerTokens(tokens))
+	}
+	if len(c.HMACKeys) > 0 {
+		h := &HMAC{Keys: make(map[string]HMACKey, len(c.HMACKeys))}
+		for _, k := range c.HMACKeys {
+			role, err := ParseRole(k.Role)
+			if err != nil {
+				return nil, fmt.Errorf("HMAC key %s: %w", k.KeyID, err)
+			}
+			h.Keys[k.KeyID] = HMACKey{Secret: []byte(k.Secret), Principal: Principal{Name: k.Name, Role: role}}
+		}
+		chain = append(chain, h)
+	}
+	if c.ClientCerts != nil {
+		certs := &ClientCerts{Roles: make(map[string]Role, len(c.ClientCerts.Roles))}
+		for name, r := range c.ClientCerts.Roles {
+			role, err := ParseRole(r)
+			if err != nil {
+				return nil, fmt.Errorf("client certificate %s: %w", name, err)
+			}
+			certs.Roles[name] = role
+		}
+		if c.ClientCerts.DefaultRole != "" {
+			role, err := ParseRole(c.ClientCerts.DefaultRole)
+			if err != nil {
+				return nil, fmt.Errorf("client certificate default: %w", err)
+			}
+			certs.DefaultRole = role
+		}
+		chain = append(chain, certs)
+	}
+	return chain, nil
+}
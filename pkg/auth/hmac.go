@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HMACScheme is the Authorization scheme of HMAC-signed requests:
+	//
+	//	Authorization: HMAC-SHA256 KeyId=<id>,Timestamp=<unix seconds>,Signature=<hex>
+	//
+	// The signature is the HMAC-SHA256 with the secret of KeyId of the
+	// method, request URI, timestamp and hex SHA-256 of the body, each
+	// on its own line.
+	HMACScheme = "HMAC-SHA256"
+
+	// DefaultMaxSkew is the default maximal difference between the
+	// timestamp of an HMAC-signed request and the time it is received.
+	DefaultMaxSkew = 5 * time.Minute
+
+	// DefaultMaxBodyBytes is the default maximal size of the body of an
+	// HMAC-signed request.
+	DefaultMaxBodyBytes = 1 << 20
+)
+
+// HMACKey is a secret that signs requests of a principal.
+type HMACKey struct {
+	Secret    []byte
+	Principal Principal
+}
+
+// HMAC authenticates HMAC-signed requests.
+type HMAC struct {
+	// Keys maps key IDs to keys.
+	Keys    map[string]HMACKey
+	MaxSkew time.Duration
+	// MaxBodyBytes is the maximal size of bodies to read and check, or
+	// 0 for DefaultMaxBodyBytes.  Bodies are read before they are
+	// authenticated, so this bounds what any caller can make the server
+	// buffer.
+	MaxBodyBytes int64
+	// Now returns the current time, or nil for time.Now.
+	Now func() time.Time
+}
+
+// signature returns the signature of a request with body and timestamp.
+func signature(secret []byte, method, requestURI string, timestamp int64, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s", method, requestURI, timestamp, hex.EncodeToString(bodyHash[:]))
+	return mac.Sum(nil)
+}
+
+// readBody returns the body of r, and replaces it so it can be read again.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// SignRequest signs r with secret of keyID at now.
+func SignRequest(r *http.Request, keyID string, secret []byte, now time.Time) error {
+	body, err := readBody(r)
+	if err != nil {
+		return fmt.Errorf("read body to sign: %w", err)
+	}
+	timestamp := now.Unix()
+	sig := signature(secret, r.Method, r.URL.RequestURI(), timestamp, body)
+	r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s,Timestamp=%d,Signature=%s",
+		HMACScheme, keyID, timestamp, hex.EncodeToString(sig)))
+	return nil
+}
+
+func (h *HMAC) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme != HMACScheme {
+		return nil, ErrNoCredentials
+	}
+	var keyID, timestampStr, sigHex string
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "KeyId":
+			keyID = value
+		case "Timestamp":
+			timestampStr = value
+		case "Signature":
+			sigHex = value
+		}
+	}
+	key, ok := h.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q: %w", keyID, ErrUnauthenticated)
+	}
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad timestamp %q: %w", timestampStr, ErrUnauthenticated)
+	}
+	now := time.Now
+	if h.Now != nil {
+		now = h.Now
+	}
+	maxSkew := h.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	if skew := now().Sub(time.Unix(timestamp, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, fmt.Errorf("timestamp skewed by %s: %w", skew, ErrUnauthenticated)
+	}
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return nil, fmt.Errorf("bad signature: %w", ErrUnauthenticated)
+	}
+	maxBodyBytes := h.MaxBodyBytes
+	if maxBodyBytes == 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(nil, r.Body, maxBodyBytes)
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %s: %w", err, ErrUnauthenticated)
+	}
+	if !hmac.Equal(sig, signature(key.Secret, r.Method, r.URL.RequestURI(), timestamp, body)) {
+		return nil, fmt.Errorf("signature mismatch for key ID %s: %w", keyID, ErrUnauthenticated)
+	}
+	return &key.Principal, nil
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ClientCerts authenticates requests by their verified TLS client
+// certificates.  The TLS server must verify client certificates, e.g. with
+// tls.VerifyClientCertIfGiven.
+type ClientCerts struct {
+	// Roles maps certificate common names to their roles.
+	Roles map[string]Role
+	// DefaultRole is the role of other verified certificates, or empty
+	// to reject them.
+	DefaultRole Role
+}
+
+func (c *ClientCerts) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	role, ok := c.Roles[name]
+	if !ok {
+		role = c.DefaultRole
+	}
+	if role == "" {
+		return nil, fmt.Errorf("client certificate %s: %w", name, ErrUnauthenticated)
+	}
+	return &Principal{Name: name, Role: role}, nil
+}
@@ -0,0 +1,70 @@
+// Package billing reports usage integrated over time, for billing by
+// byte-hours and GB-months.
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+var ErrBadMonth = errors.New("bad month")
+
+// MonthLayout is the layout of months, e.g. "2026-09".
+const MonthLayout = "2006-01"
+
+// BytesPerGB is the number of bytes in a GB, as storage is usually billed.
+const BytesPerGB = 1 << 30
+
+// ParseMonth returns the start and end of month, a MonthLayout in UTC.
+func ParseMonth(month string) (from, to time.Time, err error) {
+	from, err = time.Parse(MonthLayout, month)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%s: %w", month, ErrBadMonth)
+	}
+	return from, from.AddDate(0, 1, 0), nil
+}
+
+// Line is the usage of a key over a period.
+type Line struct {
+	Key       string
+	ByteHours float64
+	// GBMonths is ByteHours in GB over the hours of the period.  Over
+	// a month it is the GB-months used.
+	GBMonths float64
+}
+
+// Report is the usage of every key over a period.
+type Report struct {
+	From time.Time
+	To   time.Time
+	// Lines are in order of key.
+	Lines []Line
+}
+
+// NewReport returns the usage of every key of s over [from, to).  A
+// period in progress reports usage accrued until now, GBMonths are still
+// of the whole period.
+func NewReport(ctx context.Context, s store.Store, from, to time.Time) (*Report, error) {
+	end := to
+	if now := time.Now(); now.Before(end) {
+		end = now
+	}
+	integrals, err := s.ByteHours(ctx, from, end)
+	if err != nil {
+		return nil, fmt.Errorf("integrate usage: %w", err)
+	}
+	hours := to.Sub(from).Hours()
+	report := &Report{From: from, To: to, Lines: make([]Line, 0, len(integrals))}
+	for _, i := range integrals {
+		report.Lines = append(report.Lines, Line{
+			Key:       i.Key,
+			ByteHours: i.ByteHours,
+			GBMonths:  i.ByteHours / BytesPerGB / hours,
+		})
+	}
+	return report, nil
+}
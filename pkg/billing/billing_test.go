@@ -0,0 +1,70 @@
+package billing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/billing"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+func TestParseMonth(t *testing.T) {
+	cases := []struct {
+		Month    string
+		From, To time.Time
+		Err      error
+	}{
+		{Month: "2026-09", From: time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{Month: "2026-12", From: time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Month: "2026-13", Err: billing.ErrBadMonth},
+		{Month: "September", Err: billing.ErrBadMonth},
+		{Month: "", Err: billing.ErrBadMonth},
+	}
+	for _, c := range cases {
+		t.Run(c.Month, func(t *testing.T) {
+			from, to, err := billing.ParseMonth(c.Month)
+			if !errors.Is(err, c.Err) {
+				t.Fatalf("Expected error %v, got %v", c.Err, err)
+			}
+			if !from.Equal(c.From) || !to.Equal(c.To) {
+				t.Errorf("Got [%s, %s), expected [%s, %s)", from, to, c.From, c.To)
+			}
+		})
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStore(10 * billing.BytesPerGB)
+	from, to, err := billing.ParseMonth("2026-09")
+	if err != nil {
+		t.Fatalf("Parse month: %s", err)
+	}
+	// "a" uses 2 GB all month, "b" 3 GB for the last 10 of its 30 days.
+	if err = s.AddSizeBytes(ctx, "a", 2*billing.BytesPerGB, from.AddDate(0, -1, 0)); err != nil {
+		t.Fatalf("AddSizeBytes a: %s", err)
+	}
+	if err = s.AddSizeBytes(ctx, "b", 3*billing.BytesPerGB, from.AddDate(0, 0, 20)); err != nil {
+		t.Fatalf("AddSizeBytes b: %s", err)
+	}
+
+	report, err := billing.NewReport(ctx, s, from, to)
+	if err != nil {
+		t.Fatalf("NewReport: %s", err)
+	}
+	expected := &billing.Report{
+		From: from,
+		To:   to,
+		Lines: []billing.Line{
+			{Key: "a", ByteHours: 2 * billing.BytesPerGB * 720, GBMonths: 2},
+			{Key: "b", ByteHours: 3 * billing.BytesPerGB * 240, GBMonths: 1},
+		},
+	}
+	if diffs := deep.Equal(report, expected); diffs != nil {
+		t.Errorf("Report: %s", diffs)
+	}
+}
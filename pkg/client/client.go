@@ -0,0 +1,422 @@
+// Package client is a Go client of the Terminus HTTP APIs, described by
+// api.Spec.  It sends and receives the request and response types of
+// package api, and the tests of package http check those types against
+// the schemas of every operation of the spec.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/auth"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+const (
+	restPrefix  = "/internal/api/v1"
+	adminPrefix = "/internal/admin/v1"
+)
+
+// Error is an error response from the server.  It wraps store.ErrNotFound
+// and store.ErrQuotaExceeded on matching statuses.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return store.ErrNotFound
+	case http.StatusConflict:
+		return store.ErrQuotaExceeded
+	}
+	return nil
+}
+
+// RequestEditor edits every request before it is sent, e.g. to
+// authenticate it.
+type RequestEditor func(r *http.Request) error
+
+// WithBearerToken returns a RequestEditor that authenticates with token.
+func WithBearerToken(token string) RequestEditor {
+	return func(r *http.Request) error {
+		r.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+}
+
+// WithHMAC returns a RequestEditor that signs requests with secret of
+// keyID.
+func WithHMAC(keyID string, secret []byte) RequestEditor {
+	return func(r *http.Request) error {
+		return auth.SignRequest(r, keyID, secret, time.Now())
+	}
+}
+
+// Client calls a Terminus server.  When administration is served on a
+// separate address, use a separate Client for it.
+type Client struct {
+	Server *url.URL
+	// HTTPClient sends requests, or nil for http.DefaultClient.
+	HTTPClient *http.Client
+	Editors    []RequestEditor
+}
+
+// New returns a Client of the server at serverURL.
+func New(serverURL string, editors ...RequestEditor) (*Client, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse server URL: %w", err)
+	}
+	return &Client{Server: u, Editors: editors}, nil
+}
+
+// escapeKey escapes key for use as a path.  Keys may contain "/", which
+// the server takes as part of the key.
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// do sends a request with a JSON body to the escaped path with query, and
+// decodes a JSON response into out, or copies it if out is an io.Writer.
+// It returns the response status, or an *Error if it is not one of
+// okStatuses.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}, okStatuses ...int) (int, error) {
+	u, err := c.Server.Parse(strings.TrimSuffix(c.Server.Path, "/") + path)
+	if err != nil {
+		return 0, fmt.Errorf("parse path %s: %w", path, err)
+	}
+	u.RawQuery = query.Encode()
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reqBody)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, edit := range c.Editors {
+		if err := edit(req); err != nil {
+			return 0, fmt.Errorf("edit request: %w", err)
+		}
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	for _, status := range okStatuses {
+		if resp.StatusCode != status {
+			continue
+		}
+		if status == http.StatusNoContent {
+			return status, nil
+		}
+		switch o := out.(type) {
+		case nil:
+		case io.Writer:
+			if _, err := io.Copy(o, resp.Body); err != nil {
+				return status, fmt.Errorf("read response: %w", err)
+			}
+		default:
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return status, fmt.Errorf("decode response: %w", err)
+			}
+		}
+		return status, nil
+	}
+	var apiErr api.Error
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		apiErr.Message = "(no error message)"
+	}
+	return resp.StatusCode, &Error{StatusCode: resp.StatusCode, Message: apiErr.Message}
+}
+
+// GetKey returns the usage and quota of key.
+func (c *Client) GetKey(ctx context.Context, key string) (*store.Record, error) {
+	var resp store.Record
+	if _, err := c.do(ctx, http.MethodGet, restPrefix+"/keys/"+escapeKey(key), nil, nil, &resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListKeys returns up to limit keys after the key after, sorted by key, and
+// the after of the next page, which is empty on the last page.  The server
+// may return fewer keys than limit.
+func (c *Client) ListKeys(ctx context.Context, after string, limit int) ([]store.Record, string, error) {
+	query := url.Values{}
+	if after != "" {
+		query.Set("after", after)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var resp api.ListKeysResponse
+	_, err := c.do(ctx, http.MethodGet, restPrefix+"/keys", query, nil, &resp, http.StatusOK)
+	return resp.Records, resp.Next, err
+}
+
+// GetUsage returns the usage and quota of key, and its forecast.
+func (c *Client) GetUsage(ctx context.Context, key string) (*api.UsageResponse, error) {
+	var resp api.UsageResponse
+	if _, err := c.do(ctx, http.MethodGet, restPrefix+"/usage/"+escapeKey(key), nil, nil, &resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListForecast returns keys projected to exceed quota within within,
+// soonest first.  Zero within uses the server default.
+func (c *Client) ListForecast(ctx context.Context, within time.Duration) ([]api.KeyForecast, error) {
+	query := url.Values{}
+	if within > 0 {
+		query.Set("within", within.String())
+	}
+	var resp api.ForecastResponse
+	_, err := c.do(ctx, http.MethodGet, restPrefix+"/quota/forecast", query, nil, &resp, http.StatusOK)
+	return resp.Forecasts, err
+}
+
+// Billing returns the usage of every key integrated over month, e.g.
+// "2026-09".
+func (c *Client) Billing(ctx context.Context, month string) (*api.BillingResponse, error) {
+	query := url.Values{"month": []string{month}}
+	var resp api.BillingResponse
+	if _, err := c.do(ctx, http.MethodGet, restPrefix+"/billing", query, nil, &resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetHistory returns the usage history of key in [from, to), in order of
+// time.  If step is positive it returns the last point in each interval
+// of step.  Zero from or to use the server defaults.
+func (c *Client) GetHistory(ctx context.Context, key string, from, to time.Time, step time.Duration) ([]store.HistoryPoint, error) {
+	query := url.Values{}
+	if !from.IsZero() {
+		query.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		query.Set("to", to.Format(time.RFC3339))
+	}
+	if step > 0 {
+		query.Set("step", step.String())
+	}
+	var resp api.HistoryResponse
+	_, err := c.do(ctx, http.MethodGet, restPrefix+"/usage-history/"+escapeKey(key), query, nil, &resp, http.StatusOK)
+	return resp.Points, err
+}
+
+// ExportUsage writes all keys in format, one of export.Formats, to w.
+func (c *Client) ExportUsage(ctx context.Context, format string, w io.Writer) error {
+	_, err := c.do(ctx, http.MethodGet, restPrefix+"/usage/export", url.Values{"format": {format}}, nil, w, http.StatusOK)
+	return err
+}
+
+// GetExceeded returns all keys over quota, sorted by key.
+func (c *Client) GetExceeded(ctx context.Context) ([]store.Record, error) {
+	var resp api.ExceededResponse
+	_, err := c.do(ctx, http.MethodGet, restPrefix+"/quota/exceeded", nil, nil, &resp, http.StatusOK)
+	return resp.Records, err
+}
+
+// GetRateExceeded returns the keys that ingest more than a rate quota, by
+// key.
+func (c *Client) GetRateExceeded(ctx context.Context) ([]api.RateStatus, error) {
+	var resp api.RateExceededResponse
+	_, err := c.do(ctx, http.MethodGet, restPrefix+"/quota/rate-exceeded", nil, nil, &resp, http.StatusOK)
+	return resp.Statuses, err
+}
+
+// GetAnomalies returns the keys whose ingest is anomalous, by key.
+func (c *Client) GetAnomalies(ctx context.Context) (*api.AnomaliesResponse, error) {
+	var resp api.AnomaliesResponse
+	if _, err := c.do(ctx, http.MethodGet, restPrefix+"/anomalies", nil, nil, &resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CheckQuota returns whether a key may grow.
+func (c *Client) CheckQuota(ctx context.Context, req api.CheckQuotaRequest) (*api.CheckQuotaResponse, error) {
+	var resp api.CheckQuotaResponse
+	if _, err := c.do(ctx, http.MethodPost, restPrefix+"/quota/check", nil, req, &resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Reserve reserves quota for an upload.  It returns nil if the path is not
+// tracked, and an error wrapping store.ErrQuotaExceeded if the
+// reservation does not fit.
+func (c *Client) Reserve(ctx context.Context, req api.ReserveRequest) (*store.Reservation, error) {
+	var resp store.Reservation
+	status, err := c.do(ctx, http.MethodPost, restPrefix+"/quota/reservations", nil, req, &resp, http.StatusCreated, http.StatusNoContent)
+	if err != nil || status == http.StatusNoContent {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ReleaseReservation releases the reservation id.
+func (c *Client) ReleaseReservation(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, restPrefix+"/quota/reservations/"+url.PathEscape(id), nil, nil, nil, http.StatusNoContent)
+	return err
+}
+
+// SetQuota sets the quota of key.  It requires the admin role.
+func (c *Client) SetQuota(ctx context.Context, key string, quotaBytes int64) error {
+	_, err := c.do(ctx, http.MethodPut, adminPrefix+"/quota/"+escapeKey(key), nil,
+		api.SetQuotaRequest{QuotaBytes: quotaBytes}, nil, http.StatusNoContent)
+	return err
+}
+
+// ClearQuota clears the quota of key.  It requires the admin role.
+func (c *Client) ClearQuota(ctx context.Context, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, adminPrefix+"/quota/"+escapeKey(key), nil, nil, nil, http.StatusNoContent)
+	return err
+}
+
+// SetObjectQuota sets the quota of objects of key, or removes it if
+// quotaObjects is 0.  It requires the admin role.
+func (c *Client) SetObjectQuota(ctx context.Context, key string, quotaObjects int64) error {
+	_, err := c.do(ctx, http.MethodPut, adminPrefix+"/object-quota/"+escapeKey(key), nil,
+		api.SetObjectQuotaRequest{QuotaObjects: quotaObjects}, nil, http.StatusNoContent)
+	return err
+}
+
+// ClearObjectQuota clears the quota of objects of key.  It requires the
+// admin role.
+func (c *Client) ClearObjectQuota(ctx context.Context, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, adminPrefix+"/object-quota/"+escapeKey(key), nil, nil, nil, http.StatusNoContent)
+	return err
+}
+
+// SetGracePeriod sets the grace period of key, truncated to seconds.  It
+// requires the admin role.
+func (c *Client) SetGracePeriod(ctx context.Context, key string, gracePeriod time.Duration) error {
+	_, err := c.do(ctx, http.MethodPut, adminPrefix+"/grace-period/"+escapeKey(key), nil,
+		api.SetGracePeriodRequest{GracePeriodSeconds: int64(gracePeriod / time.Second)}, nil, http.StatusNoContent)
+	return err
+}
+
+// ClearGracePeriod clears the grace period of key.  It requires the admin
+// role.
+func (c *Client) ClearGracePeriod(ctx context.Context, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, adminPrefix+"/grace-period/"+escapeKey(key), nil, nil, nil, http.StatusNoContent)
+	return err
+}
+
+// SetPlan creates or replaces the plan p.Name, with its windows truncated
+// to seconds.  It requires the admin role.
+func (c *Client) SetPlan(ctx context.Context, p store.Plan) error {
+	_, err := c.do(ctx, http.MethodPut, adminPrefix+"/plans/"+url.PathEscape(p.Name), nil,
+		api.NewPlan(p), nil, http.StatusNoContent)
+	return err
+}
+
+// GetPlan returns the plan name, or an error wrapping store.ErrNotFound.
+// It requires the admin role.
+func (c *Client) GetPlan(ctx context.Context, name string) (store.Plan, error) {
+	var resp api.Plan
+	if _, err := c.do(ctx, http.MethodGet, adminPrefix+"/plans/"+url.PathEscape(name), nil, nil, &resp, http.StatusOK); err != nil {
+		return store.Plan{}, err
+	}
+	return resp.StorePlan(), nil
+}
+
+// ListPlans returns all plans, sorted by name.  It requires the admin
+// role.
+func (c *Client) ListPlans(ctx context.Context) ([]store.Plan, error) {
+	var resp api.PlansResponse
+	if _, err := c.do(ctx, http.MethodGet, adminPrefix+"/plans", nil, nil, &resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	plans := make([]store.Plan, 0, len(resp.Plans))
+	for _, p := range resp.Plans {
+		plans = append(plans, p.StorePlan())
+	}
+	return plans, nil
+}
+
+// DeletePlan deletes the plan name, or returns an error wrapping
+// store.ErrNotFound.  It requires the admin role.
+func (c *Client) DeletePlan(ctx context.Context, name string) error {
+	_, err := c.do(ctx, http.MethodDelete, adminPrefix+"/plans/"+url.PathEscape(name), nil, nil, nil, http.StatusNoContent)
+	return err
+}
+
+// AssignPlan puts key on the plan name, or returns an error wrapping
+// store.ErrNotFound if there is no such plan.  It requires the admin
+// role.
+func (c *Client) AssignPlan(ctx context.Context, key, name string) error {
+	_, err := c.do(ctx, http.MethodPut, adminPrefix+"/plan/"+escapeKey(key), nil,
+		api.AssignPlanRequest{Plan: name}, nil, http.StatusNoContent)
+	return err
+}
+
+// UnassignPlan removes key from its plan.  It requires the admin role.
+func (c *Client) UnassignPlan(ctx context.Context, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, adminPrefix+"/plan/"+escapeKey(key), nil, nil, nil, http.StatusNoContent)
+	return err
+}
+
+// SetUsage sets the usage of key.  It requires the admin role.
+func (c *Client) SetUsage(ctx context.Context, key string, sizeBytes int64) error {
+	_, err := c.do(ctx, http.MethodPut, adminPrefix+"/usage/"+escapeKey(key), nil,
+		api.SetUsageRequest{SizeBytes: sizeBytes}, nil, http.StatusNoContent)
+	return err
+}
+
+// ListAudit returns audit entries matching f.  It requires the admin role.
+func (c *Client) ListAudit(ctx context.Context, f store.AuditFilter) ([]store.AuditEntry, error) {
+	query := url.Values{}
+	if f.Key != "" {
+		query.Set("key", f.Key)
+	}
+	if !f.Since.IsZero() {
+		query.Set("since", f.Since.Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		query.Set("until", f.Until.Format(time.RFC3339))
+	}
+	if f.Limit > 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+	var resp api.AuditResponse
+	_, err := c.do(ctx, http.MethodGet, adminPrefix+"/audit", query, nil, &resp, http.StatusOK)
+	return resp.Entries, err
+}
@@ -0,0 +1,220 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/audit"
+	"github.com/treeverse/terminus/pkg/auth"
+	"github.com/treeverse/terminus/pkg/billing"
+	"github.com/treeverse/terminus/pkg/client"
+	terminushttp "github.com/treeverse/terminus/pkg/http"
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+const (
+	defaultQuota = 100
+	adminToken   = "admin-token"
+)
+
+func newClient(t *testing.T) *client.Client {
+	mapper, err := keys.NewMapper(`^s3://[^/]+/user/([^/]+)/.*$`, "$1")
+	if err != nil {
+		t.Fatalf("New key mapper: %s", err)
+	}
+	s := &terminushttp.Server{
+		Store:  audit.NewStore(memory.NewStore(defaultQuota), logging.Discard()),
+		Logger: logging.Discard(),
+		Keys:   mapper,
+	}
+	a := auth.NewBearerTokens(map[string]auth.BearerToken{adminToken: {Principal: auth.Principal{Name: "root", Role: auth.RoleAdmin}}})
+	router := chi.NewRouter()
+	router.Mount("/internal/api/v1", auth.Require(a, auth.RoleUser, logging.Discard())(s.ServeREST()))
+	router.Mount("/", auth.Require(a, auth.RoleAdmin, logging.Discard())(s.ServeAdmin()))
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+
+	c, err := client.New(ts.URL, client.WithBearerToken(adminToken))
+	if err != nil {
+		t.Fatalf("New client: %s", err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+
+	// Keys may need escaping.
+	const key = "team/alice smith%"
+	if err := c.SetQuota(ctx, key, 10); err != nil {
+		t.Fatalf("SetQuota: %s", err)
+	}
+	if err := c.SetUsage(ctx, key, 11); err != nil {
+		t.Fatalf("SetUsage: %s", err)
+	}
+	exceeded, err := c.GetExceeded(ctx)
+	if err != nil {
+		t.Fatalf("GetExceeded: %s", err)
+	}
+	if len(exceeded) != 1 || exceeded[0].Key != key {
+		t.Errorf("GetExceeded: got %+v", exceeded)
+	}
+	check, err := c.CheckQuota(ctx, api.CheckQuotaRequest{Key: key, Bytes: 1})
+	if err != nil {
+		t.Fatalf("CheckQuota: %s", err)
+	}
+	if check.Allowed || check.Info.UsageBytes != 11 {
+		t.Errorf("CheckQuota: got %+v", check)
+	}
+	record, err := c.GetKey(ctx, key)
+	if err != nil {
+		t.Fatalf("GetKey: %s", err)
+	}
+	if record.Key != key || record.Info.UsageBytes != 11 || record.Info.QuotaBytes != 10 {
+		t.Errorf("GetKey: got %+v", record)
+	}
+	if err := c.ClearQuota(ctx, key); err != nil {
+		t.Fatalf("ClearQuota: %s", err)
+	}
+	if err := c.SetObjectQuota(ctx, key, 5); err != nil {
+		t.Fatalf("SetObjectQuota: %s", err)
+	}
+	if record, err = c.GetKey(ctx, key); err != nil || record.Info.QuotaObjects != 5 {
+		t.Errorf("GetKey after SetObjectQuota: got %+v, %v", record, err)
+	}
+	if err := c.ClearObjectQuota(ctx, key); err != nil {
+		t.Fatalf("ClearObjectQuota: %s", err)
+	}
+	if err := c.SetGracePeriod(ctx, key, time.Hour); err != nil {
+		t.Fatalf("SetGracePeriod: %s", err)
+	}
+	if err := c.ClearGracePeriod(ctx, key); err != nil {
+		t.Fatalf("ClearGracePeriod: %s", err)
+	}
+	records, next, err := c.ListKeys(ctx, "", 0)
+	if err != nil {
+		t.Fatalf("ListKeys: %s", err)
+	}
+	if len(records) != 1 || records[0].Info.QuotaBytes != defaultQuota || next != "" {
+		t.Errorf("ListKeys: got %+v, next %q", records, next)
+	}
+
+	points, err := c.GetHistory(ctx, key, time.Time{}, time.Time{}, time.Hour)
+	if err != nil || len(points) != 0 {
+		t.Errorf("GetHistory: got %+v, %v", points, err)
+	}
+
+	usage, err := c.GetUsage(ctx, key)
+	if err != nil || usage.Info.UsageBytes != 11 || usage.Forecast != nil {
+		t.Errorf("GetUsage: got %+v, %v", usage, err)
+	}
+	forecasts, err := c.ListForecast(ctx, 24*time.Hour)
+	if err != nil || len(forecasts) != 0 {
+		t.Errorf("ListForecast: got %+v, %v", forecasts, err)
+	}
+	statuses, err := c.GetRateExceeded(ctx)
+	if err != nil || len(statuses) != 0 {
+		t.Errorf("GetRateExceeded: got %+v, %v", statuses, err)
+	}
+	anomalies, err := c.GetAnomalies(ctx)
+	if err != nil || len(anomalies.Anomalies) != 0 {
+		t.Errorf("GetAnomalies: got %+v, %v", anomalies, err)
+	}
+	report, err := c.Billing(ctx, time.Now().UTC().Format(billing.MonthLayout))
+	if err != nil || len(report.Lines) != 1 || report.Lines[0].Key != key {
+		t.Errorf("Billing: got %+v, %v", report, err)
+	}
+	if _, err = c.Billing(ctx, "never"); err == nil {
+		t.Error("Billing bad month: expected error")
+	}
+
+	var exported bytes.Buffer
+	if err := c.ExportUsage(ctx, "jsonl", &exported); err != nil {
+		t.Fatalf("ExportUsage: %s", err)
+	}
+	if !strings.Contains(exported.String(), `"usage_bytes":11`) {
+		t.Errorf("ExportUsage: got %s", exported.String())
+	}
+
+	entries, err := c.ListAudit(ctx, store.AuditFilter{Key: key})
+	if err != nil {
+		t.Fatalf("ListAudit: %s", err)
+	}
+	if len(entries) != 7 || entries[0].Actor != "root" {
+		t.Errorf("ListAudit: got %+v", entries)
+	}
+
+	planQuota := int64(defaultQuota * 2)
+	plan := store.Plan{Name: "team", QuotaBytes: &planQuota, RateLimits: []store.RateLimit{{Window: time.Hour, Bytes: 5}}}
+	if err := c.SetPlan(ctx, plan); err != nil {
+		t.Fatalf("SetPlan: %s", err)
+	}
+	if p, err := c.GetPlan(ctx, "team"); err != nil {
+		t.Errorf("GetPlan: %s", err)
+	} else if diffs := deep.Equal(p, plan); diffs != nil {
+		t.Errorf("GetPlan: %s", diffs)
+	}
+	if plans, err := c.ListPlans(ctx); err != nil || len(plans) != 1 {
+		t.Errorf("ListPlans: got %+v, %v", plans, err)
+	}
+	if err := c.AssignPlan(ctx, key, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("AssignPlan missing: expected %s, got %v", store.ErrNotFound, err)
+	}
+	if err := c.AssignPlan(ctx, key, "team"); err != nil {
+		t.Fatalf("AssignPlan: %s", err)
+	}
+	if record, err := c.GetKey(ctx, key); err != nil || record.Info.Plan != "team" || record.Info.QuotaBytes != planQuota {
+		t.Errorf("GetKey on plan: got %+v, %v", record, err)
+	}
+	if err := c.UnassignPlan(ctx, key); err != nil {
+		t.Fatalf("UnassignPlan: %s", err)
+	}
+	if err := c.DeletePlan(ctx, "team"); err != nil {
+		t.Fatalf("DeletePlan: %s", err)
+	}
+	if _, err := c.GetPlan(ctx, "team"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetPlan deleted: expected %s, got %v", store.ErrNotFound, err)
+	}
+
+	r, err := c.Reserve(ctx, api.ReserveRequest{Path: "s3://bucket/user/bob/upload", Bytes: defaultQuota + 1})
+	if !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("Reserve over quota: expected %s, got %+v, %v", store.ErrQuotaExceeded, r, err)
+	}
+	r, err = c.Reserve(ctx, api.ReserveRequest{Path: "s3://bucket/shared/upload", Bytes: 1})
+	if err != nil || r != nil {
+		t.Errorf("Reserve untracked: got %+v, %v", r, err)
+	}
+	r, err = c.Reserve(ctx, api.ReserveRequest{Path: "s3://bucket/user/bob/upload", Bytes: 1})
+	if err != nil {
+		t.Fatalf("Reserve: %s", err)
+	}
+	if err := c.ReleaseReservation(ctx, r.ID); err != nil {
+		t.Errorf("ReleaseReservation: %s", err)
+	}
+	if err := c.ReleaseReservation(ctx, r.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("ReleaseReservation again: expected %s, got %v", store.ErrNotFound, err)
+	}
+}
+
+func TestClientUnauthenticated(t *testing.T) {
+	c := newClient(t)
+	c.Editors = []client.RequestEditor{client.WithBearerToken("guess")}
+	_, err := c.GetExceeded(context.Background())
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 401 || apiErr.Message == "" {
+		t.Errorf("Expected 401 error with message, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Package cost prices usage by storage class, and enforces quotas
+// expressed in dollars.
+package cost
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/treeverse/terminus/pkg/billing"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+var ErrBadConfig = errors.New("bad cost config")
+
+// DefaultPrices are the dollars per GB-month of S3 storage classes in
+// us-east-1.
+var DefaultPrices = map[string]float64{
+	"STANDARD":            0.023,
+	"INTELLIGENT_TIERING": 0.023,
+	"REDUCED_REDUNDANCY":  0.024,
+	"STANDARD_IA":         0.0125,
+	"ONEZONE_IA":          0.01,
+	"GLACIER_IR":          0.004,
+	"GLACIER":             0.0036,
+	"DEEP_ARCHIVE":        0.00099,
+}
+
+// Config prices storage classes and sets cost quotas.
+type Config struct {
+	// Prices are the dollars per GB-month of storage classes, over
+	// DefaultPrices.  Unknown classes cost as store.DefaultStorageClass.
+	Prices map[string]float64
+	// DefaultQuotaDollars is the monthly cost quota of keys without
+	// QuotaDollars, or 0 for none.
+	DefaultQuotaDollars float64
+	// QuotaDollars are monthly cost quotas of keys.
+	QuotaDollars map[string]float64
+}
+
+// LoadConfig reads a Config from the JSON file at path.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open cost config: %w", err)
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	var cfg Config
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parse cost config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("cost config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Validate returns ErrBadConfig if c has negative prices or quotas.
+func (c *Config) Validate() error {
+	for class, price := range c.Prices {
+		if price < 0 {
+			return fmt.Errorf("negative price %f of %s: %w", price, class, ErrBadConfig)
+		}
+	}
+	if c.DefaultQuotaDollars < 0 {
+		return fmt.Errorf("negative DefaultQuotaDollars %f: %w", c.DefaultQuotaDollars, ErrBadConfig)
+	}
+	for key, quota := range c.QuotaDollars {
+		if quota < 0 {
+			return fmt.Errorf("negative quota %f of %s: %w", quota, key, ErrBadConfig)
+		}
+	}
+	return nil
+}
+
+// Price returns the dollars per GB-month of storageClass.
+func (c *Config) Price(storageClass string) float64 {
+	if price, ok := c.Prices[storageClass]; ok {
+		return price
+	}
+	if price, ok := DefaultPrices[storageClass]; ok {
+		return price
+	}
+	if storageClass != store.DefaultStorageClass {
+		return c.Price(store.DefaultStorageClass)
+	}
+	return 0
+}
+
+// Quota returns the monthly cost quota of key, or 0 if it has none.
+func (c *Config) Quota(key string) float64 {
+	if quota, ok := c.QuotaDollars[key]; ok {
+		return quota
+	}
+	return c.DefaultQuotaDollars
+}
+
+// Cost is the monthly cost of the usage of a key.
+type Cost struct {
+	MonthlyDollars float64
+	// QuotaDollars is the monthly cost quota of the key, or 0 if it has
+	// none.
+	QuotaDollars float64
+}
+
+// Exceeded returns true if c exceeds its quota.
+func (c Cost) Exceeded() bool {
+	return c.QuotaDollars > 0 && c.MonthlyDollars > c.QuotaDollars
+}
+
+// Cost returns the cost of key using usageBytes, of which classBytes are
+// by storage class.  Usage in no class costs as store.DefaultStorageClass.
+func (c *Config) Cost(key string, usageBytes int64, classBytes map[string]int64) Cost {
+	dollars := 0.0
+	unclassified := usageBytes
+	for class, sizeBytes := range classBytes {
+		dollars += c.Dollars(class, sizeBytes)
+		unclassified -= sizeBytes
+	}
+	if unclassified > 0 {
+		dollars += c.Dollars(store.DefaultStorageClass, unclassified)
+	}
+	return Cost{MonthlyDollars: dollars, QuotaDollars: c.Quota(key)}
+}
+
+// Dollars returns the monthly cost of sizeBytes in storageClass.
+func (c *Config) Dollars(storageClass string, sizeBytes int64) float64 {
+	return float64(sizeBytes) / billing.BytesPerGB * c.Price(storageClass)
+}
@@ -0,0 +1,153 @@
+package cost_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/billing"
+	"github.com/treeverse/terminus/pkg/cost"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+const gb = billing.BytesPerGB
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestCost(t *testing.T) {
+	cfg := &cost.Config{
+		Prices:       map[string]float64{"STANDARD": 0.02, "GLACIER": 0.004},
+		QuotaDollars: map[string]float64{"capped": 1},
+	}
+	cases := []struct {
+		Name       string
+		Key        string
+		UsageBytes int64
+		ClassBytes map[string]int64
+		Dollars    float64
+		Exceeded   bool
+	}{
+		{Name: "Unclassified", Key: "k", UsageBytes: 10 * gb, Dollars: 0.2},
+		{Name: "Classified", Key: "k", UsageBytes: 10 * gb, ClassBytes: map[string]int64{"GLACIER": 10 * gb}, Dollars: 0.04},
+		{Name: "Mixed", Key: "k", UsageBytes: 10 * gb, ClassBytes: map[string]int64{"GLACIER": 5 * gb}, Dollars: 0.12},
+		{Name: "DefaultPrice", Key: "k", UsageBytes: 2 * gb, ClassBytes: map[string]int64{"DEEP_ARCHIVE": 2 * gb}, Dollars: 2 * cost.DefaultPrices["DEEP_ARCHIVE"]},
+		{Name: "UnknownClass", Key: "k", UsageBytes: gb, ClassBytes: map[string]int64{"NEW_CLASS": gb}, Dollars: 0.02},
+		{Name: "UnderQuota", Key: "capped", UsageBytes: 50 * gb, Dollars: 1},
+		{Name: "OverQuota", Key: "capped", UsageBytes: 51 * gb, Dollars: 1.02, Exceeded: true},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			got := cfg.Cost(c.Key, c.UsageBytes, c.ClassBytes)
+			if !near(got.MonthlyDollars, c.Dollars) {
+				t.Errorf("Got $%f, expected $%f", got.MonthlyDollars, c.Dollars)
+			}
+			if got.Exceeded() != c.Exceeded {
+				t.Errorf("Got exceeded %t, expected %t", got.Exceeded(), c.Exceeded)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatalf("Write %s: %s", path, err)
+		}
+		return path
+	}
+
+	cfg, err := cost.LoadConfig(write("good.json", `{"Prices": {"GLACIER": 0.001}, "DefaultQuotaDollars": 5}`))
+	if err != nil {
+		t.Fatalf("LoadConfig: %s", err)
+	}
+	if cfg.Price("GLACIER") != 0.001 || cfg.Price("STANDARD") != cost.DefaultPrices["STANDARD"] || cfg.Quota("any") != 5 {
+		t.Errorf("Loaded %+v", cfg)
+	}
+
+	if _, err = cost.LoadConfig(write("negative.json", `{"QuotaDollars": {"k": -1}}`)); !errors.Is(err, cost.ErrBadConfig) {
+		t.Errorf("LoadConfig negative quota: expected %s, got %v", cost.ErrBadConfig, err)
+	}
+	if _, err = cost.LoadConfig(write("unknown.json", `{"Price": {}}`)); err == nil {
+		t.Error("LoadConfig unknown field: expected error")
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	cfg := &cost.Config{
+		Prices:       map[string]float64{"STANDARD": 0.02, "GLACIER": 0.004},
+		QuotaDollars: map[string]float64{"capped": 1},
+	}
+	s := cost.NewStore(memory.NewStore(math.MaxInt64), cfg)
+	now := time.Now()
+
+	// 40 GB of Standard costs $0.80, 40 GB of Glacier another $0.16.
+	if err := s.AddClassSizeBytes(ctx, "capped", "STANDARD", 40*gb, now); err != nil {
+		t.Fatalf("AddClassSizeBytes STANDARD: %s", err)
+	}
+	if err := s.AddClassSizeBytes(ctx, "capped", "GLACIER", 40*gb, now); err != nil {
+		t.Fatalf("AddClassSizeBytes GLACIER: %s", err)
+	}
+	if err := s.AddSizeBytes(ctx, "uncapped", 1000*gb, now); err != nil {
+		t.Fatalf("AddSizeBytes uncapped: %s", err)
+	}
+
+	check, err := s.CheckQuota(ctx, "capped", gb)
+	if err != nil {
+		t.Fatalf("CheckQuota: %s", err)
+	}
+	// $0.04 remain, 2 GB of Standard.
+	if !check.Allowed || check.RemainingBytes != 2*gb {
+		t.Errorf("CheckQuota 1 GB: got %+v", check)
+	}
+	if check, err = s.CheckQuota(ctx, "capped", 3*gb); err != nil || check.Allowed {
+		t.Errorf("CheckQuota 3 GB: got %+v, %v", check, err)
+	}
+	if _, err = s.Reserve(ctx, store.Reservation{Key: "capped", Path: "s3://b/o", SizeBytes: 3 * gb, ExpiresAt: now.Add(time.Hour)}); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("Reserve 3 GB: expected %s, got %v", store.ErrQuotaExceeded, err)
+	}
+
+	exceeded, err := s.GetExceeded(ctx)
+	if err != nil || len(exceeded) != 0 {
+		t.Errorf("GetExceeded under cost quota: got %v, %v", exceeded, err)
+	}
+	if err = s.AddClassSizeBytes(ctx, "capped", "STANDARD", 3*gb, now); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("AddClassSizeBytes over cost quota: expected %s, got %v", store.ErrQuotaExceeded, err)
+	}
+	exceeded, err = s.GetExceeded(ctx)
+	if err != nil || len(exceeded) != 1 || exceeded[0].Key != "capped" {
+		t.Fatalf("GetExceeded over cost quota: got %v, %v", exceeded, err)
+	}
+	if diffs := deep.Equal(exceeded[0].Exceeded, []string{store.LimitDollars}); diffs != nil {
+		t.Errorf("Exceeded limits over cost quota: %s", diffs)
+	}
+	// Exceeding another quota too reports both.
+	if err = s.SetObjectQuota(ctx, "capped", 1); err != nil {
+		t.Fatalf("SetObjectQuota: %s", err)
+	}
+	for _, path := range []string{"s3://b/1", "s3://b/2"} {
+		_ = s.PutObject(ctx, "capped", store.Object{Path: path}, now)
+	}
+	exceeded, err = s.GetExceeded(ctx)
+	if err != nil || len(exceeded) != 1 {
+		t.Fatalf("GetExceeded over cost and object quotas: got %v, %v", exceeded, err)
+	}
+	if diffs := deep.Equal(exceeded[0].Exceeded, []string{store.LimitObjects, store.LimitDollars}); diffs != nil {
+		t.Errorf("Exceeded limits over cost and object quotas: %s", diffs)
+	}
+	c, err := s.Cost(ctx, "capped")
+	if err != nil || !near(c.MonthlyDollars, 1.02) {
+		t.Errorf("Cost: got %+v, %v", c, err)
+	}
+}
@@ -0,0 +1,198 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/billing"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// listPageSize is the number of keys that GetExceeded prices per page.
+const listPageSize = 1000
+
+// Store is a store.Store whose keys also exceed quota when their usage
+// costs more than their cost quota.  Growth that is not yet classified
+// counts as store.DefaultStorageClass.
+//
+// Changes of usage already happened when their cost is checked, so
+// failures to check it are not errors of the change; enforcement catches
+// up when it next reconciles.
+type Store struct {
+	store.Store
+	Config *Config
+}
+
+// NewStore returns a Store that enforces the cost quotas of cfg on s.
+func NewStore(s store.Store, cfg *Config) *Store {
+	return &Store{Store: s, Config: cfg}
+}
+
+// Cost returns the current cost of key.
+func (s *Store) Cost(ctx context.Context, key string) (Cost, error) {
+	check, err := s.Store.CheckQuota(ctx, key, 0)
+	if err != nil {
+		return Cost{}, err
+	}
+	return s.cost(ctx, key, check.Info.UsageBytes)
+}
+
+func (s *Store) cost(ctx context.Context, key string, usageBytes int64) (Cost, error) {
+	classBytes, err := s.Store.GetClassUsage(ctx, key)
+	if err != nil {
+		return Cost{}, fmt.Errorf("get usage of %s by storage class: %w", key, err)
+	}
+	return s.Config.Cost(key, usageBytes, classBytes), nil
+}
+
+// checkCost returns err of a change of key, or ErrQuotaExceeded if key
+// now exceeds its cost quota.
+func (s *Store) checkCost(ctx context.Context, key string, err error) error {
+	if err != nil || s.Config.Quota(key) == 0 {
+		return err
+	}
+	if c, costErr := s.Cost(ctx, key); costErr == nil && c.Exceeded() {
+		return store.ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (s *Store) Set(ctx context.Context, key string, value store.Value) error {
+	return s.checkCost(ctx, key, s.Store.Set(ctx, key, value))
+}
+
+func (s *Store) AddSizeBytes(ctx context.Context, key string, numBytes int64, at time.Time) error {
+	return s.checkCost(ctx, key, s.Store.AddSizeBytes(ctx, key, numBytes, at))
+}
+
+func (s *Store) AddClassSizeBytes(ctx context.Context, key, storageClass string, numBytes int64, at time.Time) error {
+	return s.checkCost(ctx, key, s.Store.AddClassSizeBytes(ctx, key, storageClass, numBytes, at))
+}
+
+func (s *Store) PutObject(ctx context.Context, key string, o store.Object, at time.Time) error {
+	return s.checkCost(ctx, key, s.Store.PutObject(ctx, key, o, at))
+}
+
+func (s *Store) DeleteObject(ctx context.Context, path, versionID string, at time.Time) (string, error) {
+	key, err := s.Store.DeleteObject(ctx, path, versionID, at)
+	if key == "" {
+		return key, err
+	}
+	return key, s.checkCost(ctx, key, err)
+}
+
+// TransitionObject also checks the cost quota of the key of the object,
+// which changes with the price of its storage class.
+func (s *Store) TransitionObject(ctx context.Context, path, versionID, storageClass string) (string, error) {
+	key, err := s.Store.TransitionObject(ctx, path, versionID, storageClass)
+	if key == "" {
+		return key, err
+	}
+	return key, s.checkCost(ctx, key, err)
+}
+
+// CheckQuota also disallows growth that would exceed the cost quota of key,
+// pricing reserved and new bytes as store.DefaultStorageClass.
+// RemainingBytes is the least of the bytes remaining by either quota.
+func (s *Store) CheckQuota(ctx context.Context, key string, numBytes int64) (store.QuotaCheck, error) {
+	check, err := s.Store.CheckQuota(ctx, key, numBytes)
+	quota := s.Config.Quota(key)
+	if err != nil || quota == 0 {
+		return check, err
+	}
+	c, err := s.cost(ctx, key, check.Info.UsageBytes)
+	if err != nil {
+		return store.QuotaCheck{}, err
+	}
+	reservedDollars := s.Config.Dollars(store.DefaultStorageClass, check.ReservedBytes)
+	if c.MonthlyDollars+reservedDollars+s.Config.Dollars(store.DefaultStorageClass, numBytes) > quota {
+		check.Allowed = false
+	}
+	remainingDollars := math.Max(quota-c.MonthlyDollars-reservedDollars, 0)
+	if price := s.Config.Price(store.DefaultStorageClass); price > 0 {
+		remainingBytes := math.Round(remainingDollars / price * billing.BytesPerGB)
+		if remainingBytes < float64(check.RemainingBytes) {
+			check.RemainingBytes = int64(remainingBytes)
+		}
+	}
+	return check, nil
+}
+
+// Reserve also refuses reservations that would exceed the cost quota of
+// r.Key.
+func (s *Store) Reserve(ctx context.Context, r store.Reservation) (store.Reservation, error) {
+	if s.Config.Quota(r.Key) > 0 {
+		check, err := s.CheckQuota(ctx, r.Key, r.SizeBytes)
+		if err != nil {
+			return store.Reservation{}, err
+		}
+		if !check.Allowed {
+			return store.Reservation{}, store.ErrQuotaExceeded
+		}
+	}
+	return s.Store.Reserve(ctx, r)
+}
+
+// GetExceeded also returns keys that exceed their cost quota, with
+// store.LimitDollars.  With a DefaultQuotaDollars it prices every key.
+func (s *Store) GetExceeded(ctx context.Context) ([]store.Record, error) {
+	records, err := s.Store.GetExceeded(ctx)
+	if err != nil {
+		return nil, err
+	}
+	exceeded := make(map[string]int, len(records))
+	for i, r := range records {
+		exceeded[r.Key] = i
+	}
+	add := func(r store.Record) error {
+		if s.Config.Quota(r.Key) == 0 {
+			return nil
+		}
+		c, err := s.cost(ctx, r.Key, r.Info.UsageBytes)
+		if err != nil || !c.Exceeded() {
+			return err
+		}
+		if i, ok := exceeded[r.Key]; ok {
+			records[i].Exceeded = append(records[i].Exceeded, store.LimitDollars)
+			return nil
+		}
+		r.Exceeded = []string{store.LimitDollars}
+		exceeded[r.Key] = len(records)
+		records = append(records, r)
+		return nil
+	}
+
+	if s.Config.DefaultQuotaDollars == 0 {
+		for key := range s.Config.QuotaDollars {
+			check, err := s.Store.CheckQuota(ctx, key, 0)
+			if err != nil {
+				return nil, err
+			}
+			if err = add(store.Record{Key: key, Info: check.Info}); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		after := ""
+		for {
+			page, err := s.Store.List(ctx, after, listPageSize)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range page {
+				if err = add(r); err != nil {
+					return nil, err
+				}
+			}
+			if len(page) < listPageSize {
+				break
+			}
+			after = page[len(page)-1].Key
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records, nil
+}
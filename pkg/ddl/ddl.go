@@ -4,5 +4,17 @@ package ddl
 
 import _ "embed"
 
+// DDL creates a store on PostgreSQL or CockroachDB.
+//
 //go:embed "store.sql"
 var DDL string
+
+// SQLiteDDL creates a store on SQLite.
+//
+//go:embed "store_sqlite.sql"
+var SQLiteDDL string
+
+// MySQLDDL creates a store on MySQL or MariaDB.
+//
+//go:embed "store_mysql.sql"
+var MySQLDDL string
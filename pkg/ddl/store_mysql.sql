@@ -0,0 +1,50 @@
+-- Store table
+
+-- Keys are case-sensitive, so collate them as binary.
+CREATE TABLE IF NOT EXISTS `usage` (`key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin PRIMARY KEY, size_bytes BIGINT NOT NULL, quota BIGINT);
+
+-- Reservations of quota for uploads in progress.  Times are Unix
+-- milliseconds, which every database compares the same way.
+CREATE TABLE IF NOT EXISTS reservations (id VARCHAR(64) PRIMARY KEY, `key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, path TEXT CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, size_bytes BIGINT NOT NULL, expires_at BIGINT NOT NULL, INDEX reservations_key (`key`));
+
+-- Append-only audit log of changes to quota, usage and enforcement.
+-- An index on (key, at) would exceed the maximal index length.
+CREATE TABLE IF NOT EXISTS audit (id BIGINT AUTO_INCREMENT PRIMARY KEY, at BIGINT NOT NULL, actor TEXT NOT NULL, action VARCHAR(64) NOT NULL, `key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, old_value BIGINT, new_value BIGINT, detail TEXT NOT NULL, INDEX audit_key (`key`), INDEX audit_at (at));
+
+-- Usage of every key, recorded periodically.  A primary key on (key, at)
+-- would exceed the maximal index length, so index a prefix of keys.
+CREATE TABLE IF NOT EXISTS usage_history (id BIGINT AUTO_INCREMENT PRIMARY KEY, `key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, at BIGINT NOT NULL, size_bytes BIGINT NOT NULL, INDEX usage_history_key_at (`key`(760), at), INDEX usage_history_at (at));
+
+-- Changes of usage, for billing by byte-hours.  Start the ledger with
+-- the usage of every key at the Unix epoch.
+CREATE TABLE IF NOT EXISTS usage_ledger (id BIGINT AUTO_INCREMENT PRIMARY KEY, `key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, at BIGINT NOT NULL, delta_bytes BIGINT NOT NULL, INDEX usage_ledger_at (at));
+INSERT INTO usage_ledger (`key`, at, delta_bytes) SELECT `key`, 0, size_bytes FROM `usage` WHERE size_bytes <> 0 AND NOT EXISTS (SELECT 1 FROM usage_ledger);
+
+-- Usage of every key by S3 storage class.  A key has few storage
+-- classes, so index only a prefix of keys.
+CREATE TABLE IF NOT EXISTS usage_by_class (id BIGINT AUTO_INCREMENT PRIMARY KEY, `key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, storage_class VARCHAR(64) NOT NULL, size_bytes BIGINT NOT NULL, INDEX usage_by_class_key (`key`(760)));
+
+-- Number and quota of objects of every key.
+CREATE TABLE IF NOT EXISTS object_counts (`key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin PRIMARY KEY, object_count BIGINT NOT NULL, quota BIGINT);
+
+-- Objects counted toward the usage of keys.  version_id is empty in
+-- buckets without versioning, storage_class when it is unknown.  Paths
+-- are too long to index entirely, so index a prefix of them.
+CREATE TABLE IF NOT EXISTS objects (id BIGINT AUTO_INCREMENT PRIMARY KEY, path TEXT CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, version_id VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL, `key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, size_bytes BIGINT NOT NULL, storage_class VARCHAR(64) NOT NULL, INDEX objects_path_version (path(700), version_id));
+
+-- Bytes ingested by every key, counted in buckets starting at "at".  A
+-- primary key on (key, at) would exceed the maximal index length, so
+-- index a prefix of keys.
+CREATE TABLE IF NOT EXISTS ingest (id BIGINT AUTO_INCREMENT PRIMARY KEY, `key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, at BIGINT NOT NULL, ingest_bytes BIGINT NOT NULL, INDEX ingest_key_at (`key`(760), at), INDEX ingest_at (at));
+
+-- Grace period of every key, and since when it exceeds quota.
+CREATE TABLE IF NOT EXISTS grace_periods (`key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin PRIMARY KEY, grace_ms BIGINT, exceeded_since BIGINT);
+
+-- Plans of limits shared by keys, their rate limits, and the plan of
+-- every key on one.
+CREATE TABLE IF NOT EXISTS plans (name VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin PRIMARY KEY, quota_bytes BIGINT, quota_objects BIGINT);
+CREATE TABLE IF NOT EXISTS plan_rate_limits (plan VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, window_ms BIGINT NOT NULL, size_bytes BIGINT NOT NULL, PRIMARY KEY (plan, window_ms));
+CREATE TABLE IF NOT EXISTS key_plans (`key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin PRIMARY KEY, plan VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL, INDEX key_plans_plan (plan));
+
+-- Keys blocked by enforcement, and since when.
+CREATE TABLE IF NOT EXISTS blocked_keys (`key` VARCHAR(768) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin PRIMARY KEY, at BIGINT NOT NULL);
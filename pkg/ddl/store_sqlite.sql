@@ -0,0 +1,50 @@
+-- Store table
+
+CREATE TABLE IF NOT EXISTS usage (key TEXT PRIMARY KEY, size_bytes INTEGER NOT NULL, quota INTEGER);
+
+-- Reservations of quota for uploads in progress.  Times are Unix
+-- milliseconds, which every database compares the same way.
+CREATE TABLE IF NOT EXISTS reservations (id TEXT PRIMARY KEY, key TEXT NOT NULL, path TEXT NOT NULL, size_bytes INTEGER NOT NULL, expires_at INTEGER NOT NULL);
+CREATE INDEX IF NOT EXISTS reservations_key ON reservations (key);
+
+-- Append-only audit log of changes to quota, usage and enforcement.
+CREATE TABLE IF NOT EXISTS audit (id INTEGER PRIMARY KEY AUTOINCREMENT, at INTEGER NOT NULL, actor TEXT NOT NULL, action TEXT NOT NULL, key TEXT NOT NULL, old_value INTEGER, new_value INTEGER, detail TEXT NOT NULL);
+CREATE INDEX IF NOT EXISTS audit_key_at ON audit (key, at);
+CREATE INDEX IF NOT EXISTS audit_at ON audit (at);
+
+-- Usage of every key, recorded periodically.
+CREATE TABLE IF NOT EXISTS usage_history (key TEXT NOT NULL, at INTEGER NOT NULL, size_bytes INTEGER NOT NULL, PRIMARY KEY (key, at));
+CREATE INDEX IF NOT EXISTS usage_history_at ON usage_history (at);
+
+-- Changes of usage, for billing by byte-hours.  Start the ledger with
+-- the usage of every key at the Unix epoch.
+CREATE TABLE IF NOT EXISTS usage_ledger (key TEXT NOT NULL, at INTEGER NOT NULL, delta_bytes INTEGER NOT NULL);
+CREATE INDEX IF NOT EXISTS usage_ledger_at ON usage_ledger (at);
+INSERT INTO usage_ledger (key, at, delta_bytes) SELECT key, 0, size_bytes FROM usage WHERE size_bytes <> 0 AND NOT EXISTS (SELECT 1 FROM usage_ledger);
+
+-- Usage of every key by S3 storage class.
+CREATE TABLE IF NOT EXISTS usage_by_class (key TEXT NOT NULL, storage_class TEXT NOT NULL, size_bytes INTEGER NOT NULL, PRIMARY KEY (key, storage_class));
+
+-- Number and quota of objects of every key.
+CREATE TABLE IF NOT EXISTS object_counts (key TEXT PRIMARY KEY, object_count INTEGER NOT NULL, quota INTEGER);
+
+-- Objects counted toward the usage of keys.  version_id is empty in
+-- buckets without versioning, storage_class when it is unknown.
+CREATE TABLE IF NOT EXISTS objects (path TEXT NOT NULL, version_id TEXT NOT NULL, key TEXT NOT NULL, size_bytes INTEGER NOT NULL, storage_class TEXT NOT NULL, PRIMARY KEY (path, version_id));
+
+-- Bytes ingested by every key, counted in buckets starting at "at".
+CREATE TABLE IF NOT EXISTS ingest (key TEXT NOT NULL, at INTEGER NOT NULL, ingest_bytes INTEGER NOT NULL, PRIMARY KEY (key, at));
+CREATE INDEX IF NOT EXISTS ingest_at ON ingest (at);
+
+-- Grace period of every key, and since when it exceeds quota.
+CREATE TABLE IF NOT EXISTS grace_periods (key TEXT PRIMARY KEY, grace_ms INTEGER, exceeded_since INTEGER);
+
+-- Plans of limits shared by keys, their rate limits, and the plan of
+-- every key on one.
+CREATE TABLE IF NOT EXISTS plans (name TEXT PRIMARY KEY, quota_bytes INTEGER, quota_objects INTEGER);
+CREATE TABLE IF NOT EXISTS plan_rate_limits (plan TEXT NOT NULL, window_ms INTEGER NOT NULL, size_bytes INTEGER NOT NULL, PRIMARY KEY (plan, window_ms));
+CREATE TABLE IF NOT EXISTS key_plans (key TEXT PRIMARY KEY, plan TEXT NOT NULL);
+CREATE INDEX IF NOT EXISTS key_plans_plan ON key_plans (plan);
+
+-- Keys blocked by enforcement, and since when.
+CREATE TABLE IF NOT EXISTS blocked_keys (key TEXT PRIMARY KEY, at INTEGER NOT NULL);
@@ -0,0 +1,202 @@
+// Package enforce acts on keys that exceed their quota, and undoes its
+// actions once they are back within quota.
+package enforce
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"github.com/treeverse/terminus/pkg/audit"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// Action blocks and unblocks keys.  Both methods must be idempotent.
+type Action interface {
+	// Block acts on key, which exceeds its quota.
+	Block(ctx context.Context, key string) error
+	// Unblock undoes Block on key, which is within its quota again.
+	Unblock(ctx context.Context, key string) error
+}
+
+// Enforcer blocks keys that exceed quota on a store, and unblocks them
+// once they are back within quota.  It is safe for concurrent use.
+//
+// Enforcer records blocked keys on the store, so that after a restart it
+// still unblocks keys that dropped back within quota while it was not
+// running.
+type Enforcer struct {
+	Store  store.Store
+	Action Action
+	Logger logging.Logger
+
+	// keyLocks serialize actions on each key, without holding up
+	// actions on other keys while calling Action.
+	keyLocks [keyLockStripes]sync.Mutex
+
+	mu      sync.Mutex
+	blocked map[string]struct{}
+}
+
+const keyLockStripes = 64
+
+// New returns an Enforcer that performs action on keys of s.  Call Load
+// before using it to learn keys blocked by previous runs.
+func New(s store.Store, action Action, l logging.Logger) *Enforcer {
+	return &Enforcer{Store: s, Action: action, Logger: l, blocked: make(map[string]struct{})}
+}
+
+// Load learns the keys recorded as blocked on the store.
+func (e *Enforcer) Load(ctx context.Context) error {
+	keys, err := e.Store.ListBlocked(ctx)
+	if err != nil {
+		return fmt.Errorf("list blocked keys: %w", err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, key := range keys {
+		e.blocked[key] = struct{}{}
+	}
+	return nil
+}
+
+// Updated blocks or unblocks key after its usage changes.
+func (e *Enforcer) Updated(ctx context.Context, key string, exceeded bool) {
+	if err := e.set(ctx, key, exceeded); err != nil {
+		e.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Enforce quota")
+	}
+}
+
+// keyLock returns the lock that serializes actions on key.
+func (e *Enforcer) keyLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &e.keyLocks[h.Sum32()%keyLockStripes]
+}
+
+// set blocks key if exceeded and unblocks it if not, unless it is already
+// in that state.
+func (e *Enforcer) set(ctx context.Context, key string, exceeded bool) error {
+	l := e.keyLock(key)
+	l.Lock()
+	defer l.Unlock()
+	if e.Blocked(key) == exceeded {
+		return nil
+	}
+	if exceeded {
+		if err := e.Action.Block(ctx, key); err != nil {
+			return fmt.Errorf("block %s: %w", key, err)
+		}
+		e.Logger.WithField(logging.FieldKey, key).Info("Blocked key over quota")
+		e.audit(ctx, store.AuditBlock, key)
+	} else {
+		if err := e.Action.Unblock(ctx, key); err != nil {
+			return fmt.Errorf("unblock %s: %w", key, err)
+		}
+		e.Logger.WithField(logging.FieldKey, key).Info("Unblocked key within quota")
+		e.audit(ctx, store.AuditUnblock, key)
+	}
+	return e.record(ctx, key, exceeded)
+}
+
+// record records whether key is blocked, in memory and on the store.
+func (e *Enforcer) record(ctx context.Context, key string, blocked bool) error {
+	e.mu.Lock()
+	if blocked {
+		e.blocked[key] = struct{}{}
+	} else {
+		delete(e.blocked, key)
+	}
+	e.mu.Unlock()
+	if err := e.Store.SetBlocked(ctx, key, blocked); err != nil {
+		return fmt.Errorf("record %s blocked %t: %w", key, blocked, err)
+	}
+	return nil
+}
+
+// audit records action on key in the audit log.  The action already
+// happened, so failures are only logged, and the entry is lost: auditing
+// is best-effort.
+func (e *Enforcer) audit(ctx context.Context, action, key string) {
+	err := e.Store.AppendAudit(ctx, store.AuditEntry{
+		Time:   time.Now(),
+		Actor:  audit.Actor(ctx),
+		Action: action,
+		Key:    key,
+		Detail: fmt.Sprintf("%T", e.Action),
+	})
+	if err != nil {
+		e.Logger.WithError(err).WithFields(logging.Fields{logging.FieldKey: key, "action": action}).Error("Audit enforcement")
+	}
+}
+
+// Blocked returns whether key is blocked.
+func (e *Enforcer) Blocked(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.blocked[key]
+	return ok
+}
+
+// Reconcile blocks all keys that exceed quota past any grace period, and
+// unblocks all blocked keys that no longer do.  It catches changes that do
+// not pass through Updated, such as changes of quota and expiring grace.
+func (e *Enforcer) Reconcile(ctx context.Context) error {
+	if err := e.Load(ctx); err != nil {
+		return err
+	}
+	exceeded, err := e.Store.GetExceeded(ctx)
+	if err != nil {
+		return fmt.Errorf("get keys exceeding quota: %w", err)
+	}
+	exceededKeys := make(map[string]struct{}, len(exceeded))
+	for _, r := range exceeded {
+		if !r.InGrace {
+			exceededKeys[r.Key] = struct{}{}
+		}
+	}
+
+	e.mu.Lock()
+	var unblock []string
+	for key := range e.blocked {
+		if _, ok := exceededKeys[key]; !ok {
+			unblock = append(unblock, key)
+		}
+	}
+	e.mu.Unlock()
+
+	var merr *multierror.Error
+	for key := range exceededKeys {
+		if err := e.set(ctx, key, true); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	for _, key := range unblock {
+		if err := e.set(ctx, key, false); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+// ReconcileEvery reconciles immediately and then every interval, until ctx
+// is cancelled.
+func (e *Enforcer) ReconcileEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := e.Reconcile(ctx); err != nil {
+			e.Logger.WithError(err).Error("Reconcile enforcement")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package enforce_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/enforce"
+	"github.com/treeverse/terminus/pkg/grace"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+const defaultQuota = 100
+
+// recordAction records the calls made to it.
+type recordAction struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (a *recordAction) Block(_ context.Context, key string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = append(a.calls, "block "+key)
+	return nil
+}
+
+func (a *recordAction) Unblock(_ context.Context, key string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = append(a.calls, "unblock "+key)
+	return nil
+}
+
+// take returns and forgets the calls made to a.
+func (a *recordAction) take() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ret := a.calls
+	a.calls = nil
+	return ret
+}
+
+func expectCalls(t *testing.T, a *recordAction, expected []string) {
+	t.Helper()
+	if diffs := deep.Equal(a.take(), expected); diffs != nil {
+		t.Errorf("Unexpected actions: %s", diffs)
+	}
+}
+
+func TestUpdated(t *testing.T) {
+	ctx := context.Background()
+	a := &recordAction{}
+	e := enforce.New(memory.NewStore(defaultQuota), a, logging.Discard())
+
+	e.Updated(ctx, "alice", false)
+	expectCalls(t, a, nil)
+	e.Updated(ctx, "alice", true)
+	expectCalls(t, a, []string{"block alice"})
+	if !e.Blocked("alice") {
+		t.Error("Expected alice blocked")
+	}
+	e.Updated(ctx, "alice", true)
+	expectCalls(t, a, nil)
+	e.Updated(ctx, "alice", false)
+	expectCalls(t, a, []string{"unblock alice"})
+	if e.Blocked("alice") {
+		t.Error("Expected alice unblocked")
+	}
+
+	entries, err := e.Store.ListAudit(ctx, store.AuditFilter{Key: "alice"})
+	if err != nil {
+		t.Fatalf("ListAudit: %s", err)
+	}
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	if diffs := deep.Equal(actions, []string{store.AuditBlock, store.AuditUnblock}); diffs != nil {
+		t.Errorf("Unexpected audit log: %s", diffs)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStore(defaultQuota)
+	a := &recordAction{}
+	e := enforce.New(s, a, logging.Discard())
+
+	if err := s.Set(ctx, "alice", store.Value{SizeBytes: defaultQuota + 1}); err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Fatalf("Set: %s", err)
+	}
+	if err := e.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+	expectCalls(t, a, []string{"block alice"})
+
+	// Raising the quota does not pass through Updated.
+	if err := s.SetQuota(ctx, "alice", 2*defaultQuota); err != nil {
+		t.Fatalf("SetQuota: %s", err)
+	}
+	if err := e.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+	expectCalls(t, a, []string{"unblock alice"})
+}
+
+func TestReconcileAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStore(defaultQuota)
+	a := &recordAction{}
+
+	if err := s.Set(ctx, "alice", store.Value{SizeBytes: defaultQuota + 1}); err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Fatalf("Set: %s", err)
+	}
+	if err := enforce.New(s, a, logging.Discard()).Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+	expectCalls(t, a, []string{"block alice"})
+
+	// alice drops back within quota while no enforcer is running.
+	if err := s.Set(ctx, "alice", store.Value{SizeBytes: defaultQuota}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	e := enforce.New(s, a, logging.Discard())
+	if err := e.Load(ctx); err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	if !e.Blocked("alice") {
+		t.Error("Expected alice blocked after restart")
+	}
+	if err := e.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+	expectCalls(t, a, []string{"unblock alice"})
+	if blocked, err := s.ListBlocked(ctx); err != nil || len(blocked) > 0 {
+		t.Errorf("Got blocked keys %v, %v after unblocking", blocked, err)
+	}
+}
+
+func TestReconcileGrace(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := grace.NewStore(memory.NewStore(defaultQuota), time.Hour)
+	s.Now = func() time.Time { return now }
+	a := &recordAction{}
+	e := enforce.New(s, a, logging.Discard())
+
+	if err := s.Set(ctx, "alice", store.Value{SizeBytes: defaultQuota + 1}); !errors.Is(err, store.ErrQuotaGrace) {
+		t.Fatalf("Set over quota: expected %s, got %v", store.ErrQuotaGrace, err)
+	}
+	if err := e.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+	expectCalls(t, a, nil)
+
+	now = now.Add(time.Hour)
+	if err := e.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+	expectCalls(t, a, []string{"block alice"})
+}
@@ -0,0 +1,109 @@
+package enforce
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrLakeFS = errors.New("lakeFS request failed")
+
+// LakeFS is a minimal client of the lakeFS REST API.
+type LakeFS struct {
+	// Endpoint is the base URL of the API, e.g. "http://lakefs/api/v1".
+	Endpoint        *url.URL
+	AccessKeyID     string
+	SecretAccessKey string
+	Client          *http.Client
+}
+
+// do sends a request with a JSON body to path under the endpoint.  It
+// returns ErrLakeFS unless the response status is one of okStatuses.
+func (c *LakeFS) do(ctx context.Context, method string, path []string, body interface{}, okStatuses ...int) error {
+	u := *c.Endpoint
+	escaped := make([]string, len(path))
+	for i, p := range path {
+		escaped[i] = url.PathEscape(p)
+	}
+	u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + strings.Join(escaped, "/")
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.Join(path, "/")
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.SetBasicAuth(c.AccessKeyID, c.SecretAccessKey)
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	for _, status := range okStatuses {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s: %s %s: %w", method, u.Path, resp.Status, strings.TrimSpace(string(message)), ErrLakeFS)
+}
+
+// PolicyAction blocks keys, which are lakeFS user IDs, by attaching a
+// policy to them.  That policy should deny writes.
+type PolicyAction struct {
+	LakeFS   *LakeFS
+	PolicyID string
+}
+
+func (a *PolicyAction) Block(ctx context.Context, key string) error {
+	// Conflict means the policy is already attached.
+	return a.LakeFS.do(ctx, http.MethodPut, []string{"auth", "users", key, "policies", a.PolicyID}, nil,
+		http.StatusCreated, http.StatusNoContent, http.StatusConflict)
+}
+
+func (a *PolicyAction) Unblock(ctx context.Context, key string) error {
+	return a.LakeFS.do(ctx, http.MethodDelete, []string{"auth", "users", key, "policies", a.PolicyID}, nil,
+		http.StatusNoContent, http.StatusNotFound)
+}
+
+// BranchProtectionAction blocks keys, which are lakeFS repository IDs, by
+// protecting branches matching Pattern against direct writes and commits.
+type BranchProtectionAction struct {
+	LakeFS  *LakeFS
+	Pattern string
+}
+
+type branchProtectionRule struct {
+	Pattern string `json:"pattern"`
+}
+
+func (a *BranchProtectionAction) Block(ctx context.Context, key string) error {
+	return a.LakeFS.do(ctx, http.MethodPost, []string{"repositories", key, "branch_protection"}, branchProtectionRule{a.Pattern},
+		http.StatusCreated, http.StatusNoContent, http.StatusConflict)
+}
+
+func (a *BranchProtectionAction) Unblock(ctx context.Context, key string) error {
+	return a.LakeFS.do(ctx, http.MethodDelete, []string{"repositories", key, "branch_protection"}, branchProtectionRule{a.Pattern},
+		http.StatusNoContent, http.StatusNotFound)
+}
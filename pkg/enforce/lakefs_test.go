@@ -0,0 +1,103 @@
+package enforce_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/enforce"
+)
+
+const (
+	accessKeyID     = "AKIAEXAMPLE"
+	secretAccessKey = "secret"
+)
+
+// lakeFSStandIn records requests, and answers them with status.
+type lakeFSStandIn struct {
+	status   int
+	requests []string
+}
+
+func (l *lakeFSStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if id, secret, ok := r.BasicAuth(); !ok || id != accessKeyID || secret != secretAccessKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	l.requests = append(l.requests, strings.TrimSpace(r.Method+" "+r.URL.EscapedPath()+" "+string(body)))
+	w.WriteHeader(l.status)
+}
+
+func newLakeFS(t *testing.T, status int) (*enforce.LakeFS, *lakeFSStandIn) {
+	standIn := &lakeFSStandIn{status: status}
+	ts := httptest.NewServer(standIn)
+	t.Cleanup(ts.Close)
+	endpoint, err := url.Parse(ts.URL + "/api/v1")
+	if err != nil {
+		t.Fatalf("Parse endpoint: %s", err)
+	}
+	return &enforce.LakeFS{Endpoint: endpoint, AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey}, standIn
+}
+
+func TestLakeFSActions(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		Name     string
+		Action   func(c *enforce.LakeFS) enforce.Action
+		Status   int
+		Expected []string
+	}{
+		{
+			Name: "Policy",
+			Action: func(c *enforce.LakeFS) enforce.Action {
+				return &enforce.PolicyAction{LakeFS: c, PolicyID: "QuotaExceeded"}
+			},
+			Status: http.StatusNoContent,
+			Expected: []string{
+				"PUT /api/v1/auth/users/a%2Fb/policies/QuotaExceeded",
+				"DELETE /api/v1/auth/users/a%2Fb/policies/QuotaExceeded",
+			},
+		}, {
+			Name: "BranchProtection",
+			Action: func(c *enforce.LakeFS) enforce.Action {
+				return &enforce.BranchProtectionAction{LakeFS: c, Pattern: "*"}
+			},
+			Status: http.StatusNoContent,
+			Expected: []string{
+				`POST /api/v1/repositories/a%2Fb/branch_protection {"pattern":"*"}`,
+				`DELETE /api/v1/repositories/a%2Fb/branch_protection {"pattern":"*"}`,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			client, standIn := newLakeFS(t, c.Status)
+			action := c.Action(client)
+			if err := action.Block(ctx, "a/b"); err != nil {
+				t.Errorf("Block: %s", err)
+			}
+			if err := action.Unblock(ctx, "a/b"); err != nil {
+				t.Errorf("Unblock: %s", err)
+			}
+			if diffs := deep.Equal(standIn.requests, c.Expected); diffs != nil {
+				t.Errorf("Unexpected requests: %s", diffs)
+			}
+		})
+	}
+}
+
+func TestLakeFSFailure(t *testing.T) {
+	client, _ := newLakeFS(t, http.StatusInternalServerError)
+	action := &enforce.PolicyAction{LakeFS: client, PolicyID: "QuotaExceeded"}
+	if err := action.Block(context.Background(), "alice"); !errors.Is(err, enforce.ErrLakeFS) {
+		t.Errorf("Block: expected %s, got %v", enforce.ErrLakeFS, err)
+	}
+}
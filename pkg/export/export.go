@@ -0,0 +1,178 @@
+// Package export writes the usage and quota of all keys as CSV, JSON Lines
+// or Parquet.
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Formats of exports.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// Formats lists all formats.
+var Formats = []string{FormatCSV, FormatJSONL, FormatParquet}
+
+// PageSize is the number of keys that Export reads at a time.
+const PageSize = 1000
+
+// parquetRowGroupSize bounds the bytes that a Parquet Writer buffers.
+const parquetRowGroupSize = 8 << 20
+
+// Row is an exported key.
+type Row struct {
+	Key        string `json:"key" parquet:"name=key, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UsageBytes int64  `json:"usage_bytes" parquet:"name=usage_bytes, type=INT64"`
+	// QuotaBytes is the effective quota: the key's own quota if it has
+	// one, else the quota of its plan if that has one, else the default
+	// quota.
+	QuotaBytes int64 `json:"quota_bytes" parquet:"name=quota_bytes, type=INT64"`
+	// PercentUsed is the percentage of quota used, or nil if the quota
+	// is zero.
+	PercentUsed *float64 `json:"percent_used" parquet:"name=percent_used, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+// NewRow returns the Row of r.
+func NewRow(r store.Record) Row {
+	row := Row{Key: r.Key, UsageBytes: r.Info.UsageBytes, QuotaBytes: r.Info.QuotaBytes}
+	if r.Info.QuotaBytes > 0 {
+		percent := 100 * float64(r.Info.UsageBytes) / float64(r.Info.QuotaBytes)
+		row.PercentUsed = &percent
+	}
+	return row
+}
+
+// Writer writes rows in some format.  Close flushes the rows but does not
+// close the underlying io.Writer.
+type Writer interface {
+	Write(row Row) error
+	Close() error
+}
+
+// CheckFormat returns ErrUnknownFormat unless format is one of Formats.
+func CheckFormat(format string) error {
+	for _, f := range Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %w", format, ErrUnknownFormat)
+}
+
+// NewWriter returns a Writer of format on w.  Some formats write a header
+// at once, so set any headers of w first.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w)
+	case FormatJSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		pw, err := writer.NewParquetWriterFromWriter(w, new(Row), 1)
+		if err != nil {
+			return nil, fmt.Errorf("new parquet writer: %w", err)
+		}
+		pw.RowGroupSize = parquetRowGroupSize
+		return &parquetWriter{pw: pw}, nil
+	}
+	return nil, fmt.Errorf("%s: %w", format, ErrUnknownFormat)
+}
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatJSONL:
+		return "application/jsonl"
+	case FormatParquet:
+		return "application/vnd.apache.parquet"
+	}
+	return "application/octet-stream"
+}
+
+// Export writes all keys on s to w, reading PageSize keys at a time, and
+// closes w.  It returns the number of keys written.
+func Export(ctx context.Context, s store.Store, w Writer) (int, error) {
+	n := 0
+	after := ""
+	for {
+		records, err := s.List(ctx, after, PageSize)
+		if err != nil {
+			return n, fmt.Errorf("list keys after %q: %w", after, err)
+		}
+		for _, r := range records {
+			if err := w.Write(NewRow(r)); err != nil {
+				return n, fmt.Errorf("write key %s: %w", r.Key, err)
+			}
+			n++
+		}
+		if len(records) < PageSize {
+			break
+		}
+		after = records[len(records)-1].Key
+	}
+	if err := w.Close(); err != nil {
+		return n, fmt.Errorf("close: %w", err)
+	}
+	return n, nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"key", "usage_bytes", "quota_bytes", "percent_used"}); err != nil {
+		return nil, err
+	}
+	return &csvWriter{w: cw}, nil
+}
+
+func (w *csvWriter) Write(row Row) error {
+	percentUsed := ""
+	if row.PercentUsed != nil {
+		percentUsed = strconv.FormatFloat(*row.PercentUsed, 'f', 2, 64)
+	}
+	return w.w.Write([]string{
+		row.Key,
+		strconv.FormatInt(row.UsageBytes, 10),
+		strconv.FormatInt(row.QuotaBytes, 10),
+		percentUsed,
+	})
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonlWriter) Write(row Row) error { return w.encoder.Encode(row) }
+func (w *jsonlWriter) Close() error        { return nil }
+
+type parquetWriter struct {
+	pw *writer.ParquetWriter
+}
+
+func (w *parquetWriter) Write(row Row) error { return w.pw.Write(row) }
+func (w *parquetWriter) Close() error        { return w.pw.WriteStop() }
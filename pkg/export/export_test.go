@@ -0,0 +1,128 @@
+package export_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+
+	"github.com/treeverse/terminus/pkg/export"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+const defaultQuota = 200
+
+func float64p(f float64) *float64 {
+	return &f
+}
+
+// newStore returns a store holding more than a page of keys, and the rows
+// expected to export from it.
+func newStore(t *testing.T) (store.Store, []export.Row) {
+	ctx := context.Background()
+	s := memory.NewStore(defaultQuota)
+	var rows []export.Row
+	for i := 0; i < export.PageSize+2; i++ {
+		key := fmt.Sprintf("user-%05d", i)
+		if err := s.Set(ctx, key, store.Value{SizeBytes: int64(i % 100)}); err != nil {
+			t.Fatalf("Set %s: %s", key, err)
+		}
+		rows = append(rows, export.Row{
+			Key:         key,
+			UsageBytes:  int64(i % 100),
+			QuotaBytes:  defaultQuota,
+			PercentUsed: float64p(float64(i%100) / 2),
+		})
+	}
+	if err := s.SetQuota(ctx, rows[0].Key, 0); err != nil {
+		t.Fatalf("Set quota: %s", err)
+	}
+	rows[0].QuotaBytes = 0
+	rows[0].PercentUsed = nil
+	return s, rows
+}
+
+// exportStore exports s as format.
+func exportStore(t *testing.T, s store.Store, format string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w, err := export.NewWriter(&b, format)
+	if err != nil {
+		t.Fatalf("New %s writer: %s", format, err)
+	}
+	n, err := export.Export(context.Background(), s, w)
+	if err != nil {
+		t.Fatalf("Export %s: %s", format, err)
+	}
+	if n != export.PageSize+2 {
+		t.Errorf("Exported %d keys, expected %d", n, export.PageSize+2)
+	}
+	return b.Bytes()
+}
+
+func TestCSV(t *testing.T) {
+	s, rows := newStore(t)
+	lines := strings.Split(strings.TrimSuffix(string(exportStore(t, s, export.FormatCSV)), "\n"), "\n")
+	expected := []string{
+		"key,usage_bytes,quota_bytes,percent_used",
+		"user-00000,0,0,",
+		"user-00001,1,200,0.50",
+		"user-00002,2,200,1.00",
+	}
+	if diffs := deep.Equal(lines[:len(expected)], expected); diffs != nil {
+		t.Errorf("Unexpected CSV: %s", diffs)
+	}
+	if len(lines) != len(rows)+1 {
+		t.Errorf("Got %d lines, expected %d", len(lines), len(rows)+1)
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	s, rows := newStore(t)
+	decoder := json.NewDecoder(bytes.NewReader(exportStore(t, s, export.FormatJSONL)))
+	var actual []export.Row
+	for decoder.More() {
+		var row export.Row
+		if err := decoder.Decode(&row); err != nil {
+			t.Fatalf("Decode row %d: %s", len(actual), err)
+		}
+		actual = append(actual, row)
+	}
+	if diffs := deep.Equal(actual, rows); diffs != nil {
+		t.Errorf("Unexpected rows: %s", diffs)
+	}
+}
+
+func TestParquet(t *testing.T) {
+	s, rows := newStore(t)
+	f, err := buffer.NewBufferFile(exportStore(t, s, export.FormatParquet))
+	if err != nil {
+		t.Fatalf("Open buffer: %s", err)
+	}
+	pr, err := reader.NewParquetReader(f, new(export.Row), 1)
+	if err != nil {
+		t.Fatalf("New parquet reader: %s", err)
+	}
+	defer pr.ReadStop()
+	actual := make([]export.Row, pr.GetNumRows())
+	if err := pr.Read(&actual); err != nil {
+		t.Fatalf("Read rows: %s", err)
+	}
+	if diffs := deep.Equal(actual, rows); diffs != nil {
+		t.Errorf("Unexpected rows: %s", diffs)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := export.NewWriter(&bytes.Buffer{}, "xml"); !errors.Is(err, export.ErrUnknownFormat) {
+		t.Errorf("Expected %s, got %v", export.ErrUnknownFormat, err)
+	}
+}
@@ -0,0 +1,151 @@
+// Package forecast projects when keys will exceed their quota from their
+// usage history.
+package forecast
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+var ErrUnknownMethod = errors.New("unknown forecast method")
+
+// Methods of fitting growth.
+const (
+	// MethodLinear fits a least-squares line to usage.
+	MethodLinear = "linear"
+	// MethodEWMA averages the growth between successive points,
+	// weighting recent growth exponentially more.
+	MethodEWMA = "ewma"
+)
+
+// Defaults of a Forecaster.
+const (
+	DefaultWindow = 7 * 24 * time.Hour
+	DefaultAlpha  = 0.3
+)
+
+// maxHorizon bounds projections, beyond it usage is not projected to
+// exceed quota.
+const maxHorizon = 100 * 365 * 24 * time.Hour
+
+// Forecast is the projected growth of a key.
+type Forecast struct {
+	Method string
+	// GrowthBytesPerDay is the fitted growth of usage.
+	GrowthBytesPerDay float64
+	// ExceedsAt is when usage is projected to exceed quota, or nil if
+	// it is not.  If usage already exceeds quota it is the time of the
+	// forecast.
+	ExceedsAt *time.Time
+}
+
+// Forecaster forecasts keys from their usage history.
+type Forecaster struct {
+	// Method is MethodLinear or MethodEWMA.
+	Method string
+	// Window is the duration of history to fit.
+	Window time.Duration
+	// Alpha is the weight of each new growth for MethodEWMA, in (0, 1].
+	Alpha float64
+}
+
+// New returns a Forecaster, or an error if its configuration is invalid.
+func New(method string, window time.Duration, alpha float64) (*Forecaster, error) {
+	if method != MethodLinear && method != MethodEWMA {
+		return nil, fmt.Errorf("%s: %w", method, ErrUnknownMethod)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("window %s not positive", window)
+	}
+	if method == MethodEWMA && (alpha <= 0 || alpha > 1) {
+		return nil, fmt.Errorf("alpha %g not in (0, 1]", alpha)
+	}
+	return &Forecaster{Method: method, Window: window, Alpha: alpha}, nil
+}
+
+// Forecast returns the forecast at now of a key with info and usage
+// history points, sorted by time.  It returns nil if there is too little
+// history to fit growth.
+func (f *Forecaster) Forecast(points []store.HistoryPoint, info store.Info, now time.Time) *Forecast {
+	// The current usage is the latest point.
+	points = append(points[:len(points):len(points)], store.HistoryPoint{Time: now, UsageBytes: info.UsageBytes})
+	var (
+		perSecond float64
+		ok        bool
+	)
+	switch f.Method {
+	case MethodEWMA:
+		perSecond, ok = ewma(points, f.Alpha)
+	default:
+		perSecond, ok = linear(points)
+	}
+	if !ok {
+		return nil
+	}
+	ret := &Forecast{Method: f.Method, GrowthBytesPerDay: perSecond * (24 * time.Hour).Seconds()}
+	remaining := info.QuotaBytes - info.UsageBytes
+	switch {
+	case remaining < 0:
+		ret.ExceedsAt = &now
+	case perSecond > 0:
+		// Usage exceeds quota one byte after reaching it.
+		seconds := float64(remaining+1) / perSecond
+		if seconds < maxHorizon.Seconds() {
+			exceedsAt := now.Add(time.Duration(math.Ceil(seconds * float64(time.Second))))
+			ret.ExceedsAt = &exceedsAt
+		}
+	}
+	return ret
+}
+
+// linear returns the slope of the least-squares line of points, in bytes
+// per second.
+func linear(points []store.HistoryPoint) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+	t0 := points[0].Time
+	var sumX, sumY float64
+	for _, p := range points {
+		sumX += p.Time.Sub(t0).Seconds()
+		sumY += float64(p.UsageBytes)
+	}
+	n := float64(len(points))
+	meanX, meanY := sumX/n, sumY/n
+	var cov, variance float64
+	for _, p := range points {
+		dx := p.Time.Sub(t0).Seconds() - meanX
+		cov += dx * (float64(p.UsageBytes) - meanY)
+		variance += dx * dx
+	}
+	if variance == 0 {
+		return 0, false
+	}
+	return cov / variance, true
+}
+
+// ewma returns the exponentially weighted moving average of the growth
+// between successive points, in bytes per second.
+func ewma(points []store.HistoryPoint, alpha float64) (float64, bool) {
+	var (
+		avg float64
+		ok  bool
+	)
+	for i := 1; i < len(points); i++ {
+		dt := points[i].Time.Sub(points[i-1].Time).Seconds()
+		if dt <= 0 {
+			continue
+		}
+		growth := float64(points[i].UsageBytes-points[i-1].UsageBytes) / dt
+		if !ok {
+			avg, ok = growth, true
+			continue
+		}
+		avg = alpha*growth + (1-alpha)*avg
+	}
+	return avg, ok
+}
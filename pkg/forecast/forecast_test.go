@@ -0,0 +1,98 @@
+package forecast_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/forecast"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+var now = time.Date(2021, 1, 8, 0, 0, 0, 0, time.UTC)
+
+// daily returns points of usages on successive days ending the day before
+// now.
+func daily(usages ...int64) []store.HistoryPoint {
+	points := make([]store.HistoryPoint, 0, len(usages))
+	for i, u := range usages {
+		points = append(points, store.HistoryPoint{
+			Time:       now.AddDate(0, 0, i-len(usages)),
+			UsageBytes: u,
+		})
+	}
+	return points
+}
+
+func TestForecast(t *testing.T) {
+	linear, err := forecast.New(forecast.MethodLinear, forecast.DefaultWindow, 0)
+	if err != nil {
+		t.Fatalf("New linear: %s", err)
+	}
+	ewma, err := forecast.New(forecast.MethodEWMA, forecast.DefaultWindow, 0.5)
+	if err != nil {
+		t.Fatalf("New EWMA: %s", err)
+	}
+	day := 24 * time.Hour
+
+	cases := []struct {
+		Name       string
+		Forecaster *forecast.Forecaster
+		Points     []store.HistoryPoint
+		Info       store.Info
+		// Nil means no forecast.
+		Growth *float64
+		// Zero means never exceeds.
+		ExceedsIn time.Duration
+	}{
+		{"LinearSteady", linear, daily(100, 200, 300), store.Info{UsageBytes: 400, QuotaBytes: 1000}, float64p(100), 6*day + day/100},
+		{"LinearFlat", linear, daily(400, 400), store.Info{UsageBytes: 400, QuotaBytes: 1000}, float64p(0), 0},
+		{"LinearShrinking", linear, daily(600, 500), store.Info{UsageBytes: 400, QuotaBytes: 1000}, float64p(-100), 0},
+		{"AlreadyExceeded", linear, daily(0), store.Info{UsageBytes: 1001, QuotaBytes: 1000}, float64p(1001), time.Nanosecond},
+		{"NoHistory", linear, nil, store.Info{UsageBytes: 400, QuotaBytes: 1000}, nil, 0},
+		// Growth was 100, 100, then 300 bytes/day.
+		{"EWMA", ewma, daily(100, 200, 300), store.Info{UsageBytes: 600, QuotaBytes: 1000}, float64p(200), 2*day + day/200},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			f := c.Forecaster.Forecast(c.Points, c.Info, now)
+			if c.Growth == nil {
+				if f != nil {
+					t.Errorf("Expected no forecast, got %+v", f)
+				}
+				return
+			}
+			if f == nil {
+				t.Fatal("Expected a forecast")
+			}
+			if math.Abs(f.GrowthBytesPerDay-*c.Growth) > 1e-6 {
+				t.Errorf("Got growth %g bytes/day, expected %g", f.GrowthBytesPerDay, *c.Growth)
+			}
+			switch {
+			case c.ExceedsIn == 0 && f.ExceedsAt != nil:
+				t.Errorf("Expected never to exceed, got %s", f.ExceedsAt)
+			case c.ExceedsIn == time.Nanosecond && (f.ExceedsAt == nil || !f.ExceedsAt.Equal(now)):
+				t.Errorf("Expected to exceed now, got %v", f.ExceedsAt)
+			case c.ExceedsIn > time.Nanosecond && (f.ExceedsAt == nil || f.ExceedsAt.Sub(now).Round(time.Second) != c.ExceedsIn.Round(time.Second)):
+				t.Errorf("Expected to exceed in %s, got %v", c.ExceedsIn, f.ExceedsAt)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := forecast.New("magic", forecast.DefaultWindow, 0); !errors.Is(err, forecast.ErrUnknownMethod) {
+		t.Errorf("Expected %s, got %v", forecast.ErrUnknownMethod, err)
+	}
+	if _, err := forecast.New(forecast.MethodEWMA, forecast.DefaultWindow, 0); err == nil {
+		t.Error("Expected an error for zero alpha")
+	}
+	if _, err := forecast.New(forecast.MethodLinear, 0, 0); err == nil {
+		t.Error("Expected an error for zero window")
+	}
+}
+
+func float64p(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,158 @@
+// Package grace gives keys that exceed quota a grace period, during which
+// they may clean up before their quota is enforced.
+package grace
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// Store is a store.Store that records since when keys exceed quota, by any
+// limit of the store it wraps.  Changes that leave a key over quota within
+// its grace period return store.ErrQuotaGrace, and GetExceeded reports
+// such keys InGrace, so that enforcement waits for grace to expire.
+// CheckQuota reports keys that may not grow InGrace likewise, so that
+// their writes are not refused until grace expires.  A key that does not
+// exceed quota yet starts its grace period with the checked growth.
+//
+// Store should wrap all stores that add limits.  Failures to record a
+// change of exceeding are not errors of the change; the key is then
+// enforced without grace until GetExceeded records it.
+type Store struct {
+	store.Store
+	// DefaultGracePeriod is the grace period of keys without one.
+	DefaultGracePeriod time.Duration
+	// Now returns the current time, or nil for time.Now.
+	Now func() time.Time
+}
+
+// NewStore returns a Store that gives keys of s defaultGracePeriod.
+func NewStore(s store.Store, defaultGracePeriod time.Duration) *Store {
+	return &Store{Store: s, DefaultGracePeriod: defaultGracePeriod}
+}
+
+func (s *Store) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// GracePeriod returns the grace period of key.
+func (s *Store) GracePeriod(ctx context.Context, key string) (time.Duration, error) {
+	gracePeriod, err := s.Store.GetGracePeriod(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return s.DefaultGracePeriod, nil
+	}
+	return gracePeriod, err
+}
+
+// inGrace returns true if key, exceeding quota since since, is within its
+// grace period at now.
+func (s *Store) inGrace(ctx context.Context, key string, since, now time.Time) (bool, error) {
+	gracePeriod, err := s.GracePeriod(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return now.Before(since.Add(gracePeriod)), nil
+}
+
+// mark records whether key exceeds quota after a change that returned
+// err.  It returns err, or ErrQuotaGrace if key is in grace.
+func (s *Store) mark(ctx context.Context, key string, err error) error {
+	exceeded := errors.Is(err, store.ErrQuotaExceeded)
+	if err != nil && !exceeded {
+		return err
+	}
+	now := s.now()
+	since, markErr := s.Store.MarkExceeded(ctx, key, exceeded, now)
+	if markErr != nil || !exceeded {
+		return err
+	}
+	if ok, graceErr := s.inGrace(ctx, key, since, now); graceErr == nil && ok {
+		return store.ErrQuotaGrace
+	}
+	return err
+}
+
+func (s *Store) Set(ctx context.Context, key string, value store.Value) error {
+	return s.mark(ctx, key, s.Store.Set(ctx, key, value))
+}
+
+func (s *Store) AddSizeBytes(ctx context.Context, key string, numBytes int64, at time.Time) error {
+	return s.mark(ctx, key, s.Store.AddSizeBytes(ctx, key, numBytes, at))
+}
+
+func (s *Store) AddClassSizeBytes(ctx context.Context, key, storageClass string, numBytes int64, at time.Time) error {
+	return s.mark(ctx, key, s.Store.AddClassSizeBytes(ctx, key, storageClass, numBytes, at))
+}
+
+func (s *Store) PutObject(ctx context.Context, key string, o store.Object, at time.Time) error {
+	return s.mark(ctx, key, s.Store.PutObject(ctx, key, o, at))
+}
+
+func (s *Store) DeleteObject(ctx context.Context, path, versionID string, at time.Time) (string, error) {
+	key, err := s.Store.DeleteObject(ctx, path, versionID, at)
+	if key == "" {
+		return key, err
+	}
+	return key, s.mark(ctx, key, err)
+}
+
+func (s *Store) TransitionObject(ctx context.Context, path, versionID, storageClass string) (string, error) {
+	key, err := s.Store.TransitionObject(ctx, path, versionID, storageClass)
+	if key == "" {
+		return key, err
+	}
+	return key, s.mark(ctx, key, err)
+}
+
+// CheckQuota also reports whether a key that may not grow is in grace.
+func (s *Store) CheckQuota(ctx context.Context, key string, numBytes int64) (store.QuotaCheck, error) {
+	check, err := s.Store.CheckQuota(ctx, key, numBytes)
+	if err != nil || check.Allowed {
+		return check, err
+	}
+	now := s.now()
+	since, err := s.Store.GetExceededSince(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		since = now
+	} else if err != nil {
+		return store.QuotaCheck{}, err
+	}
+	if check.InGrace, err = s.inGrace(ctx, key, since, now); err != nil {
+		return store.QuotaCheck{}, err
+	}
+	return check, nil
+}
+
+// GetExceeded also records that exactly the returned keys exceed quota,
+// catching changes that did not pass through s such as changes of quota,
+// and reports since when and whether they are in grace.
+func (s *Store) GetExceeded(ctx context.Context) ([]store.Record, error) {
+	records, err := s.Store.GetExceeded(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(records))
+	for _, r := range records {
+		keys = append(keys, r.Key)
+	}
+	now := s.now()
+	since, err := s.Store.MarkAllExceeded(ctx, keys, now)
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		r := &records[i]
+		t := since[r.Key]
+		r.ExceededSince = &t
+		if r.InGrace, err = s.inGrace(ctx, r.Key, t, now); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,84 @@
+package grace_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/grace"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+const defaultQuota = 100
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	now := start
+	s := grace.NewStore(memory.NewStore(defaultQuota), time.Hour)
+	s.Now = func() time.Time { return now }
+	if err := s.SetGracePeriod(ctx, "strict", 0); err != nil {
+		t.Fatalf("SetGracePeriod: %s", err)
+	}
+
+	cases := []struct {
+		Name      string
+		Key       string
+		Path      string
+		SizeBytes int64
+		Err       error
+	}{
+		{"Within", "lenient", "s3://b/1", defaultQuota, nil},
+		{"Over", "lenient", "s3://b/2", 1, store.ErrQuotaGrace},
+		{"StillOver", "lenient", "s3://b/3", 1, store.ErrQuotaGrace},
+		{"NoGrace", "strict", "s3://b/4", defaultQuota + 1, store.ErrQuotaExceeded},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			err := s.PutObject(ctx, c.Key, store.Object{Path: c.Path, SizeBytes: c.SizeBytes}, now)
+			if !errors.Is(err, c.Err) || c.Err != store.ErrQuotaGrace && errors.Is(err, store.ErrQuotaGrace) {
+				t.Errorf("Got %v, expected %v", err, c.Err)
+			}
+		})
+		now = now.Add(time.Minute)
+	}
+
+	check := func(name string, expected map[string]bool) {
+		t.Helper()
+		records, err := s.GetExceeded(ctx)
+		if err != nil {
+			t.Fatalf("%s: GetExceeded: %s", name, err)
+		}
+		if len(records) != len(expected) {
+			t.Fatalf("%s: GetExceeded: got %+v", name, records)
+		}
+		for _, r := range records {
+			inGrace, ok := expected[r.Key]
+			if !ok || r.InGrace != inGrace || r.ExceededSince == nil {
+				t.Errorf("%s: got %s in grace %t since %v", name, r.Key, r.InGrace, r.ExceededSince)
+			}
+		}
+	}
+	check("InGrace", map[string]bool{"lenient": true, "strict": false})
+
+	// Grace counts from when the key started to exceed quota.
+	now = start.Add(time.Minute + time.Hour)
+	check("GraceExpired", map[string]bool{"lenient": false, "strict": false})
+	if _, err := s.DeleteObject(ctx, "s3://b/2", "", now); !errors.Is(err, store.ErrQuotaExceeded) || errors.Is(err, store.ErrQuotaGrace) {
+		t.Errorf("DeleteObject still over quota: expected %s, got %v", store.ErrQuotaExceeded, err)
+	}
+
+	// Cleaning up ends the grace period, so exceeding again starts a
+	// new one.
+	for _, path := range []string{"s3://b/1", "s3://b/3"} {
+		if _, err := s.DeleteObject(ctx, path, "", now); err != nil {
+			t.Fatalf("DeleteObject %s: %s", path, err)
+		}
+	}
+	if err := s.AddSizeBytes(ctx, "lenient", defaultQuota+1, now); !errors.Is(err, store.ErrQuotaGrace) {
+		t.Errorf("AddSizeBytes over quota again: expected %s, got %v", store.ErrQuotaGrace, err)
+	}
+	check("Again", map[string]bool{"lenient": true, "strict": false})
+}
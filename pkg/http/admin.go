@@ -0,0 +1,219 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/audit"
+	"github.com/treeverse/terminus/pkg/auth"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// DefaultAuditLimit is the number of audit entries returned when the
+// request sets no limit.
+const DefaultAuditLimit = 1000
+
+// withActor is middleware that makes the authenticated principal, or the
+// remote address without authentication, the actor of changes.
+func withActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := r.RemoteAddr
+		if p := auth.FromContext(r.Context()); p != nil {
+			actor = p.Name
+		}
+		next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), actor)))
+	})
+}
+
+// routeKey returns the key of a request routed on "/*".  Keys may hold
+// any character, so they are the rest of the path.  It is escaped if the
+// request path needed escaping, as chi then routes on the raw path.
+func routeKey(r *http.Request) (string, error) {
+	key := chi.URLParam(r, "*")
+	if r.URL.RawPath == "" {
+		return key, nil
+	}
+	return url.PathUnescape(key)
+}
+
+func (s *Server) setQuota(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	var req api.SetQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse request: %v", err)
+		return
+	}
+	if req.QuotaBytes < 0 {
+		s.writeError(w, http.StatusBadRequest, "Negative QuotaBytes %d", req.QuotaBytes)
+		return
+	}
+	if err = s.Store.SetQuota(r.Context(), key, req.QuotaBytes); err != nil {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Set quota")
+		s.writeError(w, http.StatusInternalServerError, "Set quota: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) clearQuota(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	if err = s.Store.ClearQuota(r.Context(), key); err != nil {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Clear quota")
+		s.writeError(w, http.StatusInternalServerError, "Clear quota: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) setObjectQuota(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	var req api.SetObjectQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse request: %v", err)
+		return
+	}
+	if req.QuotaObjects < 0 {
+		s.writeError(w, http.StatusBadRequest, "Negative QuotaObjects %d", req.QuotaObjects)
+		return
+	}
+	if err = s.Store.SetObjectQuota(r.Context(), key, req.QuotaObjects); err != nil {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Set object quota")
+		s.writeError(w, http.StatusInternalServerError, "Set object quota: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) clearObjectQuota(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	if err = s.Store.ClearObjectQuota(r.Context(), key); err != nil {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Clear object quota")
+		s.writeError(w, http.StatusInternalServerError, "Clear object quota: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) setGracePeriod(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	var req api.SetGracePeriodRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse request: %v", err)
+		return
+	}
+	if req.GracePeriodSeconds < 0 {
+		s.writeError(w, http.StatusBadRequest, "Negative GracePeriodSeconds %d", req.GracePeriodSeconds)
+		return
+	}
+	gracePeriod := time.Duration(req.GracePeriodSeconds) * time.Second
+	if err = s.Store.SetGracePeriod(r.Context(), key, gracePeriod); err != nil {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Set grace period")
+		s.writeError(w, http.StatusInternalServerError, "Set grace period: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) clearGracePeriod(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	if err = s.Store.ClearGracePeriod(r.Context(), key); err != nil {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Clear grace period")
+		s.writeError(w, http.StatusInternalServerError, "Clear grace period: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) setUsage(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	var req api.SetUsageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse request: %v", err)
+		return
+	}
+	err = s.Store.Set(r.Context(), key, store.Value{SizeBytes: req.SizeBytes})
+	if err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Set usage")
+		s.writeError(w, http.StatusInternalServerError, "Set usage: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseAuditFilter returns the audit filter of the query parameters "key",
+// "since" and "until" (RFC 3339), and "limit".
+func parseAuditFilter(r *http.Request) (store.AuditFilter, error) {
+	q := r.URL.Query()
+	f := store.AuditFilter{Key: q.Get("key"), Limit: DefaultAuditLimit}
+	var err error
+	if since := q.Get("since"); since != "" {
+		if f.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return f, err
+		}
+	}
+	if until := q.Get("until"); until != "" {
+		if f.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return f, err
+		}
+	}
+	if limit := q.Get("limit"); limit != "" {
+		if f.Limit, err = strconv.Atoi(limit); err != nil {
+			return f, err
+		}
+		if f.Limit <= 0 || f.Limit > DefaultAuditLimit {
+			f.Limit = DefaultAuditLimit
+		}
+	}
+	return f, nil
+}
+
+func (s *Server) listAudit(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse filter: %v", err)
+		return
+	}
+	entries, err := s.Store.ListAudit(r.Context(), f)
+	if err != nil {
+		s.Logger.WithError(err).Error("List audit")
+		s.writeError(w, http.StatusInternalServerError, "List audit: %v", err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, api.AuditResponse{Entries: entries})
+}
@@ -0,0 +1,140 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/audit"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+func TestAdmin(t *testing.T) {
+	s, _ := newServer(t)
+	s.Store = audit.NewStore(s.Store, logging.Discard())
+	ts := httptest.NewServer(s.ServeAdmin())
+	t.Cleanup(ts.Close)
+
+	const key = "team/alice"
+	requests := []struct {
+		Method string
+		Path   string
+		Body   interface{}
+		Status int
+	}{
+		{http.MethodPut, "/internal/admin/v1/quota/" + key, api.SetQuotaRequest{QuotaBytes: 7}, http.StatusNoContent},
+		{http.MethodPut, "/internal/admin/v1/quota/" + key, api.SetQuotaRequest{QuotaBytes: -1}, http.StatusBadRequest},
+		{http.MethodPut, "/internal/admin/v1/usage/" + key, api.SetUsageRequest{SizeBytes: 9}, http.StatusNoContent},
+		{http.MethodDelete, "/internal/admin/v1/quota/" + key, nil, http.StatusNoContent},
+		{http.MethodPut, "/internal/admin/v1/object-quota/" + key, api.SetObjectQuotaRequest{QuotaObjects: 3}, http.StatusNoContent},
+		{http.MethodPut, "/internal/admin/v1/object-quota/" + key, api.SetObjectQuotaRequest{QuotaObjects: -1}, http.StatusBadRequest},
+		{http.MethodDelete, "/internal/admin/v1/object-quota/" + key, nil, http.StatusNoContent},
+		{http.MethodPut, "/internal/admin/v1/grace-period/" + key, api.SetGracePeriodRequest{GracePeriodSeconds: 3600}, http.StatusNoContent},
+		{http.MethodPut, "/internal/admin/v1/grace-period/" + key, api.SetGracePeriodRequest{GracePeriodSeconds: -1}, http.StatusBadRequest},
+		{http.MethodDelete, "/internal/admin/v1/grace-period/" + key, nil, http.StatusNoContent},
+		{http.MethodPut, "/internal/admin/v1/usage/other", api.SetUsageRequest{SizeBytes: 1}, http.StatusNoContent},
+	}
+	for _, r := range requests {
+		if status := do(t, ts, r.Method, r.Path, r.Body, nil); status != r.Status {
+			t.Errorf("%s %s: got status %d expected %d", r.Method, r.Path, status, r.Status)
+		}
+	}
+
+	var resp api.AuditResponse
+	if status := do(t, ts, http.MethodGet, "/internal/admin/v1/audit?key="+url.QueryEscape(key), nil, &resp); status != http.StatusOK {
+		t.Fatalf("List audit: got status %d", status)
+	}
+	var actions []string
+	for _, e := range resp.Entries {
+		if e.Actor == "" || e.Actor == audit.SystemActor {
+			t.Errorf("Entry %d has actor %q, expected remote address", e.ID, e.Actor)
+		}
+		actions = append(actions, e.Action)
+	}
+	expected := []string{
+		store.AuditSetQuota, store.AuditSetUsage, store.AuditClearQuota,
+		store.AuditSetObjectQuota, store.AuditClearObjectQuota,
+		store.AuditSetGracePeriod, store.AuditClearGracePeriod,
+	}
+	if diffs := deep.Equal(actions, expected); diffs != nil {
+		t.Errorf("Unexpected audited actions: %s", diffs)
+	}
+
+	if status := do(t, ts, http.MethodGet, "/internal/admin/v1/audit?since=yesterday", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("List audit with bad time: got status %d expected %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestPlans(t *testing.T) {
+	s, rest := newServer(t)
+	ts := httptest.NewServer(s.ServeAdmin())
+	t.Cleanup(ts.Close)
+
+	quotaBytes := int64(70)
+	team := api.Plan{Name: "team", QuotaBytes: &quotaBytes, RateLimits: []api.PlanRateLimit{{WindowSeconds: 3600, LimitBytes: 10}}}
+	negative := int64(-1)
+	requests := []struct {
+		Method string
+		Path   string
+		Body   interface{}
+		Status int
+	}{
+		{http.MethodPut, "/internal/admin/v1/plans/team", team, http.StatusNoContent},
+		{http.MethodPut, "/internal/admin/v1/plans/bad", api.Plan{QuotaBytes: &negative}, http.StatusBadRequest},
+		{http.MethodPut, "/internal/admin/v1/plan/alice", api.AssignPlanRequest{Plan: "missing"}, http.StatusNotFound},
+		{http.MethodPut, "/internal/admin/v1/plan/alice", api.AssignPlanRequest{Plan: "team"}, http.StatusNoContent},
+		{http.MethodGet, "/internal/admin/v1/plans/missing", nil, http.StatusNotFound},
+		{http.MethodDelete, "/internal/admin/v1/plans/missing", nil, http.StatusNotFound},
+	}
+	for _, r := range requests {
+		if status := do(t, ts, r.Method, r.Path, r.Body, nil); status != r.Status {
+			t.Errorf("%s %s: got status %d expected %d", r.Method, r.Path, status, r.Status)
+		}
+	}
+
+	var plan api.Plan
+	if status := do(t, ts, http.MethodGet, "/internal/admin/v1/plans/team", nil, &plan); status != http.StatusOK {
+		t.Fatalf("Get plan: got status %d", status)
+	}
+	if diffs := deep.Equal(plan, team); diffs != nil {
+		t.Errorf("Get plan: %s", diffs)
+	}
+	var plans api.PlansResponse
+	if status := do(t, ts, http.MethodGet, "/internal/admin/v1/plans", nil, &plans); status != http.StatusOK {
+		t.Fatalf("List plans: got status %d", status)
+	}
+	if diffs := deep.Equal(plans.Plans, []api.Plan{team}); diffs != nil {
+		t.Errorf("List plans: %s", diffs)
+	}
+
+	// Changes of the plan apply at once to its keys.
+	quotaBytes = 80
+	if status := do(t, ts, http.MethodPut, "/internal/admin/v1/plans/team", team, nil); status != http.StatusNoContent {
+		t.Fatalf("Set plan: got status %d", status)
+	}
+	var record store.Record
+	if status := do(t, rest, http.MethodGet, "/keys/alice", nil, &record); status != http.StatusOK {
+		t.Fatalf("Get key: got status %d", status)
+	}
+	if record.Info.Plan != "team" || record.Info.QuotaBytes != 80 {
+		t.Errorf("Get key on plan: got %+v", record.Info)
+	}
+
+	if status := do(t, ts, http.MethodDelete, "/internal/admin/v1/plan/alice", nil, nil); status != http.StatusNoContent {
+		t.Errorf("Unassign plan: got status %d", status)
+	}
+	if status := do(t, ts, http.MethodDelete, "/internal/admin/v1/plans/team", nil, nil); status != http.StatusNoContent {
+		t.Errorf("Delete plan: got status %d", status)
+	}
+	if status := do(t, rest, http.MethodGet, "/keys/alice", nil, &record); status != http.StatusOK {
+		t.Fatalf("Get key: got status %d", status)
+	}
+	if record.Info.Plan != "" || record.Info.QuotaBytes != defaultQuota {
+		t.Errorf("Get key without plan: got %+v", record.Info)
+	}
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/anomaly"
+	"github.com/treeverse/terminus/pkg/api"
+)
+
+func (s *Server) detector() *anomaly.Detector {
+	if s.Anomalies != nil {
+		return s.Anomalies
+	}
+	return anomaly.DefaultDetector
+}
+
+// getAnomalies lists keys whose ingest over the latest window is
+// anomalous.
+func (s *Server) getAnomalies(w http.ResponseWriter, r *http.Request) {
+	d := s.detector()
+	anomalies, err := d.Detect(r.Context(), s.Store, time.Now())
+	if err != nil {
+		s.Logger.WithError(err).Error("Detect anomalies")
+		s.writeError(w, http.StatusInternalServerError, "Detect anomalies: %v", err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, api.AnomaliesResponse{
+		WindowSeconds: int64(d.Window / time.Second),
+		Anomalies:     anomalies,
+	})
+}
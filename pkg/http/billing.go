@@ -0,0 +1,26 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/billing"
+)
+
+// getBilling reports the usage of every key over the month in the query
+// parameter "month".
+func (s *Server) getBilling(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	from, to, err := billing.ParseMonth(month)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse month: %v", err)
+		return
+	}
+	report, err := billing.NewReport(r.Context(), s.Store, from, to)
+	if err != nil {
+		s.Logger.WithError(err).WithField("month", month).Error("Report billing")
+		s.writeError(w, http.StatusInternalServerError, "Report billing: %v", err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, api.BillingResponse{Month: month, From: report.From, To: report.To, Lines: report.Lines})
+}
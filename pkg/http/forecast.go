@@ -0,0 +1,116 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/forecast"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// DefaultForecastWithin is the horizon of forecasts listed when the
+// request sets no "within".
+const DefaultForecastWithin = 7 * 24 * time.Hour
+
+// defaultForecaster forecasts when the Server has no Forecaster.
+var defaultForecaster = &forecast.Forecaster{Method: forecast.MethodLinear, Window: forecast.DefaultWindow}
+
+func (s *Server) forecaster() *forecast.Forecaster {
+	if s.Forecaster != nil {
+		return s.Forecaster
+	}
+	return defaultForecaster
+}
+
+// forecast returns the forecast of key with info at now, or nil if it has
+// too little history.
+func (s *Server) forecast(ctx context.Context, key string, info store.Info, now time.Time) (*forecast.Forecast, error) {
+	f := s.forecaster()
+	points, err := s.Store.GetHistory(ctx, key, now.Add(-f.Window), now)
+	if err != nil {
+		return nil, err
+	}
+	return f.Forecast(points, info, now), nil
+}
+
+func (s *Server) getUsage(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	l := s.Logger.WithField(logging.FieldKey, key)
+	check, err := s.Store.CheckQuota(r.Context(), key, 0)
+	if err != nil {
+		l.WithError(err).Error("Get usage")
+		s.writeError(w, http.StatusInternalServerError, "Get usage: %v", err)
+		return
+	}
+	f, err := s.forecast(r.Context(), key, check.Info, time.Now())
+	if err != nil {
+		l.WithError(err).Error("Forecast")
+		s.writeError(w, http.StatusInternalServerError, "Forecast: %v", err)
+		return
+	}
+	classBytes, err := s.Store.GetClassUsage(r.Context(), key)
+	if err != nil {
+		l.WithError(err).Error("Get usage by storage class")
+		s.writeError(w, http.StatusInternalServerError, "Get usage by storage class: %v", err)
+		return
+	}
+	resp := api.UsageResponse{Key: key, Info: check.Info, StorageClasses: classBytes, Forecast: f}
+	if s.Costs != nil {
+		c := s.Costs.Cost(key, check.Info.UsageBytes, classBytes)
+		resp.Cost = &c
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// listForecast lists keys projected to exceed quota within the query
+// parameter "within".  It forecasts every key, a page at a time.
+func (s *Server) listForecast(w http.ResponseWriter, r *http.Request) {
+	within := DefaultForecastWithin
+	if q := r.URL.Query().Get("within"); q != "" {
+		var err error
+		if within, err = parseDuration(q); err != nil {
+			s.writeError(w, http.StatusBadRequest, "Parse within: %v", err)
+			return
+		}
+	}
+	ctx := r.Context()
+	now := time.Now()
+	deadline := now.Add(within)
+	resp := api.ForecastResponse{Forecasts: []api.KeyForecast{}}
+	after := ""
+	for {
+		records, err := s.Store.List(ctx, after, DefaultListLimit)
+		if err != nil {
+			s.Logger.WithError(err).Error("List keys to forecast")
+			s.writeError(w, http.StatusInternalServerError, "List keys: %v", err)
+			return
+		}
+		for _, rec := range records {
+			f, err := s.forecast(ctx, rec.Key, rec.Info, now)
+			if err != nil {
+				s.Logger.WithError(err).WithField(logging.FieldKey, rec.Key).Error("Forecast")
+				s.writeError(w, http.StatusInternalServerError, "Forecast %s: %v", rec.Key, err)
+				return
+			}
+			if f != nil && f.ExceedsAt != nil && !f.ExceedsAt.After(deadline) {
+				resp.Forecasts = append(resp.Forecasts, api.KeyForecast{Key: rec.Key, Info: rec.Info, Forecast: *f})
+			}
+		}
+		if len(records) < DefaultListLimit {
+			break
+		}
+		after = records[len(records)-1].Key
+	}
+	sort.SliceStable(resp.Forecasts, func(i, j int) bool {
+		return resp.Forecasts[i].Forecast.ExceedsAt.Before(*resp.Forecasts[j].Forecast.ExceedsAt)
+	})
+	s.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// DefaultHistoryRange is the range of history returned when the request
+// sets no "from".
+const DefaultHistoryRange = 7 * 24 * time.Hour
+
+// parseDuration parses a Go duration, or a number of days such as "7d".
+func parseDuration(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse days %s: %w", s, err)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// parseHistoryQuery returns the query parameters "from" and "to" (RFC
+// 3339) and "step" (as parseDuration) of r, defaulting to the
+// DefaultHistoryRange until now and no step.
+func parseHistoryQuery(r *http.Request) (from, to time.Time, step time.Duration, err error) {
+	q := r.URL.Query()
+	to = time.Now()
+	if s := q.Get("to"); s != "" {
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			return from, to, step, fmt.Errorf("to: %w", err)
+		}
+	}
+	from = to.Add(-DefaultHistoryRange)
+	if s := q.Get("from"); s != "" {
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			return from, to, step, fmt.Errorf("from: %w", err)
+		}
+	}
+	if s := q.Get("step"); s != "" {
+		if step, err = parseDuration(s); err != nil {
+			return from, to, step, fmt.Errorf("step: %w", err)
+		}
+		if step <= 0 {
+			return from, to, step, fmt.Errorf("step %s not positive", step)
+		}
+	}
+	return from, to, step, nil
+}
+
+// getHistory serves the usage history of a key on its own route rather
+// than under "/usage/*": keys may hold "/", so no suffix of a key route can
+// tell its requests apart.
+func (s *Server) getHistory(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	from, to, step, err := parseHistoryQuery(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse query: %v", err)
+		return
+	}
+	points, err := s.Store.GetHistory(r.Context(), key, from, to)
+	if err != nil {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Get history")
+		s.writeError(w, http.StatusInternalServerError, "Get history: %v", err)
+		return
+	}
+	if step > 0 {
+		points = store.Resample(points, step)
+	}
+	s.writeJSON(w, http.StatusOK, api.HistoryResponse{Key: key, Points: points})
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// lakeFSHook fails lakeFS pre-commit and pre-merge hooks of committers
+// whose keys may not grow, by the same check as the S3 proxy.  That covers
+// every limit of the store: bytes, objects and any rate or cost quotas.
+// Keys in grace still pass.
+// lakeFS fails the action on any response other than 2xx, and reports the
+// response body.
+func (s *Server) lakeFSHook(w http.ResponseWriter, r *http.Request) {
+	var event api.LakeFSHookEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse lakeFS hook event: %v", err)
+		return
+	}
+	if event.EventType != api.EventTypePreCommit && event.EventType != api.EventTypePreMerge {
+		s.writeError(w, http.StatusBadRequest, "Unsupported event type %q, configure only %s or %s hooks",
+			event.EventType, api.EventTypePreCommit, api.EventTypePreMerge)
+		return
+	}
+
+	path := keys.LakeFSPath(event.RepositoryID, event.Committer)
+	key, ok := s.HookKeys.Key(path)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	l := s.Logger.WithFields(logging.Fields{
+		logging.FieldKey:  key,
+		logging.FieldPath: path,
+		"event_type":      event.EventType,
+		"branch":          event.BranchID,
+	})
+
+	check, err := s.Store.CheckQuota(r.Context(), key, 0)
+	if err != nil {
+		l.WithError(err).Error("Check quota")
+		s.writeError(w, http.StatusInternalServerError, "Check quota: %v", err)
+		return
+	}
+	if check.InGrace {
+		l.Debug("Pass lakeFS hook over quota in grace")
+	}
+	if !check.Allowed && !check.InGrace {
+		l.Info("Fail lakeFS hook over quota")
+		s.writeError(w, http.StatusPreconditionFailed, "Terminus: %s blocked, %s is over quota: %s",
+			event.EventType, key, describeUsage(check))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// describeUsage describes the usage and quotas of check for users.
+func describeUsage(check store.QuotaCheck) string {
+	info := check.Info
+	ret := fmt.Sprintf("uses %s of its %s quota", humanize.IBytes(uint64(info.UsageBytes)), humanize.IBytes(uint64(info.QuotaBytes)))
+	if check.ReservedBytes > 0 {
+		ret += fmt.Sprintf(" with %s reserved for uploads", humanize.IBytes(uint64(check.ReservedBytes)))
+	}
+	if info.QuotaObjects > 0 {
+		ret += fmt.Sprintf(", %d of its %d objects", info.ObjectCount, info.QuotaObjects)
+	}
+	return ret
+}
@@ -0,0 +1,88 @@
+package http_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/grace"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+func TestLakeFSHook(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	if err := s.Store.Set(ctx, "alice", store.Value{SizeBytes: defaultQuota}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if err := s.Store.Set(ctx, "bob", store.Value{SizeBytes: defaultQuota + 1}); err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Fatalf("Set: %s", err)
+	}
+
+	// dave is within the quota of bytes but over the quota of objects.
+	if err := s.Store.SetObjectQuota(ctx, "dave", 1); err != nil {
+		t.Fatalf("SetObjectQuota: %s", err)
+	}
+	for _, path := range []string{"s3://b/user/dave/a", "s3://b/user/dave/b"} {
+		if err := s.Store.PutObject(ctx, "dave", store.Object{Path: path, SizeBytes: 1}, time.Now()); err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+			t.Fatalf("PutObject %s: %s", path, err)
+		}
+	}
+	cases := []struct {
+		Name   string
+		Event  api.LakeFSHookEvent
+		Status int
+	}{
+		{"CommitAtQuota", api.LakeFSHookEvent{EventType: api.EventTypePreCommit, RepositoryID: "repo", Committer: "alice"}, http.StatusNoContent},
+		{"CommitOverQuota", api.LakeFSHookEvent{EventType: api.EventTypePreCommit, RepositoryID: "repo", Committer: "bob"}, http.StatusPreconditionFailed},
+		{"MergeOverQuota", api.LakeFSHookEvent{EventType: api.EventTypePreMerge, RepositoryID: "repo", Committer: "bob"}, http.StatusPreconditionFailed},
+		{"CommitOverObjectQuota", api.LakeFSHookEvent{EventType: api.EventTypePreCommit, RepositoryID: "repo", Committer: "dave"}, http.StatusPreconditionFailed},
+		{"NewCommitter", api.LakeFSHookEvent{EventType: api.EventTypePreCommit, RepositoryID: "repo", Committer: "carol"}, http.StatusNoContent},
+		{"UnsupportedEvent", api.LakeFSHookEvent{EventType: "post-commit", RepositoryID: "repo", Committer: "bob"}, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if status := do(t, ts, http.MethodPost, "/hooks/lakefs", c.Event, nil); status != c.Status {
+				t.Errorf("Got status %d expected %d", status, c.Status)
+			}
+		})
+	}
+}
+
+func TestLakeFSHookGrace(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	now := time.Now()
+	g := grace.NewStore(s.Store, time.Hour)
+	g.Now = func() time.Time { return now }
+	s.Store = g
+	// expired has exceeded quota for longer than its grace period.
+	if _, err := g.Store.MarkExceeded(ctx, "expired", true, now.Add(-2*time.Hour)); err != nil {
+		t.Fatalf("MarkExceeded: %s", err)
+	}
+	for _, key := range []string{"lenient", "expired"} {
+		if err := g.Set(ctx, key, store.Value{SizeBytes: defaultQuota + 1}); err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+			t.Fatalf("Set %s: %s", key, err)
+		}
+	}
+
+	cases := []struct {
+		Name      string
+		Committer string
+		Status    int
+	}{
+		{"InGrace", "lenient", http.StatusNoContent},
+		{"GraceExpired", "expired", http.StatusPreconditionFailed},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			event := api.LakeFSHookEvent{EventType: api.EventTypePreCommit, RepositoryID: "repo", Committer: c.Committer}
+			if status := do(t, ts, http.MethodPost, "/hooks/lakefs", event, nil); status != c.Status {
+				t.Errorf("Got status %d expected %d", status, c.Status)
+			}
+		})
+	}
+}
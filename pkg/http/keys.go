@@ -0,0 +1,88 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/export"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// DefaultListLimit is the number of keys returned when the request sets
+// no limit.
+const DefaultListLimit = 1000
+
+func (s *Server) listKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := DefaultListLimit
+	if l := q.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			s.writeError(w, http.StatusBadRequest, "Parse limit: %v", err)
+			return
+		}
+		if limit <= 0 || limit > DefaultListLimit {
+			limit = DefaultListLimit
+		}
+	}
+	records, err := s.Store.List(r.Context(), q.Get("after"), limit)
+	if err != nil {
+		s.Logger.WithError(err).Error("List keys")
+		s.writeError(w, http.StatusInternalServerError, "List keys: %v", err)
+		return
+	}
+	resp := api.ListKeysResponse{Records: records}
+	if len(records) == limit {
+		resp.Next = records[len(records)-1].Key
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) getKey(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	check, err := s.Store.CheckQuota(r.Context(), key, 0)
+	if err != nil {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Get key")
+		s.writeError(w, http.StatusInternalServerError, "Get key: %v", err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, store.Record{Key: key, Info: check.Info})
+}
+
+// exportUsage streams all keys in the format of the query parameter
+// "format", CSV by default.
+func (s *Server) exportUsage(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if err := export.CheckFormat(format); err != nil {
+		s.writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	// The Parquet writer sends its header as soon as it starts.
+	h := w.Header()
+	h.Set("Content-Type", export.ContentType(format))
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage.%s"`, format))
+	ew, err := export.NewWriter(w, format)
+	if err != nil {
+		s.Logger.WithError(err).WithField("format", format).Error("Start export")
+		h.Del("Content-Disposition")
+		s.writeError(w, http.StatusInternalServerError, "Start export: %v", err)
+		return
+	}
+	n, err := export.Export(r.Context(), s.Store, ew)
+	if err != nil {
+		// The status is already sent, abort so the client sees a
+		// truncated response rather than a short export.
+		s.Logger.WithError(err).WithFields(logging.Fields{"format": format, "keys": n}).Error("Export")
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// routePlan returns the plan name of a request routed on "/{name}".
+func routePlan(r *http.Request) (string, error) {
+	name := chi.URLParam(r, "name")
+	if r.URL.RawPath == "" {
+		return name, nil
+	}
+	return url.PathUnescape(name)
+}
+
+func (s *Server) listPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := s.Store.ListPlans(r.Context())
+	if err != nil {
+		s.Logger.WithError(err).Error("List plans")
+		s.writeError(w, http.StatusInternalServerError, "List plans: %v", err)
+		return
+	}
+	resp := api.PlansResponse{Plans: make([]api.Plan, 0, len(plans))}
+	for _, p := range plans {
+		resp.Plans = append(resp.Plans, api.NewPlan(p))
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) getPlan(w http.ResponseWriter, r *http.Request) {
+	name, err := routePlan(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse plan: %v", err)
+		return
+	}
+	p, err := s.Store.GetPlan(r.Context(), name)
+	if errors.Is(err, store.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, "No plan %s", name)
+		return
+	}
+	if err != nil {
+		s.Logger.WithError(err).WithField("plan", name).Error("Get plan")
+		s.writeError(w, http.StatusInternalServerError, "Get plan: %v", err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, api.NewPlan(p))
+}
+
+// setPlan creates or replaces the plan of the route.  The Name of the
+// request is ignored.
+func (s *Server) setPlan(w http.ResponseWriter, r *http.Request) {
+	name, err := routePlan(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse plan: %v", err)
+		return
+	}
+	var req api.Plan
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse request: %v", err)
+		return
+	}
+	req.Name = name
+	err = s.Store.SetPlan(r.Context(), req.StorePlan())
+	if errors.Is(err, store.ErrBadPlan) {
+		s.writeError(w, http.StatusBadRequest, "Set plan: %v", err)
+		return
+	}
+	if err != nil {
+		s.Logger.WithError(err).WithField("plan", name).Error("Set plan")
+		s.writeError(w, http.StatusInternalServerError, "Set plan: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deletePlan(w http.ResponseWriter, r *http.Request) {
+	name, err := routePlan(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse plan: %v", err)
+		return
+	}
+	err = s.Store.DeletePlan(r.Context(), name)
+	if errors.Is(err, store.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, "No plan %s", name)
+		return
+	}
+	if err != nil {
+		s.Logger.WithError(err).WithField("plan", name).Error("Delete plan")
+		s.writeError(w, http.StatusInternalServerError, "Delete plan: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) assignPlan(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	var req api.AssignPlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse request: %v", err)
+		return
+	}
+	err = s.Store.AssignPlan(r.Context(), key, req.Plan)
+	if errors.Is(err, store.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, "No plan %s", req.Plan)
+		return
+	}
+	if err != nil {
+		s.Logger.WithError(err).WithFields(logging.Fields{logging.FieldKey: key, "plan": req.Plan}).Error("Assign plan")
+		s.writeError(w, http.StatusInternalServerError, "Assign plan: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) unassignPlan(w http.ResponseWriter, r *http.Request) {
+	key, err := routeKey(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse key: %v", err)
+		return
+	}
+	if err = s.Store.UnassignPlan(r.Context(), key); err != nil {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Unassign plan")
+		s.writeError(w, http.StatusInternalServerError, "Unassign plan: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,29 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/api"
+)
+
+// getRateExceeded lists keys that ingest more than any of their rate
+// limits.
+func (s *Server) getRateExceeded(w http.ResponseWriter, r *http.Request) {
+	statuses, err := s.RateLimits.Exceeded(r.Context(), s.Store, time.Now())
+	if err != nil {
+		s.Logger.WithError(err).Error("Get keys exceeding rate quota")
+		s.writeError(w, http.StatusInternalServerError, "Get keys exceeding rate quota: %v", err)
+		return
+	}
+	resp := api.RateExceededResponse{Statuses: make([]api.RateStatus, 0, len(statuses))}
+	for _, status := range statuses {
+		resp.Statuses = append(resp.Statuses, api.RateStatus{
+			Key:           status.Key,
+			WindowSeconds: int64(status.Limit.Window / time.Second),
+			LimitBytes:    status.Limit.Bytes,
+			IngestBytes:   status.IngestBytes,
+		})
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
@@ -3,10 +3,10 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	http_pprof "net/http/pprof"
 	"os"
@@ -17,6 +17,14 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/treeverse/terminus/pkg/anomaly"
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/auth"
+	"github.com/treeverse/terminus/pkg/cost"
+	"github.com/treeverse/terminus/pkg/forecast"
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/rate"
 	"github.com/treeverse/terminus/pkg/store"
 )
 
@@ -26,27 +34,77 @@ const (
 )
 
 type Server struct {
-	Store store.Store
+	Store  store.Store
+	Logger logging.Logger
+	// Auth authenticates requests to the REST API, which requires
+	// auth.RoleUser, and to profiling, which requires auth.RoleAdmin.
+	// If nil, nothing is authenticated.
+	Auth auth.Authenticator
+	// Keys maps paths to keys.
+	Keys *keys.Mapper
+	// HookKeys maps lakeFS committers on repositories, as paths
+	// "lakefs://repository/committer", to keys.  If nil, lakeFS hooks
+	// are not served.
+	HookKeys *keys.Mapper
+	// ReservationTTL is the time to live of reservations that do not
+	// request one.
+	ReservationTTL time.Duration
+	// Forecaster forecasts usage, or nil for a linear fit of a week.
+	Forecaster *forecast.Forecaster
+	// Costs prices usage of keys, or nil not to price it.
+	Costs *cost.Config
+	// Anomalies detects anomalous ingest, or nil for
+	// anomaly.DefaultDetector.
+	Anomalies *anomaly.Detector
+	// RateLimits are quotas of ingest of keys, or empty for none.  Keys
+	// on plans with rate limits use those instead.
+	RateLimits rate.Limits
+	// AdminListenAddress is the address for profiling and
+	// administration, e.g. on localhost only.  If empty they share the
+	// main address.
+	AdminListenAddress string
+	// TLSConfig configures TLS on all addresses, or nil to serve plain
+	// HTTP.
+	TLSConfig *tls.Config
 }
 
-// Serve serves all HTTP traffic on ctx, until that is cancelled.
+// Serve serves all HTTP traffic on ctx, until that is cancelled.  It
+// serves profiling and administration on AdminListenAddress if set, and
+// otherwise on listenAddress.
 func (s *Server) Serve(ctx context.Context, listenAddress string) {
 	router := chi.NewRouter()
 	router.Mount("/_health", ServeHealth())
-	router.Mount("/internal/_pprof/", ServePPRof())
 	// Internal service, respond only on a designated "internal" endpoint.
-	router.Mount("/internal/api/v1", s.ServeREST())
+	router.Mount("/internal/api/v1", s.requireRole(auth.RoleUser, s.ServeREST()))
+	if s.AdminListenAddress == "" {
+		router.Mount("/", s.requireRole(auth.RoleAdmin, s.ServeAdmin()))
+	} else {
+		s.listen(ctx, s.AdminListenAddress, s.requireRole(auth.RoleAdmin, s.ServeAdmin()))
+	}
+	s.listen(ctx, listenAddress, router)
+}
+
+// listen serves handler on listenAddress until ctx is cancelled.
+func (s *Server) listen(ctx context.Context, listenAddress string, handler http.Handler) {
 	server := &http.Server{
-		Addr:    listenAddress,
-		Handler: router,
+		Addr:      listenAddress,
+		Handler:   handler,
+		TLSConfig: s.TLSConfig,
 	}
 
 	// termCtx is the context for stopping the server.
 	termCtx, _ := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("server failed to listen on %s: %v\n", listenAddress, err)
+		var err error
+		if s.TLSConfig != nil {
+			// Certificates come from TLSConfig.
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.Logger.WithError(err).WithField("listen_address", listenAddress).Fatal("Server failed to listen")
 		}
 	}()
 
@@ -56,15 +114,50 @@ func (s *Server) Serve(ctx context.Context, listenAddress string) {
 			return // Never cancelled
 		}
 		<-done
+		// Callers wait for their own work to end, e.g. to let stores
+		// persist their state.
 		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("server failed to shut down: %v", err)
+			s.Logger.WithError(err).WithField("listen_address", listenAddress).Error("Server failed to shut down")
 			time.Sleep(forceShutdownTime)
 			os.Exit(1)
 		}
-		os.Exit(0)
 	}()
 }
 
+// ServeAdmin returns the handler of profiling and administration
+// endpoints.  Administration changes quota and usage, so Store should
+// audit them.
+func (s *Server) ServeAdmin() http.Handler {
+	router := chi.NewRouter()
+	router.Mount("/internal/_pprof/", ServePPRof())
+	router.Route("/internal/admin/v1", func(r chi.Router) {
+		r.Use(withActor)
+		r.Put("/quota/*", s.setQuota)
+		r.Delete("/quota/*", s.clearQuota)
+		r.Put("/object-quota/*", s.setObjectQuota)
+		r.Delete("/object-quota/*", s.clearObjectQuota)
+		r.Put("/grace-period/*", s.setGracePeriod)
+		r.Delete("/grace-period/*", s.clearGracePeriod)
+		r.Get("/plans", s.listPlans)
+		r.Get("/plans/{name}", s.getPlan)
+		r.Put("/plans/{name}", s.setPlan)
+		r.Delete("/plans/{name}", s.deletePlan)
+		r.Put("/plan/*", s.assignPlan)
+		r.Delete("/plan/*", s.unassignPlan)
+		r.Put("/usage/*", s.setUsage)
+		r.Get("/audit", s.listAudit)
+	})
+	return router
+}
+
+// requireRole returns handler, requiring role if s authenticates.
+func (s *Server) requireRole(role auth.Role, handler http.Handler) http.Handler {
+	if s.Auth == nil {
+		return handler
+	}
+	return auth.Require(s.Auth, role, s.Logger)(handler)
+}
+
 func ServeHealth() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		fmt.Fprint(w, "alive!")
@@ -73,33 +166,164 @@ func ServeHealth() http.Handler {
 
 func (s *Server) ServeREST() http.Handler {
 	router := chi.NewRouter()
-	router.Get("/quota/exceeded", func(w http.ResponseWriter, r *http.Request) {
-		exceeded, err := s.Store.GetExceeded(r.Context())
-		if err != nil {
-			log.Printf("[ERROR] Get keys exceeding quota: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			_, err = fmt.Fprintf(w, "Get keys exceeding quota: %v", err)
-			if err != nil {
-				log.Printf("[ERROR]   Write error message: %v", err)
-			}
-			return
-		}
-		h := w.Header()
-		h["Content-Type"] = []string{JSONContentType}
-		body := struct{ Records []store.Record }{exceeded}
-		encodedBody, err := json.Marshal(body)
-		if err != nil {
-			log.Printf("[ERROR] [I] %s while encoding %v", err, body)
-			w.WriteHeader(http.StatusInternalServerError)
+	router.Get("/openapi.json", serveSpec)
+	router.Get("/keys", s.listKeys)
+	router.Get("/keys/*", s.getKey)
+	router.Get("/usage/export", s.exportUsage)
+	router.Get("/usage/*", s.getUsage)
+	router.Get("/usage-history/*", s.getHistory)
+	router.Get("/quota/exceeded", s.getExceeded)
+	router.Get("/quota/forecast", s.listForecast)
+	router.Get("/quota/rate-exceeded", s.getRateExceeded)
+	router.Get("/anomalies", s.getAnomalies)
+	router.Get("/billing", s.getBilling)
+	router.Post("/quota/check", s.checkQuota)
+	router.Post("/quota/reservations", s.reserve)
+	router.Delete("/quota/reservations/{id}", s.releaseReservation)
+	if s.HookKeys != nil {
+		router.Post("/hooks/lakefs", s.lakeFSHook)
+	}
+	return router
+}
+
+func serveSpec(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", JSONContentType)
+	_, _ = w.Write(api.Spec)
+}
+
+// writeJSON writes body to w as JSON with status.
+func (s *Server) writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	encodedBody, err := json.Marshal(body)
+	if err != nil {
+		s.Logger.WithError(err).WithField("body", body).Error("Encode response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h["Content-Type"] = []string{JSONContentType}
+	w.WriteHeader(status)
+	_, err = fmt.Fprint(w, string(encodedBody))
+	if err != nil {
+		s.Logger.WithError(err).WithField("length", len(encodedBody)).Error("Write response")
+	}
+}
+
+// writeError writes an api.Error with a formatted message to w with
+// status.
+func (s *Server) writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	s.writeJSON(w, status, api.Error{Message: fmt.Sprintf(format, args...)})
+}
+
+func (s *Server) getExceeded(w http.ResponseWriter, r *http.Request) {
+	exceeded, err := s.Store.GetExceeded(r.Context())
+	if err != nil {
+		s.Logger.WithError(err).Error("Get keys exceeding quota")
+		s.writeError(w, http.StatusInternalServerError, "Get keys exceeding quota: %v", err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, api.ExceededResponse{Records: exceeded})
+}
+
+func (s *Server) checkQuota(w http.ResponseWriter, r *http.Request) {
+	var req api.CheckQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse request: %v", err)
+		return
+	}
+	if (req.Key == "") == (req.Path == "") {
+		s.writeError(w, http.StatusBadRequest, "Exactly one of Key, Path required")
+		return
+	}
+	if req.Bytes < 0 {
+		s.writeError(w, http.StatusBadRequest, "Negative Bytes %d", req.Bytes)
+		return
+	}
+
+	key := req.Key
+	if req.Path != "" {
+		var ok bool
+		key, ok = s.Keys.Key(req.Path)
+		if !ok {
+			s.writeJSON(w, http.StatusOK, api.CheckQuotaResponse{QuotaCheck: store.QuotaCheck{Allowed: true}})
 			return
 		}
-		_, err = fmt.Fprint(w, string(encodedBody))
-		if err != nil {
-			log.Printf("[ERROR] %s while writing %d-byte response", err, len(encodedBody))
+	}
+
+	check, err := s.Store.CheckQuota(r.Context(), key, req.Bytes)
+	if err != nil {
+		s.Logger.WithError(err).WithField(logging.FieldKey, key).Error("Check quota")
+		s.writeError(w, http.StatusInternalServerError, "Check quota: %v", err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, api.CheckQuotaResponse{Key: key, QuotaCheck: check})
+}
+
+func (s *Server) reserve(w http.ResponseWriter, r *http.Request) {
+	var req api.ReserveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Parse request: %v", err)
+		return
+	}
+	if req.Path == "" {
+		s.writeError(w, http.StatusBadRequest, "Path required")
+		return
+	}
+	if req.Bytes < 0 {
+		s.writeError(w, http.StatusBadRequest, "Negative Bytes %d", req.Bytes)
+		return
+	}
+	if req.TTLSeconds < 0 {
+		s.writeError(w, http.StatusBadRequest, "Negative TTLSeconds %d", req.TTLSeconds)
+		return
+	}
+
+	key := req.Key
+	if key == "" {
+		var ok bool
+		key, ok = s.Keys.Key(req.Path)
+		if !ok {
+			// Untracked paths need no reservation.
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl == 0 {
+		ttl = s.ReservationTTL
+	}
+
+	l := s.Logger.WithFields(logging.Fields{logging.FieldKey: key, logging.FieldPath: req.Path})
+	reservation, err := s.Store.Reserve(r.Context(), store.Reservation{
+		Key:       key,
+		Path:      req.Path,
+		SizeBytes: req.Bytes,
+		ExpiresAt: time.Now().Add(ttl),
 	})
-	return router
+	if errors.Is(err, store.ErrQuotaExceeded) {
+		s.writeError(w, http.StatusConflict, "Reserve %d bytes on %s: %v", req.Bytes, key, err)
+		return
+	}
+	if err != nil {
+		l.WithError(err).Error("Reserve quota")
+		s.writeError(w, http.StatusInternalServerError, "Reserve quota: %v", err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, reservation)
+}
+
+func (s *Server) releaseReservation(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := s.Store.ReleaseReservation(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, "Reservation %s not found", id)
+		return
+	}
+	if err != nil {
+		s.Logger.WithError(err).WithField("reservation_id", id).Error("Release reservation")
+		s.writeError(w, http.StatusInternalServerError, "Release reservation: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func ServePPRof() http.Handler {
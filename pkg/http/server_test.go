@@ -0,0 +1,607 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+
+	"github.com/treeverse/terminus/pkg/anomaly"
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/cost"
+	"github.com/treeverse/terminus/pkg/export"
+	terminushttp "github.com/treeverse/terminus/pkg/http"
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/rate"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+const defaultQuota = 100
+
+// newServer returns a server on a new memory store and an HTTP test server
+// for its REST API.
+func newServer(t *testing.T) (*terminushttp.Server, *httptest.Server) {
+	mapper, err := keys.NewMapper(`^s3://[^/]+/user/([^/]+)/.*$`, "$1")
+	if err != nil {
+		t.Fatalf("New key mapper: %s", err)
+	}
+	hookMapper, err := keys.NewMapper(`^lakefs://[^/]+/(.+)$`, "$1")
+	if err != nil {
+		t.Fatalf("New hook key mapper: %s", err)
+	}
+	s := &terminushttp.Server{
+		Store:    memory.NewStore(defaultQuota),
+		Logger:   logging.Discard(),
+		Keys:     mapper,
+		HookKeys: hookMapper,
+	}
+	ts := httptest.NewServer(s.ServeREST())
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// do sends a request with a JSON body to ts and decodes the JSON response
+// into out.  It returns the status code.
+func do(t *testing.T, ts *httptest.Server, method, path string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatalf("Encode request: %s", err)
+		}
+	}
+	req, err := http.NewRequest(method, ts.URL+path, &reqBody)
+	if err != nil {
+		t.Fatalf("New request %s %s: %s", method, path, err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Decode response to %s %s: %s", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestCheckQuota(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	if err := s.Store.Set(ctx, "alice", store.Value{SizeBytes: 60}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	cases := []struct {
+		Name     string
+		Request  api.CheckQuotaRequest
+		Status   int
+		Expected api.CheckQuotaResponse
+	}{
+		{
+			Name:    "KeyAllowed",
+			Request: api.CheckQuotaRequest{Key: "alice", Bytes: 40},
+			Status:  http.StatusOK,
+			Expected: api.CheckQuotaResponse{Key: "alice", QuotaCheck: store.QuotaCheck{
+				Allowed: true, Info: store.Info{UsageBytes: 60, QuotaBytes: defaultQuota}, RemainingBytes: 40,
+			}},
+		}, {
+			Name:    "PathDenied",
+			Request: api.CheckQuotaRequest{Path: "s3://bucket/user/alice/big", Bytes: 41},
+			Status:  http.StatusOK,
+			Expected: api.CheckQuotaResponse{Key: "alice", QuotaCheck: store.QuotaCheck{
+				Allowed: false, Info: store.Info{UsageBytes: 60, QuotaBytes: defaultQuota}, RemainingBytes: 40,
+			}},
+		}, {
+			Name:     "PathUntracked",
+			Request:  api.CheckQuotaRequest{Path: "s3://bucket/shared/big", Bytes: 1000},
+			Status:   http.StatusOK,
+			Expected: api.CheckQuotaResponse{QuotaCheck: store.QuotaCheck{Allowed: true}},
+		}, {
+			Name:    "KeyAndPath",
+			Request: api.CheckQuotaRequest{Key: "alice", Path: "s3://bucket/user/alice/big", Bytes: 1},
+			Status:  http.StatusBadRequest,
+		}, {
+			Name:    "NegativeBytes",
+			Request: api.CheckQuotaRequest{Key: "alice", Bytes: -1},
+			Status:  http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			var actual api.CheckQuotaResponse
+			status := do(t, ts, http.MethodPost, "/quota/check", c.Request, &actual)
+			if status != c.Status {
+				t.Fatalf("Got status %d expected %d", status, c.Status)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			if diffs := deep.Equal(actual, c.Expected); diffs != nil {
+				t.Errorf("Unexpected response: %s", diffs)
+			}
+		})
+	}
+}
+
+func TestReservations(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	if err := s.Store.Set(ctx, "alice", store.Value{SizeBytes: 60}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	var reservation store.Reservation
+	status := do(t, ts, http.MethodPost, "/quota/reservations",
+		api.ReserveRequest{Path: "s3://bucket/user/alice/upload", Bytes: 30, TTLSeconds: 60}, &reservation)
+	if status != http.StatusCreated {
+		t.Fatalf("Reserve: got status %d expected %d", status, http.StatusCreated)
+	}
+	if reservation.Key != "alice" || reservation.ID == "" {
+		t.Errorf("Reserve: unexpected reservation %+v", reservation)
+	}
+
+	cases := []struct {
+		Name    string
+		Request api.ReserveRequest
+		Status  int
+	}{
+		{"OverQuota", api.ReserveRequest{Path: "s3://bucket/user/alice/other", Bytes: 11}, http.StatusConflict},
+		{"Untracked", api.ReserveRequest{Path: "s3://bucket/shared/big", Bytes: 1000}, http.StatusNoContent},
+		{"NoPath", api.ReserveRequest{Key: "alice", Bytes: 1}, http.StatusBadRequest},
+		{"NegativeBytes", api.ReserveRequest{Path: "s3://bucket/user/alice/other", Bytes: -1}, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if status := do(t, ts, http.MethodPost, "/quota/reservations", c.Request, nil); status != c.Status {
+				t.Errorf("Got status %d expected %d", status, c.Status)
+			}
+		})
+	}
+
+	if status := do(t, ts, http.MethodDelete, "/quota/reservations/"+reservation.ID, nil, nil); status != http.StatusNoContent {
+		t.Errorf("Release: got status %d expected %d", status, http.StatusNoContent)
+	}
+	if status := do(t, ts, http.MethodDelete, "/quota/reservations/"+reservation.ID, nil, nil); status != http.StatusNotFound {
+		t.Errorf("Release again: got status %d expected %d", status, http.StatusNotFound)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	for i, key := range []string{"carol", "alice", "bob"} {
+		if err := s.Store.Set(ctx, key, store.Value{SizeBytes: int64(i)}); err != nil {
+			t.Fatalf("Set %s: %s", key, err)
+		}
+	}
+	record := func(key string, usageBytes int64) store.Record {
+		return store.Record{Key: key, Info: store.Info{UsageBytes: usageBytes, QuotaBytes: defaultQuota}}
+	}
+
+	t.Run("List", func(t *testing.T) {
+		cases := []struct {
+			Name     string
+			Query    string
+			Expected api.ListKeysResponse
+		}{
+			{"All", "", api.ListKeysResponse{Records: []store.Record{record("alice", 1), record("bob", 2), record("carol", 0)}}},
+			{"FirstPage", "?limit=2", api.ListKeysResponse{Records: []store.Record{record("alice", 1), record("bob", 2)}, Next: "bob"}},
+			{"LastPage", "?after=bob&limit=2", api.ListKeysResponse{Records: []store.Record{record("carol", 0)}}},
+		}
+		for _, c := range cases {
+			t.Run(c.Name, func(t *testing.T) {
+				var actual api.ListKeysResponse
+				if status := do(t, ts, http.MethodGet, "/keys"+c.Query, nil, &actual); status != http.StatusOK {
+					t.Fatalf("Got status %d", status)
+				}
+				if diffs := deep.Equal(actual, c.Expected); diffs != nil {
+					t.Errorf("Unexpected response: %s", diffs)
+				}
+			})
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		cases := []struct {
+			Name     string
+			Key      string
+			Expected store.Record
+		}{
+			{"Tracked", "bob", record("bob", 2)},
+			{"Untracked", "dave", record("dave", 0)},
+		}
+		for _, c := range cases {
+			t.Run(c.Name, func(t *testing.T) {
+				var actual store.Record
+				if status := do(t, ts, http.MethodGet, "/keys/"+c.Key, nil, &actual); status != http.StatusOK {
+					t.Fatalf("Got status %d", status)
+				}
+				if diffs := deep.Equal(actual, c.Expected); diffs != nil {
+					t.Errorf("Unexpected response: %s", diffs)
+				}
+			})
+		}
+	})
+}
+
+func TestExportUsage(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	for i, key := range []string{"bob", "alice"} {
+		if err := s.Store.Set(ctx, key, store.Value{SizeBytes: int64(i + 1)}); err != nil {
+			t.Fatalf("Set %s: %s", key, err)
+		}
+	}
+
+	cases := []struct {
+		Name        string
+		Query       string
+		Status      int
+		ContentType string
+		Body        string
+	}{
+		{"DefaultCSV", "", http.StatusOK, "text/csv", "key,usage_bytes,quota_bytes,percent_used\nalice,2,100,2.00\nbob,1,100,1.00\n"},
+		{"JSONL", "?format=jsonl", http.StatusOK, "application/jsonl",
+			`{"key":"alice","usage_bytes":2,"quota_bytes":100,"percent_used":2}` + "\n" +
+				`{"key":"bob","usage_bytes":1,"quota_bytes":100,"percent_used":1}` + "\n"},
+		{"UnknownFormat", "?format=xml", http.StatusBadRequest, "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			resp, err := ts.Client().Get(ts.URL + "/usage/export" + c.Query)
+			if err != nil {
+				t.Fatalf("Get: %s", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != c.Status {
+				t.Fatalf("Got status %d expected %d", resp.StatusCode, c.Status)
+			}
+			if c.Status != http.StatusOK {
+				return
+			}
+			if contentType := resp.Header.Get("Content-Type"); contentType != c.ContentType {
+				t.Errorf("Got Content-Type %s expected %s", contentType, c.ContentType)
+			}
+			checkDisposition(t, resp, "usage."+format(c.Query))
+			var body bytes.Buffer
+			if _, err := body.ReadFrom(resp.Body); err != nil {
+				t.Fatalf("Read body: %s", err)
+			}
+			if body.String() != c.Body {
+				t.Errorf("Got body %q expected %q", body.String(), c.Body)
+			}
+		})
+	}
+
+	t.Run("Parquet", func(t *testing.T) {
+		resp, err := ts.Client().Get(ts.URL + "/usage/export?format=parquet")
+		if err != nil {
+			t.Fatalf("Get: %s", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Got status %d expected %d", resp.StatusCode, http.StatusOK)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/vnd.apache.parquet" {
+			t.Errorf("Got Content-Type %s expected application/vnd.apache.parquet", contentType)
+		}
+		checkDisposition(t, resp, "usage.parquet")
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Read body: %s", err)
+		}
+		f, err := buffer.NewBufferFile(body)
+		if err != nil {
+			t.Fatalf("Open buffer: %s", err)
+		}
+		pr, err := reader.NewParquetReader(f, new(export.Row), 1)
+		if err != nil {
+			t.Fatalf("New parquet reader: %s", err)
+		}
+		defer pr.ReadStop()
+		rows := make([]export.Row, pr.GetNumRows())
+		if err := pr.Read(&rows); err != nil {
+			t.Fatalf("Read rows: %s", err)
+		}
+		two, one := 2.0, 1.0
+		expected := []export.Row{
+			{Key: "alice", UsageBytes: 2, QuotaBytes: defaultQuota, PercentUsed: &two},
+			{Key: "bob", UsageBytes: 1, QuotaBytes: defaultQuota, PercentUsed: &one},
+		}
+		if diffs := deep.Equal(rows, expected); diffs != nil {
+			t.Errorf("Unexpected rows: %s", diffs)
+		}
+	})
+}
+
+// format returns the export format requested by query.
+func format(query string) string {
+	if query == "" {
+		return export.FormatCSV
+	}
+	return strings.TrimPrefix(query, "?format=")
+}
+
+// checkDisposition fails t unless resp is an attachment named filename.
+func checkDisposition(t *testing.T, resp *http.Response, filename string) {
+	t.Helper()
+	expected := fmt.Sprintf(`attachment; filename="%s"`, filename)
+	if disposition := resp.Header.Get("Content-Disposition"); disposition != expected {
+		t.Errorf("Got Content-Disposition %s expected %s", disposition, expected)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for m := 0; m < 4; m++ {
+		if err := s.Store.Set(ctx, "team/alice", store.Value{SizeBytes: int64(m)}); err != nil {
+			t.Fatalf("Set: %s", err)
+		}
+		if err := s.Store.Set(ctx, "team/alice/history", store.Value{SizeBytes: int64(10 + m)}); err != nil {
+			t.Fatalf("Set: %s", err)
+		}
+		if _, err := s.Store.RecordHistory(ctx, base.Add(time.Duration(m)*30*time.Minute)); err != nil {
+			t.Fatalf("Record history: %s", err)
+		}
+	}
+	point := func(m int, usageBytes int64) store.HistoryPoint {
+		return store.HistoryPoint{Time: base.Add(time.Duration(m) * time.Minute), UsageBytes: usageBytes}
+	}
+
+	cases := []struct {
+		Name     string
+		Path     string
+		Status   int
+		Expected []store.HistoryPoint
+	}{
+		{"Range", "/usage-history/team/alice?from=2021-01-01T00:30:00Z&to=2021-01-01T01:30:00Z", http.StatusOK,
+			[]store.HistoryPoint{point(30, 1), point(60, 2)}},
+		{"Step", "/usage-history/team/alice?from=2021-01-01T00:00:00Z&to=2021-01-02T00:00:00Z&step=1h", http.StatusOK,
+			[]store.HistoryPoint{point(0, 1), point(60, 3)}},
+		{"KeyEndingInHistory", "/usage-history/team/alice/history?from=2021-01-01T00:30:00Z&to=2021-01-01T01:30:00Z", http.StatusOK,
+			[]store.HistoryPoint{point(30, 11), point(60, 12)}},
+		{"Untracked", "/usage-history/bob", http.StatusOK, nil},
+		{"BadStep", "/usage-history/team/alice?step=-1h", http.StatusBadRequest, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			var actual api.HistoryResponse
+			status := do(t, ts, http.MethodGet, c.Path, nil, &actual)
+			if status != c.Status {
+				t.Fatalf("Got status %d expected %d", status, c.Status)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			if len(actual.Points) == 0 && len(c.Expected) == 0 {
+				return
+			}
+			if diffs := deep.Equal(actual.Points, c.Expected); diffs != nil {
+				t.Errorf("Unexpected history: %s", diffs)
+			}
+		})
+	}
+}
+
+func TestForecast(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	// alice grows 10 bytes a day, so she exceeds her quota of 100 in 3
+	// days.  bob grows 1 byte a day.  carol has no history.
+	now := time.Now()
+	for d := 3; d > 0; d-- {
+		if err := s.Store.Set(ctx, "alice", store.Value{SizeBytes: int64(100 - 10*(d+3))}); err != nil {
+			t.Fatalf("Set alice: %s", err)
+		}
+		if err := s.Store.Set(ctx, "bob", store.Value{SizeBytes: int64(10 - d)}); err != nil {
+			t.Fatalf("Set bob: %s", err)
+		}
+		if _, err := s.Store.RecordHistory(ctx, now.AddDate(0, 0, -d)); err != nil {
+			t.Fatalf("Record history: %s", err)
+		}
+	}
+	if err := s.Store.Set(ctx, "alice", store.Value{SizeBytes: 70}); err != nil {
+		t.Fatalf("Set alice: %s", err)
+	}
+	if err := s.Store.Set(ctx, "carol", store.Value{SizeBytes: 1}); err != nil {
+		t.Fatalf("Set carol: %s", err)
+	}
+
+	t.Run("Usage", func(t *testing.T) {
+		var alice api.UsageResponse
+		if status := do(t, ts, http.MethodGet, "/usage/alice", nil, &alice); status != http.StatusOK {
+			t.Fatalf("Got status %d", status)
+		}
+		if alice.Info.UsageBytes != 70 || alice.Forecast == nil || alice.Forecast.ExceedsAt == nil {
+			t.Fatalf("Expected alice to exceed, got %+v", alice)
+		}
+		if exceedsIn := alice.Forecast.ExceedsAt.Sub(now); exceedsIn < 3*24*time.Hour || exceedsIn > 4*24*time.Hour {
+			t.Errorf("Expected alice to exceed in 3 days, got %s", exceedsIn)
+		}
+		var carol api.UsageResponse
+		if status := do(t, ts, http.MethodGet, "/usage/carol", nil, &carol); status != http.StatusOK {
+			t.Fatalf("Got status %d", status)
+		}
+		if carol.Info.UsageBytes != 1 || carol.Forecast != nil {
+			t.Errorf("Expected carol without forecast, got %+v", carol)
+		}
+	})
+
+	cases := []struct {
+		Name   string
+		Within string
+		Status int
+		Keys   []string
+	}{
+		{"Default", "", http.StatusOK, []string{"alice"}},
+		{"Days", "?within=2d", http.StatusOK, []string{}},
+		{"Long", "?within=87600h", http.StatusOK, []string{"alice", "bob"}},
+		{"Bad", "?within=soon", http.StatusBadRequest, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			var resp api.ForecastResponse
+			status := do(t, ts, http.MethodGet, "/quota/forecast"+c.Within, nil, &resp)
+			if status != c.Status {
+				t.Fatalf("Got status %d expected %d", status, c.Status)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			keys := []string{}
+			for _, f := range resp.Forecasts {
+				keys = append(keys, f.Key)
+			}
+			if diffs := deep.Equal(keys, c.Keys); diffs != nil {
+				t.Errorf("Unexpected keys: %s", diffs)
+			}
+		})
+	}
+}
+
+func TestBilling(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	// alice uses 24 bytes for the whole of September, 720 hours.
+	if err := s.Store.AddSizeBytes(ctx, "alice", 24, time.Date(2026, 8, 15, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("AddSizeBytes alice: %s", err)
+	}
+
+	cases := []struct {
+		Name      string
+		Query     string
+		Status    int
+		ByteHours float64
+	}{
+		{"Month", "?month=2026-09", http.StatusOK, 24 * 720},
+		{"Before", "?month=2026-07", http.StatusOK, 0},
+		{"Missing", "", http.StatusBadRequest, 0},
+		{"Bad", "?month=september", http.StatusBadRequest, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			var resp api.BillingResponse
+			status := do(t, ts, http.MethodGet, "/billing"+c.Query, nil, &resp)
+			if status != c.Status {
+				t.Fatalf("Got status %d expected %d", status, c.Status)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			byteHours := 0.0
+			for _, line := range resp.Lines {
+				byteHours += line.ByteHours
+			}
+			if byteHours != c.ByteHours {
+				t.Errorf("Got %f byte-hours, expected %f in %+v", byteHours, c.ByteHours, resp)
+			}
+		})
+	}
+}
+
+func TestUsageCost(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	if err := s.Store.AddClassSizeBytes(ctx, "alice", "GLACIER", 10, time.Now()); err != nil {
+		t.Fatalf("AddClassSizeBytes: %s", err)
+	}
+
+	var resp api.UsageResponse
+	if status := do(t, ts, http.MethodGet, "/usage/alice", nil, &resp); status != http.StatusOK {
+		t.Fatalf("Got status %d", status)
+	}
+	if diffs := deep.Equal(resp.StorageClasses, map[string]int64{"GLACIER": 10}); diffs != nil {
+		t.Errorf("Storage classes: %s", diffs)
+	}
+	if resp.Cost != nil {
+		t.Errorf("Expected no cost without prices, got %+v", resp.Cost)
+	}
+
+	s.Costs = &cost.Config{Prices: map[string]float64{"GLACIER": 1 << 30}, DefaultQuotaDollars: 5}
+	if status := do(t, ts, http.MethodGet, "/usage/alice", nil, &resp); status != http.StatusOK {
+		t.Fatalf("Got status %d", status)
+	}
+	if resp.Cost == nil || resp.Cost.MonthlyDollars != 10 || !resp.Cost.Exceeded() {
+		t.Errorf("Expected $10 over quota, got %+v", resp.Cost)
+	}
+}
+
+func TestRateExceeded(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	now := time.Now()
+	for i, size := range []int64{30, 40} {
+		path := fmt.Sprintf("s3://bucket/user/alice/%d", i)
+		if err := s.Store.PutObject(ctx, "alice", store.Object{Path: path, SizeBytes: size}, now); err != nil {
+			t.Fatalf("PutObject %s: %s", path, err)
+		}
+	}
+	if err := s.Store.PutObject(ctx, "bob", store.Object{Path: "s3://bucket/user/bob/0", SizeBytes: 20}, now); err != nil {
+		t.Fatalf("PutObject bob: %s", err)
+	}
+
+	var resp api.RateExceededResponse
+	if status := do(t, ts, http.MethodGet, "/quota/rate-exceeded", nil, &resp); status != http.StatusOK {
+		t.Fatalf("Got status %d", status)
+	}
+	if len(resp.Statuses) != 0 {
+		t.Errorf("Expected no statuses without rate quotas, got %+v", resp.Statuses)
+	}
+
+	s.RateLimits = rate.Limits{{Window: time.Hour, Bytes: 50}, {Window: 24 * time.Hour, Bytes: 100}}
+	if status := do(t, ts, http.MethodGet, "/quota/rate-exceeded", nil, &resp); status != http.StatusOK {
+		t.Fatalf("Got status %d", status)
+	}
+	expected := []api.RateStatus{{Key: "alice", WindowSeconds: 3600, LimitBytes: 50, IngestBytes: 70}}
+	if diffs := deep.Equal(resp.Statuses, expected); diffs != nil {
+		t.Errorf("Rate statuses: %s", diffs)
+	}
+}
+
+func TestAnomalies(t *testing.T) {
+	s, ts := newServer(t)
+	ctx := context.Background()
+	// 5 bytes in each hour of the baseline, then a spike.
+	now := time.Now()
+	for i, size := range []int64{80, 5, 5} {
+		path := fmt.Sprintf("s3://bucket/user/alice/%d", i)
+		if err := s.Store.PutObject(ctx, "alice", store.Object{Path: path, SizeBytes: size}, now.Add(-time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("PutObject %s: %s", path, err)
+		}
+	}
+
+	var resp api.AnomaliesResponse
+	if status := do(t, ts, http.MethodGet, "/anomalies", nil, &resp); status != http.StatusOK {
+		t.Fatalf("Got status %d", status)
+	}
+	if resp.WindowSeconds != 3600 || len(resp.Anomalies) != 0 {
+		t.Errorf("Expected no anomalies under the default minimal size, got %+v", resp)
+	}
+
+	s.Anomalies = &anomaly.Detector{Factor: 10, Window: time.Hour, Baseline: 2 * time.Hour, MinBytes: 50}
+	if status := do(t, ts, http.MethodGet, "/anomalies", nil, &resp); status != http.StatusOK {
+		t.Fatalf("Got status %d", status)
+	}
+	expected := []anomaly.Anomaly{{Key: "alice", IngestBytes: 80, BaselineBytes: 5}}
+	if diffs := deep.Equal(resp.Anomalies, expected); diffs != nil {
+		t.Errorf("Anomalies: %s", diffs)
+	}
+}
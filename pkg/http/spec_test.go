@@ -0,0 +1,350 @@
+package http_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/api"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// routes returns "METHOD path" of all routes of handler under prefix, in
+// the path syntax of OpenAPI.
+func routes(t *testing.T, prefix string, handler http.Handler) []string {
+	t.Helper()
+	var ret []string
+	err := chi.Walk(handler.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/internal/_pprof/") {
+			return nil
+		}
+		route = strings.ReplaceAll(prefix+route, "/*", "/{key}")
+		ret = append(ret, method+" "+route)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk routes: %s", err)
+	}
+	return ret
+}
+
+// TestSpecMatchesRoutes checks that the OpenAPI spec documents exactly the
+// served endpoints.
+func TestSpecMatchesRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(api.Spec, &spec); err != nil {
+		t.Fatalf("Parse spec: %s", err)
+	}
+	var documented []string
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	s, _ := newServer(t)
+	served := []string{"GET /_health"}
+	served = append(served, routes(t, "/internal/api/v1", s.ServeREST())...)
+	served = append(served, routes(t, "", s.ServeAdmin())...)
+
+	sort.Strings(documented)
+	sort.Strings(served)
+	if diffs := deep.Equal(documented, served); diffs != nil {
+		t.Errorf("Spec does not match routes: %s", diffs)
+	}
+}
+
+// bodyTypes are the Go types of the JSON request and success response
+// bodies of an operation, or nil for none.
+type bodyTypes struct {
+	Request, Response interface{}
+}
+
+// operationTypes maps operation IDs to the types that their handlers and
+// the client encode and decode.
+var operationTypes = map[string]bodyTypes{
+	"health":             {},
+	"getSpec":            {Response: map[string]interface{}{}},
+	"listKeys":           {Response: api.ListKeysResponse{}},
+	"getKey":             {Response: store.Record{}},
+	"exportUsage":        {},
+	"getUsage":           {Response: api.UsageResponse{}},
+	"getHistory":         {Response: api.HistoryResponse{}},
+	"getExceeded":        {Response: api.ExceededResponse{}},
+	"listForecast":       {Response: api.ForecastResponse{}},
+	"getRateExceeded":    {Response: api.RateExceededResponse{}},
+	"getAnomalies":       {Response: api.AnomaliesResponse{}},
+	"getBilling":         {Response: api.BillingResponse{}},
+	"checkQuota":         {Request: api.CheckQuotaRequest{}, Response: api.CheckQuotaResponse{}},
+	"reserve":            {Request: api.ReserveRequest{}, Response: store.Reservation{}},
+	"releaseReservation": {},
+	"lakeFSHook":         {Request: api.LakeFSHookEvent{}},
+	"setQuota":           {Request: api.SetQuotaRequest{}},
+	"clearQuota":         {},
+	"setObjectQuota":     {Request: api.SetObjectQuotaRequest{}},
+	"clearObjectQuota":   {},
+	"setGracePeriod":     {Request: api.SetGracePeriodRequest{}},
+	"clearGracePeriod":   {},
+	"listPlans":          {Response: api.PlansResponse{}},
+	"getPlan":            {Response: api.Plan{}},
+	"setPlan":            {Request: api.Plan{}},
+	"deletePlan":         {},
+	"assignPlan":         {Request: api.AssignPlanRequest{}},
+	"unassignPlan":       {},
+	"setUsage":           {Request: api.SetUsageRequest{}},
+	"listAudit":          {Response: api.AuditResponse{}},
+}
+
+// schema is the part of an OpenAPI schema object that Go types determine.
+type schema struct {
+	Ref                  string `json:"$ref"`
+	Type                 string
+	Format               string
+	Nullable             bool
+	Required             []string
+	Properties           map[string]*schema
+	Items                *schema
+	AdditionalProperties *schema
+	AllOf                []*schema
+}
+
+type mediaTypes struct {
+	Content map[string]struct{ Schema *schema }
+}
+
+// jsonSchema returns the schema of the JSON body of m, or nil if it has
+// none.
+func (m *mediaTypes) jsonSchema() *schema {
+	if m == nil {
+		return nil
+	}
+	return m.Content["application/json"].Schema
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// schemaChecker checks schemas against the Go types that encode them.
+type schemaChecker struct {
+	schemas map[string]*schema
+	errs    []string
+}
+
+func (c *schemaChecker) errorf(format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Sprintf(format, args...))
+}
+
+// check checks that s at where describes the JSON encoding of t.
+func (c *schemaChecker) check(where string, s *schema, t reflect.Type) {
+	nullable := s.Nullable
+	if len(s.AllOf) == 1 {
+		s = s.AllOf[0]
+	}
+	if s.Ref != "" {
+		ref, ok := c.schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			c.errorf("%s: unknown schema %s", where, s.Ref)
+			return
+		}
+		s = ref
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		if !nullable {
+			c.errorf("%s: %s is not nullable", where, t)
+		}
+		t = t.Elem()
+	case reflect.Slice, reflect.Map, reflect.Interface:
+	default:
+		if nullable {
+			c.errorf("%s: %s is nullable", where, t)
+		}
+	}
+
+	expect := func(typ, format string) {
+		if s.Type != typ || format != "" && s.Format != format {
+			c.errorf("%s: %s is %s %s, not %s %s", where, t, s.Type, s.Format, typ, format)
+		}
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		expect("integer", "")
+	case reflect.Float32, reflect.Float64:
+		expect("number", "")
+	case reflect.String:
+		expect("string", "")
+	case reflect.Bool:
+		expect("boolean", "")
+	case reflect.Slice:
+		expect("array", "")
+		if s.Items == nil {
+			c.errorf("%s: array without items", where)
+		} else {
+			c.check(where+"[]", s.Items, t.Elem())
+		}
+	case reflect.Map:
+		expect("object", "")
+		if s.AdditionalProperties != nil {
+			c.check(where+"{}", s.AdditionalProperties, t.Elem())
+		}
+	case reflect.Struct:
+		if t == timeType {
+			expect("string", "date-time")
+			return
+		}
+		expect("object", "")
+		c.checkStruct(where, s, t)
+	case reflect.Interface:
+	default:
+		c.errorf("%s: unsupported Go type %s", where, t)
+	}
+}
+
+// jsonField is a field of the JSON encoding of a struct.
+type jsonField struct {
+	Type      reflect.Type
+	OmitEmpty bool
+}
+
+// jsonFields returns the fields of the JSON encoding of struct t.
+func jsonFields(t reflect.Type) map[string]jsonField {
+	fields := make(map[string]jsonField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for name, field := range jsonFields(f.Type) {
+				fields[name] = field
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = jsonField{Type: f.Type, OmitEmpty: strings.Contains(options, "omitempty")}
+	}
+	return fields
+}
+
+// checkStruct checks that the properties of s are the fields of struct t.
+func (c *schemaChecker) checkStruct(where string, s *schema, t reflect.Type) {
+	if t == durationType {
+		return
+	}
+	fields := jsonFields(t)
+	for name, f := range fields {
+		prop, ok := s.Properties[name]
+		if !ok {
+			c.errorf("%s: no property %s of %s", where, name, t)
+			continue
+		}
+		c.check(where+"."+name, prop, f.Type)
+	}
+	for name := range s.Properties {
+		if _, ok := fields[name]; !ok {
+			c.errorf("%s: %s has no field %s", where, t, name)
+		}
+	}
+	for _, name := range s.Required {
+		if f, ok := fields[name]; !ok || f.OmitEmpty {
+			c.errorf("%s: required %s is not always encoded by %s", where, name, t)
+		}
+	}
+}
+
+// TestSpecMatchesTypes checks that the request and response schemas of
+// every operation in the OpenAPI spec match the Go types that handlers
+// and the client use.
+func TestSpecMatchesTypes(t *testing.T) {
+	var spec struct {
+		Paths      map[string]map[string]json.RawMessage
+		Components struct {
+			Schemas map[string]*schema
+		}
+	}
+	if err := json.Unmarshal(api.Spec, &spec); err != nil {
+		t.Fatalf("Parse spec: %s", err)
+	}
+
+	c := &schemaChecker{schemas: spec.Components.Schemas}
+	documented := make(map[string]bool)
+	for path, item := range spec.Paths {
+		for method, rawOp := range item {
+			if method == "parameters" {
+				continue
+			}
+			where := strings.ToUpper(method) + " " + path
+			var op struct {
+				OperationID string
+				RequestBody *mediaTypes
+				Responses   map[string]*mediaTypes
+			}
+			if err := json.Unmarshal(rawOp, &op); err != nil {
+				t.Fatalf("Parse %s: %s", where, err)
+			}
+			documented[op.OperationID] = true
+			types, ok := operationTypes[op.OperationID]
+			if !ok {
+				c.errorf("%s: no types for operation %q", where, op.OperationID)
+				continue
+			}
+			if s := op.RequestBody.jsonSchema(); s == nil && types.Request != nil {
+				c.errorf("%s: no request schema for %T", where, types.Request)
+			} else if s != nil && types.Request == nil {
+				c.errorf("%s: unexpected request schema", where)
+			} else if s != nil {
+				c.check(where+" request", s, reflect.TypeOf(types.Request))
+			}
+			success := false
+			for status, resp := range op.Responses {
+				s := resp.jsonSchema()
+				if !strings.HasPrefix(status, "2") {
+					if s != nil {
+						c.check(where+" "+status, s, reflect.TypeOf(api.Error{}))
+					}
+					continue
+				}
+				if s == nil {
+					continue
+				}
+				success = true
+				if types.Response == nil {
+					c.errorf("%s: unexpected %s response schema", where, status)
+					continue
+				}
+				c.check(where+" "+status, s, reflect.TypeOf(types.Response))
+			}
+			if !success && types.Response != nil {
+				c.errorf("%s: no response schema for %T", where, types.Response)
+			}
+		}
+	}
+	for id := range operationTypes {
+		if !documented[id] {
+			c.errorf("operation %q is not documented", id)
+		}
+	}
+	sort.Strings(c.errs)
+	for _, e := range c.errs {
+		t.Error(e)
+	}
+}
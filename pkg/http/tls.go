@@ -0,0 +1,126 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/logging"
+)
+
+var ErrBadCA = errors.New("no certificates in CA file")
+
+// CertReloader holds a TLS certificate and reloads it when its files
+// change.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader returns a CertReloader with the certificate loaded from
+// certFile and keyFile.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{CertFile: certFile, KeyFile: keyFile}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// latestModTime returns the latest modification time of the certificate files.
+func (c *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.CertFile, c.KeyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Reload loads the certificate again if its files changed since it was
+// last loaded, and returns whether it did.  On error it keeps the current
+// certificate.
+func (c *CertReloader) Reload() (bool, error) {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return false, fmt.Errorf("stat certificate: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cert != nil && modTime.Equal(c.modTime) {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return false, fmt.Errorf("load certificate %s: %w", c.CertFile, err)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return true, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
+
+// ReloadEvery reloads the certificate every interval if it changed, until
+// ctx is cancelled.
+func (c *CertReloader) ReloadEvery(ctx context.Context, l logging.Logger, interval time.Duration) {
+	l = l.WithField("cert_file", c.CertFile)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := c.Reload()
+			if err != nil {
+				l.WithError(err).Error("Reload TLS certificate, keeping the previous one")
+			} else if reloaded {
+				l.Info("Reloaded TLS certificate")
+			}
+		}
+	}
+}
+
+// NewTLSConfig returns a TLS configuration serving the certificate of
+// reloader.  If clientCAFile is set, it verifies client certificates
+// signed by the CAs in that file, for authentication.
+func NewTLSConfig(reloader *CertReloader, clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: %w", clientCAFile, ErrBadCA)
+		}
+		cfg.ClientCAs = pool
+		// Authentication also accepts other credentials.
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
@@ -0,0 +1,92 @@
+package http_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	terminushttp "github.com/treeverse/terminus/pkg/http"
+)
+
+// writeCert writes a new self-signed certificate for commonName to
+// certFile and keyFile, with modification time modTime.
+func writeCert(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Marshal key: %s", err)
+	}
+	files := []struct {
+		Path  string
+		Type  string
+		Bytes []byte
+	}{{certFile, "CERTIFICATE", der}, {keyFile, "EC PRIVATE KEY", keyDER}}
+	for _, f := range files {
+		if err := os.WriteFile(f.Path, pem.EncodeToMemory(&pem.Block{Type: f.Type, Bytes: f.Bytes}), 0o600); err != nil {
+			t.Fatalf("Write %s: %s", f.Path, err)
+		}
+		if err := os.Chtimes(f.Path, modTime, modTime); err != nil {
+			t.Fatalf("Set time of %s: %s", f.Path, err)
+		}
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Minute)
+	writeCert(t, certFile, keyFile, "first", start)
+
+	reloader, err := terminushttp.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %s", err)
+	}
+	first, _ := reloader.GetCertificate(nil)
+
+	if reloaded, err := reloader.Reload(); err != nil || reloaded {
+		t.Errorf("Reload unchanged: got %t, %v", reloaded, err)
+	}
+
+	writeCert(t, certFile, keyFile, "second", start.Add(time.Second))
+	if reloaded, err := reloader.Reload(); err != nil || !reloaded {
+		t.Errorf("Reload changed: got %t, %v", reloaded, err)
+	}
+	second, _ := reloader.GetCertificate(nil)
+	if bytes.Equal(first.Certificate[0], second.Certificate[0]) {
+		t.Error("Certificate unchanged after reload")
+	}
+
+	// A bad certificate keeps the previous one.
+	if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("Write %s: %s", certFile, err)
+	}
+	if _, err := reloader.Reload(); err == nil {
+		t.Error("Reload bad certificate: expected error")
+	}
+	if current, _ := reloader.GetCertificate(nil); current != second {
+		t.Error("Bad certificate replaced previous one")
+	}
+}
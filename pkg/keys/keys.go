@@ -0,0 +1,47 @@
+// Package keys maps S3 object paths to the keys that Terminus tracks on
+// its store.
+package keys
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Mapper maps paths "s3://bucket/..." to store keys.
+type Mapper struct {
+	// Pattern matches paths to track.
+	Pattern *regexp.Regexp
+	// Replacement is expanded on paths that match Pattern to generate
+	// their key, as in regexp.Regexp.Expand.
+	Replacement string
+}
+
+// NewMapper returns a Mapper that tracks paths matching pattern, with keys
+// generated by expanding replacement.
+func NewMapper(pattern, replacement string) (*Mapper, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("key pattern %s: %w", pattern, err)
+	}
+	return &Mapper{Pattern: re, Replacement: replacement}, nil
+}
+
+// Key returns the key for path, or false if path is not tracked.
+func (m *Mapper) Key(path string) (string, bool) {
+	match := m.Pattern.FindStringSubmatchIndex(path)
+	if len(match) == 0 {
+		return "", false
+	}
+	return string(m.Pattern.ExpandString(nil, m.Replacement, path, match)), true
+}
+
+// Path returns the "s3://..." path of key on bucket.
+func Path(bucket, key string) string {
+	return "s3://" + bucket + "/" + key
+}
+
+// LakeFSPath returns the "lakefs://..." path of committer on repository,
+// which hook mappers map to keys.
+func LakeFSPath(repository, committer string) string {
+	return "lakefs://" + repository + "/" + committer
+}
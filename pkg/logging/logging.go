@@ -0,0 +1,158 @@
+// Package logging provides the structured, leveled logger used by all of
+// Terminus.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fields are structured key-value pairs attached to a log line.
+type Fields = logrus.Fields
+
+// Well-known field names.  Redaction applies to some of these fields, see
+// Redactor.
+const (
+	// FieldObjectKey is the key of an S3 object.  It may contain PII.
+	FieldObjectKey = "object_key"
+	// FieldPath is the full "s3://..." path of an object.  It may
+	// contain PII.
+	FieldPath = "path"
+	// FieldBody is the body of a message received from a queue.  It
+	// may contain PII.
+	FieldBody = "body"
+	// FieldKey is the key on the store used to track quota.
+	FieldKey = "key"
+	// FieldMessageID is the ID of a message received from a queue.
+	FieldMessageID = "message_id"
+)
+
+// Logger is a structured, leveled logger.
+type Logger interface {
+	WithField(key string, value interface{}) Logger
+	WithFields(fields Fields) Logger
+	WithError(err error) Logger
+
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+	Fatal(args ...interface{})
+
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// Formats of log output.
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+	FormatText   = "text"
+)
+
+// Config configures a Logger.
+type Config struct {
+	// Level is the minimal level to output: "debug", "info", "warn",
+	// "error".
+	Level string
+	// Format is one of FormatJSON, FormatLogfmt or FormatText.
+	Format string
+	// Output receives log lines.  It defaults to os.Stderr.
+	Output io.Writer
+	// Redact is the redaction policy for sensitive fields.
+	Redact RedactConfig
+}
+
+type logger struct {
+	e *logrus.Entry
+}
+
+// New returns a Logger configured by cfg.
+func New(cfg Config) (Logger, error) {
+	l := logrus.New()
+
+	level := cfg.Level
+	if level == "" {
+		level = "info"
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("log level %s: %w", level, err)
+	}
+	l.SetLevel(lvl)
+
+	switch strings.ToLower(cfg.Format) {
+	case FormatJSON:
+		l.SetFormatter(&logrus.JSONFormatter{})
+	case "", FormatLogfmt:
+		l.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	case FormatText:
+		l.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return nil, fmt.Errorf("log format %s: %w", cfg.Format, ErrBadConfig)
+	}
+
+	out := cfg.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	l.SetOutput(out)
+
+	redactor, err := NewRedactor(cfg.Redact)
+	if err != nil {
+		return nil, err
+	}
+	l.AddHook(redactor)
+
+	return &logger{e: logrus.NewEntry(l)}, nil
+}
+
+// Default returns a Logger that logs at "info" level in logfmt to stderr,
+// hashing sensitive fields.
+func Default() Logger {
+	l, err := New(Config{})
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// Discard returns a Logger that outputs nothing.
+func Discard() Logger {
+	l, err := New(Config{Output: io.Discard})
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func (l *logger) WithField(key string, value interface{}) Logger {
+	return &logger{e: l.e.WithField(key, value)}
+}
+
+func (l *logger) WithFields(fields Fields) Logger {
+	return &logger{e: l.e.WithFields(fields)}
+}
+
+func (l *logger) WithError(err error) Logger {
+	return &logger{e: l.e.WithError(err)}
+}
+
+func (l *logger) Debug(args ...interface{}) { l.e.Debug(args...) }
+func (l *logger) Info(args ...interface{})  { l.e.Info(args...) }
+func (l *logger) Warn(args ...interface{})  { l.e.Warn(args...) }
+func (l *logger) Error(args ...interface{}) { l.e.Error(args...) }
+func (l *logger) Fatal(args ...interface{}) { l.e.Fatal(args...) }
+
+func (l *logger) Debugf(format string, args ...interface{}) { l.e.Debugf(format, args...) }
+func (l *logger) Infof(format string, args ...interface{})  { l.e.Infof(format, args...) }
+func (l *logger) Warnf(format string, args ...interface{})  { l.e.Warnf(format, args...) }
+func (l *logger) Errorf(format string, args ...interface{}) { l.e.Errorf(format, args...) }
+func (l *logger) Fatalf(format string, args ...interface{}) { l.e.Fatalf(format, args...) }
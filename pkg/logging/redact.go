@@ -0,0 +1,131 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrBadConfig = errors.New("bad logging configuration")
+
+// Redaction modes.
+const (
+	// RedactNone logs sensitive fields as-is.
+	RedactNone = "none"
+	// RedactHash replaces sensitive fields with a (salted) hash.  Equal
+	// values still hash to equal strings, so lines can be correlated.
+	RedactHash = "hash"
+	// RedactTruncate keeps only a prefix of sensitive fields.
+	RedactTruncate = "truncate"
+	// RedactDrop removes sensitive fields entirely.
+	RedactDrop = "drop"
+)
+
+// DefaultRedactFields are the fields redacted if RedactConfig.Fields is
+// empty.
+var DefaultRedactFields = []string{FieldObjectKey, FieldPath, FieldBody}
+
+const defaultTruncateLength = 16
+
+// RedactConfig is the redaction policy for sensitive fields.
+type RedactConfig struct {
+	// Mode is one of RedactNone, RedactHash, RedactTruncate or
+	// RedactDrop.  It defaults to RedactHash.
+	Mode string
+	// Fields are the names of fields to redact.  It defaults to
+	// DefaultRedactFields.
+	Fields []string
+	// TruncateLength is the number of bytes to keep in RedactTruncate
+	// mode.  Truncation never splits a UTF-8 rune, so fewer bytes may be
+	// kept.
+	TruncateLength int
+	// Salt is prepended to values before hashing in RedactHash mode.
+	Salt string
+}
+
+// Redactor is a logrus hook that redacts sensitive fields of every log
+// line.
+type Redactor struct {
+	mode           string
+	fields         map[string]struct{}
+	truncateLength int
+	salt           string
+}
+
+// NewRedactor returns a Redactor for cfg.
+func NewRedactor(cfg RedactConfig) (*Redactor, error) {
+	r := &Redactor{
+		mode:           cfg.Mode,
+		truncateLength: cfg.TruncateLength,
+		salt:           cfg.Salt,
+	}
+	switch r.mode {
+	case "":
+		r.mode = RedactHash
+	case RedactNone, RedactHash, RedactTruncate, RedactDrop:
+	default:
+		return nil, fmt.Errorf("redaction mode %s: %w", cfg.Mode, ErrBadConfig)
+	}
+	if r.truncateLength <= 0 {
+		r.truncateLength = defaultTruncateLength
+	}
+	fields := cfg.Fields
+	if len(fields) == 0 {
+		fields = DefaultRedactFields
+	}
+	r.fields = make(map[string]struct{}, len(fields))
+	for _, f := range fields {
+		r.fields[f] = struct{}{}
+	}
+	return r, nil
+}
+
+// Redact returns s redacted according to the policy of r.
+func (r *Redactor) Redact(s string) string {
+	switch r.mode {
+	case RedactHash:
+		sum := sha256.Sum256([]byte(r.salt + s))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	case RedactTruncate:
+		if len(s) <= r.truncateLength {
+			return s
+		}
+		n := r.truncateLength
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		return fmt.Sprintf("%s...[%d bytes]", s[:n], len(s))
+	case RedactDrop:
+		return ""
+	default:
+		return s
+	}
+}
+
+func (r *Redactor) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire redacts sensitive fields of entry.  logrus passes each hook a copy
+// of the fields, so this does not affect other lines.
+func (r *Redactor) Fire(entry *logrus.Entry) error {
+	if r.mode == RedactNone {
+		return nil
+	}
+	for name := range r.fields {
+		v, ok := entry.Data[name]
+		if !ok {
+			continue
+		}
+		if r.mode == RedactDrop {
+			delete(entry.Data, name)
+			continue
+		}
+		entry.Data[name] = r.Redact(fmt.Sprint(v))
+	}
+	return nil
+}
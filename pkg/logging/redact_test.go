@@ -0,0 +1,111 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/treeverse/terminus/pkg/logging"
+)
+
+func TestRedact(t *testing.T) {
+	const objectKey = "user/alice/some/very/long/secret/path"
+
+	cases := []struct {
+		Name   string
+		Redact logging.RedactConfig
+		// Check returns true if the logged object key is acceptable.
+		Check func(logged string, present bool) bool
+	}{
+		{
+			Name: "Default",
+			Check: func(logged string, present bool) bool {
+				return present && strings.HasPrefix(logged, "sha256:") && !strings.Contains(logged, "alice")
+			},
+		}, {
+			Name:   "None",
+			Redact: logging.RedactConfig{Mode: logging.RedactNone},
+			Check:  func(logged string, present bool) bool { return present && logged == objectKey },
+		}, {
+			Name:   "Hash",
+			Redact: logging.RedactConfig{Mode: logging.RedactHash},
+			Check: func(logged string, present bool) bool {
+				return present && strings.HasPrefix(logged, "sha256:") && !strings.Contains(logged, "alice")
+			},
+		}, {
+			Name:   "Truncate",
+			Redact: logging.RedactConfig{Mode: logging.RedactTruncate, TruncateLength: 5},
+			Check: func(logged string, present bool) bool {
+				return present && strings.HasPrefix(logged, "user/...") && !strings.Contains(logged, "alice")
+			},
+		}, {
+			Name:   "Drop",
+			Redact: logging.RedactConfig{Mode: logging.RedactDrop},
+			Check:  func(_ string, present bool) bool { return !present },
+		}, {
+			Name:   "OtherField",
+			Redact: logging.RedactConfig{Mode: logging.RedactDrop, Fields: []string{logging.FieldBody}},
+			Check:  func(logged string, present bool) bool { return present && logged == objectKey },
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := logging.New(logging.Config{Format: logging.FormatJSON, Output: &buf, Redact: c.Redact})
+			if err != nil {
+				t.Fatalf("New logger: %s", err)
+			}
+			l.WithField(logging.FieldObjectKey, objectKey).Info("hello")
+
+			var line map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("Parse log line %s: %s", buf.String(), err)
+			}
+			logged, present := line[logging.FieldObjectKey]
+			loggedString, _ := logged.(string)
+			if !c.Check(loggedString, present) {
+				t.Errorf("Unexpected logged object key in %s", buf.String())
+			}
+		})
+	}
+}
+
+func TestRedactHashIsStable(t *testing.T) {
+	r, err := logging.NewRedactor(logging.RedactConfig{Mode: logging.RedactHash, Salt: "pepper"})
+	if err != nil {
+		t.Fatalf("New redactor: %s", err)
+	}
+	if a, b := r.Redact("user/alice"), r.Redact("user/alice"); a != b {
+		t.Errorf("Got different hashes %s, %s for the same value", a, b)
+	}
+	if a, b := r.Redact("user/alice"), r.Redact("user/bob"); a == b {
+		t.Errorf("Got same hash %s for different values", a)
+	}
+}
+
+func TestRedactTruncateRunes(t *testing.T) {
+	r, err := logging.NewRedactor(logging.RedactConfig{Mode: logging.RedactTruncate, TruncateLength: 6})
+	if err != nil {
+		t.Fatalf("New redactor: %s", err)
+	}
+	// "ü" takes bytes 5 and 6, so it may not be kept.
+	redacted := r.Redact("user/über/secret")
+	if !utf8.ValidString(redacted) {
+		t.Errorf("Got invalid UTF-8 %q", redacted)
+	}
+	if expected := "user/...[17 bytes]"; redacted != expected {
+		t.Errorf("Got %q, expected %q", redacted, expected)
+	}
+}
+
+func TestBadConfig(t *testing.T) {
+	if _, err := logging.New(logging.Config{Format: "xml"}); err == nil {
+		t.Error("Expected error for bad format")
+	}
+	if _, err := logging.New(logging.Config{Redact: logging.RedactConfig{Mode: "rot13"}}); err == nil {
+		t.Error("Expected error for bad redaction mode")
+	}
+}
@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// s3Error is the body of an S3 error response.
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+// writeError writes an S3 error response with status and code to w.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	body, err := xml.Marshal(s3Error{Code: code, Message: message, Resource: r.URL.Path})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(body)
+}
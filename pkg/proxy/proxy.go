@@ -0,0 +1,284 @@
+// Package proxy provides an S3-compatible reverse proxy that rejects
+// writes to keys over quota, once any grace period expires.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+const (
+	shutdownTimeout = 10 * time.Second
+
+	// DefaultReservationTTL is the default time to live of reservations
+	// for parts of multipart uploads.
+	DefaultReservationTTL = 24 * time.Hour
+
+	decodedContentLengthHeader = "X-Amz-Decoded-Content-Length"
+	copySourceHeader           = "X-Amz-Copy-Source"
+)
+
+// Operations that the proxy enforces.
+const (
+	OpPutObject             = "PutObject"
+	OpCopyObject            = "CopyObject"
+	OpCreateMultipartUpload = "CreateMultipartUpload"
+	OpUploadPart            = "UploadPart"
+	OpUploadPartCopy        = "UploadPartCopy"
+	OpAbortMultipartUpload  = "AbortMultipartUpload"
+)
+
+const uploadIDParam = "uploadId"
+
+// objectSubresources are query parameters of PUT requests on an object
+// that do not write its data.
+var objectSubresources = []string{"acl", "tagging", "retention", "legal-hold"}
+
+// Proxy forwards S3 requests to a backend, rejecting writes to keys over
+// quota unless the store reports them in grace.  The Host header passes
+// through unchanged, so request signatures remain valid for backends that
+// accept them on the proxy host.
+type Proxy struct {
+	Store  store.Store
+	Keys   *keys.Mapper
+	Logger logging.Logger
+	// Domain is the domain of virtual-hosted-style requests
+	// "bucket.Domain".  If empty, all requests are path-style.
+	Domain string
+	// ReservationTTL is the time to live of the reservation of each
+	// object put and each part of a multipart upload.  Reservations of
+	// an upload are released when it fails or is aborted, or when its
+	// object is created.
+	ReservationTTL time.Duration
+
+	backend *httputil.ReverseProxy
+}
+
+// New returns a Proxy that forwards requests to backend.
+func New(backend *url.URL, s store.Store, mapper *keys.Mapper, l logging.Logger) *Proxy {
+	p := &Proxy{Store: s, Keys: mapper, Logger: l, ReservationTTL: DefaultReservationTTL}
+	p.backend = httputil.NewSingleHostReverseProxy(backend)
+	p.backend.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		p.Logger.WithError(err).WithField("backend", backend.String()).Error("Forward request")
+		writeError(w, r, http.StatusBadGateway, "InternalError", "Backend unavailable")
+	}
+	return p
+}
+
+// Serve serves the proxy on listenAddress until ctx is cancelled.
+func (p *Proxy) Serve(ctx context.Context, listenAddress string) error {
+	server := &http.Server{Addr: listenAddress, Handler: p}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			p.Logger.WithError(err).Error("Proxy failed to shut down")
+		}
+	}()
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	op, size := operation(r)
+	if op == "" {
+		p.backend.ServeHTTP(w, r)
+		return
+	}
+	bucket, objectKey := p.object(r)
+	if bucket == "" || objectKey == "" {
+		p.backend.ServeHTTP(w, r)
+		return
+	}
+	path := keys.Path(bucket, objectKey)
+	key, ok := p.Keys.Key(path)
+	if !ok {
+		p.backend.ServeHTTP(w, r)
+		return
+	}
+
+	l := p.Logger.WithFields(logging.Fields{
+		logging.FieldKey:  key,
+		logging.FieldPath: path,
+		"operation":       op,
+	})
+	switch op {
+	case OpPutObject, OpUploadPart:
+		p.reserveWrite(w, r, l, key, path, size)
+		return
+	case OpAbortMultipartUpload:
+		p.abortMultipartUpload(w, r, l, key, path)
+		return
+	}
+	check, err := p.Store.CheckQuota(r.Context(), key, size)
+	if err != nil {
+		l.WithError(err).Error("Check quota")
+		writeError(w, r, http.StatusServiceUnavailable, "ServiceUnavailable", "Cannot check quota, please retry")
+		return
+	}
+	if !check.Allowed && !check.InGrace {
+		l.WithField("size_bytes", size).Info("Reject write over quota")
+		writeError(w, r, http.StatusForbidden, "QuotaExceeded", "Quota exceeded for "+key)
+		return
+	}
+	if !check.Allowed {
+		l.WithField("size_bytes", size).Debug("Allow write over quota in grace")
+	}
+	p.backend.ServeHTTP(w, r)
+}
+
+// reserveWrite reserves size bytes on key for an object or a part of a
+// multipart upload to path, and forwards r if that succeeds.  Concurrent
+// writes thus cannot together exceed quota.  The reservation is released
+// if the backend fails the write.  Keys in grace write without reserving.
+func (p *Proxy) reserveWrite(w http.ResponseWriter, r *http.Request, l logging.Logger, key, path string, size int64) {
+	reservation, err := p.Store.Reserve(r.Context(), store.Reservation{
+		Key:       key,
+		Path:      path,
+		SizeBytes: size,
+		ExpiresAt: time.Now().Add(p.ReservationTTL),
+	})
+	if errors.Is(err, store.ErrQuotaExceeded) {
+		check, checkErr := p.Store.CheckQuota(r.Context(), key, size)
+		if checkErr == nil && check.InGrace {
+			l.WithField("size_bytes", size).Debug("Allow write over quota in grace")
+			p.backend.ServeHTTP(w, r)
+			return
+		}
+		l.WithField("size_bytes", size).Info("Reject write over quota")
+		writeError(w, r, http.StatusForbidden, "QuotaExceeded", "Quota exceeded for "+key)
+		return
+	}
+	if err != nil {
+		l.WithError(err).Error("Reserve quota")
+		writeError(w, r, http.StatusServiceUnavailable, "ServiceUnavailable", "Cannot reserve quota, please retry")
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	p.backend.ServeHTTP(sw, r)
+	if sw.status < http.StatusMultipleChoices {
+		return
+	}
+	// Use a fresh context: the client may be gone.
+	if err := p.Store.ReleaseReservation(context.Background(), reservation.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		l.WithError(err).WithField("reservation_id", reservation.ID).Error("Release reservation of failed write")
+	}
+}
+
+// abortMultipartUpload forwards r, and releases the reservations of key
+// for parts uploaded to path if the backend aborts the upload.
+func (p *Proxy) abortMultipartUpload(w http.ResponseWriter, r *http.Request, l logging.Logger, key, path string) {
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	p.backend.ServeHTTP(sw, r)
+	if sw.status >= http.StatusMultipleChoices {
+		return
+	}
+	n, err := p.Store.ReleaseReservations(context.Background(), key, path)
+	if err != nil {
+		l.WithError(err).Error("Release reservations of aborted upload")
+		return
+	}
+	l.WithField("count", n).Debug("Released reservations of aborted upload")
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets the reverse proxy flush streamed responses.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// operation returns the enforced operation of r and the number of bytes
+// it writes, or "" if r is not enforced.  The size of a copy is unknown,
+// so copies and new multipart uploads are only rejected on keys that are
+// already at or over quota.  Each uploaded part reserves its size until
+// the upload completes or aborts.  Completing an upload writes no new
+// bytes, so it is not enforced.
+func operation(r *http.Request) (string, int64) {
+	q := r.URL.Query()
+	_, multipart := q[uploadIDParam]
+	switch r.Method {
+	case http.MethodPost:
+		if _, ok := q["uploads"]; ok {
+			return OpCreateMultipartUpload, 0
+		}
+	case http.MethodPut:
+		for _, sub := range objectSubresources {
+			if _, ok := q[sub]; ok {
+				return "", 0
+			}
+		}
+		copied := r.Header.Get(copySourceHeader) != ""
+		switch {
+		case multipart && copied:
+			return OpUploadPartCopy, 0
+		case multipart:
+			return OpUploadPart, contentLength(r)
+		case copied:
+			return OpCopyObject, 0
+		}
+		return OpPutObject, contentLength(r)
+	case http.MethodDelete:
+		if multipart {
+			return OpAbortMultipartUpload, 0
+		}
+	}
+	return "", 0
+}
+
+// contentLength returns the length of the object data in r, or 0 if
+// unknown.  Streaming signatures wrap data in chunks, and report its
+// length separately.
+func contentLength(r *http.Request) int64 {
+	if decoded := r.Header.Get(decodedContentLengthHeader); decoded != "" {
+		if n, err := strconv.ParseInt(decoded, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	if r.ContentLength < 0 {
+		return 0
+	}
+	return r.ContentLength
+}
+
+// object returns the bucket and object key that r addresses.
+func (p *Proxy) object(r *http.Request) (string, string) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if p.Domain != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if bucket := strings.TrimSuffix(host, "."+p.Domain); bucket != host {
+			return bucket, path
+		}
+	}
+	bucket, objectKey, _ := strings.Cut(path, "/")
+	return bucket, objectKey
+}
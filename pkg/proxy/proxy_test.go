@@ -0,0 +1,321 @@
+package proxy_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/grace"
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/proxy"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+const defaultQuota = 100
+
+// backend is a stand-in for S3 that records the requests it receives.
+type backend struct {
+	mu       sync.Mutex
+	requests []string
+	// status is the status of responses, or 0 for OK.
+	status int
+}
+
+func (b *backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests = append(b.requests, r.Method+" "+r.URL.RequestURI())
+	if b.status != 0 {
+		w.WriteHeader(b.status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setStatus sets the status of responses of b, or 0 for OK.
+func (b *backend) setStatus(status int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.status = status
+}
+
+// take returns and forgets the requests that b received.
+func (b *backend) take() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ret := b.requests
+	b.requests = nil
+	return ret
+}
+
+func newProxy(t *testing.T) (*proxy.Proxy, *backend, *httptest.Server) {
+	mapper, err := keys.NewMapper(`^s3://[^/]+/user/([^/]+)/.*$`, "$1")
+	if err != nil {
+		t.Fatalf("New key mapper: %s", err)
+	}
+	b := &backend{}
+	bs := httptest.NewServer(b)
+	t.Cleanup(bs.Close)
+	backendURL, err := url.Parse(bs.URL)
+	if err != nil {
+		t.Fatalf("Parse backend URL %s: %s", bs.URL, err)
+	}
+	p := proxy.New(backendURL, memory.NewStore(defaultQuota), mapper, logging.Discard())
+	p.Domain = "s3.example.com"
+	ps := httptest.NewServer(p)
+	t.Cleanup(ps.Close)
+	return p, b, ps
+}
+
+func TestProxy(t *testing.T) {
+	p, b, ps := newProxy(t)
+	ctx := context.Background()
+	if err := p.Store.Set(ctx, "alice", store.Value{SizeBytes: 60}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if err := p.Store.Set(ctx, "bob", store.Value{SizeBytes: defaultQuota + 1}); err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Fatalf("Set: %s", err)
+	}
+
+	cases := []struct {
+		Name    string
+		Method  string
+		Host    string
+		Path    string
+		Body    string
+		Header  map[string]string
+		Status  int
+		Code    string
+		Forward bool
+	}{
+		{Name: "PutAllowed", Method: http.MethodPut, Path: "/bucket/user/alice/a", Body: strings.Repeat("x", 40), Status: http.StatusOK, Forward: true},
+		{Name: "PutOverQuota", Method: http.MethodPut, Path: "/bucket/user/alice/a", Body: strings.Repeat("x", 41), Status: http.StatusForbidden, Code: "QuotaExceeded"},
+		{Name: "PutStreamingOverQuota", Method: http.MethodPut, Path: "/bucket/user/alice/a", Body: "chunked", Header: map[string]string{"X-Amz-Decoded-Content-Length": "41"}, Status: http.StatusForbidden, Code: "QuotaExceeded"},
+		{Name: "PutVirtualHostOverQuota", Method: http.MethodPut, Host: "bucket.s3.example.com", Path: "/user/alice/a", Body: strings.Repeat("x", 41), Status: http.StatusForbidden, Code: "QuotaExceeded"},
+		{Name: "PutUntracked", Method: http.MethodPut, Path: "/bucket/shared/a", Body: strings.Repeat("x", 1000), Status: http.StatusOK, Forward: true},
+		{Name: "PutTagging", Method: http.MethodPut, Path: "/bucket/user/bob/a?tagging", Status: http.StatusOK, Forward: true},
+		{Name: "CopyAllowed", Method: http.MethodPut, Path: "/bucket/user/alice/b", Header: map[string]string{"X-Amz-Copy-Source": "/bucket/user/bob/a"}, Status: http.StatusOK, Forward: true},
+		{Name: "CopyOverQuota", Method: http.MethodPut, Path: "/bucket/user/bob/b", Header: map[string]string{"X-Amz-Copy-Source": "/bucket/user/alice/a"}, Status: http.StatusForbidden, Code: "QuotaExceeded"},
+		{Name: "CreateMultipartUploadOverQuota", Method: http.MethodPost, Path: "/bucket/user/bob/c?uploads", Status: http.StatusForbidden, Code: "QuotaExceeded"},
+		{Name: "GetOverQuota", Method: http.MethodGet, Path: "/bucket/user/bob/a", Status: http.StatusOK, Forward: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			req, err := http.NewRequest(c.Method, ps.URL+c.Path, strings.NewReader(c.Body))
+			if err != nil {
+				t.Fatalf("New request: %s", err)
+			}
+			if c.Host != "" {
+				req.Host = c.Host
+			}
+			for k, v := range c.Header {
+				req.Header.Set(k, v)
+			}
+			resp, err := ps.Client().Do(req)
+			if err != nil {
+				t.Fatalf("%s %s: %s", c.Method, c.Path, err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != c.Status {
+				t.Errorf("Got status %d expected %d", resp.StatusCode, c.Status)
+			}
+			if c.Code != "" {
+				var s3Err struct{ Code string }
+				if err := xml.NewDecoder(resp.Body).Decode(&s3Err); err != nil {
+					t.Fatalf("Decode error: %s", err)
+				}
+				if s3Err.Code != c.Code {
+					t.Errorf("Got error code %s expected %s", s3Err.Code, c.Code)
+				}
+			}
+			if forwarded := len(b.take()) > 0; forwarded != c.Forward {
+				t.Errorf("Got forwarded %t expected %t", forwarded, c.Forward)
+			}
+		})
+	}
+}
+
+func TestProxyMultipart(t *testing.T) {
+	p, b, ps := newProxy(t)
+	ctx := context.Background()
+	if err := p.Store.Set(ctx, "alice", store.Value{SizeBytes: 60}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if err := p.Store.Set(ctx, "bob", store.Value{SizeBytes: defaultQuota + 1}); err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Fatalf("Set: %s", err)
+	}
+
+	const part = "/bucket/user/alice/big?partNumber=%d&uploadId=u1"
+	cases := []struct {
+		Name          string
+		Method        string
+		Path          string
+		Body          string
+		Header        map[string]string
+		BackendStatus int
+		Status        int
+		Forward       bool
+		// ReservedBytes is the number of bytes reserved on alice
+		// after the request.
+		ReservedBytes int64
+	}{
+		{Name: "UploadPart", Method: http.MethodPut, Path: fmt.Sprintf(part, 1), Body: strings.Repeat("x", 30), Status: http.StatusOK, Forward: true, ReservedBytes: 30},
+		{Name: "UploadPartOverQuota", Method: http.MethodPut, Path: fmt.Sprintf(part, 2), Body: strings.Repeat("x", 11), Status: http.StatusForbidden, ReservedBytes: 30},
+		{Name: "UploadPartFailed", Method: http.MethodPut, Path: fmt.Sprintf(part, 2), Body: strings.Repeat("x", 10), BackendStatus: http.StatusInternalServerError, Status: http.StatusInternalServerError, Forward: true, ReservedBytes: 30},
+		{Name: "UploadPartCopyOverQuota", Method: http.MethodPut, Path: "/bucket/user/bob/big?partNumber=1&uploadId=u2", Header: map[string]string{"X-Amz-Copy-Source": "/bucket/user/alice/a"}, Status: http.StatusForbidden, ReservedBytes: 30},
+		{Name: "Complete", Method: http.MethodPost, Path: "/bucket/user/alice/big?uploadId=u1", Status: http.StatusOK, Forward: true, ReservedBytes: 30},
+		{Name: "AbortFailed", Method: http.MethodDelete, Path: "/bucket/user/alice/big?uploadId=u1", BackendStatus: http.StatusNotFound, Status: http.StatusNotFound, Forward: true, ReservedBytes: 30},
+		{Name: "Abort", Method: http.MethodDelete, Path: "/bucket/user/alice/big?uploadId=u1", Status: http.StatusOK, Forward: true, ReservedBytes: 0},
+		{Name: "UploadPartAfterAbort", Method: http.MethodPut, Path: fmt.Sprintf(part, 1), Body: strings.Repeat("x", 40), Status: http.StatusOK, Forward: true, ReservedBytes: 40},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			b.setStatus(c.BackendStatus)
+			req, err := http.NewRequest(c.Method, ps.URL+c.Path, strings.NewReader(c.Body))
+			if err != nil {
+				t.Fatalf("New request: %s", err)
+			}
+			for k, v := range c.Header {
+				req.Header.Set(k, v)
+			}
+			resp, err := ps.Client().Do(req)
+			if err != nil {
+				t.Fatalf("%s %s: %s", c.Method, c.Path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != c.Status {
+				t.Errorf("Got status %d expected %d", resp.StatusCode, c.Status)
+			}
+			if forwarded := len(b.take()) > 0; forwarded != c.Forward {
+				t.Errorf("Got forwarded %t expected %t", forwarded, c.Forward)
+			}
+			check, err := p.Store.CheckQuota(ctx, "alice", 0)
+			if err != nil {
+				t.Fatalf("CheckQuota: %s", err)
+			}
+			if check.ReservedBytes != c.ReservedBytes {
+				t.Errorf("Got %d reserved bytes, expected %d", check.ReservedBytes, c.ReservedBytes)
+			}
+		})
+	}
+}
+
+func TestProxyGrace(t *testing.T) {
+	p, b, ps := newProxy(t)
+	ctx := context.Background()
+	now := time.Now()
+	g := grace.NewStore(p.Store, time.Hour)
+	g.Now = func() time.Time { return now }
+	p.Store = g
+	if err := g.SetGracePeriod(ctx, "strict", 0); err != nil {
+		t.Fatalf("SetGracePeriod: %s", err)
+	}
+	// expired has exceeded quota for longer than its grace period.
+	if _, err := g.Store.MarkExceeded(ctx, "expired", true, now.Add(-2*time.Hour)); err != nil {
+		t.Fatalf("MarkExceeded: %s", err)
+	}
+	for _, key := range []string{"lenient", "strict", "expired"} {
+		if err := g.Set(ctx, key, store.Value{SizeBytes: defaultQuota + 1}); err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+			t.Fatalf("Set %s: %s", key, err)
+		}
+	}
+	if err := g.Set(ctx, "growing", store.Value{SizeBytes: 60}); err != nil {
+		t.Fatalf("Set growing: %s", err)
+	}
+
+	cases := []struct {
+		Name    string
+		Path    string
+		Status  int
+		Forward bool
+	}{
+		{"InGrace", "/bucket/user/lenient/a", http.StatusOK, true},
+		{"UploadPartInGrace", "/bucket/user/lenient/big?partNumber=1&uploadId=u1", http.StatusOK, true},
+		{"StartsGrace", "/bucket/user/growing/a", http.StatusOK, true},
+		{"NoGrace", "/bucket/user/strict/a", http.StatusForbidden, false},
+		{"GraceExpired", "/bucket/user/expired/a", http.StatusForbidden, false},
+		{"UploadPartGraceExpired", "/bucket/user/expired/big?partNumber=1&uploadId=u2", http.StatusForbidden, false},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, ps.URL+c.Path, strings.NewReader(strings.Repeat("x", 41)))
+			if err != nil {
+				t.Fatalf("New request: %s", err)
+			}
+			resp, err := ps.Client().Do(req)
+			if err != nil {
+				t.Fatalf("PUT %s: %s", c.Path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != c.Status {
+				t.Errorf("Got status %d expected %d", resp.StatusCode, c.Status)
+			}
+			if forwarded := len(b.take()) > 0; forwarded != c.Forward {
+				t.Errorf("Got forwarded %t expected %t", forwarded, c.Forward)
+			}
+		})
+	}
+}
+
+func TestProxyPutReserves(t *testing.T) {
+	p, b, ps := newProxy(t)
+	ctx := context.Background()
+	if err := p.Store.Set(ctx, "alice", store.Value{SizeBytes: 60}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	cases := []struct {
+		Name          string
+		Path          string
+		BackendStatus int
+		Status        int
+		Forward       bool
+		// ReservedBytes is the number of bytes reserved on alice
+		// after the request.
+		ReservedBytes int64
+	}{
+		{Name: "PutFailed", Path: "/bucket/user/alice/a", BackendStatus: http.StatusInternalServerError, Status: http.StatusInternalServerError, Forward: true},
+		{Name: "Put", Path: "/bucket/user/alice/a", Status: http.StatusOK, Forward: true, ReservedBytes: 30},
+		// The object of the first put is not created yet.
+		{Name: "ConcurrentPutOverQuota", Path: "/bucket/user/alice/b", Status: http.StatusForbidden, ReservedBytes: 30},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			b.setStatus(c.BackendStatus)
+			req, err := http.NewRequest(http.MethodPut, ps.URL+c.Path, strings.NewReader(strings.Repeat("x", 30)))
+			if err != nil {
+				t.Fatalf("New request: %s", err)
+			}
+			resp, err := ps.Client().Do(req)
+			if err != nil {
+				t.Fatalf("PUT %s: %s", c.Path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != c.Status {
+				t.Errorf("Got status %d expected %d", resp.StatusCode, c.Status)
+			}
+			if forwarded := len(b.take()) > 0; forwarded != c.Forward {
+				t.Errorf("Got forwarded %t expected %t", forwarded, c.Forward)
+			}
+			check, err := p.Store.CheckQuota(ctx, "alice", 0)
+			if err != nil {
+				t.Fatalf("CheckQuota: %s", err)
+			}
+			if check.ReservedBytes != c.ReservedBytes {
+				t.Errorf("Got %d reserved bytes, expected %d", check.ReservedBytes, c.ReservedBytes)
+			}
+		})
+	}
+}
@@ -3,10 +3,13 @@ package queue_handler
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 
 	"golang.org/x/mod/semver"
+
+	"github.com/treeverse/terminus/pkg/keys"
 )
 
 const (
@@ -16,8 +19,30 @@ const (
 	EventTypeTest                = "TestEvent"
 	EventTypeObjectCreatedPrefix = "ObjectCreated:"
 	EventTypeObjectRemovedPrefix = "ObjectRemoved:"
+	// EventTypeLifecycleExpirationPrefix starts removals by a lifecycle
+	// rule of the bucket.
+	EventTypeLifecycleExpirationPrefix = "LifecycleExpiration:"
+	// EventTypeLifecycleTransition moves an object to another storage
+	// class by a lifecycle rule of the bucket.
+	EventTypeLifecycleTransition = "LifecycleTransition"
+	// EventTypeDeleteSuffix ends removals that permanently delete an
+	// object.  Other removals only create delete markers.
+	EventTypeDeleteSuffix = ":Delete"
 )
 
+// removalPrefixes start the names of all events that remove objects.
+var removalPrefixes = []string{EventTypeObjectRemovedPrefix, EventTypeLifecycleExpirationPrefix}
+
+// isRemoval returns true if eventName removes an object.
+func isRemoval(eventName string) bool {
+	for _, prefix := range removalPrefixes {
+		if strings.HasPrefix(eventName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // S3EventRecord is the Go-ish version of the JSON object sent as an S3 event.
 type S3EventRecord struct {
 	EventVersion string    `json:"eventVersion"`
@@ -31,7 +56,11 @@ type S3EventRecord struct {
 			Key       string `json:"key"`
 			Size      *int64 `json:"size"`
 			ETag      string `json:"eTag"`
+			VersionID string `json:"versionId"`
 			Sequencer string `json:"sequencer"`
+			// StorageClass is only present on some events, e.g. from
+			// EventBridge.
+			StorageClass string `json:"storageClass"`
 		} `json:"object"`
 	} `json:"s3"`
 }
@@ -45,6 +74,21 @@ type ObjectPathAndSize struct {
 	Path string
 	// SizeBytes is the number of bytes changed by this path.
 	SizeBytes int64
+	// Bucket and Key locate the object in S3.  Key is decoded.
+	Bucket string
+	Key    string
+	// VersionID is the version of the object, or empty in a bucket
+	// without versioning.
+	VersionID string
+	// Removed is true if the object was deleted.  SizeBytes of removed
+	// objects is unknown.
+	Removed bool
+	// Transitioned is true if the object moved to another storage
+	// class.  Its size is unchanged, so SizeBytes is unknown.
+	Transitioned bool
+	// StorageClass is the storage class of the object, or empty if the
+	// event did not carry it.
+	StorageClass string
 }
 
 var (
@@ -52,6 +96,7 @@ var (
 	ErrNotAChange   = errors.New("not a change")
 	ErrUnknownEvent = errors.New("unknown event name")
 	ErrMissingField = errors.New("field missing")
+	ErrBadKey       = errors.New("bad URL encoding of object key")
 )
 
 func checkEventVersion(version string) error {
@@ -76,10 +121,13 @@ func ComputePathAndSize(r *S3EventRecord) (ObjectPathAndSize, error) {
 	if err := checkEventVersion(r.EventVersion); err != nil {
 		return ObjectPathAndSize{}, err
 	}
-	if r.EventName == EventTypeTest || strings.HasPrefix(r.EventName, EventTypeObjectRemovedPrefix) {
+	removal := isRemoval(r.EventName)
+	removed := removal && strings.HasSuffix(r.EventName, EventTypeDeleteSuffix)
+	if r.EventName == EventTypeTest || removal && !removed {
 		return ObjectPathAndSize{}, ErrNotAChange
 	}
-	if !strings.HasPrefix(r.EventName, EventTypeObjectCreatedPrefix) {
+	transitioned := r.EventName == EventTypeLifecycleTransition
+	if !removal && !transitioned && !strings.HasPrefix(r.EventName, EventTypeObjectCreatedPrefix) {
 		return ObjectPathAndSize{}, fmt.Errorf("%s: %w", r.EventName, ErrUnknownEvent)
 	}
 
@@ -87,17 +135,29 @@ func ComputePathAndSize(r *S3EventRecord) (ObjectPathAndSize, error) {
 	if bucket == "" {
 		return ObjectPathAndSize{}, fmt.Errorf("bucket.name %w", ErrMissingField)
 	}
-	key := r.S3.Object.Key
-	if key == "" {
+	if r.S3.Object.Key == "" {
 		return ObjectPathAndSize{}, fmt.Errorf("object.key %w", ErrMissingField)
 	}
+	// S3 events URL-encode object keys, like a query.
+	key, err := url.QueryUnescape(r.S3.Object.Key)
+	if err != nil {
+		return ObjectPathAndSize{}, fmt.Errorf("object.key: %w", ErrBadKey)
+	}
+	o := ObjectPathAndSize{
+		Path:         keys.Path(bucket, key),
+		Bucket:       bucket,
+		Key:          key,
+		VersionID:    r.S3.Object.VersionID,
+		Removed:      removed,
+		Transitioned: transitioned,
+		StorageClass: r.S3.Object.StorageClass,
+	}
+	if removed || transitioned {
+		return o, nil
+	}
 	if r.S3.Object.Size == nil {
 		return ObjectPathAndSize{}, fmt.Errorf("object.size %w", ErrMissingField)
 	}
-	size := *r.S3.Object.Size
-
-	return ObjectPathAndSize{
-		Path:      fmt.Sprint("s3://" + bucket + "/" + key),
-		SizeBytes: size,
-	}, nil
+	o.SizeBytes = *r.S3.Object.Size
+	return o, nil
 }
@@ -0,0 +1,105 @@
+package queue_handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/queue_handler"
+)
+
+// record returns e as an S3EventRecord.
+func record(t *testing.T, e *event) *queue_handler.S3EventRecord {
+	t.Helper()
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("Marshal event: %s", err)
+	}
+	var r queue_handler.S3EventRecord
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("Unmarshal record: %s", err)
+	}
+	return &r
+}
+
+func TestComputePathAndSize(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Event    *event
+		Expected queue_handler.ObjectPathAndSize
+		Err      error
+	}{
+		{
+			Name:  "Put",
+			Event: makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/foo").WithSize(17),
+			Expected: queue_handler.ObjectPathAndSize{
+				Path: "s3://bbb/user/foo", SizeBytes: 17, Bucket: "bbb", Key: "user/foo",
+			},
+		}, {
+			Name:  "EncodedKey",
+			Event: makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/dt%3D2026/a+b%C3%A9").WithSize(17),
+			Expected: queue_handler.ObjectPathAndSize{
+				Path: "s3://bbb/user/dt=2026/a bé", SizeBytes: 17, Bucket: "bbb", Key: "user/dt=2026/a bé",
+			},
+		}, {
+			Name:  "BadlyEncodedKey",
+			Event: makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/100%").WithSize(17),
+			Err:   queue_handler.ErrBadKey,
+		}, {
+			Name:  "Delete",
+			Event: makeEvent().WithType("ObjectRemoved:Delete").WithBucket("bbb").WithKey("user/foo").WithVersionID("v1"),
+			Expected: queue_handler.ObjectPathAndSize{
+				Path: "s3://bbb/user/foo", Bucket: "bbb", Key: "user/foo", VersionID: "v1", Removed: true,
+			},
+		}, {
+			Name:  "LifecycleExpirationDelete",
+			Event: makeEvent().WithType("LifecycleExpiration:Delete").WithBucket("bbb").WithKey("user/foo"),
+			Expected: queue_handler.ObjectPathAndSize{
+				Path: "s3://bbb/user/foo", Bucket: "bbb", Key: "user/foo", Removed: true,
+			},
+		}, {
+			Name:  "LifecycleTransition",
+			Event: makeEvent().WithType("LifecycleTransition").WithBucket("bbb").WithKey("user/foo").WithSize(17).WithVersionID("v1"),
+			Expected: queue_handler.ObjectPathAndSize{
+				Path: "s3://bbb/user/foo", Bucket: "bbb", Key: "user/foo", VersionID: "v1", Transitioned: true,
+			},
+		}, {
+			Name:  "DeleteMarkerCreated",
+			Event: makeEvent().WithType("ObjectRemoved:DeleteMarkerCreated").WithBucket("bbb").WithKey("user/foo"),
+			Err:   queue_handler.ErrNotAChange,
+		}, {
+			Name:  "LifecycleExpirationDeleteMarkerCreated",
+			Event: makeEvent().WithType("LifecycleExpiration:DeleteMarkerCreated").WithBucket("bbb").WithKey("user/foo"),
+			Err:   queue_handler.ErrNotAChange,
+		}, {
+			Name:  "Test",
+			Event: makeEvent().WithType("TestEvent"),
+			Err:   queue_handler.ErrNotAChange,
+		}, {
+			Name:  "Unknown",
+			Event: makeEvent().WithType("ObjectRestore:Post").WithBucket("bbb").WithKey("user/foo"),
+			Err:   queue_handler.ErrUnknownEvent,
+		}, {
+			Name:  "DeleteMissingKey",
+			Event: makeEvent().WithType("LifecycleExpiration:Delete").WithBucket("bbb"),
+			Err:   queue_handler.ErrMissingField,
+		}, {
+			Name:  "PutMissingSize",
+			Event: makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/foo"),
+			Err:   queue_handler.ErrMissingField,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			o, err := queue_handler.ComputePathAndSize(record(t, c.Event))
+			if !errors.Is(err, c.Err) {
+				t.Fatalf("Got error %v expected %v", err, c.Err)
+			}
+			if diffs := deep.Equal(o, c.Expected); diffs != nil {
+				t.Errorf("Unexpected object: %s", diffs)
+			}
+		})
+	}
+}
@@ -7,21 +7,29 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"regexp"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	multierror "github.com/hashicorp/go-multierror"
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/logging"
 	"github.com/treeverse/terminus/pkg/store"
 )
 
 const sleepAfterReceiveFailed = 2 * time.Second
 
+// Observer observes keys after their usage changes.
+type Observer interface {
+	// Updated is called after key changes, with whether it now exceeds
+	// quota.
+	Updated(ctx context.Context, key string, exceeded bool)
+}
+
 // Poll repeatedly long-polls on client, and updates the store s, until ctx
-// is cancelled.
-func Poll(ctx context.Context, l *log.Logger, client *sqs.SQS, queueUrl string, keyPattern *regexp.Regexp, keyReplace string, s store.Store) {
+// is cancelled.  If classes is not nil usage is also tracked by storage
+// class.  If observer is not nil it observes every updated key.
+func Poll(ctx context.Context, l logging.Logger, client *sqs.SQS, queueUrl string, mapper *keys.Mapper, classes StorageClasses, s store.Store, observer Observer) {
 	for {
 		in := &sqs.ReceiveMessageInput{
 			// TODO(ariels): Limiting AttributeNames might increase performance.
@@ -35,19 +43,19 @@ func Poll(ctx context.Context, l *log.Logger, client *sqs.SQS, queueUrl string,
 		}
 		out, err := client.ReceiveMessageWithContext(ctx, in)
 		if ctx.Err() != nil {
-			l.Printf("DONE: %s\n", ctx.Err())
+			l.WithError(ctx.Err()).Info("Done polling")
 			return
 		}
 		if err != nil {
-			// TODO(ariels): Replace with a better logger
-			l.Printf("ERROR: %s\n", err)
+			l.WithError(err).Error("Receive messages")
 			time.Sleep(sleepAfterReceiveFailed)
 			continue
 		}
 		for i, m := range out.Messages {
-			err = UpdateStore(ctx, l, m, keyPattern, keyReplace, s)
+			ml := l.WithField(logging.FieldMessageID, aws.StringValue(m.MessageId))
+			err = UpdateStore(ctx, ml, m, mapper, classes, s, observer)
 			if err != nil {
-				l.Printf("ERROR (%d/%d): %s\n", i, len(out.Messages), err)
+				ml.WithError(err).Errorf("Update store from message %d/%d", i, len(out.Messages))
 				continue // Don't delete, message may be retries or dead-lettered.
 			}
 
@@ -56,23 +64,29 @@ func Poll(ctx context.Context, l *log.Logger, client *sqs.SQS, queueUrl string,
 				ReceiptHandle: m.ReceiptHandle,
 			})
 			if err != nil {
-				l.Printf("ERROR: Ack/delete message handle %s: %s\n", *m.ReceiptHandle, err)
+				ml.WithError(err).WithField("receipt_handle", aws.StringValue(m.ReceiptHandle)).Error("Ack/delete message")
 				continue
 			}
 		}
 	}
 }
 
-// UpdateStore updates quota on s from an SQS record.
-func UpdateStore(ctx context.Context, l *log.Logger, message *sqs.Message, keyPattern *regexp.Regexp, keyReplace string, s store.Store) error {
+// UpdateStore updates quota on s from an SQS record.  It puts created
+// objects on the keys that mapper maps them to, and deletes removed
+// objects from the keys that they were put on, and moves transitioned
+// objects to their new storage class.  It tracks usage by the
+// storage class of events that carry one, or else by the class that
+// classes looks up if it is not nil.  If observer is not nil it observes
+// every updated key.
+func UpdateStore(ctx context.Context, l logging.Logger, message *sqs.Message, mapper *keys.Mapper, classes StorageClasses, s store.Store, observer Observer) error {
 	var records struct {
 		Records []S3EventRecord `json:"Records"`
 	}
 
 	if err := json.Unmarshal([]byte(*message.Body), &records); err != nil {
-		// TODO(ariels): Can we output the bad body here?  It might
-		// contain PII in S3 object keys... but OTOH we cannot fix
-		// what we cannot see.
+		// The body may contain PII in S3 object keys, the logger
+		// redacts it according to its configured policy.
+		l.WithError(err).WithField(logging.FieldBody, *message.Body).Warn("Bad message body")
 
 		id := "[no ID]"
 		if message.MessageId != nil {
@@ -85,25 +99,139 @@ func UpdateStore(ctx context.Context, l *log.Logger, message *sqs.Message, keyPa
 	for i, rec := range records.Records {
 		o, err := ComputePathAndSize(&rec)
 		if err != nil && !errors.Is(err, ErrNotAChange) {
+			l.WithError(err).WithFields(logging.Fields{
+				"record":               i,
+				"event_name":           rec.EventName,
+				logging.FieldObjectKey: rec.S3.Object.Key,
+			}).Debug("Bad record")
 			merr = multierror.Append(merr, fmt.Errorf("record parse failed for message %s @%d: %w\n", aws.StringValue(message.MessageId), i, err))
 		}
 		if err != nil {
 			continue
 		}
 
-		match := keyPattern.FindStringSubmatchIndex(o.Path)
-		if len(match) == 0 {
+		if o.Removed {
+			// The object counts toward the key it was put on, even
+			// if its path no longer maps to that key.
+			key, err := s.DeleteObject(ctx, o.Path, o.VersionID, eventTime(&rec))
+			if errors.Is(err, store.ErrNotFound) {
+				// Put before its objects were tracked, or not on
+				// any key.
+				l.WithField(logging.FieldPath, o.Path).Debug("Delete untracked object")
+				continue
+			}
+			if err = observe(ctx, l, observer, key, o.Path, err); err != nil {
+				// Errors are logged without redaction, so only
+				// the redacted field carries the path.
+				l.WithError(err).WithFields(logging.Fields{
+					"record":          i,
+					logging.FieldKey:  key,
+					logging.FieldPath: o.Path,
+				}).Debug("Delete object")
+				merr = multierror.Append(merr, fmt.Errorf("delete object of record %d: %w", i, err))
+			}
+			continue
+		}
+
+		if o.Transitioned {
+			if err = transitionObject(ctx, l, classes, s, observer, &o); err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("transition object of record %d: %w", i, err))
+			}
+			continue
+		}
+
+		key, ok := mapper.Key(o.Path)
+		if !ok {
 			continue
 		}
 
-		key := keyPattern.ExpandString(nil, keyReplace, o.Path, match)
-		err = s.AddSizeBytes(ctx, string(key), o.SizeBytes)
-		if errors.Is(err, store.ErrQuotaExceeded) {
-			l.Printf("[TODO] Quota exceeded, key %s\n", key)
-		} else if err != nil {
-			merr = multierror.Append(merr, fmt.Errorf("add %d bytes to key %s: %w", o.SizeBytes, key, err))
+		object := store.Object{
+			Path:         o.Path,
+			VersionID:    o.VersionID,
+			SizeBytes:    o.SizeBytes,
+			StorageClass: storageClass(ctx, l, classes, &o),
+		}
+		err = s.PutObject(ctx, key, object, eventTime(&rec))
+		if err = observe(ctx, l, observer, key, o.Path, err); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("put object of %d bytes on key %s: %w", o.SizeBytes, key, err))
 			continue
 		}
+
+		// The upload is done, its usage now counts instead.
+		if _, err = s.ReleaseReservations(ctx, key, o.Path); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("release reservations of key %s: %w", key, err))
+		}
 	}
 	return merr.ErrorOrNil()
 }
+
+// transitionObject moves the tracked object o to its new storage class on
+// s.  It learns that class from o, or else looks it up on classes.  Objects
+// whose new class is unknown stay in their class.
+func transitionObject(ctx context.Context, l logging.Logger, classes StorageClasses, s store.Store, observer Observer, o *ObjectPathAndSize) error {
+	l = l.WithField(logging.FieldPath, o.Path)
+	class := o.StorageClass
+	if class == "" && classes != nil {
+		classes.Forget(o.Bucket, o.Key)
+		var err error
+		if class, err = classes.StorageClass(ctx, o.Bucket, o.Key); err != nil {
+			l.WithError(err).Warn("Look up storage class of transitioned object, keep its class")
+			return nil
+		}
+	}
+	if class == "" {
+		l.Debug("Transition to unknown storage class, keep its class")
+		return nil
+	}
+	// The object counts toward the key it was put on, as on deletion.
+	key, err := s.TransitionObject(ctx, o.Path, o.VersionID, class)
+	if errors.Is(err, store.ErrNotFound) {
+		l.Debug("Transition untracked object")
+		return nil
+	}
+	return observe(ctx, l, observer, key, o.Path, err)
+}
+
+// observe reports a change of key by the object at path that returned err
+// to observer, if it is not nil.  Keys in grace are not reported as
+// exceeding quota.  It returns err unless that is nil or ErrQuotaExceeded.
+func observe(ctx context.Context, l logging.Logger, observer Observer, key, path string, err error) error {
+	exceeded := errors.Is(err, store.ErrQuotaExceeded)
+	inGrace := errors.Is(err, store.ErrQuotaGrace)
+	if exceeded {
+		l.WithFields(logging.Fields{
+			logging.FieldKey:  key,
+			logging.FieldPath: path,
+			"in_grace":        inGrace,
+		}).Warn("Quota exceeded")
+	} else if err != nil {
+		return err
+	}
+	if observer != nil {
+		observer.Updated(ctx, key, exceeded && !inGrace)
+	}
+	return nil
+}
+
+// eventTime returns the time of rec, or the current time if rec has none.
+func eventTime(rec *S3EventRecord) time.Time {
+	if rec.EventTime.IsZero() {
+		return time.Now()
+	}
+	return rec.EventTime
+}
+
+// storageClass returns the storage class of o, looking it up on classes if
+// needed, or empty if it is unknown.  Usage counts even if the lookup
+// fails, so that counts as the default class.
+func storageClass(ctx context.Context, l logging.Logger, classes StorageClasses, o *ObjectPathAndSize) string {
+	if o.StorageClass != "" || classes == nil {
+		return o.StorageClass
+	}
+	class, err := classes.StorageClass(ctx, o.Bucket, o.Key)
+	if err != nil {
+		l.WithError(err).WithField(logging.FieldPath, o.Path).Warn("Look up storage class, count as " + store.DefaultStorageClass)
+		return store.DefaultStorageClass
+	}
+	return class
+}
@@ -6,66 +6,31 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-test/deep"
-	"log"
+	"math"
 	"regexp"
-	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/treeverse/terminus/pkg/grace"
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/logging"
 	"github.com/treeverse/terminus/pkg/queue_handler"
 	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
 )
 
-type Store struct {
-	mu sync.Mutex
-	V  map[string]int64
-}
-
-func makeStore() *Store {
-	ret := &Store{}
-	ret.V = make(map[string]int64)
-	return ret
-}
-
-func (s *Store) Get(_ context.Context, key string) (store.Value, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if size, ok := s.V[key]; !ok {
-		return store.Value{}, fmt.Errorf("%s: %w", key, store.ErrNotFound)
-	} else {
-		return store.Value{SizeBytes: size}, nil
+// diff returns differences between usage on s and expected.  Objects are
+// counted separately, see TestUpdateStoreObjectCounts.
+func diff(s *memory.Store, expected map[string]int64) []string {
+	actual := make(map[string]int64)
+	for key, e := range s.Snapshot().Entries {
+		actual[key] = e.SizeBytes
 	}
-}
-
-func (s *Store) Set(_ context.Context, key string, value store.Value) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.V[key] = value.SizeBytes
-	return nil
-}
-
-func (s *Store) AddSizeBytes(_ context.Context, key string, numBytes int64) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.V[key] += numBytes
-	return nil
-}
-
-func (s *Store) Diff(expectedV map[string]int64) []string {
-	s.mu.Lock()
-	if len(expectedV) == 0 && len(s.V) == 0 {
+	if len(expected) == 0 && len(actual) == 0 {
 		return nil
 	}
-	actualV := make(map[string]int64, len(s.V))
-	for k, v := range s.V {
-		actualV[k] = v
-	}
-	s.mu.Unlock()
-	return deep.Equal(expectedV, actualV)
-}
-
-func (s *Store) GetExceeded(_ context.Context) ([]store.Record, error) {
-	panic("Unimplemented!")
+	return deep.Equal(expected, actual)
 }
 
 func ptr(s string) *string {
@@ -77,8 +42,10 @@ type bucket struct {
 }
 
 type object struct {
-	Key  string `json:"key"`
-	Size *int64 `json:"size"`
+	Key          string `json:"key"`
+	Size         *int64 `json:"size"`
+	VersionID    string `json:"versionId,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
 }
 
 type s3Body struct {
@@ -134,6 +101,12 @@ func (e *event) WithSize(size int64) *event {
 	return e
 }
 
+// WithVersionID returns event with the object version ID set.
+func (e *event) WithVersionID(versionID string) *event {
+	e.S3.Object.VersionID = versionID
+	return e
+}
+
 // makeMessage returns a message by JSONifying all the records.
 func makeMessage(records ...interface{}) *sqs.Message {
 	type body struct {
@@ -169,13 +142,37 @@ func TestUpdateDB(t *testing.T) {
 		ErrPredicate func(err error) error
 	}{
 		{
-			Name: "SingleObjectAddedAndNotRemoved",
+			Name: "SingleObjectOverwrittenAndRemoved",
 			In: makeMessage(
 				makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/foo").WithSize(17),
 				makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/foo").WithSize(18),
 				makeEvent().WithType("ObjectRemoved:Delete").WithBucket("bbb").WithKey("user/foo"),
 			),
-			Out: map[string]int64{"b:bbb u:user": 35},
+			Out: map[string]int64{"b:bbb u:user": 0},
+		}, {
+			Name: "VersionsRemovedSeparately",
+			In: makeMessage(
+				makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/foo").WithSize(17).WithVersionID("v1"),
+				makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/foo").WithSize(18).WithVersionID("v2"),
+				makeEvent().WithType("ObjectRemoved:DeleteMarkerCreated").WithBucket("bbb").WithKey("user/foo").WithVersionID("v3"),
+				makeEvent().WithType("ObjectRemoved:Delete").WithBucket("bbb").WithKey("user/foo").WithVersionID("v1"),
+			),
+			Out: map[string]int64{"b:bbb u:user": 18},
+		}, {
+			Name: "LifecycleExpiration",
+			In: makeMessage(
+				makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/foo").WithSize(17),
+				makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/bar").WithSize(5),
+				makeEvent().WithType("LifecycleExpiration:Delete").WithBucket("bbb").WithKey("user/foo"),
+			),
+			Out: map[string]int64{"b:bbb u:user": 5},
+		}, {
+			Name: "UntrackedObjectRemoved",
+			In: makeMessage(
+				makeEvent().WithType("ObjectCreated:Put").WithBucket("bbb").WithKey("user/foo").WithSize(17),
+				makeEvent().WithType("ObjectRemoved:Delete").WithBucket("bbb").WithKey("user/bar"),
+			),
+			Out: map[string]int64{"b:bbb u:user": 17},
 		}, {
 			Name: "MultipleObjectsAdded",
 			In: makeMessage(
@@ -251,13 +248,15 @@ func TestUpdateDB(t *testing.T) {
 
 	ctx := context.Background()
 
-	keyPattern := regexp.MustCompile(`s3://(\w+)/(\w+)/.*`)
-	keyReplace := `b:$1 u:$2`
+	mapper := &keys.Mapper{
+		Pattern:     regexp.MustCompile(`s3://(\w+)/(\w+)/.*`),
+		Replacement: `b:$1 u:$2`,
+	}
 
 	for _, tc := range cases {
 		t.Run(tc.Name, func(t *testing.T) {
-			s := makeStore()
-			err := queue_handler.UpdateStore(ctx, log.Default(), tc.In, keyPattern, keyReplace, s)
+			s := memory.NewStore(math.MaxInt64)
+			err := queue_handler.UpdateStore(ctx, logging.Discard(), tc.In, mapper, nil, s, nil)
 			if tc.ErrPredicate != nil {
 				testErr := tc.ErrPredicate(err)
 				if testErr != nil {
@@ -267,10 +266,108 @@ func TestUpdateDB(t *testing.T) {
 				if err != nil {
 					t.Errorf("UpdateDB failed on %s: %s", *tc.In.Body, err)
 				}
-				if diffs := s.Diff(tc.Out); diffs != nil {
+				if diffs := diff(s, tc.Out); diffs != nil {
 					t.Errorf("Unexpected values for %s: %v", *tc.In.Body, diffs)
 				}
 			}
 		})
 	}
 }
+
+func TestUpdateStoreObjectCounts(t *testing.T) {
+	ctx := context.Background()
+	mapper := &keys.Mapper{
+		Pattern:     regexp.MustCompile(`s3://(\w+)/(\w+)/.*`),
+		Replacement: `b:$1 u:$2`,
+	}
+	s := memory.NewStore(math.MaxInt64)
+	s.DefaultQuotaObjects = 2
+	observer := &recordingObserver{}
+
+	put := func(key string) *event {
+		return makeEvent().WithType("ObjectCreated:Put").WithBucket("a").WithKey(key).WithSize(1)
+	}
+	remove := func(key string) *event {
+		return makeEvent().WithType("ObjectRemoved:Delete").WithBucket("a").WithKey(key)
+	}
+	messages := []*sqs.Message{
+		makeMessage(put("user/1"), put("user/2"), put("user/2")),
+		makeMessage(put("user/3")),
+		makeMessage(remove("user/2")),
+		makeMessage(remove("user/1"), remove("user/3")),
+	}
+	for i, m := range messages {
+		if err := queue_handler.UpdateStore(ctx, logging.Discard(), m, mapper, nil, s, observer); err != nil {
+			t.Fatalf("UpdateStore message %d: %s", i, err)
+		}
+	}
+	expected := []bool{false, false, false, true, false, false, false}
+	if diffs := deep.Equal(observer.exceeded, expected); diffs != nil {
+		t.Errorf("Observed exceeded: %s", diffs)
+	}
+	if count := s.Snapshot().Entries["b:a u:user"].ObjectCount; count != 0 {
+		t.Errorf("Got %d objects after deleting all, expected 0", count)
+	}
+}
+
+// recordingObserver records whether every update exceeded quota.
+type recordingObserver struct {
+	exceeded []bool
+}
+
+func (o *recordingObserver) Updated(_ context.Context, _ string, exceeded bool) {
+	o.exceeded = append(o.exceeded, exceeded)
+}
+
+func TestUpdateStoreGrace(t *testing.T) {
+	ctx := context.Background()
+	mapper := &keys.Mapper{
+		Pattern:     regexp.MustCompile(`s3://(\w+)/(\w+)/.*`),
+		Replacement: `b:$1 u:$2`,
+	}
+	m := memory.NewStore(math.MaxInt64)
+	m.DefaultQuotaObjects = 1
+	s := grace.NewStore(m, time.Hour)
+	if err := s.SetGracePeriod(ctx, "b:a u:strict", 0); err != nil {
+		t.Fatalf("SetGracePeriod: %s", err)
+	}
+	observer := &recordingObserver{}
+
+	put := func(key string) *event {
+		return makeEvent().WithType("ObjectCreated:Put").WithBucket("a").WithKey(key).WithSize(1)
+	}
+	message := makeMessage(put("lenient/1"), put("lenient/2"), put("strict/1"), put("strict/2"))
+	if err := queue_handler.UpdateStore(ctx, logging.Discard(), message, mapper, nil, s, observer); err != nil {
+		t.Fatalf("UpdateStore: %s", err)
+	}
+	// Keys in grace are not observed exceeding quota.
+	expected := []bool{false, false, false, true}
+	if diffs := deep.Equal(observer.exceeded, expected); diffs != nil {
+		t.Errorf("Observed exceeded: %s", diffs)
+	}
+}
+
+func TestUpdateStoreReleasesReservations(t *testing.T) {
+	ctx := context.Background()
+	mapper := &keys.Mapper{
+		Pattern:     regexp.MustCompile(`s3://(\w+)/(\w+)/.*`),
+		Replacement: `b:$1 u:$2`,
+	}
+	s := memory.NewStore(math.MaxInt64)
+	// The proxy reserves on the decoded path.
+	_, err := s.Reserve(ctx, store.Reservation{Key: "b:a u:user", Path: "s3://a/user/dt=2026/a b", SizeBytes: 10, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Reserve: %s", err)
+	}
+	message := makeMessage(makeEvent().WithType("ObjectCreated:Put").WithBucket("a").WithKey("user/dt%3D2026/a+b").WithSize(10))
+	if err := queue_handler.UpdateStore(ctx, logging.Discard(), message, mapper, nil, s, nil); err != nil {
+		t.Fatalf("UpdateStore: %s", err)
+	}
+	check, err := s.CheckQuota(ctx, "b:a u:user", 0)
+	if err != nil {
+		t.Fatalf("CheckQuota: %s", err)
+	}
+	if check.ReservedBytes != 0 || check.Info.UsageBytes != 10 {
+		t.Errorf("Got %d reserved bytes and usage %d, expected 0 and 10", check.ReservedBytes, check.Info.UsageBytes)
+	}
+}
@@ -0,0 +1,118 @@
+package queue_handler
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// DefaultStorageClassCacheSize is the number of storage classes that a
+// HeadObjectClasses caches by default.
+const DefaultStorageClassCacheSize = 10000
+
+// StorageClasses looks up the storage class of objects whose events carry
+// none.
+type StorageClasses interface {
+	// StorageClass returns the storage class of the object key in
+	// bucket.
+	StorageClass(ctx context.Context, bucket, key string) (string, error)
+	// Forget forgets any storage class of the object key in bucket
+	// that it remembers, after the object moves to another class.
+	Forget(bucket, key string)
+}
+
+// HeadObjectClasses looks up storage classes with HeadObject, and caches
+// the most recently used.  It is safe for concurrent use.
+type HeadObjectClasses struct {
+	client s3iface.S3API
+	size   int
+
+	mu sync.Mutex
+	// lru holds cached classes, most recently used first.
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// cachedClass is the storage class of the object at path.
+type cachedClass struct {
+	path  string
+	class string
+}
+
+// NewHeadObjectClasses returns a HeadObjectClasses on client that caches
+// up to size storage classes.
+func NewHeadObjectClasses(client s3iface.S3API, size int) *HeadObjectClasses {
+	return &HeadObjectClasses{
+		client:  client,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (h *HeadObjectClasses) StorageClass(ctx context.Context, bucket, key string) (string, error) {
+	path := keys.Path(bucket, key)
+	if class, ok := h.get(path); ok {
+		return class, nil
+	}
+	out, err := h.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		// Callers log the path through a redacted field.
+		return "", fmt.Errorf("head object: %w", err)
+	}
+	// S3 omits the storage class of STANDARD objects.
+	class := aws.StringValue(out.StorageClass)
+	if class == "" {
+		class = store.DefaultStorageClass
+	}
+	h.put(path, class)
+	return class, nil
+}
+
+func (h *HeadObjectClasses) Forget(bucket, key string) {
+	path := keys.Path(bucket, key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e, ok := h.entries[path]; ok {
+		h.lru.Remove(e)
+		delete(h.entries, path)
+	}
+}
+
+func (h *HeadObjectClasses) get(path string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.entries[path]
+	if !ok {
+		return "", false
+	}
+	h.lru.MoveToFront(e)
+	return e.Value.(*cachedClass).class, true
+}
+
+func (h *HeadObjectClasses) put(path, class string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e, ok := h.entries[path]; ok {
+		e.Value.(*cachedClass).class = class
+		h.lru.MoveToFront(e)
+		return
+	}
+	h.entries[path] = h.lru.PushFront(&cachedClass{path: path, class: class})
+	for h.lru.Len() > h.size {
+		oldest := h.lru.Back()
+		h.lru.Remove(oldest)
+		delete(h.entries, oldest.Value.(*cachedClass).path)
+	}
+}
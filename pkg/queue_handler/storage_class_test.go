@@ -0,0 +1,161 @@
+package queue_handler_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/keys"
+	"github.com/treeverse/terminus/pkg/logging"
+	"github.com/treeverse/terminus/pkg/queue_handler"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+var errNoSuchKey = errors.New("no such key")
+
+// fakeS3 serves HeadObject from classes of objects by key, and counts
+// calls.
+type fakeS3 struct {
+	s3iface.S3API
+	classes map[string]string
+	heads   int
+}
+
+func (f *fakeS3) HeadObjectWithContext(_ aws.Context, in *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	f.heads++
+	class, ok := f.classes[aws.StringValue(in.Key)]
+	if !ok {
+		return nil, errNoSuchKey
+	}
+	out := &s3.HeadObjectOutput{}
+	if class != "" {
+		out.StorageClass = aws.String(class)
+	}
+	return out, nil
+}
+
+func TestHeadObjectClasses(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeS3{classes: map[string]string{"a": "GLACIER", "b": "", "c": "STANDARD_IA"}}
+	classes := queue_handler.NewHeadObjectClasses(client, 2)
+
+	cases := []struct {
+		Key   string
+		Class string
+		Heads int
+	}{
+		{"a", "GLACIER", 1},
+		{"a", "GLACIER", 1},
+		{"b", "STANDARD", 2},
+		{"a", "GLACIER", 2},
+		// Evicts b, the least recently used.
+		{"c", "STANDARD_IA", 3},
+		{"a", "GLACIER", 3},
+		{"b", "STANDARD", 4},
+	}
+	for i, c := range cases {
+		class, err := classes.StorageClass(ctx, "bucket", c.Key)
+		if err != nil {
+			t.Fatalf("%d: StorageClass %s: %s", i, c.Key, err)
+		}
+		if class != c.Class {
+			t.Errorf("%d: Got class %s of %s, expected %s", i, class, c.Key, c.Class)
+		}
+		if client.heads != c.Heads {
+			t.Errorf("%d: Got %d HeadObjects, expected %d", i, client.heads, c.Heads)
+		}
+	}
+
+	if _, err := classes.StorageClass(ctx, "bucket", "missing"); !errors.Is(err, errNoSuchKey) {
+		t.Errorf("StorageClass missing: expected %s, got %v", errNoSuchKey, err)
+	}
+}
+
+func TestUpdateStoreStorageClasses(t *testing.T) {
+	ctx := context.Background()
+	mapper := &keys.Mapper{
+		Pattern:     regexp.MustCompile(`s3://(\w+)/(\w+)/.*`),
+		Replacement: `b:$1 u:$2`,
+	}
+	event := makeEvent().WithType("ObjectCreated:Put").WithBucket("a").WithKey("user/evented").WithSize(7)
+	event.S3.Object.StorageClass = "DEEP_ARCHIVE"
+	message := makeMessage(
+		makeEvent().WithType("ObjectCreated:Put").WithBucket("a").WithKey("user/cold").WithSize(11),
+		makeEvent().WithType("ObjectCreated:Put").WithBucket("a").WithKey("user/hot").WithSize(13),
+		makeEvent().WithType("ObjectCreated:Put").WithBucket("a").WithKey("user/missing").WithSize(17),
+		event,
+	)
+	client := &fakeS3{classes: map[string]string{"user/cold": "GLACIER", "user/hot": ""}}
+
+	s := memory.NewStore(math.MaxInt64)
+	classes := queue_handler.NewHeadObjectClasses(client, queue_handler.DefaultStorageClassCacheSize)
+	if err := queue_handler.UpdateStore(ctx, logging.Discard(), message, mapper, classes, s, nil); err != nil {
+		t.Fatalf("UpdateStore: %s", err)
+	}
+	usage, err := s.GetClassUsage(ctx, "b:a u:user")
+	if err != nil {
+		t.Fatalf("GetClassUsage: %s", err)
+	}
+	// A failed lookup counts as STANDARD.
+	expected := map[string]int64{"GLACIER": 11, "STANDARD": 30, "DEEP_ARCHIVE": 7}
+	if diffs := deep.Equal(usage, expected); diffs != nil {
+		t.Errorf("Usage by class: %s", diffs)
+	}
+	if client.heads != 3 {
+		t.Errorf("Got %d HeadObjects, expected 3", client.heads)
+	}
+}
+
+func TestUpdateStoreTransitions(t *testing.T) {
+	ctx := context.Background()
+	mapper := &keys.Mapper{
+		Pattern:     regexp.MustCompile(`s3://(\w+)/(\w+)/.*`),
+		Replacement: `b:$1 u:$2`,
+	}
+	client := &fakeS3{classes: map[string]string{"user/a": "", "user/b": "", "user/dt=1/c d": "STANDARD_IA"}}
+	s := memory.NewStore(math.MaxInt64)
+	classes := queue_handler.NewHeadObjectClasses(client, queue_handler.DefaultStorageClassCacheSize)
+	update := func(events ...interface{}) {
+		t.Helper()
+		if err := queue_handler.UpdateStore(ctx, logging.Discard(), makeMessage(events...), mapper, classes, s, nil); err != nil {
+			t.Fatalf("UpdateStore: %s", err)
+		}
+	}
+	expectUsage := func(expected map[string]int64) {
+		t.Helper()
+		usage, err := s.GetClassUsage(ctx, "b:a u:user")
+		if err != nil {
+			t.Fatalf("GetClassUsage: %s", err)
+		}
+		if diffs := deep.Equal(usage, expected); diffs != nil {
+			t.Errorf("Usage by class: %s", diffs)
+		}
+	}
+
+	update(
+		makeEvent().WithType("ObjectCreated:Put").WithBucket("a").WithKey("user/a").WithSize(11),
+		makeEvent().WithType("ObjectCreated:Put").WithBucket("a").WithKey("user/b").WithSize(13),
+		// Looked up by its decoded key.
+		makeEvent().WithType("ObjectCreated:Put").WithBucket("a").WithKey("user/dt%3D1/c+d").WithSize(5),
+	)
+	expectUsage(map[string]int64{"STANDARD": 24, "STANDARD_IA": 5})
+
+	// The cached class of a is stale after the transition.
+	client.classes["user/a"] = "GLACIER"
+	transitioned := makeEvent().WithType("LifecycleTransition").WithBucket("a").WithKey("user/b")
+	transitioned.S3.Object.StorageClass = "DEEP_ARCHIVE"
+	update(
+		makeEvent().WithType("LifecycleTransition").WithBucket("a").WithKey("user/a"),
+		transitioned,
+		makeEvent().WithType("LifecycleTransition").WithBucket("a").WithKey("user/untracked"),
+	)
+	expectUsage(map[string]int64{"GLACIER": 11, "DEEP_ARCHIVE": 13, "STANDARD_IA": 5})
+}
@@ -0,0 +1,150 @@
+// Package rate enforces quotas of bytes that keys ingest over sliding
+// windows, catching runaway writers before they reach their quota of
+// stored bytes.
+package rate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// Limit caps the bytes that a key may ingest over a sliding Window.
+// Windows are counted in whole store.IngestBuckets, so they may include up
+// to one bucket more.
+type Limit struct {
+	Window time.Duration
+	Bytes  int64
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d bytes per %s", l.Bytes, l.Window)
+}
+
+// Status is the ingest of a key over the window of a Limit.
+type Status struct {
+	Key         string
+	Limit       Limit
+	IngestBytes int64
+}
+
+// Exceeded returns true if s exceeds its limit.
+func (s Status) Exceeded() bool {
+	return s.IngestBytes > s.Limit.Bytes
+}
+
+// Limits are the default rate quotas of keys.  Keys on a store.Plan with
+// rate limits are limited by those instead.
+type Limits []Limit
+
+// Plan returns the limits of keys on p.
+func (ls Limits) Plan(p store.Plan) Limits {
+	if len(p.RateLimits) == 0 {
+		return ls
+	}
+	limits := make(Limits, 0, len(p.RateLimits))
+	for _, l := range p.RateLimits {
+		limits = append(limits, Limit(l))
+	}
+	return limits
+}
+
+// For returns the limits of keys on the plan named plan on s, or ls if
+// plan is empty.
+func (ls Limits) For(ctx context.Context, s store.Store, plan string) (Limits, error) {
+	if plan == "" {
+		return ls, nil
+	}
+	p, err := s.GetPlan(ctx, plan)
+	if errors.Is(err, store.ErrNotFound) {
+		// Deleted since.
+		return ls, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ls.Plan(p), nil
+}
+
+// Statuses returns the statuses of key on s under each of ls, over windows
+// ending at now.  Resolve the limits of key with For first.
+func (ls Limits) Statuses(ctx context.Context, s store.Store, key string, now time.Time) ([]Status, error) {
+	statuses := make([]Status, 0, len(ls))
+	for _, l := range ls {
+		from, to := store.IngestPeriod(now, l.Window)
+		ingestBytes, err := s.GetIngest(ctx, key, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("get ingest of %s over %s: %w", key, l.Window, err)
+		}
+		statuses = append(statuses, Status{Key: key, Limit: l, IngestBytes: ingestBytes})
+	}
+	return statuses, nil
+}
+
+// Exceeded returns the statuses of all keys on s that exceed any of their
+// limits, over windows ending at now.  They are sorted by key, and then in
+// the order of limits.
+func (ls Limits) Exceeded(ctx context.Context, s store.Store, now time.Time) ([]Status, error) {
+	plans, err := s.ListPlans(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list plans: %w", err)
+	}
+	// Only plans with rate limits replace ls.
+	planLimits := make(map[string]Limits)
+	windows := make(map[time.Duration]bool)
+	for _, l := range ls {
+		windows[l.Window] = true
+	}
+	for _, p := range plans {
+		if len(p.RateLimits) == 0 {
+			continue
+		}
+		planLimits[p.Name] = ls.Plan(p)
+		for _, l := range p.RateLimits {
+			windows[l.Window] = true
+		}
+	}
+
+	ingest := make(map[time.Duration]map[string]int64, len(windows))
+	var keys []string
+	for window := range windows {
+		from, to := store.IngestPeriod(now, window)
+		list, err := s.ListIngest(ctx, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("list ingest over %s: %w", window, err)
+		}
+		ingest[window] = make(map[string]int64, len(list))
+		for _, i := range list {
+			ingest[window][i.Key] = i.IngestBytes
+			keys = append(keys, i.Key)
+		}
+	}
+	sort.Strings(keys)
+
+	var exceeded []Status
+	for i, key := range keys {
+		if i > 0 && keys[i-1] == key {
+			continue
+		}
+		limits := ls
+		if len(planLimits) > 0 {
+			check, err := s.CheckQuota(ctx, key, 0)
+			if err != nil {
+				return nil, fmt.Errorf("get plan of %s: %w", key, err)
+			}
+			if pl, ok := planLimits[check.Info.Plan]; ok {
+				limits = pl
+			}
+		}
+		for _, l := range limits {
+			if status := (Status{Key: key, Limit: l, IngestBytes: ingest[l.Window][key]}); status.Exceeded() {
+				exceeded = append(exceeded, status)
+			}
+		}
+	}
+	return exceeded, nil
+}
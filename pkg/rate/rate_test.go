@@ -0,0 +1,190 @@
+package rate_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/rate"
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	hour := rate.Limit{Window: time.Hour, Bytes: 100}
+	day := rate.Limit{Window: 24 * time.Hour, Bytes: 150}
+	s := rate.NewStore(memory.NewStore(math.MaxInt64), rate.Limits{hour, day})
+	s.Now = func() time.Time { return now }
+
+	puts := []struct {
+		Key       string
+		Path      string
+		SizeBytes int64
+		At        time.Time
+	}{
+		{"k", "s3://b/old", 60, now.Add(-2 * time.Hour)},
+		{"k", "s3://b/new", 50, now.Add(-10 * time.Minute)},
+		{"quiet", "s3://b/quiet", 10, now},
+	}
+	for _, p := range puts {
+		if err := s.PutObject(ctx, p.Key, store.Object{Path: p.Path, SizeBytes: p.SizeBytes}, p.At); err != nil {
+			t.Fatalf("PutObject %s: %s", p.Path, err)
+		}
+	}
+
+	check, err := s.CheckQuota(ctx, "k", 40)
+	if err != nil {
+		t.Fatalf("CheckQuota: %s", err)
+	}
+	// The day has 40 bytes left, the hour 50.
+	if !check.Allowed || check.RemainingBytes != 40 {
+		t.Errorf("CheckQuota 40 bytes: got %+v", check)
+	}
+	if check, err = s.CheckQuota(ctx, "k", 41); err != nil || check.Allowed {
+		t.Errorf("CheckQuota 41 bytes: got %+v, %v", check, err)
+	}
+	if _, err = s.Reserve(ctx, store.Reservation{Key: "k", Path: "s3://b/o", SizeBytes: 41, ExpiresAt: now.Add(time.Hour)}); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("Reserve 41 bytes: expected %s, got %v", store.ErrQuotaExceeded, err)
+	}
+	exceeded, err := s.GetExceeded(ctx)
+	if err != nil || len(exceeded) != 0 {
+		t.Errorf("GetExceeded under rate quota: got %v, %v", exceeded, err)
+	}
+
+	// Overwriting still counts as ingest.
+	if err = s.PutObject(ctx, "k", store.Object{Path: "s3://b/new", SizeBytes: 60}, now); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("PutObject over rate quota: expected %s, got %v", store.ErrQuotaExceeded, err)
+	}
+	statuses, err := s.GetRateExceeded(ctx)
+	if err != nil {
+		t.Fatalf("GetRateExceeded: %s", err)
+	}
+	expected := []rate.Status{
+		{Key: "k", Limit: hour, IngestBytes: 110},
+		{Key: "k", Limit: day, IngestBytes: 170},
+	}
+	if diffs := deep.Equal(statuses, expected); diffs != nil {
+		t.Errorf("GetRateExceeded: %s", diffs)
+	}
+	exceeded, err = s.GetExceeded(ctx)
+	if err != nil || len(exceeded) != 1 || exceeded[0].Key != "k" {
+		t.Fatalf("GetExceeded over rate quota: got %v, %v", exceeded, err)
+	}
+	if diffs := deep.Equal(exceeded[0].Exceeded, []string{store.LimitRate}); diffs != nil {
+		t.Errorf("Exceeded limits over rate quota: %s", diffs)
+	}
+	// Usage is only 120 bytes, the overwritten object no longer counts.
+	if exceeded[0].Info.UsageBytes != 120 {
+		t.Errorf("Exceeded usage: got %d bytes, expected 120", exceeded[0].Info.UsageBytes)
+	}
+
+	// Deleting does not undo ingest.
+	if _, err = s.DeleteObject(ctx, "s3://b/old", "", now); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("DeleteObject over rate quota: expected %s, got %v", store.ErrQuotaExceeded, err)
+	}
+
+	// Rate quotas recover once their windows pass.
+	now = now.Add(25 * time.Hour)
+	if _, err = s.DeleteObject(ctx, "s3://b/new", "", now); err != nil {
+		t.Errorf("DeleteObject after windows: %s", err)
+	}
+	if exceeded, err = s.GetExceeded(ctx); err != nil || len(exceeded) != 0 {
+		t.Errorf("GetExceeded after windows: got %v, %v", exceeded, err)
+	}
+	if check, err = s.CheckQuota(ctx, "k", 100); err != nil || !check.Allowed {
+		t.Errorf("CheckQuota after windows: got %+v, %v", check, err)
+	}
+}
+
+func TestPlanLimits(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	hour := rate.Limit{Window: time.Hour, Bytes: 100}
+	s := rate.NewStore(memory.NewStore(math.MaxInt64), rate.Limits{hour})
+	s.Now = func() time.Time { return now }
+
+	bigHour := store.RateLimit{Window: time.Hour, Bytes: 1000}
+	if err := s.SetPlan(ctx, store.Plan{Name: "big", RateLimits: []store.RateLimit{bigHour}}); err != nil {
+		t.Fatalf("SetPlan: %s", err)
+	}
+	// Plans without rate limits keep the default ones.
+	if err := s.SetPlan(ctx, store.Plan{Name: "plain"}); err != nil {
+		t.Fatalf("SetPlan: %s", err)
+	}
+	for key, plan := range map[string]string{"k": "big", "p": "plain"} {
+		if err := s.AssignPlan(ctx, key, plan); err != nil {
+			t.Fatalf("AssignPlan %s: %s", key, err)
+		}
+	}
+	for _, key := range []string{"k", "p", "other"} {
+		err := s.PutObject(ctx, key, store.Object{Path: "s3://b/" + key, SizeBytes: 500}, now)
+		if expected := key != "k"; errors.Is(err, store.ErrQuotaExceeded) != expected {
+			t.Errorf("PutObject %s: got %v, expected exceeded %t", key, err, expected)
+		}
+	}
+	statuses, err := s.GetRateExceeded(ctx)
+	if err != nil {
+		t.Fatalf("GetRateExceeded: %s", err)
+	}
+	expected := []rate.Status{
+		{Key: "other", Limit: hour, IngestBytes: 500},
+		{Key: "p", Limit: hour, IngestBytes: 500},
+	}
+	if diffs := deep.Equal(statuses, expected); diffs != nil {
+		t.Errorf("GetRateExceeded: %s", diffs)
+	}
+
+	// Changes of the plan apply at once.
+	bigHour.Bytes = 400
+	if err := s.SetPlan(ctx, store.Plan{Name: "big", RateLimits: []store.RateLimit{bigHour}}); err != nil {
+		t.Fatalf("SetPlan: %s", err)
+	}
+	if statuses, err = s.Statuses(ctx, "k"); err != nil {
+		t.Fatalf("Statuses: %s", err)
+	}
+	if diffs := deep.Equal(statuses, []rate.Status{{Key: "k", Limit: rate.Limit(bigHour), IngestBytes: 500}}); diffs != nil {
+		t.Errorf("Statuses on plan: %s", diffs)
+	}
+	if check, err := s.CheckQuota(ctx, "k", 1); err != nil || check.Allowed {
+		t.Errorf("CheckQuota over plan rate quota: got %+v, %v", check, err)
+	}
+}
+
+// countingStore counts calls of CheckQuota.
+type countingStore struct {
+	store.Store
+	checks int
+}
+
+func (s *countingStore) CheckQuota(ctx context.Context, key string, numBytes int64) (store.QuotaCheck, error) {
+	s.checks++
+	return s.Store.CheckQuota(ctx, key, numBytes)
+}
+
+func TestResolveLimitsOnce(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	inner := &countingStore{Store: memory.NewStore(math.MaxInt64)}
+	s := rate.NewStore(inner, rate.Limits{{Window: time.Hour, Bytes: 100}})
+	s.Now = func() time.Time { return now }
+
+	if err := s.PutObject(ctx, "k", store.Object{Path: "s3://b/k", SizeBytes: 10}, now); err != nil {
+		t.Fatalf("PutObject: %s", err)
+	}
+	if inner.checks != 1 {
+		t.Errorf("PutObject checked quota %d times, expected 1", inner.checks)
+	}
+	inner.checks = 0
+	if _, err := s.CheckQuota(ctx, "k", 10); err != nil {
+		t.Fatalf("CheckQuota: %s", err)
+	}
+	if inner.checks != 1 {
+		t.Errorf("CheckQuota checked quota %d times, expected 1", inner.checks)
+	}
+}
@@ -0,0 +1,177 @@
+package rate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// Store is a store.Store whose keys also exceed quota while they ingest
+// more than any of their limits: those of their plan, or else Limits.
+// Exceeding a rate quota ends by itself once the window slides past the
+// ingest.
+//
+// Like those of a cost.Store, failures to check rate quotas after a change
+// are not errors of the change.
+type Store struct {
+	store.Store
+	Limits Limits
+	// Now returns the current time, or nil for time.Now.
+	Now func() time.Time
+}
+
+// NewStore returns a Store that enforces limits on s.
+func NewStore(s store.Store, limits Limits) *Store {
+	return &Store{Store: s, Limits: limits}
+}
+
+func (s *Store) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Statuses returns the statuses of key under each of its limits.
+func (s *Store) Statuses(ctx context.Context, key string) ([]Status, error) {
+	check, err := s.Store.CheckQuota(ctx, key, 0)
+	if err != nil {
+		return nil, fmt.Errorf("get plan of %s: %w", key, err)
+	}
+	return s.statuses(ctx, key, check.Info.Plan)
+}
+
+// statuses returns the statuses of key on plan under each of its limits.
+func (s *Store) statuses(ctx context.Context, key, plan string) ([]Status, error) {
+	limits, err := s.Limits.For(ctx, s.Store, plan)
+	if err != nil {
+		return nil, err
+	}
+	return limits.Statuses(ctx, s.Store, key, s.now())
+}
+
+// GetRateExceeded returns the statuses of all keys that exceed any of
+// their limits, sorted by key.
+func (s *Store) GetRateExceeded(ctx context.Context) ([]Status, error) {
+	return s.Limits.Exceeded(ctx, s.Store, s.now())
+}
+
+// checkRate returns store.ErrQuotaExceeded if err of a change to key is
+// nil but key exceeds a limit.
+func (s *Store) checkRate(ctx context.Context, key string, err error) error {
+	if err != nil {
+		return err
+	}
+	statuses, rateErr := s.Statuses(ctx, key)
+	if rateErr != nil {
+		return nil
+	}
+	for _, status := range statuses {
+		if status.Exceeded() {
+			return store.ErrQuotaExceeded
+		}
+	}
+	return nil
+}
+
+func (s *Store) PutObject(ctx context.Context, key string, o store.Object, at time.Time) error {
+	return s.checkRate(ctx, key, s.Store.PutObject(ctx, key, o, at))
+}
+
+// DeleteObject also returns store.ErrQuotaExceeded while the key of the
+// object still exceeds a limit: deleting does not undo its ingest.
+func (s *Store) DeleteObject(ctx context.Context, path, versionID string, at time.Time) (string, error) {
+	key, err := s.Store.DeleteObject(ctx, path, versionID, at)
+	if key == "" {
+		return key, err
+	}
+	return key, s.checkRate(ctx, key, err)
+}
+
+// TransitionObject also returns store.ErrQuotaExceeded while the key of
+// the object exceeds a limit.
+func (s *Store) TransitionObject(ctx context.Context, path, versionID, storageClass string) (string, error) {
+	key, err := s.Store.TransitionObject(ctx, path, versionID, storageClass)
+	if key == "" {
+		return key, err
+	}
+	return key, s.checkRate(ctx, key, err)
+}
+
+// CheckQuota also disallows growth that would exceed a limit, counting
+// reserved bytes as ingest.  RemainingBytes is the least of the bytes
+// remaining by any quota.
+func (s *Store) CheckQuota(ctx context.Context, key string, numBytes int64) (store.QuotaCheck, error) {
+	check, err := s.Store.CheckQuota(ctx, key, numBytes)
+	if err != nil {
+		return check, err
+	}
+	statuses, err := s.statuses(ctx, key, check.Info.Plan)
+	if err != nil {
+		return store.QuotaCheck{}, err
+	}
+	for _, status := range statuses {
+		ingestBytes := status.IngestBytes + check.ReservedBytes
+		if ingestBytes+numBytes > status.Limit.Bytes {
+			check.Allowed = false
+		}
+		remainingBytes := status.Limit.Bytes - ingestBytes
+		if remainingBytes < 0 {
+			remainingBytes = 0
+		}
+		if remainingBytes < check.RemainingBytes {
+			check.RemainingBytes = remainingBytes
+		}
+	}
+	return check, nil
+}
+
+// Reserve also refuses reservations that would exceed a limit of r.Key.
+func (s *Store) Reserve(ctx context.Context, r store.Reservation) (store.Reservation, error) {
+	check, err := s.CheckQuota(ctx, r.Key, r.SizeBytes)
+	if err != nil {
+		return store.Reservation{}, err
+	}
+	if !check.Allowed {
+		return store.Reservation{}, store.ErrQuotaExceeded
+	}
+	return s.Store.Reserve(ctx, r)
+}
+
+// GetExceeded also returns keys that exceed a limit, with
+// store.LimitRate.
+func (s *Store) GetExceeded(ctx context.Context) ([]store.Record, error) {
+	records, err := s.Store.GetExceeded(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := s.GetRateExceeded(ctx)
+	if err != nil {
+		return nil, err
+	}
+	exceeded := make(map[string]int, len(records))
+	for i, r := range records {
+		exceeded[r.Key] = i
+	}
+	for _, status := range statuses {
+		i, ok := exceeded[status.Key]
+		if !ok {
+			check, err := s.Store.CheckQuota(ctx, status.Key, 0)
+			if err != nil {
+				return nil, err
+			}
+			i = len(records)
+			exceeded[status.Key] = i
+			records = append(records, store.Record{Key: status.Key, Info: check.Info})
+		}
+		// Keys may exceed several limits.
+		if r := &records[i]; len(r.Exceeded) == 0 || r.Exceeded[len(r.Exceeded)-1] != store.LimitRate {
+			r.Exceeded = append(r.Exceeded, store.LimitRate)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records, nil
+}
@@ -0,0 +1,57 @@
+package store
+
+import "time"
+
+// Audited actions.
+const (
+	AuditSetQuota         = "set_quota"
+	AuditClearQuota       = "clear_quota"
+	AuditSetObjectQuota   = "set_object_quota"
+	AuditClearObjectQuota = "clear_object_quota"
+	// Grace periods are audited in seconds.
+	AuditSetGracePeriod   = "set_grace_period"
+	AuditClearGracePeriod = "clear_grace_period"
+	// Plans are audited by their quota of bytes, with the name of the
+	// plan as detail.  Changes of plans have no key, and their detail
+	// also lists every limit of the plan that changed, e.g.
+	// "team: quota_bytes 20 -> 30, rate_limits none -> 100/1h0m0s".
+	AuditSetPlan      = "set_plan"
+	AuditDeletePlan   = "delete_plan"
+	AuditAssignPlan   = "assign_plan"
+	AuditUnassignPlan = "unassign_plan"
+	AuditSetUsage     = "set_usage"
+	AuditBlock        = "block"
+	AuditUnblock      = "unblock"
+)
+
+// AuditEntry records a change of quota, usage or enforcement of a key.
+type AuditEntry struct {
+	// ID is assigned by the store, in order of appending.
+	ID int64
+	// Time may be truncated to milliseconds.
+	Time   time.Time
+	Actor  string
+	Action string
+	Key    string
+	// OldValue and NewValue are the values before and after the
+	// change, or nil if it has none.
+	OldValue *int64
+	NewValue *int64
+	Detail   string
+}
+
+// AuditFilter selects audit entries.  Zero fields select everything.
+type AuditFilter struct {
+	Key   string
+	Since time.Time
+	// Until is exclusive.
+	Until time.Time
+	Limit int
+}
+
+// Match returns whether e matches f, ignoring f.Limit.
+func (f *AuditFilter) Match(e *AuditEntry) bool {
+	return (f.Key == "" || e.Key == f.Key) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
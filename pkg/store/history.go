@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/logging"
+)
+
+// HistoryPoint is the usage of a key recorded at some time.
+type HistoryPoint struct {
+	Time       time.Time
+	UsageBytes int64
+}
+
+// DownsampleTimes returns the times to delete to downsample history
+// recorded at times, sorted, to the last time in each interval of step, as
+// truncated by time.Time.Truncate.
+func DownsampleTimes(times []time.Time, step time.Duration) []time.Time {
+	var drop []time.Time
+	for i := 0; i+1 < len(times); i++ {
+		if times[i].Truncate(step).Equal(times[i+1].Truncate(step)) {
+			drop = append(drop, times[i])
+		}
+	}
+	return drop
+}
+
+// Resample returns the last of points, sorted by time, in each interval of
+// step, as truncated by time.Time.Truncate.  Each returned point has the
+// time of its interval.  Intervals without points are skipped.
+func Resample(points []HistoryPoint, step time.Duration) []HistoryPoint {
+	var ret []HistoryPoint
+	for _, p := range points {
+		t := p.Time.Truncate(step)
+		if len(ret) > 0 && ret[len(ret)-1].Time.Equal(t) {
+			ret[len(ret)-1].UsageBytes = p.UsageBytes
+			continue
+		}
+		ret = append(ret, HistoryPoint{Time: t, UsageBytes: p.UsageBytes})
+	}
+	return ret
+}
+
+// HistoryConfig configures recording usage history.
+type HistoryConfig struct {
+	// Interval is the interval between recordings.  Recordings are at
+	// multiples of Interval, so servers sharing a store share them.
+	Interval time.Duration
+	// Retention is the age after which history is deleted, or zero to
+	// keep it forever.
+	Retention time.Duration
+	// DownsampleAfter is the age after which history is downsampled to
+	// DownsampleStep.
+	DownsampleAfter time.Duration
+	// DownsampleStep is the interval of downsampled history, or zero
+	// not to downsample.
+	DownsampleStep time.Duration
+}
+
+// RecordHistoryEvery records the usage history of all keys on s, deletes
+// and downsamples old history according to cfg, until ctx is cancelled.
+func RecordHistoryEvery(ctx context.Context, l logging.Logger, s Store, cfg HistoryConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			at := now.Truncate(cfg.Interval)
+			n, err := s.RecordHistory(ctx, at)
+			if err != nil {
+				l.WithError(err).Error("Record usage history")
+			} else {
+				l.WithField("count", n).Debug("Recorded usage history")
+			}
+			if cfg.Retention > 0 {
+				if _, err := s.DeleteHistory(ctx, now.Add(-cfg.Retention)); err != nil {
+					l.WithError(err).Error("Delete old usage history")
+				}
+			}
+			if cfg.DownsampleStep > 0 {
+				if _, err := s.DownsampleHistory(ctx, now.Add(-cfg.DownsampleAfter), cfg.DownsampleStep); err != nil {
+					l.WithError(err).Error("Downsample usage history")
+				}
+			}
+		}
+	}
+}
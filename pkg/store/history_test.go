@@ -0,0 +1,41 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+var base = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func minute(m int) time.Time {
+	return base.Add(time.Duration(m) * time.Minute)
+}
+
+func TestDownsampleTimes(t *testing.T) {
+	times := []time.Time{minute(0), minute(10), minute(59), minute(60), minute(130), minute(150)}
+	expected := []time.Time{minute(0), minute(10), minute(130)}
+	if diffs := deep.Equal(store.DownsampleTimes(times, time.Hour), expected); diffs != nil {
+		t.Errorf("Unexpected times to drop: %s", diffs)
+	}
+}
+
+func TestResample(t *testing.T) {
+	points := []store.HistoryPoint{
+		{Time: minute(5), UsageBytes: 1},
+		{Time: minute(25), UsageBytes: 2},
+		{Time: minute(70), UsageBytes: 3},
+		{Time: minute(190), UsageBytes: 4},
+	}
+	expected := []store.HistoryPoint{
+		{Time: minute(0), UsageBytes: 2},
+		{Time: minute(60), UsageBytes: 3},
+		{Time: minute(180), UsageBytes: 4},
+	}
+	if diffs := deep.Equal(store.Resample(points, time.Hour), expected); diffs != nil {
+		t.Errorf("Unexpected resampled points: %s", diffs)
+	}
+}
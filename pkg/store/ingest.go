@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/logging"
+)
+
+// IngestBucket is the granularity of ingest counters.  Bytes ingested at a
+// time count at its start.
+const IngestBucket = 5 * time.Minute
+
+// Ingest is the number of bytes ingested by a key over a period.
+type Ingest struct {
+	Key         string
+	IngestBytes int64
+}
+
+// IngestPeriod returns the period [from, to) of ingest counters that
+// covers the window ending at now, including its partial first bucket.
+func IngestPeriod(now time.Time, window time.Duration) (from, to time.Time) {
+	return now.Add(-window).Truncate(IngestBucket), now.Truncate(IngestBucket).Add(IngestBucket)
+}
+
+// DeleteIngestEvery deletes ingest counters older than after on s every
+// interval, until ctx is cancelled.
+func DeleteIngestEvery(ctx context.Context, l logging.Logger, s Store, interval, after time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := s.DeleteIngest(ctx, now.Add(-after))
+			if err != nil {
+				l.WithError(err).Error("Delete ingest counters")
+				continue
+			}
+			if n > 0 {
+				l.WithField("count", n).Debug("Deleted ingest counters")
+			}
+		}
+	}
+}
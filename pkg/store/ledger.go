@@ -0,0 +1,36 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/logging"
+)
+
+// UsageIntegral is the integral of the usage of a key over a period.
+type UsageIntegral struct {
+	Key       string
+	ByteHours float64
+}
+
+// CompactLedgerEvery compacts changes older than after on the ledger of s
+// every interval, until ctx is cancelled.
+func CompactLedgerEvery(ctx context.Context, l logging.Logger, s Store, interval, after time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := s.CompactLedger(ctx, now.Add(-after))
+			if err != nil {
+				l.WithError(err).Error("Compact usage ledger")
+				continue
+			}
+			if n > 0 {
+				l.WithField("count", n).Debug("Compacted usage ledger")
+			}
+		}
+	}
+}
@@ -0,0 +1,885 @@
+// Package memory provides a store.Store that keeps data in memory,
+// optionally persisting it to snapshot files.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+// Entry holds usage and quota of a single key.
+type Entry struct {
+	SizeBytes int64 `json:"size_bytes"`
+	// QuotaBytes is the quota of this key, or nil to use the default
+	// quota.
+	QuotaBytes *int64 `json:"quota_bytes,omitempty"`
+	// ClassBytes is the usage of this key by storage class.
+	ClassBytes map[string]int64 `json:"class_bytes,omitempty"`
+	// ObjectCount is the number of objects of this key.
+	ObjectCount int64 `json:"object_count,omitempty"`
+	// QuotaObjects is the quota of objects of this key, or nil to use
+	// the default quota of objects.
+	QuotaObjects *int64 `json:"quota_objects,omitempty"`
+	// GracePeriod is the grace period of this key, or nil to use the
+	// default grace period.
+	GracePeriod *time.Duration `json:"grace_period,omitempty"`
+	// ExceededSince is when this key started to exceed quota, or nil if
+	// it does not.
+	ExceededSince *time.Time `json:"exceeded_since,omitempty"`
+	// Plan is the name of the plan of this key, or empty if it has
+	// none.
+	Plan string `json:"plan,omitempty"`
+	// Blocked is true if enforcement blocks this key.
+	Blocked bool `json:"blocked,omitempty"`
+}
+
+// Plan holds the limits of a plan.  Plans are immutable once stored.
+type Plan struct {
+	QuotaBytes   *int64      `json:"quota_bytes,omitempty"`
+	QuotaObjects *int64      `json:"quota_objects,omitempty"`
+	RateLimits   []RateLimit `json:"rate_limits,omitempty"`
+}
+
+// RateLimit is a rate quota of a plan.
+type RateLimit struct {
+	Window time.Duration `json:"window"`
+	Bytes  int64         `json:"bytes"`
+}
+
+// Object is an object counted toward the usage of a key.
+type Object struct {
+	Key          string `json:"key"`
+	Path         string `json:"path"`
+	VersionID    string `json:"version_id,omitempty"`
+	SizeBytes    int64  `json:"size_bytes"`
+	StorageClass string `json:"storage_class,omitempty"`
+}
+
+// IngestCounter is the number of bytes ingested by a key in the
+// store.IngestBucket that starts at Time.
+type IngestCounter struct {
+	Key         string    `json:"key"`
+	Time        time.Time `json:"time"`
+	IngestBytes int64     `json:"ingest_bytes"`
+}
+
+// ingestID identifies the counter of the bucket of a key that starts at
+// Unix milliseconds bucket.
+type ingestID struct {
+	key    string
+	bucket int64
+}
+
+// objectID identifies a version of an object.
+type objectID struct {
+	path      string
+	versionID string
+}
+
+// Reservation holds a reservation of quota for a key.
+type Reservation struct {
+	Key       string    `json:"key"`
+	Path      string    `json:"path"`
+	SizeBytes int64     `json:"size_bytes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AuditEntry is an entry of the audit log.
+type AuditEntry struct {
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Key      string    `json:"key"`
+	OldValue *int64    `json:"old_value,omitempty"`
+	NewValue *int64    `json:"new_value,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// HistorySnapshot holds the usage of all keys recorded at a time.
+type HistorySnapshot struct {
+	Time  time.Time        `json:"time"`
+	Usage map[string]int64 `json:"usage"`
+}
+
+// LedgerEntry is a change of the usage of a key.
+type LedgerEntry struct {
+	Key        string    `json:"key"`
+	Time       time.Time `json:"time"`
+	DeltaBytes int64     `json:"delta_bytes"`
+}
+
+// Store is a Store that keeps data in memory.  It is safe for concurrent
+// use.
+type Store struct {
+	DefaultQuotaBytes int64
+	// DefaultQuotaObjects is the quota of objects of keys without one,
+	// or 0 for none.
+	DefaultQuotaObjects int64
+
+	mu           sync.Mutex
+	entries      map[string]*Entry
+	plans        map[string]Plan
+	reservations map[string]Reservation
+	objects      map[objectID]Object
+	ingest       map[ingestID]int64
+	audit        []AuditEntry
+	// history is sorted by time.  Its snapshots are immutable once
+	// recorded.
+	history []HistorySnapshot
+	// ledger is in order of appending, not of time.
+	ledger []LedgerEntry
+}
+
+// NewStore returns an empty Store.
+func NewStore(defaultQuotaBytes int64) *Store {
+	return &Store{
+		DefaultQuotaBytes: defaultQuotaBytes,
+		entries:           make(map[string]*Entry),
+		plans:             make(map[string]Plan),
+		reservations:      make(map[string]Reservation),
+		objects:           make(map[objectID]Object),
+		ingest:            make(map[ingestID]int64),
+	}
+}
+
+// quota returns the quota of e.  s.mu must be held.
+func (s *Store) quota(e *Entry) int64 {
+	if e.QuotaBytes != nil {
+		return *e.QuotaBytes
+	}
+	if p, ok := s.plans[e.Plan]; ok && p.QuotaBytes != nil {
+		return *p.QuotaBytes
+	}
+	return s.DefaultQuotaBytes
+}
+
+// quotaObjects returns the quota of objects of e.  s.mu must be held.
+func (s *Store) quotaObjects(e *Entry) int64 {
+	if e.QuotaObjects != nil {
+		return *e.QuotaObjects
+	}
+	if p, ok := s.plans[e.Plan]; ok && p.QuotaObjects != nil {
+		return *p.QuotaObjects
+	}
+	return s.DefaultQuotaObjects
+}
+
+// info returns the Info of e.  s.mu must be held.
+func (s *Store) info(e *Entry) store.Info {
+	return store.Info{
+		UsageBytes:   e.SizeBytes,
+		QuotaBytes:   s.quota(e),
+		ObjectCount:  e.ObjectCount,
+		QuotaObjects: s.quotaObjects(e),
+		Plan:         e.Plan,
+	}
+}
+
+// getOrCreate returns the entry for key, creating it if needed.  s.mu must
+// be held.
+func (s *Store) getOrCreate(key string) *Entry {
+	e, ok := s.entries[key]
+	if !ok {
+		e = &Entry{}
+		s.entries[key] = e
+	}
+	return e
+}
+
+// reserved returns the number of bytes reserved for key by reservations
+// that have not expired by now.  s.mu must be held.
+func (s *Store) reserved(key string, now time.Time) int64 {
+	var sum int64
+	for _, r := range s.reservations {
+		if r.Key == key && r.ExpiresAt.After(now) {
+			sum += r.SizeBytes
+		}
+	}
+	return sum
+}
+
+// quotaCheck returns the QuotaCheck for growing key by numBytes at now.
+// s.mu must be held.
+func (s *Store) quotaCheck(key string, numBytes int64, now time.Time) store.QuotaCheck {
+	info := store.Info{QuotaBytes: s.DefaultQuotaBytes, QuotaObjects: s.DefaultQuotaObjects}
+	if e, ok := s.entries[key]; ok {
+		info = s.info(e)
+	}
+	return store.NewQuotaCheck(info, s.reserved(key, now), numBytes)
+}
+
+// checkQuota returns ErrQuotaExceeded if e exceeds its quota of bytes or of
+// objects.  s.mu must be held.
+func (s *Store) checkQuota(e *Entry) error {
+	if len(s.info(e).Exceeded()) > 0 {
+		return store.ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (s *Store) Get(_ context.Context, key string) (store.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return store.Value{}, fmt.Errorf("%s: %w", key, store.ErrNotFound)
+	}
+	return store.Value{SizeBytes: e.SizeBytes}, nil
+}
+
+func (s *Store) Set(_ context.Context, key string, value store.Value) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.getOrCreate(key)
+	s.appendLedger(key, value.SizeBytes-e.SizeBytes, time.Now())
+	e.SizeBytes = value.SizeBytes
+	return s.checkQuota(e)
+}
+
+func (s *Store) AddSizeBytes(_ context.Context, key string, numBytes int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.getOrCreate(key)
+	s.addSizeBytes(key, e, "", numBytes, at)
+	return s.checkQuota(e)
+}
+
+func (s *Store) AddClassSizeBytes(_ context.Context, key, storageClass string, numBytes int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.getOrCreate(key)
+	s.addSizeBytes(key, e, storageClass, numBytes, at)
+	return s.checkQuota(e)
+}
+
+// addSizeBytes adds numBytes to the usage of key in e, and to its usage
+// in storageClass unless that is empty.  s.mu must be held.
+func (s *Store) addSizeBytes(key string, e *Entry, storageClass string, numBytes int64, at time.Time) {
+	s.appendLedger(key, numBytes, at)
+	e.SizeBytes += numBytes
+	addClassBytes(e, storageClass, numBytes)
+}
+
+// addClassBytes adds numBytes to the usage of e in storageClass, unless
+// that is empty.  s.mu must be held.
+func addClassBytes(e *Entry, storageClass string, numBytes int64) {
+	if storageClass == "" {
+		return
+	}
+	if e.ClassBytes == nil {
+		e.ClassBytes = make(map[string]int64)
+	}
+	e.ClassBytes[storageClass] += numBytes
+	if e.ClassBytes[storageClass] == 0 {
+		delete(e.ClassBytes, storageClass)
+	}
+}
+
+func (s *Store) PutObject(_ context.Context, key string, o store.Object, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := objectID{path: o.Path, versionID: o.VersionID}
+	if old, ok := s.objects[id]; ok {
+		s.removeObject(old, at)
+	}
+	e := s.getOrCreate(key)
+	s.addSizeBytes(key, e, o.StorageClass, o.SizeBytes, at)
+	e.ObjectCount++
+	if o.SizeBytes > 0 {
+		s.ingest[ingestID{key: key, bucket: at.Truncate(store.IngestBucket).UnixMilli()}] += o.SizeBytes
+	}
+	s.objects[id] = Object{
+		Key:          key,
+		Path:         o.Path,
+		VersionID:    o.VersionID,
+		SizeBytes:    o.SizeBytes,
+		StorageClass: o.StorageClass,
+	}
+	return s.checkQuota(e)
+}
+
+func (s *Store) DeleteObject(_ context.Context, path, versionID string, at time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := objectID{path: path, versionID: versionID}
+	o, ok := s.objects[id]
+	if !ok {
+		return "", fmt.Errorf("object version %q: %w", versionID, store.ErrNotFound)
+	}
+	delete(s.objects, id)
+	return o.Key, s.checkQuota(s.removeObject(o, at))
+}
+
+func (s *Store) TransitionObject(_ context.Context, path, versionID, storageClass string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := objectID{path: path, versionID: versionID}
+	o, ok := s.objects[id]
+	if !ok {
+		return "", fmt.Errorf("object version %q: %w", versionID, store.ErrNotFound)
+	}
+	e := s.getOrCreate(o.Key)
+	addClassBytes(e, o.StorageClass, -o.SizeBytes)
+	addClassBytes(e, storageClass, o.SizeBytes)
+	o.StorageClass = storageClass
+	s.objects[id] = o
+	return o.Key, s.checkQuota(e)
+}
+
+// removeObject removes o from the usage and objects of its key, and
+// returns the entry of that key.  s.mu must be held.
+func (s *Store) removeObject(o Object, at time.Time) *Entry {
+	e := s.getOrCreate(o.Key)
+	s.addSizeBytes(o.Key, e, o.StorageClass, -o.SizeBytes, at)
+	e.ObjectCount--
+	return e
+}
+
+func (s *Store) GetClassUsage(_ context.Context, key string) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := make(map[string]int64)
+	if e, ok := s.entries[key]; ok {
+		for class, sizeBytes := range e.ClassBytes {
+			usage[class] = sizeBytes
+		}
+	}
+	return usage, nil
+}
+
+func (s *Store) SetQuota(_ context.Context, key string, quotaBytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.getOrCreate(key)
+	e.QuotaBytes = &quotaBytes
+	return nil
+}
+
+func (s *Store) ClearQuota(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		e.QuotaBytes = nil
+	}
+	return nil
+}
+
+func (s *Store) SetObjectQuota(_ context.Context, key string, quotaObjects int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.getOrCreate(key)
+	e.QuotaObjects = &quotaObjects
+	return nil
+}
+
+func (s *Store) ClearObjectQuota(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		e.QuotaObjects = nil
+	}
+	return nil
+}
+
+func (s *Store) SetGracePeriod(_ context.Context, key string, gracePeriod time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.getOrCreate(key)
+	e.GracePeriod = &gracePeriod
+	return nil
+}
+
+func (s *Store) ClearGracePeriod(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		e.GracePeriod = nil
+	}
+	return nil
+}
+
+func (s *Store) GetGracePeriod(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || e.GracePeriod == nil {
+		return 0, store.ErrNotFound
+	}
+	return *e.GracePeriod, nil
+}
+
+func (s *Store) MarkExceeded(_ context.Context, key string, exceeded bool, at time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.markExceeded(key, exceeded, at), nil
+}
+
+// markExceeded records whether key exceeds quota at at, and returns since
+// when.  s.mu must be held.
+func (s *Store) markExceeded(key string, exceeded bool, at time.Time) time.Time {
+	if !exceeded {
+		if e, ok := s.entries[key]; ok {
+			e.ExceededSince = nil
+		}
+		return time.Time{}
+	}
+	e := s.getOrCreate(key)
+	if e.ExceededSince == nil {
+		e.ExceededSince = &at
+	}
+	return *e.ExceededSince
+}
+
+func (s *Store) GetExceededSince(_ context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || e.ExceededSince == nil {
+		return time.Time{}, store.ErrNotFound
+	}
+	return *e.ExceededSince, nil
+}
+
+func (s *Store) MarkAllExceeded(_ context.Context, keys []string, at time.Time) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since := make(map[string]time.Time, len(keys))
+	for _, key := range keys {
+		since[key] = s.markExceeded(key, true, at)
+	}
+	for key, e := range s.entries {
+		if _, ok := since[key]; !ok {
+			e.ExceededSince = nil
+		}
+	}
+	return since, nil
+}
+
+// copyInt64 returns a copy of p, or nil if it is nil.
+func copyInt64(p *int64) *int64 {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	return &c
+}
+
+func (s *Store) SetPlan(_ context.Context, p store.Plan) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	plan := Plan{QuotaBytes: copyInt64(p.QuotaBytes), QuotaObjects: copyInt64(p.QuotaObjects)}
+	for _, l := range p.RateLimits {
+		plan.RateLimits = append(plan.RateLimits, RateLimit{Window: l.Window, Bytes: l.Bytes})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.plans[p.Name] = plan
+	return nil
+}
+
+// storePlan returns the store.Plan name with p.
+func storePlan(name string, p Plan) store.Plan {
+	plan := store.Plan{Name: name, QuotaBytes: copyInt64(p.QuotaBytes), QuotaObjects: copyInt64(p.QuotaObjects)}
+	for _, l := range p.RateLimits {
+		plan.RateLimits = append(plan.RateLimits, store.RateLimit{Window: l.Window, Bytes: l.Bytes})
+	}
+	return plan
+}
+
+func (s *Store) GetPlan(_ context.Context, name string) (store.Plan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.plans[name]
+	if !ok {
+		return store.Plan{}, fmt.Errorf("plan %s: %w", name, store.ErrNotFound)
+	}
+	return storePlan(name, p), nil
+}
+
+func (s *Store) ListPlans(_ context.Context) ([]store.Plan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plans := make([]store.Plan, 0, len(s.plans))
+	for name, p := range s.plans {
+		plans = append(plans, storePlan(name, p))
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Name < plans[j].Name })
+	return plans, nil
+}
+
+func (s *Store) DeletePlan(_ context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.plans[name]; !ok {
+		return fmt.Errorf("plan %s: %w", name, store.ErrNotFound)
+	}
+	delete(s.plans, name)
+	for _, e := range s.entries {
+		if e.Plan == name {
+			e.Plan = ""
+		}
+	}
+	return nil
+}
+
+func (s *Store) AssignPlan(_ context.Context, key, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.plans[name]; !ok {
+		return fmt.Errorf("plan %s: %w", name, store.ErrNotFound)
+	}
+	s.getOrCreate(key).Plan = name
+	return nil
+}
+
+func (s *Store) UnassignPlan(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		e.Plan = ""
+	}
+	return nil
+}
+
+func (s *Store) SetBlocked(_ context.Context, key string, blocked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if blocked {
+		s.getOrCreate(key).Blocked = true
+	} else if e, ok := s.entries[key]; ok {
+		e.Blocked = false
+	}
+	return nil
+}
+
+func (s *Store) ListBlocked(_ context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key, e := range s.entries {
+		if e.Blocked {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *Store) CheckQuota(_ context.Context, key string, numBytes int64) (store.QuotaCheck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quotaCheck(key, numBytes, time.Now()), nil
+}
+
+func (s *Store) Reserve(_ context.Context, r store.Reservation) (store.Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getOrCreate(r.Key)
+	if !s.quotaCheck(r.Key, r.SizeBytes, time.Now()).Allowed {
+		return store.Reservation{}, store.ErrQuotaExceeded
+	}
+	if r.ID == "" {
+		r.ID = store.NewReservationID()
+	}
+	s.reservations[r.ID] = Reservation{Key: r.Key, Path: r.Path, SizeBytes: r.SizeBytes, ExpiresAt: r.ExpiresAt}
+	return r, nil
+}
+
+func (s *Store) ReleaseReservation(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.reservations[id]; !ok {
+		return fmt.Errorf("reservation %s: %w", id, store.ErrNotFound)
+	}
+	delete(s.reservations, id)
+	return nil
+}
+
+func (s *Store) ReleaseReservations(_ context.Context, key, path string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, r := range s.reservations {
+		if r.Key == key && r.Path == path {
+			delete(s.reservations, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Store) SweepReservations(_ context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, r := range s.reservations {
+		if !r.ExpiresAt.After(now) {
+			delete(s.reservations, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Store) List(_ context.Context, after string, limit int) ([]store.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		if key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	records := make([]store.Record, 0, len(keys))
+	for _, key := range keys {
+		e := s.entries[key]
+		records = append(records, store.Record{Key: key, Info: s.info(e)})
+	}
+	return records, nil
+}
+
+func (s *Store) GetExceeded(_ context.Context) ([]store.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []store.Record
+	for key, e := range s.entries {
+		info := s.info(e)
+		if exceeded := info.Exceeded(); len(exceeded) > 0 {
+			records = append(records, store.Record{Key: key, Info: info, Exceeded: exceeded})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records, nil
+}
+
+func (s *Store) AppendAudit(_ context.Context, e store.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var id int64 = 1
+	if len(s.audit) > 0 {
+		id = s.audit[len(s.audit)-1].ID + 1
+	}
+	s.audit = append(s.audit, AuditEntry{
+		ID:       id,
+		Time:     e.Time,
+		Actor:    e.Actor,
+		Action:   e.Action,
+		Key:      e.Key,
+		OldValue: e.OldValue,
+		NewValue: e.NewValue,
+		Detail:   e.Detail,
+	})
+	return nil
+}
+
+func (s *Store) ListAudit(_ context.Context, f store.AuditFilter) ([]store.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []store.AuditEntry
+	for _, a := range s.audit {
+		e := store.AuditEntry{
+			ID:       a.ID,
+			Time:     a.Time,
+			Actor:    a.Actor,
+			Action:   a.Action,
+			Key:      a.Key,
+			OldValue: a.OldValue,
+			NewValue: a.NewValue,
+			Detail:   a.Detail,
+		}
+		if !f.Match(&e) {
+			continue
+		}
+		entries = append(entries, e)
+		if f.Limit > 0 && len(entries) >= f.Limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// historyIndex returns the index of the first history snapshot recorded
+// at or after t.  s.mu must be held.
+func (s *Store) historyIndex(t time.Time) int {
+	return sort.Search(len(s.history), func(i int) bool { return !s.history[i].Time.Before(t) })
+}
+
+func (s *Store) RecordHistory(_ context.Context, at time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := HistorySnapshot{Time: at, Usage: make(map[string]int64, len(s.entries))}
+	for key, e := range s.entries {
+		snap.Usage[key] = e.SizeBytes
+	}
+	i := s.historyIndex(at)
+	if i < len(s.history) && s.history[i].Time.Equal(at) {
+		s.history[i] = snap
+	} else {
+		s.history = append(s.history, HistorySnapshot{})
+		copy(s.history[i+1:], s.history[i:])
+		s.history[i] = snap
+	}
+	return len(snap.Usage), nil
+}
+
+func (s *Store) GetHistory(_ context.Context, key string, from, to time.Time) ([]store.HistoryPoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var points []store.HistoryPoint
+	for _, snap := range s.history[s.historyIndex(from):] {
+		if !snap.Time.Before(to) {
+			break
+		}
+		if usage, ok := snap.Usage[key]; ok {
+			points = append(points, store.HistoryPoint{Time: snap.Time, UsageBytes: usage})
+		}
+	}
+	return points, nil
+}
+
+func (s *Store) DeleteHistory(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.historyIndex(before)
+	n := 0
+	for _, snap := range s.history[:i] {
+		n += len(snap.Usage)
+	}
+	s.history = append([]HistorySnapshot(nil), s.history[i:]...)
+	return n, nil
+}
+
+func (s *Store) DownsampleHistory(_ context.Context, before time.Time, step time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	times := make([]time.Time, 0, s.historyIndex(before))
+	for _, snap := range s.history[:cap(times)] {
+		times = append(times, snap.Time)
+	}
+	drop := store.DownsampleTimes(times, step)
+	kept := s.history[:0]
+	n := 0
+	for _, snap := range s.history {
+		if len(drop) > 0 && snap.Time.Equal(drop[0]) {
+			drop = drop[1:]
+			n += len(snap.Usage)
+			continue
+		}
+		kept = append(kept, snap)
+	}
+	s.history = kept
+	return n, nil
+}
+
+// appendLedger appends a change of deltaBytes to key at to the ledger.
+// s.mu must be held.
+func (s *Store) appendLedger(key string, deltaBytes int64, at time.Time) {
+	if deltaBytes != 0 {
+		s.ledger = append(s.ledger, LedgerEntry{Key: key, Time: at, DeltaBytes: deltaBytes})
+	}
+}
+
+func (s *Store) ByteHours(_ context.Context, from, to time.Time) ([]store.UsageIntegral, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A change at t contributes to usage from t (or from) until to.
+	byteHours := make(map[string]float64)
+	for _, e := range s.ledger {
+		if !e.Time.Before(to) {
+			continue
+		}
+		start := from
+		if e.Time.After(from) {
+			start = e.Time
+		}
+		byteHours[e.Key] += float64(e.DeltaBytes) * to.Sub(start).Hours()
+	}
+	var integrals []store.UsageIntegral
+	for key, b := range byteHours {
+		if b != 0 {
+			integrals = append(integrals, store.UsageIntegral{Key: key, ByteHours: b})
+		}
+	}
+	sort.Slice(integrals, func(i, j int) bool { return integrals[i].Key < integrals[j].Key })
+	return integrals, nil
+}
+
+func (s *Store) CompactLedger(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sums := make(map[string]int64)
+	ledger := make([]LedgerEntry, 0, len(s.ledger))
+	for _, e := range s.ledger {
+		if e.Time.Before(before) {
+			sums[e.Key] += e.DeltaBytes
+			continue
+		}
+		ledger = append(ledger, e)
+	}
+	for key, sum := range sums {
+		if sum != 0 {
+			ledger = append(ledger, LedgerEntry{Key: key, Time: before, DeltaBytes: sum})
+		}
+	}
+	n := len(s.ledger) - len(ledger)
+	s.ledger = ledger
+	return n, nil
+}
+
+// inPeriod returns whether the bucket of id starts in [from, to).
+func (id ingestID) inPeriod(from, to time.Time) bool {
+	return id.bucket >= from.UnixMilli() && id.bucket < to.UnixMilli()
+}
+
+func (s *Store) GetIngest(_ context.Context, key string, from, to time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sum int64
+	for id, ingestBytes := range s.ingest {
+		if id.key == key && id.inPeriod(from, to) {
+			sum += ingestBytes
+		}
+	}
+	return sum, nil
+}
+
+func (s *Store) ListIngest(_ context.Context, from, to time.Time) ([]store.Ingest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sums := make(map[string]int64)
+	for id, ingestBytes := range s.ingest {
+		if id.inPeriod(from, to) {
+			sums[id.key] += ingestBytes
+		}
+	}
+	var ingest []store.Ingest
+	for key, sum := range sums {
+		ingest = append(ingest, store.Ingest{Key: key, IngestBytes: sum})
+	}
+	sort.Slice(ingest, func(i, j int) bool { return ingest[i].Key < ingest[j].Key })
+	return ingest, nil
+}
+
+func (s *Store) DeleteIngest(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id := range s.ingest {
+		if id.bucket < before.UnixMilli() {
+			delete(s.ingest, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,118 @@
+package memory_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/memory"
+	"github.com/treeverse/terminus/pkg/store/storetest"
+)
+
+const defaultQuota = 50
+
+func TestMemory(t *testing.T) {
+	storetest.Run(t, func(_ *testing.T, defaultQuotaBytes int64) store.Store {
+		return memory.NewStore(defaultQuotaBytes)
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStore(defaultQuota)
+	if err := s.Set(ctx, "a", store.Value{SizeBytes: 17}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if err := s.SetQuota(ctx, "b", 3); err != nil {
+		t.Fatalf("SetQuota: %s", err)
+	}
+	if err := s.SetObjectQuota(ctx, "c", 4); err != nil {
+		t.Fatalf("SetObjectQuota: %s", err)
+	}
+	quotaBytes := int64(30)
+	plan := store.Plan{Name: "team", QuotaBytes: &quotaBytes, RateLimits: []store.RateLimit{{Window: time.Hour, Bytes: 7}}}
+	if err := s.SetPlan(ctx, plan); err != nil {
+		t.Fatalf("SetPlan: %s", err)
+	}
+	if err := s.AssignPlan(ctx, "a", "team"); err != nil {
+		t.Fatalf("AssignPlan: %s", err)
+	}
+	if err := s.PutObject(ctx, "c", store.Object{Path: "s3://bucket/c", VersionID: "v1", SizeBytes: 2}, time.Now()); err != nil {
+		t.Fatalf("PutObject: %s", err)
+	}
+	// Round-trip through JSON keeps only wall-clock times.
+	expiresAt := time.Now().Add(time.Hour).Round(0)
+	if _, err := s.Reserve(ctx, store.Reservation{Key: "a", Path: "s3://bucket/a", SizeBytes: 5, ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("Reserve: %s", err)
+	}
+	if _, err := s.RecordHistory(ctx, expiresAt); err != nil {
+		t.Fatalf("RecordHistory: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := s.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile: %s", err)
+	}
+
+	restored := memory.NewStore(defaultQuota)
+	if err := restored.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile: %s", err)
+	}
+	if diffs := deep.Equal(restored.Snapshot().Entries, s.Snapshot().Entries); diffs != nil {
+		t.Errorf("Restored snapshot differs: %s", diffs)
+	}
+	if p, err := restored.GetPlan(ctx, "team"); err != nil {
+		t.Errorf("GetPlan restored: %s", err)
+	} else if diffs := deep.Equal(p, plan); diffs != nil {
+		t.Errorf("Restored plan differs: %s", diffs)
+	}
+	if diffs := deep.Equal(restored.Snapshot().Reservations, s.Snapshot().Reservations); diffs != nil {
+		t.Errorf("Restored reservations differ: %s", diffs)
+	}
+	if diffs := deep.Equal(restored.Snapshot().History, s.Snapshot().History); diffs != nil {
+		t.Errorf("Restored history differs: %s", diffs)
+	}
+	if diffs := deep.Equal(restored.Snapshot().Ledger, s.Snapshot().Ledger); diffs != nil {
+		t.Errorf("Restored ledger differs: %s", diffs)
+	}
+	if diffs := deep.Equal(restored.Snapshot().Objects, s.Snapshot().Objects); diffs != nil {
+		t.Errorf("Restored objects differ: %s", diffs)
+	}
+	if diffs := deep.Equal(restored.Snapshot().Ingest, s.Snapshot().Ingest); diffs != nil || len(s.Snapshot().Ingest) != 1 {
+		t.Errorf("Restored ingest differs: %s", diffs)
+	}
+	if key, err := restored.DeleteObject(ctx, "s3://bucket/c", "v1", time.Now()); err != nil || key != "c" {
+		t.Errorf("DeleteObject restored object: got key %q, %v", key, err)
+	}
+
+	if err := restored.LoadFile(path + ".missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadFile missing file: expected not exist, got %v", err)
+	}
+	if err := restored.Load(bytes.NewBufferString(`{"version": 999}`)); !errors.Is(err, memory.ErrBadSnapshot) {
+		t.Errorf("Load bad version: expected %s, got %v", memory.ErrBadSnapshot, err)
+	}
+}
+
+func TestRestoreWithoutLedger(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStore(defaultQuota)
+	if err := s.Load(bytes.NewBufferString(`{"version": 1, "entries": {"a": {"size_bytes": 10}, "b": {"quota_bytes": 3}}}`)); err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	integrals, err := s.ByteHours(ctx, from, from.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("ByteHours: %s", err)
+	}
+	expected := []store.UsageIntegral{{Key: "a", ByteHours: 20}}
+	if diffs := deep.Equal(integrals, expected); diffs != nil {
+		t.Errorf("ByteHours: %s", diffs)
+	}
+}
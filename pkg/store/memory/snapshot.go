@@ -0,0 +1,247 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/logging"
+)
+
+// SnapshotVersion is the version of snapshots written by this package.
+const SnapshotVersion = 1
+
+var ErrBadSnapshot = errors.New("bad snapshot")
+
+// Snapshot is a point-in-time copy of the contents of a Store.
+type Snapshot struct {
+	Version int              `json:"version"`
+	Time    time.Time        `json:"time"`
+	Entries map[string]Entry `json:"entries"`
+	// Plans maps names of plans to their limits.
+	Plans map[string]Plan `json:"plans,omitempty"`
+	// Reservations maps reservation IDs to reservations.
+	Reservations map[string]Reservation `json:"reservations,omitempty"`
+	// Audit is the audit log, in order of appending.
+	Audit []AuditEntry `json:"audit,omitempty"`
+	// History is the usage history, in order of time.
+	History []HistorySnapshot `json:"history,omitempty"`
+	// Ledger holds changes of usage.  Snapshots without a ledger start
+	// one with the usage of every key at the Unix epoch.
+	Ledger []LedgerEntry `json:"ledger,omitempty"`
+	// Objects are the objects counted toward the usage of keys.
+	Objects []Object `json:"objects,omitempty"`
+	// Ingest holds ingest counters, in order of key and time.
+	Ingest []IngestCounter `json:"ingest,omitempty"`
+}
+
+// Snapshot returns a copy of the contents of s.
+func (s *Store) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := &Snapshot{
+		Version: SnapshotVersion,
+		Time:    time.Now(),
+		Entries: make(map[string]Entry, len(s.entries)),
+	}
+	for key, e := range s.entries {
+		c := *e
+		if e.QuotaBytes != nil {
+			q := *e.QuotaBytes
+			c.QuotaBytes = &q
+		}
+		if e.QuotaObjects != nil {
+			q := *e.QuotaObjects
+			c.QuotaObjects = &q
+		}
+		if e.GracePeriod != nil {
+			g := *e.GracePeriod
+			c.GracePeriod = &g
+		}
+		if e.ExceededSince != nil {
+			t := *e.ExceededSince
+			c.ExceededSince = &t
+		}
+		c.ClassBytes = copyClassBytes(e.ClassBytes)
+		snap.Entries[key] = c
+	}
+	if len(s.plans) > 0 {
+		snap.Plans = make(map[string]Plan, len(s.plans))
+		for name, p := range s.plans {
+			snap.Plans[name] = p.copy()
+		}
+	}
+	if len(s.reservations) > 0 {
+		snap.Reservations = make(map[string]Reservation, len(s.reservations))
+		for id, r := range s.reservations {
+			snap.Reservations[id] = r
+		}
+	}
+	for _, o := range s.objects {
+		snap.Objects = append(snap.Objects, o)
+	}
+	sort.Slice(snap.Objects, func(i, j int) bool {
+		return snap.Objects[i].Path < snap.Objects[j].Path ||
+			snap.Objects[i].Path == snap.Objects[j].Path && snap.Objects[i].VersionID < snap.Objects[j].VersionID
+	})
+	for id, ingestBytes := range s.ingest {
+		snap.Ingest = append(snap.Ingest, IngestCounter{Key: id.key, Time: time.UnixMilli(id.bucket), IngestBytes: ingestBytes})
+	}
+	sort.Slice(snap.Ingest, func(i, j int) bool {
+		return snap.Ingest[i].Key < snap.Ingest[j].Key ||
+			snap.Ingest[i].Key == snap.Ingest[j].Key && snap.Ingest[i].Time.Before(snap.Ingest[j].Time)
+	})
+	// Entries are immutable once appended.
+	snap.Audit = append([]AuditEntry(nil), s.audit...)
+	snap.History = append([]HistorySnapshot(nil), s.history...)
+	snap.Ledger = append([]LedgerEntry(nil), s.ledger...)
+	return snap
+}
+
+// copyClassBytes returns a copy of classBytes, or nil if it is empty.
+func copyClassBytes(classBytes map[string]int64) map[string]int64 {
+	if len(classBytes) == 0 {
+		return nil
+	}
+	c := make(map[string]int64, len(classBytes))
+	for class, sizeBytes := range classBytes {
+		c[class] = sizeBytes
+	}
+	return c
+}
+
+// copy returns a deep copy of p.
+func (p Plan) copy() Plan {
+	return Plan{
+		QuotaBytes:   copyInt64(p.QuotaBytes),
+		QuotaObjects: copyInt64(p.QuotaObjects),
+		RateLimits:   append([]RateLimit(nil), p.RateLimits...),
+	}
+}
+
+// Restore replaces the contents of s with those of snap.
+func (s *Store) Restore(snap *Snapshot) error {
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("version %d not %d: %w", snap.Version, SnapshotVersion, ErrBadSnapshot)
+	}
+	entries := make(map[string]*Entry, len(snap.Entries))
+	for key, e := range snap.Entries {
+		c := e
+		c.ClassBytes = copyClassBytes(e.ClassBytes)
+		entries[key] = &c
+	}
+	ledger := append([]LedgerEntry(nil), snap.Ledger...)
+	if ledger == nil {
+		for key, e := range snap.Entries {
+			if e.SizeBytes != 0 {
+				ledger = append(ledger, LedgerEntry{Key: key, Time: time.Unix(0, 0), DeltaBytes: e.SizeBytes})
+			}
+		}
+	}
+	plans := make(map[string]Plan, len(snap.Plans))
+	for name, p := range snap.Plans {
+		plans[name] = p.copy()
+	}
+	reservations := make(map[string]Reservation, len(snap.Reservations))
+	for id, r := range snap.Reservations {
+		reservations[id] = r
+	}
+	objects := make(map[objectID]Object, len(snap.Objects))
+	for _, o := range snap.Objects {
+		objects[objectID{path: o.Path, versionID: o.VersionID}] = o
+	}
+	ingest := make(map[ingestID]int64, len(snap.Ingest))
+	for _, c := range snap.Ingest {
+		ingest[ingestID{key: c.Key, bucket: c.Time.UnixMilli()}] += c.IngestBytes
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = entries
+	s.plans = plans
+	s.reservations = reservations
+	s.objects = objects
+	s.ingest = ingest
+	s.audit = append([]AuditEntry(nil), snap.Audit...)
+	s.history = append([]HistorySnapshot(nil), snap.History...)
+	s.ledger = ledger
+	return nil
+}
+
+// Save writes a snapshot of s to w.
+func (s *Store) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s.Snapshot())
+}
+
+// Load replaces the contents of s with a snapshot read from r.
+func (s *Store) Load(r io.Reader) error {
+	var snap Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("%s: %w", err, ErrBadSnapshot)
+	}
+	return s.Restore(&snap)
+}
+
+// SaveFile atomically writes a snapshot of s to path.
+func (s *Store) SaveFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create snapshot file: %w", err)
+	}
+	tmpPath := f.Name()
+	err = s.Save(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("write snapshot %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadFile replaces the contents of s with a snapshot read from path.  It
+// returns an error wrapping os.ErrNotExist if there is no such file.
+func (s *Store) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open snapshot: %w", err)
+	}
+	defer f.Close()
+	if err = s.Load(f); err != nil {
+		return fmt.Errorf("load snapshot %s: %w", path, err)
+	}
+	return nil
+}
+
+// SnapshotEvery saves a snapshot of s to path every interval, until ctx is
+// cancelled.  It then saves a final snapshot.
+func (s *Store) SnapshotEvery(ctx context.Context, l logging.Logger, path string, interval time.Duration) {
+	l = l.WithField("snapshot_path", path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.SaveFile(path); err != nil {
+				l.WithError(err).Error("Save final snapshot")
+			}
+			return
+		case <-ticker.C:
+			if err := s.SaveFile(path); err != nil {
+				l.WithError(err).Error("Save snapshot")
+			}
+		}
+	}
+}
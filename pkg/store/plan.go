@@ -0,0 +1,56 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrBadPlan = errors.New("bad plan")
+
+// Plan is a named tier of limits, such as "free" or "enterprise", shared
+// by the keys assigned to it.  A quota set on a key overrides that of its
+// plan, and keys with neither use the default quota.  Changes of a plan
+// apply at once to every key on it.
+type Plan struct {
+	Name string
+	// QuotaBytes is the quota of keys on the plan, or nil to use the
+	// default quota.
+	QuotaBytes *int64
+	// QuotaObjects is the quota of objects of keys on the plan, or nil
+	// to use the default quota of objects.  A quota of 0 is none.
+	QuotaObjects *int64
+	// RateLimits are the rate quotas of keys on the plan.  They replace
+	// the default rate quotas unless empty.
+	RateLimits []RateLimit
+}
+
+// RateLimit caps the bytes that a key may ingest over a sliding Window.
+type RateLimit struct {
+	Window time.Duration
+	Bytes  int64
+}
+
+// Validate returns ErrBadPlan if p has no name or negative limits.
+func (p *Plan) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("no name: %w", ErrBadPlan)
+	}
+	if p.QuotaBytes != nil && *p.QuotaBytes < 0 {
+		return fmt.Errorf("negative quota %d of plan %s: %w", *p.QuotaBytes, p.Name, ErrBadPlan)
+	}
+	if p.QuotaObjects != nil && *p.QuotaObjects < 0 {
+		return fmt.Errorf("negative quota of objects %d of plan %s: %w", *p.QuotaObjects, p.Name, ErrBadPlan)
+	}
+	windows := make(map[time.Duration]bool, len(p.RateLimits))
+	for _, l := range p.RateLimits {
+		if l.Window <= 0 || l.Bytes < 0 {
+			return fmt.Errorf("rate limit of %d bytes per %s of plan %s: %w", l.Bytes, l.Window, p.Name, ErrBadPlan)
+		}
+		if windows[l.Window] {
+			return fmt.Errorf("several rate limits per %s of plan %s: %w", l.Window, p.Name, ErrBadPlan)
+		}
+		windows[l.Window] = true
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/treeverse/terminus/pkg/logging"
+)
+
+// Reservation holds bytes of quota for an upload that has not yet
+// completed.
+type Reservation struct {
+	ID  string
+	Key string
+	// Path is the "s3://..." path of the object being uploaded.  The
+	// reservation is released when its object is created.
+	Path      string
+	SizeBytes int64
+	ExpiresAt time.Time
+}
+
+// NewReservationID returns a new random reservation ID.
+func NewReservationID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// SweepReservationsEvery sweeps expired reservations from s every
+// interval, until ctx is cancelled.
+func SweepReservationsEvery(ctx context.Context, l logging.Logger, s Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := s.SweepReservations(ctx, now)
+			if err != nil {
+				l.WithError(err).Error("Sweep expired reservations")
+				continue
+			}
+			if n > 0 {
+				l.WithField("count", n).Debug("Swept expired reservations")
+			}
+		}
+	}
+}
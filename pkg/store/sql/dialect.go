@@ -0,0 +1,166 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+
+	"github.com/treeverse/terminus/pkg/ddl"
+)
+
+var ErrUnknownDialect = errors.New("unknown SQL dialect")
+
+// Dialect generates SQL for a particular database.  Queries in this
+// package are written with "?" placeholders and with reserved identifiers
+// in ANSI double quotes, and the Dialect translates them.
+type Dialect interface {
+	// Name is the name of the dialect.
+	Name() string
+	// DriverName is the name of the database/sql driver to use.
+	DriverName() string
+	// Rebind translates query to this dialect.
+	Rebind(query string) string
+	// OnConflictUpdate returns the clause that follows an INSERT to
+	// update the row that conflicts on column.  It should be followed by
+	// assignments.
+	OnConflictUpdate(column string) string
+	// Excluded returns an expression for the value of column that an
+	// INSERT tried to add, for use in assignments after
+	// OnConflictUpdate.
+	Excluded(column string) string
+	// Retryable returns true if a transaction that failed with err
+	// should be retried.
+	Retryable(err error) bool
+	// Binary returns expr collated by bytes, as Go orders strings, for
+	// comparing and sorting keys.
+	Binary(expr string) string
+	// DDL returns statements that create the store schema.
+	DDL() string
+}
+
+var dialects = map[string]Dialect{}
+
+func registerDialect(d Dialect, aliases ...string) {
+	dialects[d.Name()] = d
+	for _, alias := range aliases {
+		dialects[alias] = d
+	}
+}
+
+func init() {
+	registerDialect(postgresDialect{}, "pgx", "postgresql")
+	registerDialect(cockroachDialect{}, "cockroach", "crdb")
+	registerDialect(mysqlDialect{}, "mariadb")
+	registerDialect(sqliteDialect{}, "sqlite3")
+}
+
+// DialectByName returns the Dialect called name or one of its aliases.
+func DialectByName(name string) (Dialect, error) {
+	d, ok := dialects[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrUnknownDialect)
+	}
+	return d, nil
+}
+
+// DialectNames returns the names of all known dialects and their aliases.
+func DialectNames() []string {
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// numberPlaceholders replaces each "?" in query with prefix followed by its
+// ordinal number.
+func numberPlaceholders(query, prefix string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString(prefix)
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string               { return "postgres" }
+func (postgresDialect) DriverName() string         { return "pgx" }
+func (postgresDialect) Rebind(query string) string { return numberPlaceholders(query, "$") }
+func (postgresDialect) OnConflictUpdate(col string) string {
+	return `ON CONFLICT ("` + col + `") DO UPDATE SET`
+}
+func (postgresDialect) Excluded(col string) string { return "excluded." + col }
+func (postgresDialect) Retryable(error) bool       { return false }
+func (postgresDialect) Binary(expr string) string  { return expr + ` COLLATE "C"` }
+func (postgresDialect) DDL() string                { return ddl.DDL }
+
+// cockroachDialect is PostgreSQL, except that CockroachDB runs all
+// transactions as SERIALIZABLE and requires clients to retry them.
+type cockroachDialect struct{ postgresDialect }
+
+// cockroachRetryCode is the SQLSTATE of a transaction that CockroachDB
+// requires to be retried.
+const cockroachRetryCode = "40001"
+
+func (cockroachDialect) Name() string { return "cockroachdb" }
+
+// Binary returns expr: CockroachDB collates strings by bytes unless told
+// otherwise.
+func (cockroachDialect) Binary(expr string) string { return expr }
+
+func (cockroachDialect) Retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == cockroachRetryCode
+}
+
+type mysqlDialect struct{}
+
+// mysqlDeadlock is the error number of a transaction that InnoDB aborted
+// to resolve a deadlock.
+const mysqlDeadlock = 1213
+
+func (mysqlDialect) Name() string       { return "mysql" }
+func (mysqlDialect) DriverName() string { return "mysql" }
+
+// Rebind quotes identifiers with backticks; "usage" and "key" are both
+// reserved words on MySQL.
+func (mysqlDialect) Rebind(query string) string {
+	return strings.ReplaceAll(query, `"`, "`")
+}
+func (mysqlDialect) OnConflictUpdate(string) string { return "ON DUPLICATE KEY UPDATE" }
+func (mysqlDialect) Excluded(col string) string     { return "VALUES(" + col + ")" }
+func (mysqlDialect) Retryable(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == mysqlDeadlock
+}
+
+// Binary returns expr: keys are declared with a binary collation.
+func (mysqlDialect) Binary(expr string) string { return expr }
+func (mysqlDialect) DDL() string               { return ddl.MySQLDDL }
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string               { return "sqlite" }
+func (sqliteDialect) DriverName() string         { return "sqlite" }
+func (sqliteDialect) Rebind(query string) string { return query }
+func (sqliteDialect) OnConflictUpdate(col string) string {
+	return `ON CONFLICT ("` + col + `") DO UPDATE SET`
+}
+func (sqliteDialect) Excluded(col string) string { return "excluded." + col }
+func (sqliteDialect) Retryable(error) bool       { return false }
+func (sqliteDialect) Binary(expr string) string  { return expr }
+func (sqliteDialect) DDL() string                { return ddl.SQLiteDDL }
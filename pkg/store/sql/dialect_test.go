@@ -0,0 +1,40 @@
+package sql_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/treeverse/terminus/pkg/store/sql"
+)
+
+func TestRebind(t *testing.T) {
+	const query = `SELECT "key" FROM "usage" WHERE "key" = ? AND size_bytes > ?`
+	cases := []struct {
+		Dialect  string
+		Expected string
+	}{
+		{"postgres", `SELECT "key" FROM "usage" WHERE "key" = $1 AND size_bytes > $2`},
+		{"pgx", `SELECT "key" FROM "usage" WHERE "key" = $1 AND size_bytes > $2`},
+		{"cockroachdb", `SELECT "key" FROM "usage" WHERE "key" = $1 AND size_bytes > $2`},
+		{"mysql", "SELECT `key` FROM `usage` WHERE `key` = ? AND size_bytes > ?"},
+		{"sqlite", `SELECT "key" FROM "usage" WHERE "key" = ? AND size_bytes > ?`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Dialect, func(t *testing.T) {
+			d, err := sql.DialectByName(c.Dialect)
+			if err != nil {
+				t.Fatalf("Get dialect: %s", err)
+			}
+			if actual := d.Rebind(query); actual != c.Expected {
+				t.Errorf("Got %s expected %s", actual, c.Expected)
+			}
+		})
+	}
+}
+
+func TestUnknownDialect(t *testing.T) {
+	if _, err := sql.DialectByName("oracle"); !errors.Is(err, sql.ErrUnknownDialect) {
+		t.Errorf("Expected error %s, got %s", sql.ErrUnknownDialect, err)
+	}
+}
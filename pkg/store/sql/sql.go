@@ -6,21 +6,310 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/treeverse/terminus/pkg/store"
 )
 
-func NewSQLStore(db *sql.DB, defaultQuotaBytes int64) (store.Store, error) {
-	return &SQLStore{db: db, DefaultQuotaBytes: defaultQuotaBytes}, nil
+// maxRetries is the number of times to retry a transaction that the
+// Dialect considers retryable.
+const maxRetries = 10
+
+// queries holds the SQL statements that SQLStore runs, translated to its
+// Dialect.
+type queries struct {
+	get         string
+	getInfo     string
+	set         string
+	add         string
+	setQuota    string
+	clearQuota  string
+	checkQuota  string
+	getExceeded string
+	list        string
+
+	reserved            string
+	reserve             string
+	releaseReservation  string
+	releaseReservations string
+	sweepReservations   string
+
+	appendAudit string
+	listAudit   string
+
+	deleteHistoryAt string
+	recordHistory   string
+	getHistory      string
+	deleteHistory   string
+	historyTimes    string
+
+	appendLedger string
+	byteMillis   string
+	ledgerSums   string
+	deleteLedger string
+
+	addClassBytes    string
+	insertClassBytes string
+	getClassUsage    string
+
+	addObjects       string
+	setObjectQuota   string
+	clearObjectQuota string
+	getObject        string
+	insertObject     string
+	deleteObject     string
+	setObjectClass   string
+
+	addIngest    string
+	insertIngest string
+	getIngest    string
+	listIngest   string
+	deleteIngest string
+
+	setGracePeriod    string
+	clearGracePeriod  string
+	getGracePeriod    string
+	markExceeded      string
+	clearExceeded     string
+	getExceededSince  string
+	listExceededSince string
+
+	setPlan              string
+	getPlan              string
+	listPlans            string
+	deletePlan           string
+	insertPlanRateLimit  string
+	getPlanRateLimits    string
+	listPlanRateLimits   string
+	deletePlanRateLimits string
+	assignPlan           string
+	unassignPlan         string
+	unassignPlanKeys     string
+	setBlocked           string
+	unsetBlocked         string
+	listBlocked          string
+}
+
+// infoFrom joins the usage of keys with their objects and plans.  Quotas
+// of keys override those of their plans.
+const infoFrom = `"usage" u LEFT JOIN object_counts o ON o."key" = u."key"
+	LEFT JOIN key_plans kp ON kp."key" = u."key" LEFT JOIN plans p ON p.name = kp.plan`
+
+func newQueries(d Dialect) *queries {
+	return &queries{
+		get: d.Rebind(`SELECT size_bytes FROM "usage" WHERE "key" = ?`),
+		getInfo: d.Rebind(`
+			SELECT u.size_bytes, COALESCE(u.quota, p.quota_bytes, ?), COALESCE(o.object_count, 0),
+				COALESCE(o.quota, p.quota_objects, ?), COALESCE(p.name, '')
+			FROM ` + infoFrom + `
+			WHERE u."key" = ?`),
+		set: d.Rebind(fmt.Sprintf(`
+			INSERT INTO "usage" ("key", size_bytes) VALUES (?, ?)
+			%s size_bytes=%s`,
+			d.OnConflictUpdate("key"), d.Excluded("size_bytes"))),
+		add: d.Rebind(fmt.Sprintf(`
+			INSERT INTO "usage" ("key", size_bytes) VALUES (?, ?)
+			%s size_bytes="usage".size_bytes+%s`,
+			d.OnConflictUpdate("key"), d.Excluded("size_bytes"))),
+		setQuota: d.Rebind(fmt.Sprintf(`
+			INSERT INTO "usage" ("key", size_bytes, quota) VALUES (?, 0, ?)
+			%s quota=%s`,
+			d.OnConflictUpdate("key"), d.Excluded("quota"))),
+		clearQuota: d.Rebind(`UPDATE "usage" SET quota=NULL WHERE "key"=?`),
+		// A quota of 0 objects is no quota of objects.
+		checkQuota: d.Rebind(`
+			SELECT NULL FROM ` + infoFrom + `
+			WHERE u."key"=? AND (u.size_bytes > COALESCE(u.quota, p.quota_bytes, ?) OR
+				COALESCE(o.object_count, 0) > COALESCE(o.quota, p.quota_objects, ?) AND
+				COALESCE(o.quota, p.quota_objects, ?) > 0)`),
+		getExceeded: d.Rebind(`
+			SELECT "key", size_bytes, quota, object_count, quota_objects, plan FROM (
+				SELECT u."key", u.size_bytes, COALESCE(u.quota, p.quota_bytes, ?) quota,
+					COALESCE(o.object_count, 0) object_count,
+					COALESCE(o.quota, p.quota_objects, ?) quota_objects, COALESCE(p.name, '') plan
+				FROM ` + infoFrom + `
+			) s WHERE size_bytes > quota OR object_count > quota_objects AND quota_objects > 0`),
+		list: d.Rebind(fmt.Sprintf(`
+			SELECT u."key", u.size_bytes, COALESCE(u.quota, p.quota_bytes, ?), COALESCE(o.object_count, 0),
+				COALESCE(o.quota, p.quota_objects, ?), COALESCE(p.name, '')
+			FROM `+infoFrom+`
+			WHERE %s > ? ORDER BY %s LIMIT ?`,
+			d.Binary(`u."key"`), d.Binary(`u."key"`))),
+
+		reserved: d.Rebind(`
+			SELECT COALESCE(SUM(size_bytes), 0) FROM reservations WHERE "key"=? AND expires_at > ?`),
+		reserve: d.Rebind(`
+			INSERT INTO reservations (id, "key", path, size_bytes, expires_at) VALUES (?, ?, ?, ?, ?)`),
+		releaseReservation:  d.Rebind(`DELETE FROM reservations WHERE id=?`),
+		releaseReservations: d.Rebind(`DELETE FROM reservations WHERE "key"=? AND path=?`),
+		sweepReservations:   d.Rebind(`DELETE FROM reservations WHERE expires_at <= ?`),
+
+		appendAudit: d.Rebind(`
+			INSERT INTO audit (at, actor, action, "key", old_value, new_value, detail)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		// Zero values of filters match everything.
+		listAudit: d.Rebind(`
+			SELECT id, at, actor, action, "key", old_value, new_value, detail FROM audit
+			WHERE ("key" = ? OR ? = '') AND at >= ? AND at < ?
+			ORDER BY id LIMIT ?`),
+
+		deleteHistoryAt: d.Rebind(`DELETE FROM usage_history WHERE at = ?`),
+		recordHistory: d.Rebind(`
+			INSERT INTO usage_history ("key", at, size_bytes)
+			SELECT "key", ?, size_bytes FROM "usage"`),
+		getHistory: d.Rebind(`
+			SELECT at, size_bytes FROM usage_history
+			WHERE "key" = ? AND at >= ? AND at < ? ORDER BY at`),
+		deleteHistory: d.Rebind(`DELETE FROM usage_history WHERE at < ?`),
+		historyTimes:  d.Rebind(`SELECT DISTINCT at FROM usage_history WHERE at < ? ORDER BY at`),
+
+		appendLedger: d.Rebind(`INSERT INTO usage_ledger ("key", at, delta_bytes) VALUES (?, ?, ?)`),
+		// A change at "at" contributes to usage from "at" or from the
+		// start until the end.  Multiply as a decimal or a float: byte
+		// milliseconds overflow BIGINT.
+		byteMillis: d.Rebind(`
+			SELECT "key", SUM(delta_bytes * 1.0 * (? - CASE WHEN at > ? THEN at ELSE ? END))
+			FROM usage_ledger WHERE at < ? GROUP BY "key"`),
+		ledgerSums:   d.Rebind(`SELECT "key", SUM(delta_bytes) FROM usage_ledger WHERE at < ? GROUP BY "key"`),
+		deleteLedger: d.Rebind(`DELETE FROM usage_ledger WHERE at < ?`),
+
+		addClassBytes: d.Rebind(`
+			UPDATE usage_by_class SET size_bytes = size_bytes + ?
+			WHERE "key" = ? AND storage_class = ?`),
+		insertClassBytes: d.Rebind(`
+			INSERT INTO usage_by_class ("key", storage_class, size_bytes) VALUES (?, ?, ?)`),
+		getClassUsage: d.Rebind(`
+			SELECT storage_class, size_bytes FROM usage_by_class
+			WHERE "key" = ? AND size_bytes <> 0`),
+
+		addObjects: d.Rebind(fmt.Sprintf(`
+			INSERT INTO object_counts ("key", object_count) VALUES (?, ?)
+			%s object_count=object_counts.object_count+%s`,
+			d.OnConflictUpdate("key"), d.Excluded("object_count"))),
+		setObjectQuota: d.Rebind(fmt.Sprintf(`
+			INSERT INTO object_counts ("key", object_count, quota) VALUES (?, 0, ?)
+			%s quota=%s`,
+			d.OnConflictUpdate("key"), d.Excluded("quota"))),
+		clearObjectQuota: d.Rebind(`UPDATE object_counts SET quota=NULL WHERE "key"=?`),
+		getObject: d.Rebind(`
+			SELECT "key", size_bytes, storage_class FROM objects WHERE path = ? AND version_id = ?`),
+		insertObject: d.Rebind(`
+			INSERT INTO objects (path, version_id, "key", size_bytes, storage_class) VALUES (?, ?, ?, ?, ?)`),
+		deleteObject: d.Rebind(`DELETE FROM objects WHERE path = ? AND version_id = ?`),
+		setObjectClass: d.Rebind(`
+			UPDATE objects SET storage_class = ? WHERE path = ? AND version_id = ? AND storage_class = ?`),
+
+		addIngest: d.Rebind(`
+			UPDATE ingest SET ingest_bytes = ingest_bytes + ? WHERE "key" = ? AND at = ?`),
+		insertIngest: d.Rebind(`INSERT INTO ingest ("key", at, ingest_bytes) VALUES (?, ?, ?)`),
+		getIngest: d.Rebind(`
+			SELECT COALESCE(SUM(ingest_bytes), 0) FROM ingest WHERE "key" = ? AND at >= ? AND at < ?`),
+		listIngest: d.Rebind(`
+			SELECT "key", SUM(ingest_bytes) FROM ingest WHERE at >= ? AND at < ? GROUP BY "key"`),
+		deleteIngest: d.Rebind(`DELETE FROM ingest WHERE at < ?`),
+
+		setGracePeriod: d.Rebind(fmt.Sprintf(`
+			INSERT INTO grace_periods ("key", grace_ms) VALUES (?, ?)
+			%s grace_ms=%s`,
+			d.OnConflictUpdate("key"), d.Excluded("grace_ms"))),
+		clearGracePeriod: d.Rebind(`UPDATE grace_periods SET grace_ms=NULL WHERE "key"=?`),
+		getGracePeriod:   d.Rebind(`SELECT grace_ms FROM grace_periods WHERE "key"=? AND grace_ms IS NOT NULL`),
+		// Keeps the earliest time, when the key started to exceed.
+		markExceeded: d.Rebind(fmt.Sprintf(`
+			INSERT INTO grace_periods ("key", exceeded_since) VALUES (?, ?)
+			%s exceeded_since=COALESCE(grace_periods.exceeded_since, %s)`,
+			d.OnConflictUpdate("key"), d.Excluded("exceeded_since"))),
+		clearExceeded:     d.Rebind(`UPDATE grace_periods SET exceeded_since=NULL WHERE "key"=? AND exceeded_since IS NOT NULL`),
+		getExceededSince:  d.Rebind(`SELECT exceeded_since FROM grace_periods WHERE "key"=?`),
+		listExceededSince: d.Rebind(`SELECT "key" FROM grace_periods WHERE exceeded_since IS NOT NULL`),
+
+		setPlan: d.Rebind(fmt.Sprintf(`
+			INSERT INTO plans (name, quota_bytes, quota_objects) VALUES (?, ?, ?)
+			%s quota_bytes=%s, quota_objects=%s`,
+			d.OnConflictUpdate("name"), d.Excluded("quota_bytes"), d.Excluded("quota_objects"))),
+		getPlan:    d.Rebind(`SELECT quota_bytes, quota_objects FROM plans WHERE name=?`),
+		listPlans:  d.Rebind(`SELECT name, quota_bytes, quota_objects FROM plans`),
+		deletePlan: d.Rebind(`DELETE FROM plans WHERE name=?`),
+		insertPlanRateLimit: d.Rebind(`
+			INSERT INTO plan_rate_limits (plan, window_ms, size_bytes) VALUES (?, ?, ?)`),
+		getPlanRateLimits: d.Rebind(`
+			SELECT plan, window_ms, size_bytes FROM plan_rate_limits WHERE plan=? ORDER BY window_ms`),
+		listPlanRateLimits:   d.Rebind(`SELECT plan, window_ms, size_bytes FROM plan_rate_limits ORDER BY window_ms`),
+		deletePlanRateLimits: d.Rebind(`DELETE FROM plan_rate_limits WHERE plan=?`),
+		assignPlan: d.Rebind(fmt.Sprintf(`
+			INSERT INTO key_plans ("key", plan) VALUES (?, ?)
+			%s plan=%s`,
+			d.OnConflictUpdate("key"), d.Excluded("plan"))),
+		unassignPlan:     d.Rebind(`DELETE FROM key_plans WHERE "key"=?`),
+		unassignPlanKeys: d.Rebind(`DELETE FROM key_plans WHERE plan=?`),
+		setBlocked: d.Rebind(fmt.Sprintf(`
+			INSERT INTO blocked_keys ("key", at) VALUES (?, ?)
+			%s at=%s`,
+			d.OnConflictUpdate("key"), d.Excluded("at"))),
+		unsetBlocked: d.Rebind(`DELETE FROM blocked_keys WHERE "key"=?`),
+		listBlocked:  d.Rebind(`SELECT "key" FROM blocked_keys ORDER BY "key"`),
+	}
+}
+
+// NewSQLStore returns a Store on db, which speaks dialect.  SQLite allows
+// only a single writer, so a SQLite db should be configured with a busy
+// timeout or a single connection.
+func NewSQLStore(db *sql.DB, dialect Dialect, defaultQuotaBytes int64) (*SQLStore, error) {
+	return &SQLStore{db: db, dialect: dialect, q: newQueries(dialect), DefaultQuotaBytes: defaultQuotaBytes}, nil
+}
+
+// CreateSchema creates the store schema for dialect on db, if it does not
+// already exist.
+func CreateSchema(ctx context.Context, db *sql.DB, dialect Dialect) error {
+	// Not all drivers can execute multiple statements at once.
+	for _, stmt := range strings.Split(dialect.DDL(), ";") {
+		if strings.TrimSpace(stripComments(stmt)) == "" {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("create %s schema: %w", dialect.Name(), err)
+		}
+	}
+	return nil
+}
+
+// stripComments removes "--" comments from SQL statements.
+func stripComments(stmt string) string {
+	lines := strings.Split(stmt, "\n")
+	for i, line := range lines {
+		if idx := strings.Index(line, "--"); idx >= 0 {
+			lines[i] = line[:idx]
+		}
+	}
+	return strings.Join(lines, "\n")
 }
 
 // SQLStore is a Store that keeps results in a SQL database.
 type SQLStore struct {
 	db                *sql.DB
+	dialect           Dialect
+	q                 *queries
 	DefaultQuotaBytes int64
+	// DefaultQuotaObjects is the quota of objects of keys without one,
+	// or 0 for none.
+	DefaultQuotaObjects int64
 }
 
+// transact runs fn in a transaction, retrying it while it fails in a way
+// that the dialect considers retryable.
 func (s *SQLStore) transact(ctx context.Context, fn func(tx *sql.Tx) (interface{}, error)) (interface{}, error) {
+	for attempt := 1; ; attempt++ {
+		ret, err := s.transactOnce(ctx, fn)
+		if err == nil || attempt >= maxRetries || !s.dialect.Retryable(err) || ctx.Err() != nil {
+			return ret, err
+		}
+	}
+}
+
+func (s *SQLStore) transactOnce(ctx context.Context, fn func(tx *sql.Tx) (interface{}, error)) (interface{}, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -40,7 +329,7 @@ func (s *SQLStore) transact(ctx context.Context, fn func(tx *sql.Tx) (interface{
 func (s *SQLStore) Get(ctx context.Context, key string) (store.Value, error) {
 	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
 		var value store.Value
-		row := tx.QueryRowContext(ctx, `SELECT size_bytes FROM usage WHERE key = $1`, key)
+		row := tx.QueryRowContext(ctx, s.q.get, key)
 		err := row.Scan(&value.SizeBytes)
 		return value, err
 	})
@@ -56,9 +345,7 @@ func (s *SQLStore) Get(ctx context.Context, key string) (store.Value, error) {
 // checkQuota returns true if key is still within quota.
 func (s *SQLStore) checkQuota(ctx context.Context, tx *sql.Tx, key string) (bool, error) {
 	// TODO(ariels): Add "next check" backoff.
-	row := tx.QueryRowContext(ctx, `
-                SELECT NULL FROM usage WHERE key=$1 AND size_bytes > COALESCE(quota, $2)`,
-		key, s.DefaultQuotaBytes)
+	row := tx.QueryRowContext(ctx, s.q.checkQuota, key, s.DefaultQuotaBytes, s.DefaultQuotaObjects, s.DefaultQuotaObjects)
 	var ignore *string
 	err := row.Scan(&ignore)
 	if err == nil {
@@ -74,11 +361,52 @@ func (s *SQLStore) checkQuota(ctx context.Context, tx *sql.Tx, key string) (bool
 
 func (s *SQLStore) Set(ctx context.Context, key string, value store.Value) error {
 	ok, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
-		_, err := tx.ExecContext(ctx, `
-			INSERT INTO usage (key, size_bytes) VALUES ($1, $2)
-			ON CONFLICT (key) DO UPDATE SET size_bytes=$2`,
-			key, value.SizeBytes)
-		if err != nil {
+		var oldSizeBytes int64
+		err := tx.QueryRowContext(ctx, s.q.get, key).Scan(&oldSizeBytes)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if _, err = tx.ExecContext(ctx, s.q.set, key, value.SizeBytes); err != nil {
+			return nil, err
+		}
+		if err = s.appendLedger(ctx, tx, key, value.SizeBytes-oldSizeBytes, time.Now()); err != nil {
+			return nil, err
+		}
+		return s.checkQuota(ctx, tx, key)
+	})
+	if err != nil {
+		return err
+	}
+	if !ok.(bool) {
+		return store.ErrQuotaExceeded
+	}
+	return nil
+}
+
+// appendLedger appends a change of deltaBytes to key at to the ledger.
+func (s *SQLStore) appendLedger(ctx context.Context, tx *sql.Tx, key string, deltaBytes int64, at time.Time) error {
+	if deltaBytes == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, s.q.appendLedger, key, at.UnixMilli(), deltaBytes); err != nil {
+		return fmt.Errorf("append to ledger: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) AddSizeBytes(ctx context.Context, key string, numBytes int64, at time.Time) error {
+	return s.addSizeBytes(ctx, key, "", numBytes, at)
+}
+
+func (s *SQLStore) AddClassSizeBytes(ctx context.Context, key, storageClass string, numBytes int64, at time.Time) error {
+	return s.addSizeBytes(ctx, key, storageClass, numBytes, at)
+}
+
+// addSizeBytes adds numBytes to the usage of key, and to its usage in
+// storageClass unless that is empty.
+func (s *SQLStore) addSizeBytes(ctx context.Context, key, storageClass string, numBytes int64, at time.Time) error {
+	ok, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		if err := s.addBytes(ctx, tx, key, storageClass, numBytes, at); err != nil {
 			return nil, err
 		}
 		return s.checkQuota(ctx, tx, key)
@@ -92,15 +420,48 @@ func (s *SQLStore) Set(ctx context.Context, key string, value store.Value) error
 	return nil
 }
 
-func (s *SQLStore) AddSizeBytes(ctx context.Context, key string, numBytes int64) error {
+// addBytes adds numBytes to the usage of key, and to its usage in
+// storageClass unless that is empty, in tx.
+func (s *SQLStore) addBytes(ctx context.Context, tx *sql.Tx, key, storageClass string, numBytes int64, at time.Time) error {
+	// Also locks the usage of key, serializing the update of its
+	// storage class below.
+	if _, err := tx.ExecContext(ctx, s.q.add, key, numBytes); err != nil {
+		return err
+	}
+	if err := s.appendLedger(ctx, tx, key, numBytes, at); err != nil {
+		return err
+	}
+	if storageClass != "" && numBytes != 0 {
+		return s.addClassBytes(ctx, tx, key, storageClass, numBytes)
+	}
+	return nil
+}
+
+func (s *SQLStore) PutObject(ctx context.Context, key string, o store.Object, at time.Time) error {
 	ok, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
-		_, err := tx.ExecContext(ctx, `
-			INSERT INTO usage VALUES ($1, $2)
-			ON CONFLICT (key) DO UPDATE SET size_bytes=usage.size_bytes+$2`,
-			key, numBytes)
+		// Adding nothing locks the usage row, serializing puts of
+		// the same object on the same key.
+		if _, err := tx.ExecContext(ctx, s.q.add, key, 0); err != nil {
+			return nil, fmt.Errorf("lock key: %w", err)
+		}
+		if _, err := s.removeObject(ctx, tx, o.Path, o.VersionID, at); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		_, err := tx.ExecContext(ctx, s.q.insertObject, o.Path, o.VersionID, key, o.SizeBytes, o.StorageClass)
 		if err != nil {
+			return nil, fmt.Errorf("insert object: %w", err)
+		}
+		if err = s.addBytes(ctx, tx, key, o.StorageClass, o.SizeBytes, at); err != nil {
 			return nil, err
 		}
+		if _, err = tx.ExecContext(ctx, s.q.addObjects, key, 1); err != nil {
+			return nil, fmt.Errorf("count object: %w", err)
+		}
+		if o.SizeBytes > 0 {
+			if err = s.addIngest(ctx, tx, key, o.SizeBytes, at); err != nil {
+				return nil, err
+			}
+		}
 		return s.checkQuota(ctx, tx, key)
 	})
 	if err != nil {
@@ -112,27 +473,661 @@ func (s *SQLStore) AddSizeBytes(ctx context.Context, key string, numBytes int64)
 	return nil
 }
 
+func (s *SQLStore) DeleteObject(ctx context.Context, path, versionID string, at time.Time) (string, error) {
+	type deletion struct {
+		key string
+		ok  bool
+	}
+	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		key, err := s.removeObject(ctx, tx, path, versionID, at)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := s.checkQuota(ctx, tx, key)
+		return deletion{key: key, ok: ok}, err
+	})
+	if err != nil {
+		return "", err
+	}
+	d := ret.(deletion)
+	if !d.ok {
+		return d.key, store.ErrQuotaExceeded
+	}
+	return d.key, nil
+}
+
+func (s *SQLStore) TransitionObject(ctx context.Context, path, versionID, storageClass string) (string, error) {
+	type transition struct {
+		key string
+		ok  bool
+	}
+	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		var (
+			key       string
+			sizeBytes int64
+			oldClass  string
+		)
+		err := tx.QueryRowContext(ctx, s.q.getObject, path, versionID).Scan(&key, &sizeBytes, &oldClass)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("object version %q: %w", versionID, store.ErrNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get object: %w", err)
+		}
+		// Adding nothing locks the usage row, serializing the
+		// update of storage classes below.
+		if _, err = tx.ExecContext(ctx, s.q.add, key, 0); err != nil {
+			return nil, fmt.Errorf("lock key: %w", err)
+		}
+		if oldClass != storageClass {
+			res, err := tx.ExecContext(ctx, s.q.setObjectClass, storageClass, path, versionID, oldClass)
+			if err != nil {
+				return nil, fmt.Errorf("set storage class of object: %w", err)
+			}
+			// A concurrent change may have moved or removed it first.
+			if n, err := res.RowsAffected(); err != nil || n == 0 {
+				if err == nil {
+					err = fmt.Errorf("object version %q changed concurrently: %w", versionID, store.ErrNotFound)
+				}
+				return nil, err
+			}
+			if oldClass != "" && sizeBytes != 0 {
+				if err = s.addClassBytes(ctx, tx, key, oldClass, -sizeBytes); err != nil {
+					return nil, err
+				}
+			}
+			if storageClass != "" && sizeBytes != 0 {
+				if err = s.addClassBytes(ctx, tx, key, storageClass, sizeBytes); err != nil {
+					return nil, err
+				}
+			}
+		}
+		ok, err := s.checkQuota(ctx, tx, key)
+		return transition{key: key, ok: ok}, err
+	})
+	if err != nil {
+		return "", err
+	}
+	t := ret.(transition)
+	if !t.ok {
+		return t.key, store.ErrQuotaExceeded
+	}
+	return t.key, nil
+}
+
+// addIngest counts numBytes as ingested by key at at.  Like addClassBytes,
+// it updates or inserts, serialized by the lock on the usage of key.
+func (s *SQLStore) addIngest(ctx context.Context, tx *sql.Tx, key string, numBytes int64, at time.Time) error {
+	bucket := at.Truncate(store.IngestBucket).UnixMilli()
+	res, err := tx.ExecContext(ctx, s.q.addIngest, numBytes, key, bucket)
+	if err != nil {
+		return fmt.Errorf("add ingest: %w", err)
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated > 0 {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, s.q.insertIngest, key, bucket, numBytes); err != nil {
+		return fmt.Errorf("insert ingest: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) GetIngest(ctx context.Context, key string, from, to time.Time) (int64, error) {
+	var sum int64
+	err := s.db.QueryRowContext(ctx, s.q.getIngest, key, from.UnixMilli(), to.UnixMilli()).Scan(&sum)
+	if err != nil {
+		return 0, fmt.Errorf("get ingest: %w", err)
+	}
+	return sum, nil
+}
+
+func (s *SQLStore) ListIngest(ctx context.Context, from, to time.Time) ([]store.Ingest, error) {
+	rows, err := s.db.QueryContext(ctx, s.q.listIngest, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("select ingest: %w", err)
+	}
+	defer rows.Close()
+	var ingest []store.Ingest
+	for rows.Next() {
+		var i store.Ingest
+		if err := rows.Scan(&i.Key, &i.IngestBytes); err != nil {
+			return nil, fmt.Errorf("parse ingest #%d: %w", len(ingest)+1, err)
+		}
+		ingest = append(ingest, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Databases disagree on how to collate keys.
+	sort.Slice(ingest, func(i, j int) bool { return ingest[i].Key < ingest[j].Key })
+	return ingest, nil
+}
+
+func (s *SQLStore) DeleteIngest(ctx context.Context, before time.Time) (int, error) {
+	return s.execCount(ctx, s.q.deleteIngest, before.UnixMilli())
+}
+
+// removeObject removes the object at path and versionID from the usage
+// and objects of its key in tx, and returns that key.  It returns
+// ErrNotFound if there is no such object.
+func (s *SQLStore) removeObject(ctx context.Context, tx *sql.Tx, path, versionID string, at time.Time) (string, error) {
+	var (
+		key          string
+		sizeBytes    int64
+		storageClass string
+	)
+	err := tx.QueryRowContext(ctx, s.q.getObject, path, versionID).Scan(&key, &sizeBytes, &storageClass)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("object version %q: %w", versionID, store.ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("get object: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, s.q.deleteObject, path, versionID)
+	if err != nil {
+		return "", fmt.Errorf("delete object: %w", err)
+	}
+	// A concurrent removal may have deleted it first.
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = fmt.Errorf("object version %q: %w", versionID, store.ErrNotFound)
+		}
+		return "", err
+	}
+	if err = s.addBytes(ctx, tx, key, storageClass, -sizeBytes, at); err != nil {
+		return "", err
+	}
+	if _, err = tx.ExecContext(ctx, s.q.addObjects, key, -1); err != nil {
+		return "", fmt.Errorf("uncount object: %w", err)
+	}
+	return key, nil
+}
+
+// addClassBytes adds numBytes to the usage of key in storageClass.  Keys
+// are too long for MySQL to index with storage classes, so it updates or
+// inserts instead of upserting.
+func (s *SQLStore) addClassBytes(ctx context.Context, tx *sql.Tx, key, storageClass string, numBytes int64) error {
+	res, err := tx.ExecContext(ctx, s.q.addClassBytes, numBytes, key, storageClass)
+	if err != nil {
+		return fmt.Errorf("add to storage class %s: %w", storageClass, err)
+	}
+	// numBytes is not zero, so MySQL also counts updated rows as
+	// affected.
+	if updated, err := res.RowsAffected(); err != nil || updated > 0 {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, s.q.insertClassBytes, key, storageClass, numBytes); err != nil {
+		return fmt.Errorf("insert storage class %s: %w", storageClass, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) GetClassUsage(ctx context.Context, key string) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, s.q.getClassUsage, key)
+	if err != nil {
+		return nil, fmt.Errorf("get usage by storage class: %w", err)
+	}
+	defer rows.Close()
+	usage := make(map[string]int64)
+	for rows.Next() {
+		var (
+			class     string
+			sizeBytes int64
+		)
+		if err := rows.Scan(&class, &sizeBytes); err != nil {
+			return nil, fmt.Errorf("parse usage of storage class #%d: %w", len(usage)+1, err)
+		}
+		usage[class] = sizeBytes
+	}
+	return usage, rows.Err()
+}
+
+func (s *SQLStore) SetQuota(ctx context.Context, key string, quotaBytes int64) error {
+	_, err := s.db.ExecContext(ctx, s.q.setQuota, key, quotaBytes)
+	return err
+}
+
+func (s *SQLStore) ClearQuota(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.q.clearQuota, key)
+	return err
+}
+
+func (s *SQLStore) SetObjectQuota(ctx context.Context, key string, quotaObjects int64) error {
+	_, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		// Keys exist by their usage.
+		if _, err := tx.ExecContext(ctx, s.q.add, key, 0); err != nil {
+			return nil, err
+		}
+		_, err := tx.ExecContext(ctx, s.q.setObjectQuota, key, quotaObjects)
+		return nil, err
+	})
+	return err
+}
+
+func (s *SQLStore) ClearObjectQuota(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.q.clearObjectQuota, key)
+	return err
+}
+
+func (s *SQLStore) SetGracePeriod(ctx context.Context, key string, gracePeriod time.Duration) error {
+	_, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		// Keys exist by their usage.
+		if _, err := tx.ExecContext(ctx, s.q.add, key, 0); err != nil {
+			return nil, err
+		}
+		_, err := tx.ExecContext(ctx, s.q.setGracePeriod, key, gracePeriod.Milliseconds())
+		return nil, err
+	})
+	return err
+}
+
+func (s *SQLStore) ClearGracePeriod(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.q.clearGracePeriod, key)
+	return err
+}
+
+func (s *SQLStore) GetGracePeriod(ctx context.Context, key string) (time.Duration, error) {
+	var graceMs int64
+	err := s.db.QueryRowContext(ctx, s.q.getGracePeriod, key).Scan(&graceMs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, store.ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get grace period: %w", err)
+	}
+	return time.Duration(graceMs) * time.Millisecond, nil
+}
+
+func (s *SQLStore) MarkExceeded(ctx context.Context, key string, exceeded bool, at time.Time) (time.Time, error) {
+	if !exceeded {
+		_, err := s.db.ExecContext(ctx, s.q.clearExceeded, key)
+		return time.Time{}, err
+	}
+	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		return s.markExceeded(ctx, tx, key, at)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ret.(time.Time), nil
+}
+
+// markExceeded records in tx that key exceeds quota at at, and returns
+// since when.
+func (s *SQLStore) markExceeded(ctx context.Context, tx *sql.Tx, key string, at time.Time) (time.Time, error) {
+	if _, err := tx.ExecContext(ctx, s.q.markExceeded, key, at.UnixMilli()); err != nil {
+		return time.Time{}, fmt.Errorf("mark %s exceeded: %w", key, err)
+	}
+	var since int64
+	if err := tx.QueryRowContext(ctx, s.q.getExceededSince, key).Scan(&since); err != nil {
+		return time.Time{}, fmt.Errorf("get exceeded since of %s: %w", key, err)
+	}
+	return time.UnixMilli(since), nil
+}
+
+func (s *SQLStore) GetExceededSince(ctx context.Context, key string) (time.Time, error) {
+	var since sql.NullInt64
+	err := s.db.QueryRowContext(ctx, s.q.getExceededSince, key).Scan(&since)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !since.Valid {
+		return time.Time{}, store.ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get exceeded since: %w", err)
+	}
+	return time.UnixMilli(since.Int64), nil
+}
+
+func (s *SQLStore) MarkAllExceeded(ctx context.Context, keys []string, at time.Time) (map[string]time.Time, error) {
+	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		since := make(map[string]time.Time, len(keys))
+		for _, key := range keys {
+			t, err := s.markExceeded(ctx, tx, key, at)
+			if err != nil {
+				return nil, err
+			}
+			since[key] = t
+		}
+		marked, err := s.listExceeded(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range marked {
+			if _, ok := since[key]; ok {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, s.q.clearExceeded, key); err != nil {
+				return nil, fmt.Errorf("clear exceeded of %s: %w", key, err)
+			}
+		}
+		return since, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret.(map[string]time.Time), nil
+}
+
+// listExceeded returns the keys marked in tx as exceeding quota.
+func (s *SQLStore) listExceeded(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, s.q.listExceededSince)
+	if err != nil {
+		return nil, fmt.Errorf("select exceeded keys: %w", err)
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("parse exceeded key #%d: %w", len(keys)+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLStore) SetPlan(ctx context.Context, p store.Plan) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	_, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		if _, err := tx.ExecContext(ctx, s.q.setPlan, p.Name, p.QuotaBytes, p.QuotaObjects); err != nil {
+			return nil, fmt.Errorf("set plan %s: %w", p.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, s.q.deletePlanRateLimits, p.Name); err != nil {
+			return nil, fmt.Errorf("delete rate limits of plan %s: %w", p.Name, err)
+		}
+		for _, l := range p.RateLimits {
+			if _, err := tx.ExecContext(ctx, s.q.insertPlanRateLimit, p.Name, l.Window.Milliseconds(), l.Bytes); err != nil {
+				return nil, fmt.Errorf("insert rate limit per %s of plan %s: %w", l.Window, p.Name, err)
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+func (s *SQLStore) GetPlan(ctx context.Context, name string) (store.Plan, error) {
+	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		p := store.Plan{Name: name}
+		err := tx.QueryRowContext(ctx, s.q.getPlan, name).Scan(&p.QuotaBytes, &p.QuotaObjects)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("plan %s: %w", name, store.ErrNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get plan %s: %w", name, err)
+		}
+		limits, err := s.planRateLimits(ctx, tx, s.q.getPlanRateLimits, name)
+		if err != nil {
+			return nil, err
+		}
+		p.RateLimits = limits[name]
+		return p, nil
+	})
+	if err != nil {
+		return store.Plan{}, err
+	}
+	return ret.(store.Plan), nil
+}
+
+func (s *SQLStore) ListPlans(ctx context.Context) ([]store.Plan, error) {
+	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		rows, err := tx.QueryContext(ctx, s.q.listPlans)
+		if err != nil {
+			return nil, fmt.Errorf("select plans: %w", err)
+		}
+		plans := []store.Plan{}
+		for rows.Next() {
+			var p store.Plan
+			if err := rows.Scan(&p.Name, &p.QuotaBytes, &p.QuotaObjects); err != nil {
+				return nil, fmt.Errorf("parse plan #%d: %w", len(plans)+1, err)
+			}
+			plans = append(plans, p)
+		}
+		if err := rows.Close(); err != nil {
+			return nil, fmt.Errorf("close query with #%d plans: %w", len(plans), err)
+		}
+		limits, err := s.planRateLimits(ctx, tx, s.q.listPlanRateLimits)
+		if err != nil {
+			return nil, err
+		}
+		for i := range plans {
+			plans[i].RateLimits = limits[plans[i].Name]
+		}
+		sort.Slice(plans, func(i, j int) bool { return plans[i].Name < plans[j].Name })
+		return plans, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret.([]store.Plan), nil
+}
+
+// planRateLimits returns the rate limits selected in tx by query with
+// args, by plan.
+func (s *SQLStore) planRateLimits(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (map[string][]store.RateLimit, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select rate limits of plans: %w", err)
+	}
+	defer rows.Close()
+	limits := make(map[string][]store.RateLimit)
+	for rows.Next() {
+		var (
+			plan     string
+			windowMs int64
+			l        store.RateLimit
+		)
+		if err := rows.Scan(&plan, &windowMs, &l.Bytes); err != nil {
+			return nil, fmt.Errorf("parse rate limit of plans: %w", err)
+		}
+		l.Window = time.Duration(windowMs) * time.Millisecond
+		limits[plan] = append(limits[plan], l)
+	}
+	return limits, rows.Err()
+}
+
+func (s *SQLStore) DeletePlan(ctx context.Context, name string) error {
+	_, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		res, err := tx.ExecContext(ctx, s.q.deletePlan, name)
+		if err != nil {
+			return nil, fmt.Errorf("delete plan %s: %w", name, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("plan %s: %w", name, store.ErrNotFound)
+		}
+		if _, err := tx.ExecContext(ctx, s.q.deletePlanRateLimits, name); err != nil {
+			return nil, fmt.Errorf("delete rate limits of plan %s: %w", name, err)
+		}
+		if _, err := tx.ExecContext(ctx, s.q.unassignPlanKeys, name); err != nil {
+			return nil, fmt.Errorf("unassign keys of plan %s: %w", name, err)
+		}
+		return nil, nil
+	})
+	return err
+}
+
+func (s *SQLStore) AssignPlan(ctx context.Context, key, name string) error {
+	_, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		var quotaBytes, quotaObjects *int64
+		err := tx.QueryRowContext(ctx, s.q.getPlan, name).Scan(&quotaBytes, &quotaObjects)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("plan %s: %w", name, store.ErrNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get plan %s: %w", name, err)
+		}
+		// Keys exist by their usage.
+		if _, err := tx.ExecContext(ctx, s.q.add, key, 0); err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, s.q.assignPlan, key, name)
+		return nil, err
+	})
+	return err
+}
+
+func (s *SQLStore) UnassignPlan(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.q.unassignPlan, key)
+	return err
+}
+
+func (s *SQLStore) SetBlocked(ctx context.Context, key string, blocked bool) error {
+	var err error
+	if blocked {
+		_, err = s.db.ExecContext(ctx, s.q.setBlocked, key, time.Now().UnixMilli())
+	} else {
+		_, err = s.db.ExecContext(ctx, s.q.unsetBlocked, key)
+	}
+	return err
+}
+
+func (s *SQLStore) ListBlocked(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.q.listBlocked)
+	if err != nil {
+		return nil, fmt.Errorf("list blocked keys: %w", err)
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("scan blocked key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// quotaCheck returns the QuotaCheck for growing key by numBytes at now.
+func (s *SQLStore) quotaCheck(ctx context.Context, tx *sql.Tx, key string, numBytes int64, now time.Time) (store.QuotaCheck, error) {
+	info := store.Info{QuotaBytes: s.DefaultQuotaBytes, QuotaObjects: s.DefaultQuotaObjects}
+	row := tx.QueryRowContext(ctx, s.q.getInfo, s.DefaultQuotaBytes, s.DefaultQuotaObjects, key)
+	err := row.Scan(&info.UsageBytes, &info.QuotaBytes, &info.ObjectCount, &info.QuotaObjects, &info.Plan)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return store.QuotaCheck{}, fmt.Errorf("get info: %w", err)
+	}
+	var reserved int64
+	row = tx.QueryRowContext(ctx, s.q.reserved, key, now.UnixMilli())
+	if err := row.Scan(&reserved); err != nil {
+		return store.QuotaCheck{}, fmt.Errorf("get reserved bytes: %w", err)
+	}
+	return store.NewQuotaCheck(info, reserved, numBytes), nil
+}
+
+func (s *SQLStore) CheckQuota(ctx context.Context, key string, numBytes int64) (store.QuotaCheck, error) {
+	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		return s.quotaCheck(ctx, tx, key, numBytes, time.Now())
+	})
+	if err != nil {
+		return store.QuotaCheck{}, err
+	}
+	return ret.(store.QuotaCheck), nil
+}
+
+func (s *SQLStore) Reserve(ctx context.Context, r store.Reservation) (store.Reservation, error) {
+	if r.ID == "" {
+		r.ID = store.NewReservationID()
+	}
+	ok, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		// Adding nothing locks the usage row, so concurrent
+		// reservations on the same key cannot both fit.
+		if _, err := tx.ExecContext(ctx, s.q.add, r.Key, 0); err != nil {
+			return nil, fmt.Errorf("lock key: %w", err)
+		}
+		check, err := s.quotaCheck(ctx, tx, r.Key, r.SizeBytes, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if !check.Allowed {
+			return false, nil
+		}
+		_, err = tx.ExecContext(ctx, s.q.reserve, r.ID, r.Key, r.Path, r.SizeBytes, r.ExpiresAt.UnixMilli())
+		if err != nil {
+			return nil, fmt.Errorf("insert reservation: %w", err)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return store.Reservation{}, err
+	}
+	if !ok.(bool) {
+		return store.Reservation{}, store.ErrQuotaExceeded
+	}
+	return r, nil
+}
+
+func (s *SQLStore) ReleaseReservation(ctx context.Context, id string) error {
+	n, err := s.execCount(ctx, s.q.releaseReservation, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("reservation %s: %w", id, store.ErrNotFound)
+	}
+	return nil
+}
+
+func (s *SQLStore) ReleaseReservations(ctx context.Context, key, path string) (int, error) {
+	return s.execCount(ctx, s.q.releaseReservations, key, path)
+}
+
+func (s *SQLStore) SweepReservations(ctx context.Context, now time.Time) (int, error) {
+	return s.execCount(ctx, s.q.sweepReservations, now.UnixMilli())
+}
+
+// execCount executes query with args and returns the number of rows it
+// affected.
+func (s *SQLStore) execCount(ctx context.Context, query string, args ...interface{}) (int, error) {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLStore) List(ctx context.Context, after string, limit int) ([]store.Record, error) {
+	rows, err := s.db.QueryContext(ctx, s.q.list, s.DefaultQuotaBytes, s.DefaultQuotaObjects, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("select keys: %w", err)
+	}
+	defer rows.Close()
+	var records []store.Record
+	for rows.Next() {
+		var r store.Record
+		if err := rows.Scan(&r.Key, &r.Info.UsageBytes, &r.Info.QuotaBytes, &r.Info.ObjectCount, &r.Info.QuotaObjects, &r.Info.Plan); err != nil {
+			return nil, fmt.Errorf("parse result #%d: %w", len(records)+1, err)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
 func (s *SQLStore) GetExceeded(ctx context.Context) ([]store.Record, error) {
 	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
-		rows, err := tx.QueryContext(ctx, `
-			SELECT key, size_bytes, quota FROM (
-				SELECT key, size_bytes, COALESCE(quota, $1) quota FROM usage
-			) s WHERE size_bytes > quota`,
-			s.DefaultQuotaBytes)
+		rows, err := tx.QueryContext(ctx, s.q.getExceeded, s.DefaultQuotaBytes, s.DefaultQuotaObjects)
 		if err != nil {
 			return nil, fmt.Errorf("select keys over quota: %w", err)
 		}
 		var records []store.Record
 		for rows.Next() {
 			var r store.Record
-			if err := rows.Scan(&r.Key, &r.Info.UsageBytes, &r.Info.QuotaBytes); err != nil {
+			if err := rows.Scan(&r.Key, &r.Info.UsageBytes, &r.Info.QuotaBytes, &r.Info.ObjectCount, &r.Info.QuotaObjects, &r.Info.Plan); err != nil {
 				return nil, fmt.Errorf("parse result #%d: %w", len(records)+1, err)
 			}
+			r.Exceeded = r.Info.Exceeded()
 			records = append(records, r)
 		}
 		if err := rows.Close(); err != nil {
 			return nil, fmt.Errorf("close query with #%d results: %w", len(records), err)
 		}
+		// Sort here rather than in SQL: databases disagree on how to
+		// collate keys.
+		sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
 		return records, nil
 	})
 	if err != nil {
@@ -140,3 +1135,192 @@ func (s *SQLStore) GetExceeded(ctx context.Context) ([]store.Record, error) {
 	}
 	return ret.([]store.Record), nil
 }
+
+func (s *SQLStore) AppendAudit(ctx context.Context, e store.AuditEntry) error {
+	_, err := s.db.ExecContext(ctx, s.q.appendAudit,
+		e.Time.UnixMilli(), e.Actor, e.Action, e.Key, e.OldValue, e.NewValue, e.Detail)
+	return err
+}
+
+func (s *SQLStore) ListAudit(ctx context.Context, f store.AuditFilter) ([]store.AuditEntry, error) {
+	var since, until, limit int64 = math.MinInt64, math.MaxInt64, math.MaxInt64
+	if !f.Since.IsZero() {
+		since = f.Since.UnixMilli()
+	}
+	if !f.Until.IsZero() {
+		until = f.Until.UnixMilli()
+	}
+	if f.Limit > 0 {
+		limit = int64(f.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, s.q.listAudit, f.Key, f.Key, since, until, limit)
+	if err != nil {
+		return nil, fmt.Errorf("select audit entries: %w", err)
+	}
+	defer rows.Close()
+	var entries []store.AuditEntry
+	for rows.Next() {
+		var (
+			e  store.AuditEntry
+			at int64
+		)
+		if err := rows.Scan(&e.ID, &at, &e.Actor, &e.Action, &e.Key, &e.OldValue, &e.NewValue, &e.Detail); err != nil {
+			return nil, fmt.Errorf("parse audit entry #%d: %w", len(entries)+1, err)
+		}
+		e.Time = time.UnixMilli(at)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLStore) RecordHistory(ctx context.Context, at time.Time) (int, error) {
+	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		if _, err := tx.ExecContext(ctx, s.q.deleteHistoryAt, at.UnixMilli()); err != nil {
+			return 0, fmt.Errorf("delete history at %s: %w", at, err)
+		}
+		res, err := tx.ExecContext(ctx, s.q.recordHistory, at.UnixMilli())
+		if err != nil {
+			return 0, fmt.Errorf("record history at %s: %w", at, err)
+		}
+		n, err := res.RowsAffected()
+		return int(n), err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return ret.(int), nil
+}
+
+func (s *SQLStore) GetHistory(ctx context.Context, key string, from, to time.Time) ([]store.HistoryPoint, error) {
+	rows, err := s.db.QueryContext(ctx, s.q.getHistory, key, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("select history: %w", err)
+	}
+	defer rows.Close()
+	var points []store.HistoryPoint
+	for rows.Next() {
+		var (
+			p  store.HistoryPoint
+			at int64
+		)
+		if err := rows.Scan(&at, &p.UsageBytes); err != nil {
+			return nil, fmt.Errorf("parse history point #%d: %w", len(points)+1, err)
+		}
+		p.Time = time.UnixMilli(at)
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func (s *SQLStore) DeleteHistory(ctx context.Context, before time.Time) (int, error) {
+	return s.execCount(ctx, s.q.deleteHistory, before.UnixMilli())
+}
+
+// DownsampleHistory deletes history one recording time at a time.  Usage
+// is recorded for all keys at once, so this is as if by key.
+func (s *SQLStore) DownsampleHistory(ctx context.Context, before time.Time, step time.Duration) (int, error) {
+	rows, err := s.db.QueryContext(ctx, s.q.historyTimes, before.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("select history times: %w", err)
+	}
+	var times []time.Time
+	for rows.Next() {
+		var at int64
+		if err := rows.Scan(&at); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("parse history time #%d: %w", len(times)+1, err)
+		}
+		times = append(times, time.UnixMilli(at))
+	}
+	if err := rows.Close(); err != nil {
+		return 0, fmt.Errorf("close history times: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("select history times: %w", err)
+	}
+
+	n := 0
+	for _, t := range store.DownsampleTimes(times, step) {
+		deleted, err := s.execCount(ctx, s.q.deleteHistoryAt, t.UnixMilli())
+		n += deleted
+		if err != nil {
+			return n, fmt.Errorf("delete history at %s: %w", t, err)
+		}
+	}
+	return n, nil
+}
+
+func (s *SQLStore) ByteHours(ctx context.Context, from, to time.Time) ([]store.UsageIntegral, error) {
+	fromMillis, toMillis := from.UnixMilli(), to.UnixMilli()
+	rows, err := s.db.QueryContext(ctx, s.q.byteMillis, toMillis, fromMillis, fromMillis, toMillis)
+	if err != nil {
+		return nil, fmt.Errorf("integrate ledger: %w", err)
+	}
+	defer rows.Close()
+	var integrals []store.UsageIntegral
+	for rows.Next() {
+		var (
+			key        string
+			byteMillis float64
+		)
+		if err := rows.Scan(&key, &byteMillis); err != nil {
+			return nil, fmt.Errorf("parse integral #%d: %w", len(integrals)+1, err)
+		}
+		if byteMillis != 0 {
+			integrals = append(integrals, store.UsageIntegral{Key: key, ByteHours: byteMillis / float64(time.Hour/time.Millisecond)})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Sort here rather than in SQL: databases disagree on how to collate
+	// keys.
+	sort.Slice(integrals, func(i, j int) bool { return integrals[i].Key < integrals[j].Key })
+	return integrals, nil
+}
+
+func (s *SQLStore) CompactLedger(ctx context.Context, before time.Time) (int, error) {
+	ret, err := s.transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		rows, err := tx.QueryContext(ctx, s.q.ledgerSums, before.UnixMilli())
+		if err != nil {
+			return 0, fmt.Errorf("sum ledger: %w", err)
+		}
+		sums := make(map[string]int64)
+		for rows.Next() {
+			var (
+				key string
+				sum int64
+			)
+			if err := rows.Scan(&key, &sum); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("parse ledger sum #%d: %w", len(sums)+1, err)
+			}
+			sums[key] = sum
+		}
+		if err := rows.Close(); err != nil {
+			return 0, fmt.Errorf("close ledger sums: %w", err)
+		}
+		res, err := tx.ExecContext(ctx, s.q.deleteLedger, before.UnixMilli())
+		if err != nil {
+			return 0, fmt.Errorf("delete ledger: %w", err)
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		n := int(deleted)
+		for key, sum := range sums {
+			if err := s.appendLedger(ctx, tx, key, sum, before); err != nil {
+				return 0, err
+			}
+			if sum != 0 {
+				n--
+			}
+		}
+		return n, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return ret.(int), nil
+}
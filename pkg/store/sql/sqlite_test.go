@@ -0,0 +1,44 @@
+package sql_test
+
+import (
+	"context"
+	dbsql "database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/treeverse/terminus/pkg/store"
+	"github.com/treeverse/terminus/pkg/store/sql"
+	"github.com/treeverse/terminus/pkg/store/storetest"
+)
+
+func newSQLiteStore(t *testing.T, defaultQuotaBytes int64) store.Store {
+	dialect, err := sql.DialectByName("sqlite")
+	if err != nil {
+		t.Fatalf("Get dialect: %s", err)
+	}
+	dsn := "file:" + filepath.Join(t.TempDir(), "terminus.db") + "?_pragma=busy_timeout(5000)"
+	sqliteDB, err := dbsql.Open(dialect.DriverName(), dsn)
+	if err != nil {
+		t.Fatalf("Open SQLite %s: %s", dsn, err)
+	}
+	sqliteDB.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		if err := sqliteDB.Close(); err != nil {
+			t.Errorf("Close SQLite: %s", err)
+		}
+	})
+	if err = sql.CreateSchema(context.Background(), sqliteDB, dialect); err != nil {
+		t.Fatalf("Create DB schema: %s", err)
+	}
+	s, err := sql.NewSQLStore(sqliteDB, dialect, defaultQuotaBytes)
+	if err != nil {
+		t.Fatalf("Open SQL store: %s", err)
+	}
+	return s
+}
+
+func TestSQLite(t *testing.T) {
+	storetest.Run(t, newSQLiteStore)
+}
@@ -5,40 +5,274 @@ package store
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 )
 
 type Value struct {
 	SizeBytes int64
 }
 
+// DefaultStorageClass is the S3 storage class of objects that specify
+// none.
+const DefaultStorageClass = "STANDARD"
+
 var (
 	ErrNotFound      = errors.New("not found")
 	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrQuotaGrace is ErrQuotaExceeded of a key within its grace
+	// period.
+	ErrQuotaGrace = fmt.Errorf("%w, in grace period", ErrQuotaExceeded)
+)
+
+// Limits that keys may exceed.
+const (
+	LimitBytes   = "bytes"
+	LimitObjects = "objects"
+	// LimitDollars is the cost quota of a cost.Store.
+	LimitDollars = "dollars"
+	// LimitRate is a rate quota of a rate.Store.
+	LimitRate = "rate"
 )
 
 // Info holds information about a key.
 type Info struct {
 	UsageBytes int64
 	QuotaBytes int64
+	// ObjectCount is the number of objects of the key.
+	ObjectCount int64
+	// QuotaObjects is the quota of objects of the key, or 0 if it has
+	// none.
+	QuotaObjects int64
+	// Plan is the name of the plan of the key, or empty if it has none.
+	Plan string
+}
+
+// Exceeded returns the limits of Info that are exceeded.
+func (i Info) Exceeded() []string {
+	var limits []string
+	if i.UsageBytes > i.QuotaBytes {
+		limits = append(limits, LimitBytes)
+	}
+	if i.QuotaObjects > 0 && i.ObjectCount > i.QuotaObjects {
+		limits = append(limits, LimitObjects)
+	}
+	return limits
 }
 
 // Record associates a key with its Info
 type Record struct {
 	Key  string
 	Info Info
+	// Exceeded are the limits that the key exceeds.  Only GetExceeded
+	// sets it.
+	Exceeded []string
+	// ExceededSince is when the key started to exceed quota, and
+	// InGrace is true while it is within its grace period since.  Only
+	// GetExceeded of a grace.Store sets them.  Keys in grace are not
+	// enforced.
+	ExceededSince *time.Time
+	InGrace       bool
+}
+
+// Object is an S3 object counted toward the usage of a key.
+type Object struct {
+	Path string
+	// VersionID is the S3 version of the object, or empty in a bucket
+	// without versioning.
+	VersionID    string
+	SizeBytes    int64
+	StorageClass string
+}
+
+// QuotaCheck is the result of checking whether a key may grow.
+type QuotaCheck struct {
+	// Allowed is true if the key may grow by the checked number of
+	// bytes without exceeding its quota.
+	Allowed bool
+	Info    Info
+	// ReservedBytes is the number of bytes reserved for uploads to the
+	// key that have not yet completed.
+	ReservedBytes int64
+	// RemainingBytes is the number of bytes by which the key may still
+	// grow, or 0 if it is already over quota.
+	RemainingBytes int64
+	// InGrace is true if the key may not grow but is within its grace
+	// period, so that growing is not refused yet.  Only CheckQuota of a
+	// grace.Store sets it.
+	InGrace bool
+}
+
+// NewQuotaCheck returns the QuotaCheck for growing a key with info and
+// reservedBytes by numBytes.  A key over its quota of objects may not
+// grow.
+func NewQuotaCheck(info Info, reservedBytes, numBytes int64) QuotaCheck {
+	remaining := info.QuotaBytes - info.UsageBytes - reservedBytes
+	if remaining < 0 {
+		remaining = 0
+	}
+	objectsAllowed := info.QuotaObjects == 0 || info.ObjectCount <= info.QuotaObjects
+	return QuotaCheck{
+		Allowed:        objectsAllowed && info.UsageBytes+reservedBytes+numBytes <= info.QuotaBytes,
+		Info:           info,
+		ReservedBytes:  reservedBytes,
+		RemainingBytes: remaining,
+	}
 }
 
-// Store holds per-key usage and configured quota.
+// Store holds per-key usage and configured quota.  Reservations count
+// toward quota when checking whether a key may grow, but keys only exceed
+// quota by their actual usage.
 type Store interface {
 	// Get returns the value associated with key.
 	Get(ctx context.Context, key string) (Value, error)
 	// Set associates value with key and returns ErrQuotaExceeded if
-	// that key exceeds quota.
+	// that key exceeds quota.  It records the change of usage on the
+	// ledger at the current time.
 	Set(ctx context.Context, key string, value Value) error
 	// AddSizeBytes adds to the SizeBytes field of the Value associated
 	// with key and returns ErrQuotaExceeded if that key exceeds quota.
-	// It creates a new blank Value if needed.
-	AddSizeBytes(ctx context.Context, key string, numBytes int64) error
-	// GetExceeded returns information about quota usage of all keys exceeding quota.
+	// It creates a new blank Value if needed.  It records the change on
+	// the ledger at time at, when it happened.
+	AddSizeBytes(ctx context.Context, key string, numBytes int64, at time.Time) error
+	// AddClassSizeBytes is AddSizeBytes that also adds numBytes to the
+	// usage of key in storageClass.
+	AddClassSizeBytes(ctx context.Context, key, storageClass string, numBytes int64, at time.Time) error
+	// PutObject adds o to the usage of key, and counts it as an object
+	// of key.  It first removes any object at the same path and
+	// version, so that overwriting an object does not count it twice.
+	// It also counts o.SizeBytes as ingested by key at at, even when
+	// overwriting.  It returns ErrQuotaExceeded if key exceeds quota.
+	PutObject(ctx context.Context, key string, o Object, at time.Time) error
+	// DeleteObject removes the object at path and versionID from the
+	// usage and objects of its key, and returns that key.  It returns
+	// ErrNotFound if no such object was put, or ErrQuotaExceeded if the
+	// key still exceeds quota.
+	DeleteObject(ctx context.Context, path, versionID string, at time.Time) (string, error)
+	// TransitionObject moves the object at path and versionID to
+	// storageClass, and returns its key.  Its bytes count toward
+	// storageClass instead of its previous class, and the usage of its
+	// key is unchanged.  It returns ErrNotFound if no such object was
+	// put, or ErrQuotaExceeded if the key exceeds quota.
+	TransitionObject(ctx context.Context, path, versionID, storageClass string) (string, error)
+	// GetClassUsage returns the usage of key by storage class.  It only
+	// counts usage added by AddClassSizeBytes, so the total may differ
+	// from the usage of key.
+	GetClassUsage(ctx context.Context, key string) (map[string]int64, error)
+	// SetQuota sets the quota of key to quotaBytes.  It creates key
+	// with no usage if needed.
+	SetQuota(ctx context.Context, key string, quotaBytes int64) error
+	// ClearQuota removes any quota set on key, which then uses the
+	// default quota.
+	ClearQuota(ctx context.Context, key string) error
+	// SetObjectQuota sets the quota of objects of key to quotaObjects,
+	// or to none if it is 0.  It creates key with no usage if needed.
+	SetObjectQuota(ctx context.Context, key string, quotaObjects int64) error
+	// ClearObjectQuota removes any quota of objects set on key, which
+	// then uses the default quota of objects.
+	ClearObjectQuota(ctx context.Context, key string) error
+	// SetGracePeriod sets the grace period of key, for which it may
+	// exceed quota before it is enforced.  It creates key with no usage
+	// if needed.
+	SetGracePeriod(ctx context.Context, key string, gracePeriod time.Duration) error
+	// ClearGracePeriod removes any grace period set on key, which then
+	// uses the default grace period.
+	ClearGracePeriod(ctx context.Context, key string) error
+	// GetGracePeriod returns the grace period set on key, or
+	// ErrNotFound if it has none.
+	GetGracePeriod(ctx context.Context, key string) (time.Duration, error)
+	// MarkExceeded records whether key exceeds quota at at.  It returns
+	// the time since which key has exceeded quota without a break,
+	// which is at if it just started, or the zero time if it does not.
+	MarkExceeded(ctx context.Context, key string, exceeded bool, at time.Time) (time.Time, error)
+	// GetExceededSince returns the time since which key has exceeded
+	// quota without a break, or ErrNotFound if it is not marked as
+	// exceeding.
+	GetExceededSince(ctx context.Context, key string) (time.Time, error)
+	// MarkAllExceeded records that keys and no others exceed quota at
+	// at.  It returns the times since which they have exceeded quota
+	// without a break, by key.
+	MarkAllExceeded(ctx context.Context, keys []string, at time.Time) (map[string]time.Time, error)
+	// SetPlan creates or replaces the plan p.Name.  It returns
+	// ErrBadPlan if p is not valid.
+	SetPlan(ctx context.Context, p Plan) error
+	// GetPlan returns the plan name, or ErrNotFound.
+	GetPlan(ctx context.Context, name string) (Plan, error)
+	// ListPlans returns all plans, in order of name.
+	ListPlans(ctx context.Context) ([]Plan, error)
+	// DeletePlan deletes the plan name, or returns ErrNotFound.  Keys
+	// on it are left on no plan.
+	DeletePlan(ctx context.Context, name string) error
+	// AssignPlan puts key on the plan name, or returns ErrNotFound if
+	// there is no such plan.  It creates key with no usage if needed.
+	AssignPlan(ctx context.Context, key, name string) error
+	// UnassignPlan removes key from any plan.
+	UnassignPlan(ctx context.Context, key string) error
+	// CheckQuota returns whether key may grow by numBytes without
+	// exceeding its quota, counting unexpired reservations.  A missing
+	// key has no usage and the default quota.
+	CheckQuota(ctx context.Context, key string, numBytes int64) (QuotaCheck, error)
+	// Reserve reserves r.SizeBytes of the quota of r.Key until
+	// r.ExpiresAt.  It returns ErrQuotaExceeded without reserving if
+	// the key cannot grow by that much.  It assigns an ID if r has
+	// none, and returns the new reservation.  It creates r.Key with no
+	// usage if needed.
+	Reserve(ctx context.Context, r Reservation) (Reservation, error)
+	// ReleaseReservation releases the reservation with id, or returns
+	// ErrNotFound.
+	ReleaseReservation(ctx context.Context, id string) error
+	// ReleaseReservations releases all reservations of key for path,
+	// and returns how many it released.
+	ReleaseReservations(ctx context.Context, key, path string) (int, error)
+	// SweepReservations releases all reservations that expired by now,
+	// and returns how many it released.
+	SweepReservations(ctx context.Context, now time.Time) (int, error)
+	// AppendAudit appends e to the audit log, assigning its ID.  It is
+	// not atomic with the change that e records, see package audit.
+	AppendAudit(ctx context.Context, e AuditEntry) error
+	// ListAudit returns audit entries matching f, in order of
+	// appending.
+	ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, error)
+	// RecordHistory records the usage of every key at time at,
+	// replacing any usage recorded at that time, and returns the number
+	// of keys recorded.
+	RecordHistory(ctx context.Context, at time.Time) (int, error)
+	// GetHistory returns the usage of key recorded at times in [from,
+	// to), in order of time.
+	GetHistory(ctx context.Context, key string, from, to time.Time) ([]HistoryPoint, error)
+	// DeleteHistory deletes usage recorded before before, and returns
+	// the number of points it deleted.
+	DeleteHistory(ctx context.Context, before time.Time) (int, error)
+	// DownsampleHistory deletes usage recorded before before, except at
+	// the last time recorded in each interval of step as
+	// DownsampleTimes, and returns the number of points it deleted.
+	DownsampleHistory(ctx context.Context, before time.Time, step time.Duration) (int, error)
+	// ByteHours returns the integral of usage over [from, to) of every
+	// key with usage then, from the ledger, in order of key.
+	ByteHours(ctx context.Context, from, to time.Time) ([]UsageIntegral, error)
+	// CompactLedger merges changes on the ledger before before into a
+	// single change per key at before, and returns the number of
+	// changes it removed.  ByteHours of periods that start before
+	// before are then wrong.
+	CompactLedger(ctx context.Context, before time.Time) (int, error)
+	// GetIngest returns the number of bytes ingested by key in
+	// IngestBuckets that start in [from, to).
+	GetIngest(ctx context.Context, key string, from, to time.Time) (int64, error)
+	// ListIngest returns the bytes ingested by every key that ingested
+	// any in IngestBuckets that start in [from, to), sorted by key.
+	ListIngest(ctx context.Context, from, to time.Time) ([]Ingest, error)
+	// DeleteIngest deletes ingest counters of IngestBuckets that start
+	// before before, and returns the number of counters it deleted.
+	DeleteIngest(ctx context.Context, before time.Time) (int, error)
+	// SetBlocked records whether enforcement blocks key, so that it
+	// can unblock key after a restart.
+	SetBlocked(ctx context.Context, key string, blocked bool) error
+	// ListBlocked returns the keys that enforcement blocks, sorted.
+	ListBlocked(ctx context.Context) ([]string, error)
+	// List returns up to limit records of keys after the key after, in
+	// order of key.
+	List(ctx context.Context, after string, limit int) ([]Record, error)
+	// GetExceeded returns information about quota usage of all keys
+	// exceeding quota of bytes or of objects, and which, sorted by key.
 	GetExceeded(ctx context.Context) ([]Record, error)
 }
@@ -0,0 +1,1334 @@
+// Package storetest provides a conformance test suite for implementations
+// of store.Store.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/treeverse/terminus/pkg/store"
+)
+
+const (
+	// DefaultQuota is the default quota of stores under test.
+	DefaultQuota = 50
+
+	testTimeout = 5 * time.Second
+)
+
+// Factory returns a new empty store.Store with default quota
+// defaultQuotaBytes.  It should register any cleanup on t.
+type Factory func(t *testing.T, defaultQuotaBytes int64) store.Store
+
+// Run runs all conformance tests on stores returned by newStore.  Every
+// test gets a new store.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		Name string
+		Test func(t *testing.T, newStore Factory)
+	}{
+		{"Set", testSet},
+		{"SetGet", testSetGet},
+		{"AddSizeBytes", testAddSizeBytes},
+		{"NegativeDeltas", testNegativeDeltas},
+		{"ConcurrentAddSizeBytes", testConcurrentAddSizeBytes},
+		{"QuotaBoundary", testQuotaBoundary},
+		{"DefaultQuotaFallback", testDefaultQuotaFallback},
+		{"CheckQuota", testCheckQuota},
+		{"Exceeded", testExceeded},
+		{"ExceededOrdering", testExceededOrdering},
+		{"List", testList},
+		{"Reserve", testReserve},
+		{"ReleaseReservations", testReleaseReservations},
+		{"SweepReservations", testSweepReservations},
+		{"Audit", testAudit},
+		{"History", testHistory},
+		{"Ledger", testLedger},
+		{"ClassUsage", testClassUsage},
+		{"Objects", testObjects},
+		{"TransitionObject", testTransitionObject},
+		{"ObjectQuota", testObjectQuota},
+		{"Ingest", testIngest},
+		{"GracePeriod", testGracePeriod},
+		{"MarkExceeded", testMarkExceeded},
+		{"Plans", testPlans},
+		{"Blocked", testBlocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) { tt.Test(t, newStore) })
+	}
+}
+
+func value(sizeBytes int64) store.Value {
+	return store.Value{SizeBytes: sizeBytes}
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// expectSize fails t unless key has sizeBytes on s.
+func expectSize(ctx context.Context, t *testing.T, s store.Store, key string, sizeBytes int64) {
+	t.Helper()
+	v, err := s.Get(ctx, key)
+	if err != nil {
+		t.Errorf("Get %s: %s", key, err)
+		return
+	}
+	if v.SizeBytes != sizeBytes {
+		t.Errorf("Get %s: Got %v expected %d", key, v, sizeBytes)
+	}
+}
+
+// bytesExceeded are the limits exceeded by keys over their quota of bytes.
+var bytesExceeded = []string{store.LimitBytes}
+
+// expectExceeded fails t unless GetExceeded on s returns exactly expected.
+func expectExceeded(ctx context.Context, t *testing.T, s store.Store, expected []store.Record) {
+	t.Helper()
+	exceeded, err := s.GetExceeded(ctx)
+	if err != nil {
+		t.Fatalf("Get quota exceeded: %s", err)
+	}
+	if diffs := deep.Equal(exceeded, expected); diffs != nil {
+		t.Error("Unexpected results for GetExceeded ", diffs)
+		t.Log("Got:", exceeded)
+		t.Log("Expected:", expected)
+	}
+}
+
+func testSet(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	key := "set:a"
+	cases := []struct {
+		Name      string
+		Key       string
+		SizeBytes int64
+		Err       error
+	}{
+		{"Zero", key, 0, nil},
+		{"OK", key, DefaultQuota * 3 / 4, nil},
+		{"Exceeded", key, DefaultQuota + 1, store.ErrQuotaExceeded},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			err := s.Set(ctx, c.Key, value(c.SizeBytes))
+			if !errors.Is(err, c.Err) {
+				t.Errorf("Set %s: Expected error %s, got %s", key, c.Err, err)
+			}
+		})
+	}
+}
+
+func testSetGet(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	key := "set:a"
+	expected := value(17)
+	err := s.Set(ctx, key, expected)
+	if err != nil {
+		t.Errorf("Set %s: %s", key, err)
+	}
+
+	cases := []struct {
+		Name     string
+		Key      string
+		Expected *store.Value
+		Err      error
+	}{
+		{"Found key", key, &expected, nil},
+		{"Missing key", key + "-missing", nil, store.ErrNotFound},
+		{"Empty key", "", nil, store.ErrNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			actual, err := s.Get(ctx, c.Key)
+			if !errors.Is(err, c.Err) {
+				t.Errorf("Get %s: Expected error %s, got %s", key, c.Err, err)
+			}
+			if c.Expected != nil {
+				if diffs := deep.Equal(c.Expected, &actual); diffs != nil {
+					t.Errorf("Get %s: wrong values: %s", key, diffs)
+				}
+			}
+		})
+	}
+}
+
+func testAddSizeBytes(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	keyInitialized, keyUsed := "add:initialized", "add:used"
+	if err := s.Set(ctx, keyInitialized, value(1)); err != nil {
+		t.Fatalf("Set %s: %s", keyInitialized, err)
+	}
+
+	// Not table-driven cases -- the sequence is important here to keep
+	// developing the state.
+
+	if err := s.AddSizeBytes(ctx, keyUsed, 2, time.Now()); err != nil {
+		t.Errorf("AddSizeBytes %s: %s", keyUsed, err)
+	}
+	expectSize(ctx, t, s, keyInitialized, 1)
+	expectSize(ctx, t, s, keyUsed, 2)
+
+	if err := s.AddSizeBytes(ctx, keyInitialized, 3, time.Now()); err != nil {
+		t.Errorf("AddSizeBytes %s: %s", keyInitialized, err)
+	}
+	expectSize(ctx, t, s, keyInitialized, 4)
+
+	if err := s.AddSizeBytes(ctx, keyUsed, 4, time.Now()); err != nil {
+		t.Errorf("AddSizeBytes %s: %s", keyUsed, err)
+	}
+
+	if err := s.AddSizeBytes(ctx, keyUsed, DefaultQuota, time.Now()); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("AddSizeBytes %s: expected quota exceeded, got %s", keyUsed, err)
+	}
+	expectSize(ctx, t, s, keyUsed, DefaultQuota+6)
+}
+
+func testNegativeDeltas(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	const key = "negative"
+	if err := s.AddSizeBytes(ctx, key, DefaultQuota+10, time.Now()); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("AddSizeBytes %s: expected quota exceeded, got %s", key, err)
+	}
+	if err := s.AddSizeBytes(ctx, key, -20, time.Now()); err != nil {
+		t.Errorf("AddSizeBytes %s back under quota: %s", key, err)
+	}
+	expectSize(ctx, t, s, key, DefaultQuota-10)
+	expectExceeded(ctx, t, s, nil)
+
+	// A negative delta on a new key creates it.
+	const newKey = "negative: new"
+	if err := s.AddSizeBytes(ctx, newKey, -5, time.Now()); err != nil {
+		t.Errorf("AddSizeBytes %s: %s", newKey, err)
+	}
+	expectSize(ctx, t, s, newKey, -5)
+}
+
+func testConcurrentAddSizeBytes(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	const (
+		key         = "concurrent"
+		parallelism = 8
+		numAdds     = 25
+		delta       = 3
+	)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, parallelism*numAdds)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numAdds; j++ {
+				err := s.AddSizeBytes(ctx, key, delta, time.Now())
+				if err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("AddSizeBytes %s: %s", key, err)
+	}
+	expectSize(ctx, t, s, key, parallelism*numAdds*delta)
+}
+
+func testQuotaBoundary(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	const key = "boundary"
+	if err := s.Set(ctx, key, value(DefaultQuota)); err != nil {
+		t.Errorf("Set %s to exactly quota: %s", key, err)
+	}
+	if err := s.AddSizeBytes(ctx, key, 1, time.Now()); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("AddSizeBytes %s over quota: expected quota exceeded, got %s", key, err)
+	}
+	// Still exceeded on further changes.
+	if err := s.AddSizeBytes(ctx, key, 0, time.Now()); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("AddSizeBytes %s while over quota: expected quota exceeded, got %s", key, err)
+	}
+}
+
+func testDefaultQuotaFallback(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	const (
+		key           = "fallback"
+		specificQuota = 2 * DefaultQuota
+	)
+	if err := s.SetQuota(ctx, key, specificQuota); err != nil {
+		t.Fatalf("SetQuota %s: %s", key, err)
+	}
+	// SetQuota creates the key with no usage.
+	expectSize(ctx, t, s, key, 0)
+
+	if err := s.Set(ctx, key, value(DefaultQuota+1)); err != nil {
+		t.Errorf("Set %s under specific quota: %s", key, err)
+	}
+	expectExceeded(ctx, t, s, nil)
+
+	if err := s.ClearQuota(ctx, key); err != nil {
+		t.Fatalf("ClearQuota %s: %s", key, err)
+	}
+	expectExceeded(ctx, t, s, []store.Record{
+		{Key: key, Info: store.Info{UsageBytes: DefaultQuota + 1, QuotaBytes: DefaultQuota}, Exceeded: bytesExceeded},
+	})
+	if err := s.AddSizeBytes(ctx, key, 0, time.Now()); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("AddSizeBytes %s after ClearQuota: expected quota exceeded, got %s", key, err)
+	}
+
+	// Lowering a quota makes a key exceed it.
+	if err := s.SetQuota(ctx, key, 1); err != nil {
+		t.Fatalf("SetQuota %s: %s", key, err)
+	}
+	expectExceeded(ctx, t, s, []store.Record{
+		{Key: key, Info: store.Info{UsageBytes: DefaultQuota + 1, QuotaBytes: 1}, Exceeded: bytesExceeded},
+	})
+
+	// ClearQuota of a missing key does nothing.
+	if err := s.ClearQuota(ctx, key+"-missing"); err != nil {
+		t.Errorf("ClearQuota missing key: %s", err)
+	}
+}
+
+func testCheckQuota(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	const (
+		keyUsed       = "check: used"
+		keySpecific   = "check: specific quota"
+		keyOver       = "check: over quota"
+		keyMissing    = "check: missing"
+		specificQuota = 2 * DefaultQuota
+	)
+	if err := s.Set(ctx, keyUsed, value(10)); err != nil {
+		t.Fatalf("Set %s: %s", keyUsed, err)
+	}
+	if err := s.SetQuota(ctx, keySpecific, specificQuota); err != nil {
+		t.Fatalf("SetQuota %s: %s", keySpecific, err)
+	}
+	if err := s.Set(ctx, keyOver, value(DefaultQuota+5)); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Fatalf("Set %s: expected quota exceeded, got %s", keyOver, err)
+	}
+
+	cases := []struct {
+		Name     string
+		Key      string
+		Bytes    int64
+		Expected store.QuotaCheck
+	}{
+		{"Used", keyUsed, 30, store.QuotaCheck{Allowed: true, Info: store.Info{UsageBytes: 10, QuotaBytes: DefaultQuota}, RemainingBytes: DefaultQuota - 10}},
+		{"UsedExactlyToQuota", keyUsed, DefaultQuota - 10, store.QuotaCheck{Allowed: true, Info: store.Info{UsageBytes: 10, QuotaBytes: DefaultQuota}, RemainingBytes: DefaultQuota - 10}},
+		{"UsedOverQuota", keyUsed, DefaultQuota - 9, store.QuotaCheck{Allowed: false, Info: store.Info{UsageBytes: 10, QuotaBytes: DefaultQuota}, RemainingBytes: DefaultQuota - 10}},
+		{"SpecificQuota", keySpecific, DefaultQuota + 1, store.QuotaCheck{Allowed: true, Info: store.Info{UsageBytes: 0, QuotaBytes: specificQuota}, RemainingBytes: specificQuota}},
+		{"AlreadyOver", keyOver, 0, store.QuotaCheck{Allowed: false, Info: store.Info{UsageBytes: DefaultQuota + 5, QuotaBytes: DefaultQuota}, RemainingBytes: 0}},
+		{"Missing", keyMissing, DefaultQuota, store.QuotaCheck{Allowed: true, Info: store.Info{UsageBytes: 0, QuotaBytes: DefaultQuota}, RemainingBytes: DefaultQuota}},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			check, err := s.CheckQuota(ctx, c.Key, c.Bytes)
+			if err != nil {
+				t.Fatalf("CheckQuota %s: %s", c.Key, err)
+			}
+			if diffs := deep.Equal(check, c.Expected); diffs != nil {
+				t.Errorf("CheckQuota %s %d: %s", c.Key, c.Bytes, diffs)
+			}
+		})
+	}
+
+	// CheckQuota does not change usage.
+	expectSize(ctx, t, s, keyUsed, 10)
+	if _, err := s.Get(ctx, keyMissing); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get %s after CheckQuota: expected %s, got %v", keyMissing, store.ErrNotFound, err)
+	}
+}
+
+func testExceeded(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	const (
+		keyOKDefault    = "exceeded: ok under default"
+		keyOKSpecific   = "exceeded: ok under specific quota"
+		keyOverDefault  = "exceeded: over default"
+		keyOverSpecific = "exceeded: over specific quota"
+
+		specificQuota = DefaultQuota + 20
+	)
+
+	bytes := []struct {
+		Key   string
+		Bytes int64
+	}{
+		{keyOKDefault, DefaultQuota},
+		{keyOKSpecific, DefaultQuota + 5},
+		{keyOverDefault, DefaultQuota + 10},
+		{keyOverSpecific, specificQuota + 15},
+	}
+
+	// setup quotas
+	for _, key := range []string{keyOKSpecific, keyOverSpecific} {
+		if err := s.SetQuota(ctx, key, specificQuota); err != nil {
+			t.Fatalf("Set specific quota for %s: %s", key, err)
+		}
+	}
+
+	// setup values, ignoring over-quota messages from Set.
+	for _, b := range bytes {
+		if err := s.Set(ctx, b.Key, value(b.Bytes)); err != nil && !errors.Is(err, store.ErrQuotaExceeded) {
+			t.Fatalf("Set %s to %d: %s", b.Key, b.Bytes, err)
+		}
+	}
+
+	expectExceeded(ctx, t, s, []store.Record{
+		{Key: keyOverDefault, Info: store.Info{UsageBytes: DefaultQuota + 10, QuotaBytes: DefaultQuota}, Exceeded: bytesExceeded},
+		{Key: keyOverSpecific, Info: store.Info{UsageBytes: specificQuota + 15, QuotaBytes: specificQuota}, Exceeded: bytesExceeded},
+	})
+}
+
+func testExceededOrdering(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	// Add keys out of order.
+	keys := []string{"m", "z", "a", "b/c", "b", "A"}
+	for i, key := range keys {
+		if err := s.Set(ctx, key, value(DefaultQuota+int64(i)+1)); !errors.Is(err, store.ErrQuotaExceeded) {
+			t.Fatalf("Set %s: expected quota exceeded, got %s", key, err)
+		}
+	}
+	var expected []store.Record
+	for i, key := range keys {
+		expected = append(expected, store.Record{
+			Key:      key,
+			Info:     store.Info{UsageBytes: DefaultQuota + int64(i) + 1, QuotaBytes: DefaultQuota},
+			Exceeded: bytesExceeded,
+		})
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i].Key < expected[j].Key })
+	expectExceeded(ctx, t, s, expected)
+}
+
+func testList(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	// Add keys out of order.
+	keys := []string{"m", "z", "a", "b/c", "b", "A"}
+	for i, key := range keys {
+		if err := s.Set(ctx, key, value(int64(i))); err != nil {
+			t.Fatalf("Set %s: %s", key, err)
+		}
+	}
+	if err := s.SetQuota(ctx, "m", 17); err != nil {
+		t.Fatalf("Set quota of m: %s", err)
+	}
+	record := func(key string, usageBytes, quotaBytes int64) store.Record {
+		return store.Record{Key: key, Info: store.Info{UsageBytes: usageBytes, QuotaBytes: quotaBytes}}
+	}
+
+	cases := []struct {
+		Name     string
+		After    string
+		Limit    int
+		Expected []store.Record
+	}{
+		{"All", "", 10, []store.Record{
+			record("A", 5, DefaultQuota),
+			record("a", 2, DefaultQuota),
+			record("b", 4, DefaultQuota),
+			record("b/c", 3, DefaultQuota),
+			record("m", 0, 17),
+			record("z", 1, DefaultQuota),
+		}},
+		{"First", "", 2, []store.Record{record("A", 5, DefaultQuota), record("a", 2, DefaultQuota)}},
+		{"Page", "a", 2, []store.Record{record("b", 4, DefaultQuota), record("b/c", 3, DefaultQuota)}},
+		{"AfterMissingKey", "c", 10, []store.Record{record("m", 0, 17), record("z", 1, DefaultQuota)}},
+		{"End", "z", 10, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			records, err := s.List(ctx, c.After, c.Limit)
+			if err != nil {
+				t.Fatalf("List after %q: %s", c.After, err)
+			}
+			if len(records) == 0 && len(c.Expected) == 0 {
+				return
+			}
+			if diffs := deep.Equal(records, c.Expected); diffs != nil {
+				t.Error("Unexpected results for List ", diffs)
+			}
+		})
+	}
+}
+
+// reserve reserves sizeBytes for key and path on s for an hour, and returns
+// the reservation.
+func reserve(ctx context.Context, t *testing.T, s store.Store, key, path string, sizeBytes int64) store.Reservation {
+	t.Helper()
+	r, err := s.Reserve(ctx, store.Reservation{Key: key, Path: path, SizeBytes: sizeBytes, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Reserve %d bytes for %s: %s", sizeBytes, key, err)
+	}
+	return r
+}
+
+// expectReserved fails t unless CheckQuota on key reports reservedBytes.
+func expectReserved(ctx context.Context, t *testing.T, s store.Store, key string, reservedBytes int64) {
+	t.Helper()
+	check, err := s.CheckQuota(ctx, key, 0)
+	if err != nil {
+		t.Fatalf("CheckQuota %s: %s", key, err)
+	}
+	if check.ReservedBytes != reservedBytes {
+		t.Errorf("CheckQuota %s: got %d reserved bytes, expected %d", key, check.ReservedBytes, reservedBytes)
+	}
+}
+
+func testReserve(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	const key = "reserve"
+	if err := s.Set(ctx, key, value(10)); err != nil {
+		t.Fatalf("Set %s: %s", key, err)
+	}
+	first := reserve(ctx, t, s, key, "s3://bucket/first", 25)
+	if first.ID == "" {
+		t.Errorf("Reserve %s: no ID assigned", key)
+	}
+
+	check, err := s.CheckQuota(ctx, key, DefaultQuota-35)
+	if err != nil {
+		t.Fatalf("CheckQuota %s: %s", key, err)
+	}
+	expected := store.QuotaCheck{
+		Allowed:        true,
+		Info:           store.Info{UsageBytes: 10, QuotaBytes: DefaultQuota},
+		ReservedBytes:  25,
+		RemainingBytes: DefaultQuota - 35,
+	}
+	if diffs := deep.Equal(check, expected); diffs != nil {
+		t.Errorf("CheckQuota %s with reservation: %s", key, diffs)
+	}
+
+	_, err = s.Reserve(ctx, store.Reservation{Key: key, Path: "s3://bucket/second", SizeBytes: DefaultQuota - 34, ExpiresAt: time.Now().Add(time.Hour)})
+	if !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("Reserve %s over quota: expected quota exceeded, got %v", key, err)
+	}
+	expectReserved(ctx, t, s, key, 25)
+
+	// Reservations do not make keys exceed quota.
+	expectExceeded(ctx, t, s, nil)
+
+	// Expired reservations do not count.
+	_, err = s.Reserve(ctx, store.Reservation{Key: key, Path: "s3://bucket/expired", SizeBytes: 5, ExpiresAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("Reserve expired %s: %s", key, err)
+	}
+	expectReserved(ctx, t, s, key, 25)
+
+	if err := s.ReleaseReservation(ctx, first.ID); err != nil {
+		t.Errorf("ReleaseReservation %s: %s", first.ID, err)
+	}
+	expectReserved(ctx, t, s, key, 0)
+	if err := s.ReleaseReservation(ctx, first.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("ReleaseReservation %s again: expected %s, got %v", first.ID, store.ErrNotFound, err)
+	}
+}
+
+func testReleaseReservations(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	const (
+		key   = "release"
+		path  = "s3://bucket/object"
+		other = "s3://bucket/other"
+	)
+	reserve(ctx, t, s, key, path, 5)
+	reserve(ctx, t, s, key, path, 7)
+	reserve(ctx, t, s, key, other, 11)
+
+	n, err := s.ReleaseReservations(ctx, key, path)
+	if err != nil {
+		t.Fatalf("ReleaseReservations %s %s: %s", key, path, err)
+	}
+	if n != 2 {
+		t.Errorf("ReleaseReservations %s %s: released %d, expected 2", key, path, n)
+	}
+	expectReserved(ctx, t, s, key, 11)
+}
+
+func testSweepReservations(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	const key = "sweep"
+	now := time.Now()
+	for i, ttl := range []time.Duration{-time.Hour, time.Minute, time.Hour} {
+		_, err := s.Reserve(ctx, store.Reservation{Key: key, Path: "s3://bucket/object", SizeBytes: int64(i + 1), ExpiresAt: now.Add(ttl)})
+		if err != nil {
+			t.Fatalf("Reserve %s: %s", key, err)
+		}
+	}
+
+	n, err := s.SweepReservations(ctx, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("SweepReservations: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("SweepReservations: swept %d, expected 2", n)
+	}
+	expectReserved(ctx, t, s, key, 3)
+}
+
+func int64p(i int64) *int64 {
+	return &i
+}
+
+func testAudit(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	start := time.UnixMilli(time.Now().UnixMilli()).UTC()
+	entries := []store.AuditEntry{
+		{Time: start, Actor: "admin", Action: store.AuditSetQuota, Key: "a", OldValue: int64p(DefaultQuota), NewValue: int64p(7)},
+		{Time: start.Add(time.Second), Actor: "terminus", Action: store.AuditBlock, Key: "b"},
+		{Time: start.Add(2 * time.Second), Actor: "admin", Action: store.AuditSetUsage, Key: "a", NewValue: int64p(3), Detail: "fix"},
+	}
+	for _, e := range entries {
+		if err := s.AppendAudit(ctx, e); err != nil {
+			t.Fatalf("AppendAudit %+v: %s", e, err)
+		}
+	}
+
+	cases := []struct {
+		Name     string
+		Filter   store.AuditFilter
+		Expected []int
+	}{
+		{"All", store.AuditFilter{}, []int{0, 1, 2}},
+		{"Key", store.AuditFilter{Key: "a"}, []int{0, 2}},
+		{"Since", store.AuditFilter{Since: start.Add(time.Second)}, []int{1, 2}},
+		{"Until", store.AuditFilter{Until: start.Add(time.Second)}, []int{0}},
+		{"Limit", store.AuditFilter{Limit: 2}, []int{0, 1}},
+		{"None", store.AuditFilter{Key: "missing"}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			actual, err := s.ListAudit(ctx, c.Filter)
+			if err != nil {
+				t.Fatalf("ListAudit: %s", err)
+			}
+			var expected []store.AuditEntry
+			for _, i := range c.Expected {
+				expected = append(expected, entries[i])
+			}
+			for i := range actual {
+				if i > 0 && actual[i].ID <= actual[i-1].ID {
+					t.Errorf("IDs out of order: %d after %d", actual[i].ID, actual[i-1].ID)
+				}
+				// IDs are assigned by the store, times may be in
+				// another location.
+				actual[i].ID = 0
+				actual[i].Time = actual[i].Time.UTC()
+			}
+			if diffs := deep.Equal(actual, expected); diffs != nil {
+				t.Errorf("ListAudit %+v: %s", c.Filter, diffs)
+			}
+		})
+	}
+}
+
+// expectHistory fails t unless the history of key on s in [from, to) is
+// exactly expected.
+func expectHistory(ctx context.Context, t *testing.T, s store.Store, key string, from, to time.Time, expected []store.HistoryPoint) {
+	t.Helper()
+	points, err := s.GetHistory(ctx, key, from, to)
+	if err != nil {
+		t.Fatalf("Get history of %s: %s", key, err)
+	}
+	if len(points) == 0 && len(expected) == 0 {
+		return
+	}
+	if diffs := deep.Equal(points, expected); diffs != nil {
+		t.Errorf("Unexpected history of %s: %s", key, diffs)
+		t.Log("Got:", points)
+		t.Log("Expected:", expected)
+	}
+}
+
+func testHistory(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	// SQL stores keep milliseconds.
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return base.Add(time.Duration(h) * time.Hour) }
+	point := func(h int, usageBytes int64) store.HistoryPoint {
+		return store.HistoryPoint{Time: hour(h), UsageBytes: usageBytes}
+	}
+	set := func(key string, sizeBytes int64) {
+		t.Helper()
+		if err := s.Set(ctx, key, value(sizeBytes)); err != nil {
+			t.Fatalf("Set %s: %s", key, err)
+		}
+	}
+	record := func(h, expected int) {
+		t.Helper()
+		n, err := s.RecordHistory(ctx, hour(h))
+		if err != nil {
+			t.Fatalf("Record history at %s: %s", hour(h), err)
+		}
+		if n != expected {
+			t.Errorf("Recorded %d keys at %s, expected %d", n, hour(h), expected)
+		}
+	}
+
+	set("a", 1)
+	record(0, 1)
+	set("a", 2)
+	set("b", 5)
+	record(1, 2)
+	// Recording again replaces.
+	set("a", 3)
+	record(1, 2)
+	set("a", 4)
+	record(2, 2)
+
+	expectHistory(ctx, t, s, "a", hour(0), hour(3), []store.HistoryPoint{point(0, 1), point(1, 3), point(2, 4)})
+	expectHistory(ctx, t, s, "b", hour(0), hour(3), []store.HistoryPoint{point(1, 5), point(2, 5)})
+	expectHistory(ctx, t, s, "a", hour(1), hour(2), []store.HistoryPoint{point(1, 3)})
+	expectHistory(ctx, t, s, "c", hour(0), hour(3), nil)
+
+	// Keep the last recording of each 2 hours before hour 2.
+	n, err := s.DownsampleHistory(ctx, hour(2), 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Downsample history: %s", err)
+	}
+	if n != 1 {
+		t.Errorf("Downsampled %d points, expected 1", n)
+	}
+	expectHistory(ctx, t, s, "a", hour(0), hour(3), []store.HistoryPoint{point(1, 3), point(2, 4)})
+
+	n, err = s.DeleteHistory(ctx, hour(2))
+	if err != nil {
+		t.Fatalf("Delete history: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("Deleted %d points, expected 2", n)
+	}
+	expectHistory(ctx, t, s, "a", hour(0), hour(3), []store.HistoryPoint{point(2, 4)})
+	expectHistory(ctx, t, s, "b", hour(0), hour(3), []store.HistoryPoint{point(2, 5)})
+}
+
+func testLedger(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	// SQL stores keep milliseconds.
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return base.Add(time.Duration(h) * time.Hour) }
+	add := func(key string, numBytes int64, h int) {
+		t.Helper()
+		if err := s.AddSizeBytes(ctx, key, numBytes, hour(h)); err != nil {
+			t.Fatalf("AddSizeBytes %s at %s: %s", key, hour(h), err)
+		}
+	}
+	expect := func(from, to int, expected []store.UsageIntegral) {
+		t.Helper()
+		integrals, err := s.ByteHours(ctx, hour(from), hour(to))
+		if err != nil {
+			t.Fatalf("ByteHours from %s to %s: %s", hour(from), hour(to), err)
+		}
+		if diffs := deep.Equal(integrals, expected); diffs != nil {
+			t.Errorf("ByteHours from %s to %s: %s", hour(from), hour(to), diffs)
+		}
+	}
+
+	add("a", 10, 0)
+	add("b", 5, 1)
+	add("a", 20, 2)
+	add("a", -30, 4)
+	// Set records at the current time, after every period here.
+	if err := s.Set(ctx, "c", value(3)); err != nil {
+		t.Fatalf("Set c: %s", err)
+	}
+
+	expect(0, 6, []store.UsageIntegral{{Key: "a", ByteHours: 80}, {Key: "b", ByteHours: 25}})
+	expect(3, 5, []store.UsageIntegral{{Key: "a", ByteHours: 30}, {Key: "b", ByteHours: 10}})
+	expect(5, 6, []store.UsageIntegral{{Key: "b", ByteHours: 5}})
+	expect(-2, 0, nil)
+
+	// Merges 2 changes of a and 1 of b into 1 change each at hour 3.
+	n, err := s.CompactLedger(ctx, hour(3))
+	if err != nil {
+		t.Fatalf("Compact ledger: %s", err)
+	}
+	if n != 1 {
+		t.Errorf("Compacted %d changes, expected 1", n)
+	}
+	expect(3, 5, []store.UsageIntegral{{Key: "a", ByteHours: 30}, {Key: "b", ByteHours: 10}})
+}
+
+func testClassUsage(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+	const key = "k"
+	now := time.Now()
+
+	if err := s.AddClassSizeBytes(ctx, key, "STANDARD", 10, now); err != nil {
+		t.Fatalf("AddClassSizeBytes STANDARD: %s", err)
+	}
+	if err := s.AddClassSizeBytes(ctx, key, "GLACIER", 30, now); err != nil {
+		t.Fatalf("AddClassSizeBytes GLACIER: %s", err)
+	}
+	if err := s.AddClassSizeBytes(ctx, key, "STANDARD", 5, now); err != nil {
+		t.Fatalf("AddClassSizeBytes STANDARD again: %s", err)
+	}
+	// Counts toward usage but not toward any class.
+	if err := s.AddSizeBytes(ctx, key, 2, now); err != nil {
+		t.Fatalf("AddSizeBytes: %s", err)
+	}
+	if err := s.AddClassSizeBytes(ctx, key, "GLACIER", 20, now); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("AddClassSizeBytes GLACIER over quota: expected quota exceeded, got %v", err)
+	}
+	if err := s.AddClassSizeBytes(ctx, key, "GLACIER", -50, now); err != nil {
+		t.Fatalf("AddClassSizeBytes remove GLACIER: %s", err)
+	}
+
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if value.SizeBytes != 17 {
+		t.Errorf("Got usage %d, expected 17", value.SizeBytes)
+	}
+	usage, err := s.GetClassUsage(ctx, key)
+	if err != nil {
+		t.Fatalf("GetClassUsage: %s", err)
+	}
+	if diffs := deep.Equal(usage, map[string]int64{"STANDARD": 15}); diffs != nil {
+		t.Errorf("Usage by class: %s", diffs)
+	}
+	usage, err = s.GetClassUsage(ctx, "missing")
+	if err != nil || len(usage) != 0 {
+		t.Errorf("GetClassUsage missing key: got %v, %v", usage, err)
+	}
+}
+
+func testTransitionObject(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+	const key = "k"
+	now := time.Now()
+
+	objects := []store.Object{
+		{Path: "s3://b/a", SizeBytes: 10, StorageClass: "STANDARD"},
+		{Path: "s3://b/b", SizeBytes: 20, StorageClass: "STANDARD"},
+		// Class unknown when put.
+		{Path: "s3://b/c", SizeBytes: 5},
+	}
+	for _, o := range objects {
+		if err := s.PutObject(ctx, key, o, now); err != nil {
+			t.Fatalf("PutObject %s: %s", o.Path, err)
+		}
+	}
+	transitions := []struct {
+		Path  string
+		Class string
+	}{
+		{"s3://b/b", "GLACIER"},
+		{"s3://b/c", "GLACIER"},
+		// Moving twice to the same class changes nothing.
+		{"s3://b/c", "GLACIER"},
+	}
+	for _, tr := range transitions {
+		got, err := s.TransitionObject(ctx, tr.Path, "", tr.Class)
+		if err != nil || got != key {
+			t.Errorf("TransitionObject %s to %s: got key %q, %v", tr.Path, tr.Class, got, err)
+		}
+	}
+	if _, err := s.TransitionObject(ctx, "s3://b/missing", "", "GLACIER"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("TransitionObject missing: expected %s, got %v", store.ErrNotFound, err)
+	}
+
+	expectObjects(ctx, t, s, key, 35, 3)
+	usage, err := s.GetClassUsage(ctx, key)
+	if err != nil {
+		t.Fatalf("GetClassUsage: %s", err)
+	}
+	if diffs := deep.Equal(usage, map[string]int64{"STANDARD": 10, "GLACIER": 25}); diffs != nil {
+		t.Errorf("Usage by class: %s", diffs)
+	}
+
+	// Deleting removes the bytes from the new class.
+	if _, err := s.DeleteObject(ctx, "s3://b/b", "", now); err != nil {
+		t.Fatalf("DeleteObject: %s", err)
+	}
+	if usage, err = s.GetClassUsage(ctx, key); err != nil {
+		t.Fatalf("GetClassUsage: %s", err)
+	}
+	if diffs := deep.Equal(usage, map[string]int64{"STANDARD": 10, "GLACIER": 5}); diffs != nil {
+		t.Errorf("Usage by class after delete: %s", diffs)
+	}
+}
+
+// expectObjects fails t unless key has usageBytes and objectCount on s.
+func expectObjects(ctx context.Context, t *testing.T, s store.Store, key string, usageBytes, objectCount int64) {
+	t.Helper()
+	check, err := s.CheckQuota(ctx, key, 0)
+	if err != nil {
+		t.Fatalf("CheckQuota %s: %s", key, err)
+	}
+	if check.Info.UsageBytes != usageBytes || check.Info.ObjectCount != objectCount {
+		t.Errorf("Got %d bytes in %d objects of %s, expected %d bytes in %d objects",
+			check.Info.UsageBytes, check.Info.ObjectCount, key, usageBytes, objectCount)
+	}
+}
+
+func testObjects(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+	now := time.Now()
+
+	put := func(key string, o store.Object) {
+		t.Helper()
+		if err := s.PutObject(ctx, key, o, now); err != nil {
+			t.Fatalf("PutObject %s %+v: %s", key, o, err)
+		}
+	}
+	put("a", store.Object{Path: "s3://b/x", SizeBytes: 10, StorageClass: "GLACIER"})
+	put("a", store.Object{Path: "s3://b/y", SizeBytes: 5})
+	// Versions are distinct objects.
+	put("a", store.Object{Path: "s3://b/y", VersionID: "v1", SizeBytes: 7})
+	expectObjects(ctx, t, s, "a", 22, 3)
+
+	// Overwriting replaces the object, even from another key.
+	put("a", store.Object{Path: "s3://b/x", SizeBytes: 3})
+	expectObjects(ctx, t, s, "a", 15, 3)
+	put("b", store.Object{Path: "s3://b/y", SizeBytes: 4})
+	expectObjects(ctx, t, s, "a", 10, 2)
+	expectObjects(ctx, t, s, "b", 4, 1)
+	usage, err := s.GetClassUsage(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetClassUsage: %s", err)
+	}
+	if len(usage) != 0 {
+		t.Errorf("Got usage by class %v after overwriting GLACIER object, expected none", usage)
+	}
+
+	key, err := s.DeleteObject(ctx, "s3://b/y", "v1", now)
+	if err != nil || key != "a" {
+		t.Errorf("DeleteObject y v1: got key %q, %v", key, err)
+	}
+	expectObjects(ctx, t, s, "a", 3, 1)
+	if _, err = s.DeleteObject(ctx, "s3://b/y", "v1", now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("DeleteObject deleted object: expected not found, got %v", err)
+	}
+	if _, err = s.DeleteObject(ctx, "s3://b/missing", "", now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("DeleteObject missing object: expected not found, got %v", err)
+	}
+
+	// Deleting an object of a key over quota reports if it still is.
+	put("b", store.Object{Path: "s3://b/z", SizeBytes: 40})
+	if err = s.PutObject(ctx, "b", store.Object{Path: "s3://b/w", SizeBytes: 12}, now); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("PutObject over quota: expected quota exceeded, got %v", err)
+	}
+	if key, err = s.DeleteObject(ctx, "s3://b/y", "", now); key != "b" || !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("DeleteObject over quota: got key %q, %v", key, err)
+	}
+	if key, err = s.DeleteObject(ctx, "s3://b/w", "", now); key != "b" || err != nil {
+		t.Errorf("DeleteObject back under quota: got key %q, %v", key, err)
+	}
+	expectObjects(ctx, t, s, "b", 40, 1)
+}
+
+func testObjectQuota(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+	now := time.Now()
+	const key = "objects"
+
+	if err := s.SetObjectQuota(ctx, key, 2); err != nil {
+		t.Fatalf("SetObjectQuota: %s", err)
+	}
+	// SetObjectQuota creates the key with no usage.
+	expectSize(ctx, t, s, key, 0)
+
+	for i, path := range []string{"s3://b/1", "s3://b/2"} {
+		if err := s.PutObject(ctx, key, store.Object{Path: path, SizeBytes: 1}, now); err != nil {
+			t.Fatalf("PutObject #%d: %s", i, err)
+		}
+	}
+	check, err := s.CheckQuota(ctx, key, 1)
+	if err != nil {
+		t.Fatalf("CheckQuota: %s", err)
+	}
+	if !check.Allowed || check.Info.QuotaObjects != 2 {
+		t.Errorf("CheckQuota at quota of objects: got %+v", check)
+	}
+	expectExceeded(ctx, t, s, nil)
+
+	if err = s.PutObject(ctx, key, store.Object{Path: "s3://b/3", SizeBytes: 1}, now); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("PutObject over quota of objects: expected quota exceeded, got %v", err)
+	}
+	if check, err = s.CheckQuota(ctx, key, 0); err != nil || check.Allowed {
+		t.Errorf("CheckQuota over quota of objects: got %+v, %v", check, err)
+	}
+	expected := store.Info{UsageBytes: 3, QuotaBytes: DefaultQuota, ObjectCount: 3, QuotaObjects: 2}
+	expectExceeded(ctx, t, s, []store.Record{
+		{Key: key, Info: expected, Exceeded: []string{store.LimitObjects}},
+	})
+
+	// Exceeding both quotas reports both.
+	if err = s.AddSizeBytes(ctx, key, DefaultQuota, now); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("AddSizeBytes over both quotas: expected quota exceeded, got %v", err)
+	}
+	expected.UsageBytes += DefaultQuota
+	expectExceeded(ctx, t, s, []store.Record{
+		{Key: key, Info: expected, Exceeded: []string{store.LimitBytes, store.LimitObjects}},
+	})
+	if err = s.AddSizeBytes(ctx, key, -DefaultQuota, now); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("AddSizeBytes under quota of bytes: expected quota exceeded, got %v", err)
+	}
+
+	// A quota of 0 objects is no quota, and clearing it falls back to
+	// the default of none.
+	if err = s.SetObjectQuota(ctx, key, 0); err != nil {
+		t.Fatalf("SetObjectQuota 0: %s", err)
+	}
+	expectExceeded(ctx, t, s, nil)
+	if err = s.SetObjectQuota(ctx, key, 1); err != nil {
+		t.Fatalf("SetObjectQuota 1: %s", err)
+	}
+	if err = s.ClearObjectQuota(ctx, key); err != nil {
+		t.Fatalf("ClearObjectQuota: %s", err)
+	}
+	expectExceeded(ctx, t, s, nil)
+	if err = s.ClearObjectQuota(ctx, key+"-missing"); err != nil {
+		t.Errorf("ClearObjectQuota missing key: %s", err)
+	}
+
+	records, err := s.List(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("List: %s", err)
+	}
+	expected = store.Info{UsageBytes: 3, QuotaBytes: DefaultQuota, ObjectCount: 3}
+	if diffs := deep.Equal(records, []store.Record{{Key: key, Info: expected}}); diffs != nil {
+		t.Errorf("List: %s", diffs)
+	}
+}
+
+func testIngest(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	puts := []struct {
+		Key       string
+		Path      string
+		SizeBytes int64
+		At        time.Duration
+	}{
+		{"a", "s3://b/1", 5, 0},
+		{"a", "s3://b/2", 7, time.Minute},
+		// Overwriting ingests again.
+		{"a", "s3://b/1", 3, store.IngestBucket},
+		{"b", "s3://b/3", 11, store.IngestBucket + time.Second},
+		{"a", "s3://b/4", 0, 2 * store.IngestBucket},
+		{"b", "s3://b/5", 13, 3 * store.IngestBucket},
+	}
+	for _, p := range puts {
+		if err := s.PutObject(ctx, p.Key, store.Object{Path: p.Path, SizeBytes: p.SizeBytes}, start.Add(p.At)); err != nil {
+			t.Fatalf("PutObject %s on %s: %s", p.Path, p.Key, err)
+		}
+	}
+	// Removals do not count.
+	if _, err := s.DeleteObject(ctx, "s3://b/2", "", start.Add(time.Minute)); err != nil {
+		t.Fatalf("DeleteObject: %s", err)
+	}
+
+	cases := []struct {
+		Name     string
+		From, To time.Duration
+		Expected []store.Ingest
+	}{
+		{"All", 0, time.Hour, []store.Ingest{{Key: "a", IngestBytes: 15}, {Key: "b", IngestBytes: 24}}},
+		{"FirstBucket", 0, store.IngestBucket, []store.Ingest{{Key: "a", IngestBytes: 12}}},
+		{"MiddleBuckets", store.IngestBucket, 3 * store.IngestBucket, []store.Ingest{{Key: "a", IngestBytes: 3}, {Key: "b", IngestBytes: 11}}},
+		{"Before", -time.Hour, 0, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			from, to := start.Add(c.From), start.Add(c.To)
+			ingest, err := s.ListIngest(ctx, from, to)
+			if err != nil {
+				t.Fatalf("ListIngest: %s", err)
+			}
+			if diffs := deep.Equal(ingest, c.Expected); diffs != nil {
+				t.Errorf("ListIngest: %s", diffs)
+			}
+			for _, i := range append(c.Expected, store.Ingest{Key: "missing"}) {
+				ingestBytes, err := s.GetIngest(ctx, i.Key, from, to)
+				if err != nil || ingestBytes != i.IngestBytes {
+					t.Errorf("GetIngest %s: got %d, %v expected %d", i.Key, ingestBytes, err, i.IngestBytes)
+				}
+			}
+		})
+	}
+
+	n, err := s.DeleteIngest(ctx, start.Add(store.IngestBucket))
+	if err != nil || n != 1 {
+		t.Errorf("DeleteIngest: got %d, %v expected 1", n, err)
+	}
+	ingestBytes, err := s.GetIngest(ctx, "a", start, start.Add(time.Hour))
+	if err != nil || ingestBytes != 3 {
+		t.Errorf("GetIngest after DeleteIngest: got %d, %v expected 3", ingestBytes, err)
+	}
+}
+
+func testGracePeriod(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+	const key = "grace"
+
+	if _, err := s.GetGracePeriod(ctx, key); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetGracePeriod unset: expected %s, got %v", store.ErrNotFound, err)
+	}
+	if err := s.SetGracePeriod(ctx, key, 90*time.Minute); err != nil {
+		t.Fatalf("SetGracePeriod: %s", err)
+	}
+	// SetGracePeriod creates the key with no usage.
+	expectSize(ctx, t, s, key, 0)
+	if grace, err := s.GetGracePeriod(ctx, key); err != nil || grace != 90*time.Minute {
+		t.Errorf("GetGracePeriod: got %s, %v", grace, err)
+	}
+	// A key may have no grace at all.
+	if err := s.SetGracePeriod(ctx, key, 0); err != nil {
+		t.Fatalf("SetGracePeriod 0: %s", err)
+	}
+	if grace, err := s.GetGracePeriod(ctx, key); err != nil || grace != 0 {
+		t.Errorf("GetGracePeriod 0: got %s, %v", grace, err)
+	}
+	if err := s.ClearGracePeriod(ctx, key); err != nil {
+		t.Fatalf("ClearGracePeriod: %s", err)
+	}
+	if _, err := s.GetGracePeriod(ctx, key); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetGracePeriod cleared: expected %s, got %v", store.ErrNotFound, err)
+	}
+}
+
+func testMarkExceeded(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+	// Stores may keep milliseconds.
+	start := time.UnixMilli(time.Now().UnixMilli())
+
+	mark := func(key string, exceeded bool, at, expected time.Time) {
+		t.Helper()
+		since, err := s.MarkExceeded(ctx, key, exceeded, at)
+		if err != nil {
+			t.Fatalf("MarkExceeded %s %t: %s", key, exceeded, err)
+		}
+		if !since.Equal(expected) {
+			t.Errorf("MarkExceeded %s %t: got since %s, expected %s", key, exceeded, since, expected)
+		}
+		since, err = s.GetExceededSince(ctx, key)
+		if exceeded && (err != nil || !since.Equal(expected)) {
+			t.Errorf("GetExceededSince %s: got %s, %v expected %s", key, since, err, expected)
+		}
+		if !exceeded && !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("GetExceededSince %s: expected %s, got %s, %v", key, store.ErrNotFound, since, err)
+		}
+	}
+	mark("a", true, start, start)
+	mark("a", true, start.Add(time.Minute), start)
+	mark("a", false, start.Add(2*time.Minute), time.Time{})
+	mark("a", true, start.Add(3*time.Minute), start.Add(3*time.Minute))
+	mark("b", true, start, start)
+	mark("missing", false, start, time.Time{})
+
+	since, err := s.MarkAllExceeded(ctx, []string{"a", "c"}, start.Add(4*time.Minute))
+	if err != nil {
+		t.Fatalf("MarkAllExceeded: %s", err)
+	}
+	expected := map[string]time.Time{"a": start.Add(3 * time.Minute), "c": start.Add(4 * time.Minute)}
+	if len(since) != len(expected) {
+		t.Errorf("MarkAllExceeded: got %v, expected %v", since, expected)
+	}
+	for key, e := range expected {
+		if !since[key].Equal(e) {
+			t.Errorf("MarkAllExceeded: got since %s of %s, expected %s", since[key], key, e)
+		}
+	}
+	// b no longer exceeds, so it starts again.
+	mark("b", true, start.Add(5*time.Minute), start.Add(5*time.Minute))
+}
+
+// expectInfo fails t unless key has info on s.
+func expectInfo(ctx context.Context, t *testing.T, s store.Store, key string, info store.Info) {
+	t.Helper()
+	check, err := s.CheckQuota(ctx, key, 0)
+	if err != nil {
+		t.Fatalf("CheckQuota %s: %s", key, err)
+	}
+	if diffs := deep.Equal(check.Info, info); diffs != nil {
+		t.Errorf("Info of %s: %s", key, diffs)
+	}
+}
+
+func testPlans(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	if _, err := s.GetPlan(ctx, "team"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetPlan missing: expected %s, got %v", store.ErrNotFound, err)
+	}
+	if err := s.AssignPlan(ctx, "a", "team"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("AssignPlan missing: expected %s, got %v", store.ErrNotFound, err)
+	}
+	if err := s.SetPlan(ctx, store.Plan{QuotaBytes: int64p(1)}); !errors.Is(err, store.ErrBadPlan) {
+		t.Errorf("SetPlan without name: expected %s, got %v", store.ErrBadPlan, err)
+	}
+
+	team := store.Plan{
+		Name:         "team",
+		QuotaBytes:   int64p(100),
+		QuotaObjects: int64p(2),
+		RateLimits:   []store.RateLimit{{Window: 5 * time.Minute, Bytes: 10}, {Window: time.Hour, Bytes: 60}},
+	}
+	free := store.Plan{Name: "free", QuotaBytes: int64p(10)}
+	for _, p := range []store.Plan{team, free} {
+		if err := s.SetPlan(ctx, p); err != nil {
+			t.Fatalf("SetPlan %s: %s", p.Name, err)
+		}
+	}
+	if p, err := s.GetPlan(ctx, "team"); err != nil {
+		t.Errorf("GetPlan team: %s", err)
+	} else if diffs := deep.Equal(p, team); diffs != nil {
+		t.Errorf("GetPlan team: %s", diffs)
+	}
+	plans, err := s.ListPlans(ctx)
+	if err != nil {
+		t.Fatalf("ListPlans: %s", err)
+	}
+	if diffs := deep.Equal(plans, []store.Plan{free, team}); diffs != nil {
+		t.Errorf("ListPlans: %s", diffs)
+	}
+
+	if err := s.AddSizeBytes(ctx, "a", 80, time.Now()); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Errorf("AddSizeBytes over default quota: expected %s, got %v", store.ErrQuotaExceeded, err)
+	}
+	if err := s.AssignPlan(ctx, "a", "team"); err != nil {
+		t.Fatalf("AssignPlan a: %s", err)
+	}
+	expectInfo(ctx, t, s, "a", store.Info{UsageBytes: 80, QuotaBytes: 100, QuotaObjects: 2, Plan: "team"})
+	// A quota of the key overrides that of its plan.
+	if err := s.SetQuota(ctx, "b", 60); err != nil {
+		t.Fatalf("SetQuota b: %s", err)
+	}
+	if err := s.AssignPlan(ctx, "b", "team"); err != nil {
+		t.Fatalf("AssignPlan b: %s", err)
+	}
+	expectInfo(ctx, t, s, "b", store.Info{QuotaBytes: 60, QuotaObjects: 2, Plan: "team"})
+	// AssignPlan creates the key with no usage.
+	if err := s.AssignPlan(ctx, "c", "free"); err != nil {
+		t.Fatalf("AssignPlan c: %s", err)
+	}
+	expectSize(ctx, t, s, "c", 0)
+	expectInfo(ctx, t, s, "c", store.Info{QuotaBytes: 10, Plan: "free"})
+	expectExceeded(ctx, t, s, nil)
+
+	// Changes of the plan apply at once to its keys.
+	team.QuotaBytes = int64p(70)
+	if err := s.SetPlan(ctx, team); err != nil {
+		t.Fatalf("SetPlan team: %s", err)
+	}
+	expectExceeded(ctx, t, s, []store.Record{
+		{Key: "a", Info: store.Info{UsageBytes: 80, QuotaBytes: 70, QuotaObjects: 2, Plan: "team"}, Exceeded: bytesExceeded},
+	})
+	records, err := s.List(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("List: %s", err)
+	}
+	for _, r := range records {
+		if expected := map[string]string{"a": "team", "b": "team", "c": "free"}[r.Key]; r.Info.Plan != expected {
+			t.Errorf("List: got plan %q of %s, expected %q", r.Info.Plan, r.Key, expected)
+		}
+	}
+
+	if err := s.UnassignPlan(ctx, "a"); err != nil {
+		t.Fatalf("UnassignPlan a: %s", err)
+	}
+	expectInfo(ctx, t, s, "a", store.Info{UsageBytes: 80, QuotaBytes: DefaultQuota})
+	if err := s.DeletePlan(ctx, "team"); err != nil {
+		t.Fatalf("DeletePlan team: %s", err)
+	}
+	expectInfo(ctx, t, s, "b", store.Info{QuotaBytes: 60})
+	if err := s.DeletePlan(ctx, "team"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("DeletePlan deleted: expected %s, got %v", store.ErrNotFound, err)
+	}
+	// Keys of a deleted plan do not join a new plan of the same name.
+	if err := s.SetPlan(ctx, team); err != nil {
+		t.Fatalf("SetPlan team again: %s", err)
+	}
+	expectInfo(ctx, t, s, "b", store.Info{QuotaBytes: 60})
+}
+
+func testBlocked(t *testing.T, newStore Factory) {
+	s := newStore(t, DefaultQuota)
+	ctx := testContext(t)
+
+	expectBlocked := func(expected []string) {
+		t.Helper()
+		blocked, err := s.ListBlocked(ctx)
+		if err != nil {
+			t.Fatalf("ListBlocked: %s", err)
+		}
+		if diffs := deep.Equal(blocked, expected); diffs != nil {
+			t.Errorf("ListBlocked: %s", diffs)
+		}
+	}
+
+	expectBlocked(nil)
+	for _, key := range []string{"b", "a", "c", "b"} {
+		if err := s.SetBlocked(ctx, key, true); err != nil {
+			t.Fatalf("SetBlocked %s: %s", key, err)
+		}
+	}
+	expectBlocked([]string{"a", "b", "c"})
+	for _, key := range []string{"a", "d"} {
+		if err := s.SetBlocked(ctx, key, false); err != nil {
+			t.Fatalf("Unblock %s: %s", key, err)
+		}
+	}
+	expectBlocked([]string{"b", "c"})
+}